@@ -4,12 +4,14 @@ func makeMockAccounts() *mockAccounts {
 	return &mockAccounts{
 		accounts: make(map[int64]int64),
 		rejects:  make(map[int64]bool),
+		held:     make(map[int64]int64),
 	}
 }
 
 type mockAccounts struct {
 	accounts map[int64]int64
 	rejects  map[int64]bool
+	held     map[int64]int64
 }
 
 func (ma *mockAccounts) Credit(accountID, funds int64) {
@@ -25,3 +27,25 @@ func (ma *mockAccounts) DebitIfPossible(accountID, funds int64) bool {
 	ma.accounts[accountID] = cur - funds
 	return true
 }
+
+func (ma *mockAccounts) CanDebit(accountID, funds int64) bool {
+	return !ma.rejects[accountID]
+}
+
+func (ma *mockAccounts) Hold(accountID, funds int64) bool {
+	if ma.rejects[accountID] {
+		return false
+	}
+	ma.accounts[accountID] -= funds
+	ma.held[accountID] += funds
+	return true
+}
+
+func (ma *mockAccounts) Release(accountID, funds int64) {
+	ma.held[accountID] -= funds
+	ma.accounts[accountID] += funds
+}
+
+func (ma *mockAccounts) DebitHeld(accountID, funds int64) {
+	ma.held[accountID] -= funds
+}
@@ -0,0 +1,419 @@
+package economy
+
+import (
+	"sort"
+	"time"
+)
+
+// Session is the way a symbol is being traded
+type Session byte
+
+const (
+	// SessionContinuous fills orders as they arrive. It is
+	// the default.
+	SessionContinuous Session = 0
+	// SessionAuction collects orders without filling them
+	// until the auction is uncrossed, when they all trade
+	// at a single price
+	SessionAuction Session = 1
+)
+
+func (s Session) String() string {
+	switch s {
+	case SessionContinuous:
+		return "continuous"
+	case SessionAuction:
+		return "auction"
+	}
+	return "unknown"
+}
+
+// noTaker is the taker side of auction trades, where both
+// orders pay maker fees
+const noTaker Side = 2
+
+// AuctionWindow is a daily call auction. Orders are
+// collected from Start and uncrossed at End, both measured
+// from midnight in the location of the Market's clock.
+type AuctionWindow struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// AuctionSchedule is the daily call auctions for each
+// symbol, typically one for the open and one for the
+// close. Symbols without their own windows use Default.
+type AuctionSchedule struct {
+	Default []AuctionWindow
+	Symbols map[string][]AuctionWindow
+}
+
+// Windows returns the auction windows for symbol
+func (a *AuctionSchedule) Windows(symbol string) []AuctionWindow {
+	if a == nil {
+		return nil
+	}
+	if w, found := a.Symbols[symbol]; found {
+		return w
+	}
+	return a.Default
+}
+
+// due returns the end of the window for symbol that now is
+// in, or false if it isn't in one
+func (a *AuctionSchedule) due(symbol string, now time.Time) (time.Time, bool) {
+	y, m, d := now.Date()
+	midnight := time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+	for _, w := range a.Windows(symbol) {
+		start, end := midnight.Add(w.Start), midnight.Add(w.End)
+		if !now.Before(start) && now.Before(end) {
+			return end, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// WithAuctions runs daily call auctions by the Market's
+// clock. Sessions change when the Market is next used at
+// or after the scheduled time, or when UpdateSessions is
+// called, so a window the clock passes over completely
+// without the Market being used is skipped.
+func WithAuctions(a AuctionSchedule) Option {
+	return func(m *Market) {
+		m.auctions = &a
+	}
+}
+
+// session is the session state of one symbol
+type session struct {
+	auction bool
+	// close is when the auction uncrosses, or zero for an
+	// auction started by StartAuction
+	close time.Time
+	// ran is the end of the last scheduled window, so that
+	// it isn't started again if it was uncrossed early
+	ran time.Time
+}
+
+func (m *Market) session(symbol string) *session {
+	if m.sessions == nil {
+		m.sessions = make(map[string]*session)
+	}
+	st, found := m.sessions[symbol]
+	if !found {
+		st = &session{}
+		m.sessions[symbol] = st
+	}
+	return st
+}
+
+// updateSession moves symbol to the session the clock says
+// it should be in, uncrossing an auction whose time has
+// come. It returns true if the symbol is in an auction.
+func (m *Market) updateSession(symbol string) (bool, error) {
+	now := m.now()
+	st := m.session(symbol)
+	if st.auction && !st.close.IsZero() && !now.Before(st.close) {
+		if _, err := m.uncross(symbol, st.close); err != nil {
+			return false, err
+		}
+	}
+	if st.auction {
+		return true, nil
+	}
+	if end, found := m.auctions.due(symbol, now); found && !end.Equal(st.ran) {
+		m.startAuction(symbol, now, end)
+	}
+	return st.auction, nil
+}
+
+func (m *Market) startAuction(symbol string, ts, close time.Time) {
+	st := m.session(symbol)
+	st.auction, st.close = true, close
+	m.settlement.events.publish(Event{Type: EventAuctionStarted, Date: ts, Symbol: symbol})
+}
+
+// uncross ends the auction in symbol, filling every order
+// that crosses at the price that trades the most, and
+// returns the trades
+func (m *Market) uncross(symbol string, ts time.Time) ([]Transaction, error) {
+	st := m.session(symbol)
+	if !st.close.IsZero() {
+		st.ran = st.close
+	}
+	st.auction, st.close = false, time.Time{}
+	s := m.settlement
+	s.trades = nil
+	bids, offers, err := m.auctionOrders(symbol, ts)
+	if err != nil {
+		return nil, err
+	}
+	lastPrice, err := m.storage.LastPrice(symbol)
+	if err != nil {
+		return nil, err
+	}
+	price, volume := clearingPrice(bids, offers, lastPrice)
+	if volume > 0 {
+		if err = m.fillAuction(symbol, ts, bids, offers, price); err != nil {
+			return nil, err
+		}
+	}
+	var traded int64
+	for _, tx := range s.trades {
+		traded += tx.Amount
+	}
+	s.events.publish(Event{Type: EventAuctionUncrossed, Date: ts, Symbol: symbol, Price: price, Amount: traded})
+	trades := s.trades
+	if err = m.triggerStops(symbol); err != nil {
+		return trades, err
+	}
+	return trades, nil
+}
+
+// auctionOrder is an order taking part in an auction,
+// with the limit it trades at. Market orders trade at any
+// price.
+type auctionOrder struct {
+	price  int64
+	market bool
+}
+
+func (o auctionOrder) buysAt(price int64) bool {
+	return o.market || o.price >= price
+}
+
+func (o auctionOrder) sellsAt(price int64) bool {
+	return o.market || o.price <= price
+}
+
+type auctionBid struct {
+	auctionOrder
+	bid Bid
+}
+
+type auctionOffer struct {
+	auctionOrder
+	offer Offer
+}
+
+// auctionOrders returns the orders resting in symbol,
+// market orders first, cancelling any that have expired
+func (m *Market) auctionOrders(symbol string, ts time.Time) ([]auctionBid, []auctionOffer, error) {
+	resting, err := m.storage.RestingBids(symbol)
+	if err != nil {
+		return nil, nil, err
+	}
+	var bids []auctionBid
+	for _, b := range resting {
+		if b.Expired(ts) {
+			if err = m.settlement.cancelBid(m.storage, ts, b, EventExpired); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		o := auctionBid{bid: b}
+		o.market = b.BidType == OrderTypeMarket
+		if !o.market {
+			p, err := m.processor(b.BidType)
+			if err != nil {
+				return nil, nil, err
+			}
+			if o.price, err = p.GetBidPrice(m.storage, b); err != nil {
+				return nil, nil, err
+			}
+		}
+		bids = append(bids, o)
+	}
+	sort.SliceStable(bids, func(i, j int) bool { return bids[i].market && !bids[j].market })
+	selling, err := m.storage.RestingOffers(symbol)
+	if err != nil {
+		return nil, nil, err
+	}
+	var offers []auctionOffer
+	for _, off := range selling {
+		if off.Expired(ts) {
+			if err = m.settlement.cancelOffer(m.storage, ts, off, EventExpired); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		o := auctionOffer{offer: off}
+		o.market = off.OfferType == OrderTypeMarket
+		if !o.market {
+			p, err := m.processor(off.OfferType)
+			if err != nil {
+				return nil, nil, err
+			}
+			if o.price, err = p.GetAskingPrice(m.storage, off); err != nil {
+				return nil, nil, err
+			}
+		}
+		offers = append(offers, o)
+	}
+	sort.SliceStable(offers, func(i, j int) bool { return offers[i].market && !offers[j].market })
+	return bids, offers, nil
+}
+
+// clearingPrice returns the price that trades the most,
+// and how much it trades. Ties go to the price that leaves
+// the least unfilled, then the one closest to the last
+// price, then the lowest. The last price is used when only
+// market orders cross.
+func clearingPrice(bids []auctionBid, offers []auctionOffer, lastPrice int64) (int64, int64) {
+	var candidates []int64
+	for _, b := range bids {
+		if !b.market {
+			candidates = append(candidates, b.price)
+		}
+	}
+	for _, o := range offers {
+		if !o.market {
+			candidates = append(candidates, o.price)
+		}
+	}
+	if len(candidates) == 0 && lastPrice > 0 {
+		candidates = append(candidates, lastPrice)
+	}
+	var price, volume, imbalance int64
+	for _, p := range candidates {
+		var demand, supply int64
+		for _, b := range bids {
+			if b.buysAt(p) {
+				demand += b.bid.Amount
+			}
+		}
+		for _, o := range offers {
+			if o.sellsAt(p) {
+				supply += o.offer.Amount
+			}
+		}
+		v, left := demand, supply-demand
+		if supply < demand {
+			v, left = supply, demand-supply
+		}
+		better := v > volume ||
+			v == volume && left < imbalance ||
+			v == volume && left == imbalance && closer(p, price, lastPrice)
+		if v > 0 && better {
+			price, volume, imbalance = p, v, left
+		}
+	}
+	return price, volume
+}
+
+// closer returns true if p is closer to target than q, or
+// as close and lower
+func closer(p, q, target int64) bool {
+	dp, dq := p-target, q-target
+	if dp < 0 {
+		dp = -dp
+	}
+	if dq < 0 {
+		dq = -dq
+	}
+	return dp < dq || dp == dq && p < q
+}
+
+// fillAuction fills the bids and offers that cross at
+// price, in order, until one side runs out. Self-trade
+// prevention treats the offer as the newest order, since
+// neither order in an auction is the taker.
+func (m *Market) fillAuction(symbol string, ts time.Time, bids []auctionBid, offers []auctionOffer, price int64) error {
+	s := m.settlement
+	s.taker = noTaker
+	i, j := 0, 0
+	for i < len(bids) && j < len(offers) && !s.Halted(symbol) {
+		bid, off := bids[i].bid, offers[j].offer
+		if !bid.IsActive() || !bids[i].buysAt(price) {
+			i++
+			continue
+		}
+		if !off.IsActive() || !offers[j].sellsAt(price) {
+			j++
+			continue
+		}
+		bid, off, prevented, err := s.PreventSelfTrade(m.storage, ts, bid, off)
+		if err == nil && !prevented {
+			bid, off, _, err = s.Fill(m.storage, ts, bid, off, price)
+		}
+		if err != nil {
+			return err
+		}
+		bids[i].bid, offers[j].offer = bid, off
+	}
+	return nil
+}
+
+// Session returns the session symbol is in, starting or
+// uncrossing a scheduled auction if its time has come
+func (m *Market) Session(symbol string) (Session, error) {
+	m.storage.Lock()
+	defer m.storage.Unlock()
+	auction, err := m.updateSession(symbol)
+	if err != nil || !auction {
+		return SessionContinuous, err
+	}
+	return SessionAuction, nil
+}
+
+// UpdateSessions starts and uncrosses the scheduled
+// auctions whose time has come in every known symbol. It
+// can be called from a timer so that auctions uncross on
+// time without waiting for the next order.
+func (m *Market) UpdateSessions() (err error) {
+	m.storage.Lock()
+	defer m.unlock(&err)
+	symbols, err := m.storage.AllSymbols()
+	if err != nil {
+		return err
+	}
+	if m.auctions != nil {
+		for symbol := range m.auctions.Symbols {
+			symbols = append(symbols, symbol)
+		}
+	}
+	for symbol := range m.sessions {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	for i, symbol := range symbols {
+		if i > 0 && symbols[i-1] == symbol {
+			continue
+		}
+		if _, err = m.updateSession(symbol); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StartAuction starts collecting orders in symbol for an
+// auction that lasts until Uncross
+func (m *Market) StartAuction(symbol string) (err error) {
+	m.storage.Lock()
+	defer m.unlock(&err)
+	auction, err := m.updateSession(symbol)
+	if err != nil || auction {
+		return err
+	}
+	m.startAuction(symbol, m.now(), time.Time{})
+	return nil
+}
+
+// Uncross ends the auction in symbol, whether it was
+// started by StartAuction or the schedule, and returns the
+// trades. All the trades are at the same price, which is
+// the one that trades the most. It returns ErrNoAuction if
+// the symbol isn't in an auction.
+func (m *Market) Uncross(symbol string) (_ []Transaction, err error) {
+	m.storage.Lock()
+	defer m.unlock(&err)
+	auction, err := m.updateSession(symbol)
+	if err != nil {
+		return nil, err
+	}
+	if !auction {
+		return nil, ErrNoAuction
+	}
+	return m.uncross(symbol, m.now())
+}
@@ -0,0 +1,144 @@
+package economy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAuctionCollectsOrders(t *testing.T) {
+	m := MakeMarket(time.Now, MakeMemoryStorage(), makeMockAccounts())
+	if err := m.StartAuction(sym); err != nil {
+		t.Fatal(err)
+	}
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 5, OfferType: OrderTypeLimit, Price: 5})
+	report, err := m.Bid(Bid{Symbol: sym, Account: 1, Amount: 5, BidType: OrderTypeLimit, Price: 6})
+	if err != nil || report.Status != OrderStatusResting {
+		t.Fatalf("%+v %v", report, err)
+	}
+	if _, err = m.Bid(Bid{Symbol: sym, Account: 1, Amount: 5, BidType: OrderTypeLimit, Price: 6, TimeInForce: TimeInForceIOC}); err != ErrAuctionInProgress {
+		t.Fatalf("Expected ErrAuctionInProgress, got %v", err)
+	}
+	if s, _ := m.Session(sym); s != SessionAuction {
+		t.Fatalf("Session %s", s)
+	}
+}
+
+func TestAuctionClearingPrice(t *testing.T) {
+	m := MakeMarket(time.Now, MakeMemoryStorage(), makeMockAccounts())
+	m.StartAuction(sym)
+	m.Bid(Bid{Symbol: sym, Account: 1, Amount: 5, BidType: OrderTypeLimit, Price: 12})
+	m.Bid(Bid{Symbol: sym, Account: 1, Amount: 5, BidType: OrderTypeLimit, Price: 10})
+	m.Bid(Bid{Symbol: sym, Account: 1, Amount: 5, BidType: OrderTypeLimit, Price: 8})
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 4, OfferType: OrderTypeMarket})
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 4, OfferType: OrderTypeLimit, Price: 9})
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 6, OfferType: OrderTypeLimit, Price: 11})
+	var events []Event
+	m.Subscribe(func(e Event) { events = append(events, e) })
+	// 9 and 10 both trade 8 and leave 2, and there is no
+	// last price, so the lower one clears
+	trades, err := m.Uncross(sym)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var volume int64
+	for _, tx := range trades {
+		if tx.Price != 9 {
+			t.Fatalf("%+v", trades)
+		}
+		volume += tx.Amount
+	}
+	if volume != 8 {
+		t.Fatalf("Traded %d", volume)
+	}
+	last := events[len(events)-1]
+	if last.Type != EventAuctionUncrossed || last.Price != 9 || last.Amount != 8 {
+		t.Fatalf("%+v", last)
+	}
+	if s, _ := m.Session(sym); s != SessionContinuous {
+		t.Fatalf("Session %s", s)
+	}
+	report, _ := m.Bid(Bid{Symbol: sym, Account: 1, Amount: 6, BidType: OrderTypeLimit, Price: 11})
+	if report.Status != OrderStatusFilled {
+		t.Fatalf("%+v", report)
+	}
+	if _, err = m.Uncross(sym); err != ErrNoAuction {
+		t.Fatalf("Expected ErrNoAuction, got %v", err)
+	}
+}
+
+func TestAuctionNothingCrosses(t *testing.T) {
+	m := MakeMarket(time.Now, MakeMemoryStorage(), makeMockAccounts())
+	m.StartAuction(sym)
+	m.Bid(Bid{Symbol: sym, Account: 1, Amount: 5, BidType: OrderTypeLimit, Price: 4})
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 5, OfferType: OrderTypeLimit, Price: 5})
+	trades, err := m.Uncross(sym)
+	if err != nil || len(trades) != 0 {
+		t.Fatalf("%+v %v", trades, err)
+	}
+	if d, _ := m.Depth(sym, 0); len(d.Bids) != 1 || len(d.Offers) != 1 {
+		t.Fatalf("%+v", d)
+	}
+}
+
+func TestAuctionSchedule(t *testing.T) {
+	now := time.Date(2021, 3, 4, 8, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	m := MakeMarket(clock, MakeMemoryStorage(), makeMockAccounts(), WithAuctions(AuctionSchedule{
+		Default: []AuctionWindow{{Start: 8 * time.Hour, End: 9 * time.Hour}},
+	}))
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 5, OfferType: OrderTypeLimit, Price: 5})
+	report, _ := m.Bid(Bid{Symbol: sym, Account: 1, Amount: 5, BidType: OrderTypeLimit, Price: 5})
+	if report.Status != OrderStatusResting {
+		t.Fatalf("%+v", report)
+	}
+	now = now.Add(90 * time.Minute)
+	if err := m.UpdateSessions(); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := m.FindBid(report.OrderID)
+	if b.IsActive() {
+		t.Fatalf("%+v", b)
+	}
+	txs, _ := m.storage.Transactions(TransactionFilter{})
+	if len(txs) != 1 || !txs[0].Date.Equal(time.Date(2021, 3, 4, 9, 0, 0, 0, time.UTC)) {
+		t.Fatalf("%+v", txs)
+	}
+	if s, _ := m.Session(sym); s != SessionContinuous {
+		t.Fatalf("Session %s", s)
+	}
+}
+
+func TestAuctionUncrossedEarlyDoesNotRestart(t *testing.T) {
+	now := time.Date(2021, 3, 4, 8, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	m := MakeMarket(clock, MakeMemoryStorage(), makeMockAccounts(), WithAuctions(AuctionSchedule{
+		Symbols: map[string][]AuctionWindow{sym: {{Start: 8 * time.Hour, End: 9 * time.Hour}}},
+	}))
+	if s, _ := m.Session(sym); s != SessionAuction {
+		t.Fatalf("Session %s", s)
+	}
+	if s, _ := m.Session("other"); s != SessionContinuous {
+		t.Fatalf("Session %s", s)
+	}
+	m.Uncross(sym)
+	if s, _ := m.Session(sym); s != SessionContinuous {
+		t.Fatalf("Session %s", s)
+	}
+	now = now.Add(24 * time.Hour)
+	if s, _ := m.Session(sym); s != SessionAuction {
+		t.Fatalf("Session %s the next day", s)
+	}
+}
+
+func TestAuctionPaysMakerFees(t *testing.T) {
+	accounts := makeMockAccounts()
+	m := MakeMarket(time.Now, MakeMemoryStorage(), accounts,
+		WithFees(FeeSchedule{House: 99, Default: FeeRates{MakerFlat: 1, TakerFlat: 3}}))
+	m.StartAuction(sym)
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 5, OfferType: OrderTypeLimit, Price: 5})
+	m.Bid(Bid{Symbol: sym, Account: 1, Amount: 5, BidType: OrderTypeLimit, Price: 5})
+	trades, _ := m.Uncross(sym)
+	if len(trades) != 1 || trades[0].BidFee != 1 || trades[0].OfferFee != 1 {
+		t.Fatalf("%+v", trades)
+	}
+}
@@ -0,0 +1,185 @@
+package economy
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	CandleMinute = time.Minute
+	CandleHour   = time.Hour
+	// CandleDay is a 24 hour day. Games with a different
+	// length of day can pass their own interval to
+	// WithCandles.
+	CandleDay = 24 * time.Hour
+)
+
+// DefaultCandleIntervals are the intervals a Market keeps
+// candles for unless WithCandles is used
+var DefaultCandleIntervals = []time.Duration{CandleMinute, CandleHour, CandleDay}
+
+// Candle summarizes the trades in one symbol over one
+// interval, starting at Start
+type Candle struct {
+	Symbol   string
+	Interval time.Duration
+	Start    time.Time
+	Open     int64
+	High     int64
+	Low      int64
+	Close    int64
+	Volume   int64
+	// Value is the total price paid, used to calculate
+	// the VWAP
+	Value int64
+}
+
+// VWAP returns the volume weighted average price of the
+// trades in the candle, rounded down
+func (c Candle) VWAP() int64 {
+	if c.Volume == 0 {
+		return 0
+	}
+	return c.Value / c.Volume
+}
+
+func (c *Candle) add(tx Transaction) {
+	if c.Volume == 0 {
+		c.Open, c.High, c.Low = tx.Price, tx.Price, tx.Price
+	}
+	if tx.Price > c.High {
+		c.High = tx.Price
+	}
+	if tx.Price < c.Low {
+		c.Low = tx.Price
+	}
+	c.Close = tx.Price
+	c.Volume += tx.Amount
+	c.Value += tx.Price * tx.Amount
+}
+
+// candleStart returns the start of the interval that
+// contains ts. Intervals are aligned to the zero time, so
+// days start at midnight UTC.
+func candleStart(ts time.Time, interval time.Duration) time.Time {
+	return ts.Truncate(interval).UTC()
+}
+
+// WithCandles makes the Market keep candles for the
+// specified intervals instead of DefaultCandleIntervals.
+// Calling it with no intervals turns candles off.
+func WithCandles(intervals ...time.Duration) Option {
+	return func(m *Market) {
+		m.settlement.intervals = intervals
+	}
+}
+
+// BuildCandles returns the candles for txs at each of the
+// intervals, ordered by symbol, interval and start.
+// Transactions must be in the order they happened.
+func BuildCandles(txs []Transaction, intervals []time.Duration) []Candle {
+	type key struct {
+		symbol   string
+		interval time.Duration
+		start    time.Time
+	}
+	index := make(map[key]int)
+	var candles []Candle
+	for _, tx := range txs {
+		for _, interval := range intervals {
+			k := key{tx.Symbol, interval, candleStart(tx.Date, interval)}
+			i, found := index[k]
+			if !found {
+				i = len(candles)
+				index[k] = i
+				candles = append(candles, Candle{Symbol: k.symbol, Interval: interval, Start: k.start})
+			}
+			candles[i].add(tx)
+		}
+	}
+	sort.SliceStable(candles, func(i, j int) bool {
+		a, b := candles[i], candles[j]
+		if a.Symbol != b.Symbol {
+			return a.Symbol < b.Symbol
+		}
+		if a.Interval != b.Interval {
+			return a.Interval < b.Interval
+		}
+		return a.Start.Before(b.Start)
+	})
+	return candles
+}
+
+// updateCandles adds tx to the current candle for each
+// interval the Market keeps
+func (s *Settlement) updateCandles(ms MarketStorage, tx Transaction) error {
+	for _, interval := range s.intervals {
+		start := candleStart(tx.Date, interval)
+		c, found, err := ms.Candle(tx.Symbol, interval, start)
+		if err != nil {
+			return err
+		}
+		if !found {
+			c = Candle{Symbol: tx.Symbol, Interval: interval, Start: start}
+		}
+		c.add(tx)
+		if err := ms.SetCandle(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Candles returns the candles for symbol at interval that
+// start at or after since and before until, oldest first.
+// A zero since or until leaves that end open. Intervals
+// with no trades have no candle.
+func (m *Market) Candles(symbol string, interval time.Duration, since, until time.Time) ([]Candle, error) {
+	m.storage.Lock()
+	defer m.storage.Unlock()
+	return m.storage.Candles(symbol, interval, since, until)
+}
+
+// RebuildCandles recalculates every candle the Market
+// keeps from its transactions, for example after loading
+// a snapshot saved before candles existed. The candles
+// already stored are deleted first. Transactions
+// saved without a symbol take it from the offer they
+// filled, and are skipped if the offer is gone.
+func (m *Market) RebuildCandles() (err error) {
+	m.storage.Lock()
+	defer m.unlock(&err)
+	txs, err := m.storage.Transactions(TransactionFilter{})
+	if err != nil {
+		return err
+	}
+	symbols, err := m.storage.AllSymbols()
+	if err != nil {
+		return err
+	}
+	for _, symbol := range symbols {
+		if err := m.storage.DeleteCandles(symbol); err != nil {
+			return err
+		}
+	}
+	var known []Transaction
+	for _, tx := range txs {
+		if tx.Symbol == "" {
+			off, err := m.storage.GetOffer(tx.OfferID)
+			if err == ErrOrderNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			tx.Symbol = off.Symbol
+		}
+		known = append(known, tx)
+	}
+	for _, c := range BuildCandles(known, m.settlement.intervals) {
+		if err := m.storage.SetCandle(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package economy
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCandlesFromTrades(t *testing.T) {
+	now := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	m := MakeMarket(clock, MakeMemoryStorage(), makeMockAccounts())
+	trade := func(price, amount int64) {
+		m.Offer(Offer{Symbol: sym, Account: 2, Amount: amount, OfferType: OrderTypeLimit, Price: price})
+		m.Bid(Bid{Symbol: sym, Account: 1, Amount: amount, BidType: OrderTypeLimit, Price: price})
+	}
+	trade(10, 1)
+	now = now.Add(10 * time.Second)
+	trade(14, 2)
+	now = now.Add(10 * time.Second)
+	trade(8, 1)
+	now = now.Add(time.Minute)
+	trade(9, 3)
+	candles, err := m.Candles(sym, CandleMinute, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candles) != 2 {
+		t.Fatalf("%+v", candles)
+	}
+	c := candles[0]
+	if c.Open != 10 || c.High != 14 || c.Low != 8 || c.Close != 8 || c.Volume != 4 || c.VWAP() != 11 {
+		t.Fatalf("%+v", c)
+	}
+	if !c.Start.Equal(time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Fatalf("%s", c.Start)
+	}
+	candles, _ = m.Candles(sym, CandleHour, time.Time{}, time.Time{})
+	if len(candles) != 1 || candles[0].Volume != 7 || candles[0].Close != 9 {
+		t.Fatalf("%+v", candles)
+	}
+	candles, _ = m.Candles(sym, CandleMinute, now.Truncate(time.Minute), time.Time{})
+	if len(candles) != 1 || candles[0].Open != 9 {
+		t.Fatalf("%+v", candles)
+	}
+}
+
+func TestWithCandlesIntervals(t *testing.T) {
+	gameDay := 20 * time.Minute
+	m := MakeMarket(time.Now, MakeMemoryStorage(), makeMockAccounts(), WithCandles(gameDay))
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 1, OfferType: OrderTypeLimit, Price: 5})
+	m.Bid(Bid{Symbol: sym, Account: 1, Amount: 1, BidType: OrderTypeLimit, Price: 5})
+	if c, _ := m.Candles(sym, gameDay, time.Time{}, time.Time{}); len(c) != 1 {
+		t.Fatalf("%+v", c)
+	}
+	if c, _ := m.Candles(sym, CandleMinute, time.Time{}, time.Time{}); len(c) != 0 {
+		t.Fatalf("%+v", c)
+	}
+}
+
+func TestCandlesSurviveSnapshot(t *testing.T) {
+	s := MakeMemoryStorage()
+	m := MakeMarket(time.Now, s, makeMockAccounts())
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 3, OfferType: OrderTypeLimit, Price: 5})
+	m.Bid(Bid{Symbol: sym, Account: 1, Amount: 3, BidType: OrderTypeLimit, Price: 5})
+	var buf bytes.Buffer
+	if err := s.Marshal(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded := MakeMemoryStorage()
+	if err := loaded.UnMarshal(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, interval := range DefaultCandleIntervals {
+		c, _ := loaded.Candles(sym, interval, time.Time{}, time.Time{})
+		if len(c) != 1 || c[0].Volume != 3 || c[0].Value != 15 {
+			t.Fatalf("%s: %+v", interval, c)
+		}
+	}
+}
+
+func TestRebuildCandles(t *testing.T) {
+	s := MakeMemoryStorage()
+	m := MakeMarket(time.Now, s, makeMockAccounts(), WithCandles())
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 3, OfferType: OrderTypeLimit, Price: 5})
+	m.Bid(Bid{Symbol: sym, Account: 1, Amount: 1, BidType: OrderTypeLimit, Price: 5})
+	m.Bid(Bid{Symbol: sym, Account: 1, Amount: 2, BidType: OrderTypeLimit, Price: 6})
+	m = MakeMarket(time.Now, s, makeMockAccounts())
+	if err := m.RebuildCandles(); err != nil {
+		t.Fatal(err)
+	}
+	c, _ := m.Candles(sym, CandleDay, time.Time{}, time.Time{})
+	if len(c) != 1 || c[0].Volume != 3 || c[0].Open != 5 || c[0].Close != 5 {
+		t.Fatalf("%+v", c)
+	}
+}
+
+func TestRebuildCandlesTwice(t *testing.T) {
+	s := MakeMemoryStorage()
+	m := MakeMarket(time.Now, s, makeMockAccounts())
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 3, OfferType: OrderTypeLimit, Price: 5})
+	m.Bid(Bid{Symbol: sym, Account: 1, Amount: 3, BidType: OrderTypeLimit, Price: 5})
+	stale := Candle{Symbol: sym, Interval: CandleDay, Start: time.Now().Add(-48 * time.Hour), Volume: 7}
+	s.SetCandle(stale)
+	if err := m.RebuildCandles(); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := m.Candles(sym, CandleDay, time.Time{}, time.Time{})
+	if err := m.RebuildCandles(); err != nil {
+		t.Fatal(err)
+	}
+	second, _ := m.Candles(sym, CandleDay, time.Time{}, time.Time{})
+	if len(first) != 1 || first[0].Volume != 3 || !reflect.DeepEqual(first, second) {
+		t.Fatalf("%+v then %+v", first, second)
+	}
+}
+
+func TestBuildCandles(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	txs := []Transaction{
+		{Symbol: "B", Price: 3, Amount: 1, Date: start},
+		{Symbol: "A", Price: 4, Amount: 1, Date: start.Add(time.Hour)},
+		{Symbol: "A", Price: 2, Amount: 1, Date: start},
+	}
+	candles := BuildCandles(txs, []time.Duration{CandleHour})
+	if len(candles) != 3 ||
+		candles[0].Symbol != "A" || candles[0].Close != 2 ||
+		candles[1].Symbol != "A" || candles[1].Close != 4 ||
+		candles[2].Symbol != "B" {
+		t.Fatalf("%+v", candles)
+	}
+}
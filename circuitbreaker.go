@@ -0,0 +1,225 @@
+package economy
+
+import "time"
+
+// BandRule limits how far the price of a symbol may move
+// before trading in it halts
+type BandRule struct {
+	// Band is how far trades may be from the reference
+	// price, in hundredths of a percent either way. Zero
+	// leaves prices unlimited.
+	Band int64
+	// HaltFor is how long trading halts when a trade would
+	// be outside the band. Zero halts until Resume.
+	HaltFor time.Duration
+}
+
+// PriceBands are the band rules for each symbol. Symbols
+// without their own rule use Default.
+//
+// The reference price of a symbol is set by its first
+// trade, by Resume, and by SetReferencePrice. It doesn't
+// follow later trades, so a price can't be walked out of
+// the band a little at a time either.
+type PriceBands struct {
+	Default BandRule
+	Symbols map[string]BandRule
+}
+
+// Rule returns the band rule for symbol
+func (b *PriceBands) Rule(symbol string) BandRule {
+	if b == nil {
+		return BandRule{}
+	}
+	if r, found := b.Symbols[symbol]; found {
+		return r
+	}
+	return b.Default
+}
+
+// WithPriceBands rejects orders priced outside each
+// symbol's band, and halts trading in a symbol when a
+// trade would be outside it. Halts and reference prices
+// are kept in memory, not in storage.
+func WithPriceBands(b PriceBands) Option {
+	return func(m *Market) {
+		m.settlement.bands = &b
+	}
+}
+
+// halt is a halt in trading in one symbol
+type halt struct {
+	// until is when trading resumes by itself, or zero if
+	// it waits for Resume
+	until time.Time
+}
+
+// inBand returns true if price is within the band for
+// symbol, or the symbol has no band
+func (s *Settlement) inBand(symbol string, price int64) bool {
+	ref, found := s.reference[symbol]
+	return !found || s.withinBand(symbol, ref, price)
+}
+
+// withinBand returns true if price is within the symbol's
+// band around ref
+func (s *Settlement) withinBand(symbol string, ref, price int64) bool {
+	band := s.bands.Rule(symbol).Band
+	if band == 0 {
+		return true
+	}
+	width := ref * band / 10000
+	return price >= ref-width && price <= ref+width
+}
+
+// traded sets the reference price from the first trade in
+// a symbol
+func (s *Settlement) traded(symbol string, price int64) {
+	if _, found := s.reference[symbol]; found {
+		return
+	}
+	s.setReference(symbol, price)
+}
+
+func (s *Settlement) setReference(symbol string, price int64) {
+	if s.reference == nil {
+		s.reference = make(map[string]int64)
+	}
+	s.reference[symbol] = price
+}
+
+// Halted returns true if trading in symbol is halted.
+// Processors stop matching orders in a halted symbol, and
+// leave what is left of the order to be finished as usual.
+func (s *Settlement) Halted(symbol string) bool {
+	_, found := s.halts[symbol]
+	return found
+}
+
+// halted returns true if trading in symbol is halted at
+// now. A halt that has run its time is ended here, so it
+// resumes at the price it halted at.
+func (s *Settlement) halted(ms MarketStorage, symbol string, now time.Time) (bool, error) {
+	h, found := s.halts[symbol]
+	if !found {
+		return false, nil
+	}
+	if h.until.IsZero() || now.Before(h.until) {
+		return true, nil
+	}
+	return false, s.resume(ms, symbol, h.until)
+}
+
+// halt stops trading in symbol, for the rule's HaltFor
+// if timed is true and otherwise until Resume. price is
+// the trade that caused the halt, if there was one.
+func (s *Settlement) halt(symbol string, ts time.Time, price int64, timed bool) {
+	if s.halts == nil {
+		s.halts = make(map[string]halt)
+	}
+	var h halt
+	if haltFor := s.bands.Rule(symbol).HaltFor; timed && haltFor > 0 {
+		h.until = ts.Add(haltFor)
+	}
+	s.halts[symbol] = h
+	s.events.publish(Event{Type: EventHalted, Date: ts, Symbol: symbol, Price: price})
+}
+
+// resume ends a halt, with the last price as the new
+// reference price
+func (s *Settlement) resume(ms MarketStorage, symbol string, ts time.Time) error {
+	lastPrice, err := ms.LastPrice(symbol)
+	if err != nil {
+		return err
+	}
+	delete(s.halts, symbol)
+	if lastPrice > 0 {
+		s.setReference(symbol, lastPrice)
+	}
+	s.events.publish(Event{Type: EventResumed, Date: ts, Symbol: symbol, Price: lastPrice})
+	return nil
+}
+
+// checkTrading returns ErrSymbolHalted if trading in
+// symbol is halted, or ErrPriceOutsideBand if priced is
+// true and price is outside the symbol's band
+func (m *Market) checkTrading(symbol string, price int64, priced bool) error {
+	halted, err := m.settlement.halted(m.storage, symbol, m.now())
+	if err != nil {
+		return err
+	}
+	if halted {
+		return ErrSymbolHalted
+	}
+	if priced && !m.settlement.inBand(symbol, price) {
+		return ErrPriceOutsideBand
+	}
+	return nil
+}
+
+// checkBid checks that b can be placed. Limit prices are
+// checked against the band, and registered types are
+// checked at the price their processor gives them.
+func (m *Market) checkBid(p OrderProcessor, b Bid) error {
+	switch b.BidType {
+	case OrderTypeMarket, OrderTypeStopMarket:
+		return m.checkTrading(b.Symbol, 0, false)
+	case OrderTypeLimit, OrderTypeStopLimit:
+		return m.checkTrading(b.Symbol, b.Price, true)
+	}
+	price, err := p.GetBidPrice(m.storage, b)
+	if err != nil {
+		return err
+	}
+	return m.checkTrading(b.Symbol, price, true)
+}
+
+// checkOffer is checkBid for offers
+func (m *Market) checkOffer(p OrderProcessor, o Offer) error {
+	switch o.OfferType {
+	case OrderTypeMarket, OrderTypeStopMarket:
+		return m.checkTrading(o.Symbol, 0, false)
+	case OrderTypeLimit, OrderTypeStopLimit:
+		return m.checkTrading(o.Symbol, o.Price, true)
+	}
+	price, err := p.GetAskingPrice(m.storage, o)
+	if err != nil {
+		return err
+	}
+	return m.checkTrading(o.Symbol, price, true)
+}
+
+// Halt stops trading in symbol until Resume. Orders can
+// still be cancelled while trading is halted, but new
+// orders and amendments are rejected with ErrSymbolHalted.
+func (m *Market) Halt(symbol string) {
+	m.storage.Lock()
+	defer m.storage.Unlock()
+	m.settlement.halt(symbol, m.now(), 0, false)
+}
+
+// Resume ends a halt in trading in symbol. The last price
+// becomes the reference price for its band.
+func (m *Market) Resume(symbol string) (err error) {
+	m.storage.Lock()
+	defer m.unlock(&err)
+	if _, found := m.settlement.halts[symbol]; !found {
+		return nil
+	}
+	return m.settlement.resume(m.storage, symbol, m.now())
+}
+
+// Halted returns true if trading in symbol is halted
+func (m *Market) Halted(symbol string) (bool, error) {
+	m.storage.Lock()
+	defer m.storage.Unlock()
+	return m.settlement.halted(m.storage, symbol, m.now())
+}
+
+// SetReferencePrice sets the price that symbol's band is
+// centred on
+func (m *Market) SetReferencePrice(symbol string, price int64) {
+	m.storage.Lock()
+	defer m.storage.Unlock()
+	m.settlement.setReference(symbol, price)
+}
@@ -0,0 +1,117 @@
+package economy
+
+import (
+	"testing"
+	"time"
+)
+
+func makeBandedMarket(now func() time.Time, rule BandRule) *Market {
+	return MakeMarket(now, MakeMemoryStorage(), makeMockAccounts(), WithPriceBands(PriceBands{Default: rule}))
+}
+
+func TestPriceBandRejectsOrders(t *testing.T) {
+	m := makeBandedMarket(time.Now, BandRule{Band: 1000})
+	m.SetReferencePrice(sym, 100)
+	if _, err := m.Offer(Offer{Symbol: sym, Account: 2, Amount: 5, OfferType: OrderTypeLimit, Price: 111}); err != ErrPriceOutsideBand {
+		t.Fatalf("Expected ErrPriceOutsideBand, got %v", err)
+	}
+	report, err := m.Bid(Bid{Symbol: sym, Account: 1, Amount: 5, BidType: OrderTypeLimit, Price: 90})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.AmendBid(1, report.OrderID, 89, 5); err != ErrPriceOutsideBand {
+		t.Fatalf("Expected ErrPriceOutsideBand, got %v", err)
+	}
+	if b, _ := m.FindBid(report.OrderID); b.Price != 90 {
+		t.Fatalf("%+v", b)
+	}
+	if _, err = m.Bid(Bid{Symbol: "other", Account: 1, Amount: 5, BidType: OrderTypeLimit, Price: 1}); err != nil {
+		t.Fatalf("Symbol without a reference price rejected: %v", err)
+	}
+}
+
+func TestPriceBandHaltsOnBreach(t *testing.T) {
+	m := makeBandedMarket(time.Now, BandRule{Band: 1000})
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 5, OfferType: OrderTypeLimit, Price: 100})
+	m.Offer(Offer{Symbol: sym, Account: 3, Amount: 5, OfferType: OrderTypeLimit, Price: 150})
+	var events []Event
+	m.Subscribe(func(e Event) { events = append(events, e) })
+	report, err := m.Bid(Bid{Symbol: sym, Account: 1, Amount: 10, BidType: OrderTypeMarket})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Transactions) != 1 || report.Transactions[0].Price != 100 {
+		t.Fatalf("%+v", report)
+	}
+	var halted bool
+	for _, e := range events {
+		if e.Type == EventHalted {
+			halted = e.Price == 150 && e.Symbol == sym
+		}
+	}
+	if !halted {
+		t.Fatalf("%+v", events)
+	}
+	if h, _ := m.Halted(sym); !h {
+		t.Fatal("Trading not halted")
+	}
+	if _, err = m.Bid(Bid{Symbol: sym, Account: 1, Amount: 1, BidType: OrderTypeLimit, Price: 100}); err != ErrSymbolHalted {
+		t.Fatalf("Expected ErrSymbolHalted, got %v", err)
+	}
+	if d, _ := m.Depth(sym, 0); len(d.Offers) != 1 || d.Offers[0].Amount != 5 {
+		t.Fatalf("%+v", d)
+	}
+}
+
+func TestHaltAndResume(t *testing.T) {
+	m := makeBandedMarket(time.Now, BandRule{})
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 5, OfferType: OrderTypeLimit, Price: 100})
+	m.Halt(sym)
+	report, err := m.Bid(Bid{Symbol: sym, Account: 1, Amount: 5, BidType: OrderTypeMarket})
+	if err != ErrSymbolHalted || len(report.Transactions) != 0 {
+		t.Fatalf("%+v %v", report, err)
+	}
+	var events []Event
+	m.Subscribe(func(e Event) { events = append(events, e) })
+	if err = m.Resume(sym); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Type != EventResumed {
+		t.Fatalf("%+v", events)
+	}
+	report, _ = m.Bid(Bid{Symbol: sym, Account: 1, Amount: 5, BidType: OrderTypeMarket})
+	if report.Status != OrderStatusFilled {
+		t.Fatalf("%+v", report)
+	}
+}
+
+func TestHaltAllowsCancel(t *testing.T) {
+	m := makeBandedMarket(time.Now, BandRule{})
+	report, _ := m.Offer(Offer{Symbol: sym, Account: 2, Amount: 5, OfferType: OrderTypeLimit, Price: 100})
+	m.Halt(sym)
+	if err := m.AmendOffer(2, report.OrderID, 90, 5); err != ErrSymbolHalted {
+		t.Fatalf("Expected ErrSymbolHalted, got %v", err)
+	}
+	if err := m.CancelOffer(2, report.OrderID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHaltExpires(t *testing.T) {
+	now := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	clock := func() time.Time { return now }
+	m := makeBandedMarket(clock, BandRule{Band: 500, HaltFor: time.Minute})
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 1, OfferType: OrderTypeLimit, Price: 100})
+	m.Offer(Offer{Symbol: sym, Account: 3, Amount: 1, OfferType: OrderTypeLimit, Price: 200})
+	m.Bid(Bid{Symbol: sym, Account: 1, Amount: 2, BidType: OrderTypeMarket})
+	if h, _ := m.Halted(sym); !h {
+		t.Fatal("Trading not halted")
+	}
+	now = now.Add(time.Minute)
+	if h, _ := m.Halted(sym); h {
+		t.Fatal("Halt didn't expire")
+	}
+	if _, err := m.Bid(Bid{Symbol: sym, Account: 1, Amount: 1, BidType: OrderTypeLimit, Price: 106}); err != ErrPriceOutsideBand {
+		t.Fatalf("Expected ErrPriceOutsideBand, got %v", err)
+	}
+}
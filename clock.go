@@ -0,0 +1,174 @@
+package economy
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// SimClock is simulated time for a Market, so that a
+// trading day can be replayed in milliseconds and the same
+// way every time. Pass its Now method to MakeMarket and
+// every order, trade and event is timed by it.
+//
+// Time only moves when Advance or RunUntil is called. They
+// run the callbacks scheduled up to the new time in order,
+// with the clock set to each callback's time while it
+// runs. Callbacks can schedule more callbacks, but must
+// not call Advance or RunUntil themselves.
+type SimClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers timerHeap
+	// seq orders callbacks scheduled for the same time by
+	// when they were scheduled
+	seq uint64
+}
+
+// MakeSimClock returns a SimClock that starts at start
+func MakeSimClock(start time.Time) *SimClock {
+	return &SimClock{now: start}
+}
+
+// Now returns the simulated time
+func (c *SimClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+// At schedules f to run at t, or at the current time if t
+// has passed. The returned function cancels it.
+func (c *SimClock) At(t time.Time, f func(time.Time) error) func() {
+	return c.schedule(t, 0, f)
+}
+
+// After schedules f to run once d has passed
+func (c *SimClock) After(d time.Duration, f func(time.Time) error) func() {
+	return c.schedule(c.Now().Add(d), 0, f)
+}
+
+// Every schedules f to run each time interval passes,
+// until the returned function is called. Nothing is
+// scheduled if the interval isn't greater than zero.
+func (c *SimClock) Every(interval time.Duration, f func(time.Time) error) func() {
+	if interval <= 0 {
+		return func() {}
+	}
+	return c.schedule(c.Now().Add(interval), interval, f)
+}
+
+func (c *SimClock) schedule(t time.Time, every time.Duration, f func(time.Time) error) func() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.seq++
+	tm := &timer{at: t, seq: c.seq, every: every, f: f}
+	heap.Push(&c.timers, tm)
+	return func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		tm.stopped = true
+	}
+}
+
+// Advance moves the clock forward by d, running the
+// callbacks that come due
+func (c *SimClock) Advance(d time.Duration) error {
+	return c.RunUntil(c.Now().Add(d))
+}
+
+// RunUntil moves the clock forward to t, running the
+// callbacks that come due. If a callback returns an error
+// the clock stops at that callback's time and the error is
+// returned. The clock never moves backwards, so RunUntil
+// does nothing but run overdue callbacks if t has passed.
+func (c *SimClock) RunUntil(t time.Time) error {
+	for {
+		f, now := c.next(t)
+		if f == nil {
+			return nil
+		}
+		if err := f(now); err != nil {
+			return err
+		}
+	}
+}
+
+// next returns the first callback due by t, with the
+// clock moved to its time, or moves the clock to t and
+// returns nil if there are none. Repeating callbacks are
+// scheduled again.
+func (c *SimClock) next(t time.Time) (func(time.Time) error, time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for len(c.timers) > 0 {
+		tm := c.timers[0]
+		if tm.at.After(t) {
+			break
+		}
+		heap.Pop(&c.timers)
+		if tm.stopped {
+			continue
+		}
+		if tm.at.After(c.now) {
+			c.now = tm.at
+		}
+		f := tm.f
+		if tm.every > 0 {
+			c.seq++
+			tm.at, tm.seq = tm.at.Add(tm.every), c.seq
+			heap.Push(&c.timers, tm)
+		}
+		return f, c.now
+	}
+	if t.After(c.now) {
+		c.now = t
+	}
+	return nil, c.now
+}
+
+// Drive schedules m's housekeeping every interval:
+// starting and uncrossing scheduled auctions and expiring
+// good till date orders. The returned function stops it.
+// Like Every, it does nothing if interval isn't greater
+// than zero.
+func (c *SimClock) Drive(m *Market, interval time.Duration) func() {
+	return c.Every(interval, func(time.Time) error {
+		if err := m.UpdateSessions(); err != nil {
+			return err
+		}
+		return m.ExpireOrders()
+	})
+}
+
+// timer is a scheduled callback
+type timer struct {
+	at    time.Time
+	seq   uint64
+	every time.Duration
+	f     func(time.Time) error
+	// stopped timers are dropped when they come due
+	stopped bool
+}
+
+type timerHeap []*timer
+
+func (h timerHeap) Len() int { return len(h) }
+
+func (h timerHeap) Less(i, j int) bool {
+	if h[i].at.Equal(h[j].at) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].at.Before(h[j].at)
+}
+
+func (h timerHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *timerHeap) Push(x interface{}) { *h = append(*h, x.(*timer)) }
+
+func (h *timerHeap) Pop() interface{} {
+	old := *h
+	tm := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return tm
+}
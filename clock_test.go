@@ -0,0 +1,118 @@
+package economy
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSimClockRunsCallbacksInOrder(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := MakeSimClock(start)
+	var ran []time.Duration
+	record := func(now time.Time) error {
+		if !c.Now().Equal(now) {
+			t.Fatalf("Clock %v, callback %v", c.Now(), now)
+		}
+		ran = append(ran, now.Sub(start))
+		return nil
+	}
+	c.After(3*time.Second, record)
+	c.At(start.Add(time.Second), record)
+	c.After(2*time.Second, func(now time.Time) error {
+		c.After(0, record)
+		return nil
+	})
+	if err := c.Advance(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if len(ran) != 3 || ran[0] != time.Second || ran[1] != 2*time.Second || ran[2] != 3*time.Second {
+		t.Fatalf("%v", ran)
+	}
+	if !c.Now().Equal(start.Add(10 * time.Second)) {
+		t.Fatal(c.Now())
+	}
+}
+
+func TestSimClockEvery(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := MakeSimClock(start)
+	var count int
+	stop := c.Every(time.Minute, func(time.Time) error {
+		count++
+		return nil
+	})
+	c.RunUntil(start.Add(time.Hour))
+	if count != 60 {
+		t.Fatalf("Ran %d times", count)
+	}
+	stop()
+	c.Advance(time.Hour)
+	if count != 60 {
+		t.Fatalf("Ran %d times after stopping", count)
+	}
+}
+
+func TestSimClockEveryNonPositive(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := MakeSimClock(start)
+	var count int
+	stop := c.Every(0, func(time.Time) error {
+		count++
+		return nil
+	})
+	if err := c.Advance(time.Hour); err != nil || count != 0 {
+		t.Fatalf("Ran %d times: %v", count, err)
+	}
+	stop()
+}
+
+func TestSimClockStopsOnError(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := MakeSimClock(start)
+	failed := errors.New("failed")
+	c.After(time.Minute, func(time.Time) error { return failed })
+	if err := c.Advance(time.Hour); err != failed {
+		t.Fatalf("Expected failure, got %v", err)
+	}
+	if !c.Now().Equal(start.Add(time.Minute)) {
+		t.Fatal(c.Now())
+	}
+	if err := c.RunUntil(start); err != nil || !c.Now().Equal(start.Add(time.Minute)) {
+		t.Fatalf("Clock moved backwards to %v", c.Now())
+	}
+}
+
+func TestSimClockDrivesMarket(t *testing.T) {
+	start := time.Date(2021, 1, 1, 9, 0, 0, 0, time.UTC)
+	c := MakeSimClock(start)
+	m := MakeMarket(c.Now, MakeMemoryStorage(), makeMockAccounts(), WithAuctions(AuctionSchedule{
+		Default: []AuctionWindow{{Start: 9 * time.Hour, End: 9*time.Hour + 30*time.Minute}},
+	}))
+	c.Drive(m, time.Minute)
+	var events []Event
+	m.Subscribe(func(e Event) { events = append(events, e) })
+	m.Offer(Offer{
+		Symbol: sym, Account: 2, Amount: 5, OfferType: OrderTypeLimit, Price: 5,
+		TimeInForce: TimeInForceGTD, Expires: start.Add(2 * time.Hour),
+	})
+	m.Bid(Bid{Symbol: sym, Account: 1, Amount: 2, BidType: OrderTypeLimit, Price: 5})
+	if err := c.RunUntil(start.Add(8 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	var uncrossed, expired time.Time
+	for _, e := range events {
+		switch e.Type {
+		case EventAuctionUncrossed:
+			uncrossed = e.Date
+		case EventExpired:
+			expired = e.Date
+		}
+	}
+	if !uncrossed.Equal(start.Add(30 * time.Minute)) {
+		t.Fatalf("Uncrossed at %v", uncrossed)
+	}
+	if !expired.Equal(start.Add(2 * time.Hour)) {
+		t.Fatalf("Expired at %v", expired)
+	}
+}
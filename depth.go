@@ -0,0 +1,123 @@
+package economy
+
+// PriceLevel is the total of all the orders resting at
+// one price
+type PriceLevel struct {
+	Price  int64
+	Amount int64
+	Orders int
+}
+
+// Depth is an aggregated view of the orders resting for
+// a symbol, best price first. Orders are shown at the
+// price their processor gives, so market orders are at
+// the last price, which is the price they would trade at.
+type Depth struct {
+	Symbol    string
+	LastPrice int64
+	Bids      []PriceLevel
+	Offers    []PriceLevel
+}
+
+// Book lists every order resting for a symbol, in the
+// order they would be matched
+type Book struct {
+	Symbol    string
+	LastPrice int64
+	Bids      []Bid
+	Offers    []Offer
+}
+
+// Depth returns up to levels price levels on each side of
+// the market for the symbol. If levels is less than 1 all
+// price levels are returned.
+func (m *Market) Depth(symbol string, levels int) (Depth, error) {
+	m.storage.Lock()
+	defer m.storage.Unlock()
+	b, err := m.book(symbol)
+	if err != nil {
+		return Depth{}, err
+	}
+	d := Depth{Symbol: b.Symbol, LastPrice: b.LastPrice}
+	for _, bid := range b.Bids {
+		p, err := m.processor(bid.BidType)
+		if err != nil {
+			return Depth{}, err
+		}
+		price, err := p.GetBidPrice(m.storage, bid)
+		if err != nil {
+			return Depth{}, err
+		}
+		d.Bids = addToLevels(d.Bids, price, bid.Amount)
+	}
+	for _, offer := range b.Offers {
+		p, err := m.processor(offer.OfferType)
+		if err != nil {
+			return Depth{}, err
+		}
+		price, err := p.GetAskingPrice(m.storage, offer)
+		if err != nil {
+			return Depth{}, err
+		}
+		d.Offers = addToLevels(d.Offers, price, offer.Amount)
+	}
+	if levels > 0 {
+		if len(d.Bids) > levels {
+			d.Bids = d.Bids[:levels]
+		}
+		if len(d.Offers) > levels {
+			d.Offers = d.Offers[:levels]
+		}
+	}
+	return d, nil
+}
+
+// Book returns every order resting for the symbol
+func (m *Market) Book(symbol string) (Book, error) {
+	m.storage.Lock()
+	defer m.storage.Unlock()
+	return m.book(symbol)
+}
+
+func (m *Market) book(symbol string) (Book, error) {
+	var err error
+	b := Book{Symbol: symbol}
+	b.LastPrice, err = m.storage.LastPrice(symbol)
+	if err != nil {
+		return Book{}, err
+	}
+	bids, err := m.storage.RestingBids(symbol)
+	if err != nil {
+		return Book{}, err
+	}
+	offers, err := m.storage.RestingOffers(symbol)
+	if err != nil {
+		return Book{}, err
+	}
+	// Expired orders are only removed when they are
+	// matched against, so they may still be in storage
+	now := m.now()
+	for _, bid := range bids {
+		if !bid.Expired(now) {
+			b.Bids = append(b.Bids, bid)
+		}
+	}
+	for _, offer := range offers {
+		if !offer.Expired(now) {
+			b.Offers = append(b.Offers, offer)
+		}
+	}
+	return b, nil
+}
+
+// addToLevels adds an order to the last level if it has
+// the same price, or starts a new level. Orders must be
+// added in the order they would be matched.
+func addToLevels(levels []PriceLevel, price, amount int64) []PriceLevel {
+	if n := len(levels); n > 0 && levels[n-1].Price == price {
+		levels[n-1].Amount += amount
+		levels[n-1].Orders++
+		return levels
+	}
+	return append(levels, PriceLevel{Price: price, Amount: amount, Orders: 1})
+}
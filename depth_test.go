@@ -0,0 +1,81 @@
+package economy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDepthAggregatesLevels(t *testing.T) {
+	m := MakeMarket(time.Now, MakeMemoryStorage(), makeMockAccounts())
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 10, OfferType: OrderTypeLimit, Price: 7})
+	m.Offer(Offer{Symbol: sym, Account: 3, Amount: 5, OfferType: OrderTypeLimit, Price: 6})
+	m.Offer(Offer{Symbol: sym, Account: 4, Amount: 2, OfferType: OrderTypeLimit, Price: 6})
+	m.Bid(Bid{Symbol: sym, Account: 1, Amount: 3, BidType: OrderTypeLimit, Price: 4})
+	m.Bid(Bid{Symbol: sym, Account: 1, Amount: 4, BidType: OrderTypeLimit, Price: 5})
+	d, err := m.Depth(sym, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedOffers := []PriceLevel{{Price: 6, Amount: 7, Orders: 2}, {Price: 7, Amount: 10, Orders: 1}}
+	expectedBids := []PriceLevel{{Price: 5, Amount: 4, Orders: 1}, {Price: 4, Amount: 3, Orders: 1}}
+	if len(d.Offers) != len(expectedOffers) || len(d.Bids) != len(expectedBids) {
+		t.Fatalf("%+v", d)
+	}
+	for i, l := range expectedOffers {
+		if d.Offers[i] != l {
+			t.Fatalf("%d: %+v != %+v", i, d.Offers[i], l)
+		}
+	}
+	for i, l := range expectedBids {
+		if d.Bids[i] != l {
+			t.Fatalf("%d: %+v != %+v", i, d.Bids[i], l)
+		}
+	}
+	d, _ = m.Depth(sym, 1)
+	if len(d.Offers) != 1 || len(d.Bids) != 1 || d.Offers[0].Price != 6 || d.Bids[0].Price != 5 {
+		t.Fatalf("%+v", d)
+	}
+}
+
+func TestDepthExcludesInactiveOrders(t *testing.T) {
+	m := MakeMarket(time.Now, MakeMemoryStorage(), makeMockAccounts())
+	report, _ := m.Offer(Offer{Symbol: sym, Account: 2, Amount: 10, OfferType: OrderTypeLimit, Price: 7})
+	m.CancelOffer(2, report.OrderID)
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 10, OfferType: OrderTypeLimit, Price: 8})
+	m.Bid(Bid{Symbol: sym, Account: 1, Amount: 10, BidType: OrderTypeLimit, Price: 8})
+	d, _ := m.Depth(sym, 0)
+	if len(d.Offers) != 0 || len(d.Bids) != 0 || d.LastPrice != 8 {
+		t.Fatalf("%+v", d)
+	}
+}
+
+func TestBookListsOrdersInPriority(t *testing.T) {
+	s := MakeMemoryStorage()
+	s.SetLastPrice(sym, 6)
+	m := MakeMarket(time.Now, s, makeMockAccounts())
+	first, _ := m.Offer(Offer{Symbol: sym, Account: 2, Amount: 1, OfferType: OrderTypeLimit, Price: 6})
+	second, _ := m.Offer(Offer{Symbol: sym, Account: 3, Amount: 1, OfferType: OrderTypeMarket})
+	best, _ := m.Offer(Offer{Symbol: sym, Account: 4, Amount: 1, OfferType: OrderTypeLimit, Price: 5})
+	b, err := m.Book(sym)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b.Offers) != 3 ||
+		b.Offers[0].ID != best.OrderID ||
+		b.Offers[1].ID != first.OrderID ||
+		b.Offers[2].ID != second.OrderID {
+		t.Fatalf("%+v", b.Offers)
+	}
+	d, _ := m.Depth(sym, 0)
+	if len(d.Offers) != 2 || d.Offers[1] != (PriceLevel{Price: 6, Amount: 2, Orders: 2}) {
+		t.Fatalf("%+v", d.Offers)
+	}
+}
+
+func TestDepthUnknownSymbol(t *testing.T) {
+	m := MakeMarket(time.Now, MakeMemoryStorage(), makeMockAccounts())
+	d, err := m.Depth("none", 5)
+	if err != nil || len(d.Bids) != 0 || len(d.Offers) != 0 {
+		t.Fatalf("%+v %v", d, err)
+	}
+}
@@ -0,0 +1,63 @@
+package economy
+
+// hold sets aside the funds needed to pay for the rest of
+// b, adjusting whatever is already held for it. It returns
+// ErrInsufficientFunds, leaving b unchanged, if the funds
+// can't be held. The buyer's fee for a single fill is held
+// too. It does nothing unless escrow is enabled.
+func (s *Settlement) hold(ms MarketStorage, b *Bid) error {
+	if s.escrow == nil {
+		return nil
+	}
+	price := b.Price
+	switch b.BidType {
+	case OrderTypeMarket:
+		lastPrice, err := ms.LastPrice(b.Symbol)
+		if err != nil {
+			return err
+		}
+		price = lastPrice * (100 + s.slippage) / 100
+	case OrderTypeStopMarket:
+		// Stop bids are triggered at about their stop price
+		price = b.StopPrice * (100 + s.slippage) / 100
+	}
+	value := price * b.Amount
+	needed := value + s.fees.maxFee(b.Symbol, value) - b.Held
+	if needed > 0 {
+		if !s.escrow.Hold(b.Account, needed) {
+			return ErrInsufficientFunds
+		}
+	} else if needed < 0 {
+		s.escrow.Release(b.Account, -needed)
+	}
+	b.Held += needed
+	return nil
+}
+
+// release returns any funds still held for b
+func (s *Settlement) release(b *Bid) {
+	if b.Held == 0 || s.escrow == nil {
+		return
+	}
+	s.escrow.Release(b.Account, b.Held)
+	b.Held = 0
+}
+
+// debit takes funds from the buyer of b, using funds held
+// for b first. It returns false if the funds aren't there.
+func (s *Settlement) debit(b *Bid, funds int64) bool {
+	if b.Held == 0 || s.escrow == nil {
+		return s.accounts.DebitIfPossible(b.Account, funds)
+	}
+	if funds > b.Held {
+		// Market bids can cost more than was held if the
+		// price moves past the slippage allowance
+		if !s.accounts.DebitIfPossible(b.Account, funds-b.Held) {
+			return false
+		}
+		funds = b.Held
+	}
+	s.escrow.DebitHeld(b.Account, funds)
+	b.Held -= funds
+	return true
+}
@@ -0,0 +1,155 @@
+package economy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEscrowRejectsBidWithoutFunds(t *testing.T) {
+	accounts := makeMockAccounts()
+	accounts.rejects[1] = true
+	storage := MakeMemoryStorage()
+	m := MakeMarket(time.Now, storage, accounts, WithEscrow(0))
+	_, err := m.Bid(Bid{Symbol: sym, Account: 1, Amount: 10, BidType: OrderTypeLimit, Price: 5})
+	if err != ErrInsufficientFunds {
+		t.Fatalf("Expected ErrInsufficientFunds, got %v", err)
+	}
+	if len(storage.bids) != 0 {
+		t.Fatalf("%+v", storage.bids)
+	}
+}
+
+func TestEscrowHoldsLimitCost(t *testing.T) {
+	accounts := makeMockAccounts()
+	m := MakeMarket(time.Now, MakeMemoryStorage(), accounts, WithEscrow(0))
+	report, err := m.Bid(Bid{Symbol: sym, Account: 1, Amount: 10, BidType: OrderTypeLimit, Price: 5})
+	id := report.OrderID
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accounts.held[1] != 50 || m.GetBid(id).Held != 50 {
+		t.Fatalf("%+v", accounts)
+	}
+}
+
+func TestEscrowHoldsMarketCostWithSlippage(t *testing.T) {
+	accounts := makeMockAccounts()
+	storage := MakeMemoryStorage()
+	storage.SetLastPrice(sym, 20)
+	m := MakeMarket(time.Now, storage, accounts, WithEscrow(10))
+	m.Bid(Bid{Symbol: sym, Account: 1, Amount: 10, BidType: OrderTypeMarket})
+	if accounts.held[1] != 220 {
+		t.Fatalf("%+v", accounts.held)
+	}
+}
+
+func TestEscrowReleasesRemainderOnFill(t *testing.T) {
+	accounts := makeMockAccounts()
+	m := MakeMarket(time.Now, MakeMemoryStorage(), accounts, WithEscrow(0))
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 10, OfferType: OrderTypeLimit, Price: 3})
+	report, _ := m.Bid(Bid{Symbol: sym, Account: 1, Amount: 10, BidType: OrderTypeLimit, Price: 5})
+	id := report.OrderID
+	if accounts.held[1] != 0 {
+		t.Fatalf("Still held: %+v", accounts.held)
+	}
+	if accounts.accounts[1] != -30 || accounts.accounts[2] != 30 {
+		t.Fatalf("%+v", accounts.accounts)
+	}
+	if b, _ := m.FindBid(id); b.Held != 0 || b.IsActive() {
+		t.Fatalf("%+v", b)
+	}
+}
+
+func TestEscrowReleasesOnCancel(t *testing.T) {
+	accounts := makeMockAccounts()
+	m := MakeMarket(time.Now, MakeMemoryStorage(), accounts, WithEscrow(0))
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 4, OfferType: OrderTypeLimit, Price: 5})
+	report, _ := m.Bid(Bid{Symbol: sym, Account: 1, Amount: 10, BidType: OrderTypeLimit, Price: 5})
+	id := report.OrderID
+	if accounts.held[1] != 30 {
+		t.Fatalf("%+v", accounts.held)
+	}
+	if err := m.CancelBid(1, id); err != nil {
+		t.Fatal(err)
+	}
+	if accounts.held[1] != 0 || accounts.accounts[1] != -20 {
+		t.Fatalf("%+v %+v", accounts.held, accounts.accounts)
+	}
+}
+
+func TestEscrowAmendAdjustsHold(t *testing.T) {
+	accounts := makeMockAccounts()
+	m := MakeMarket(time.Now, MakeMemoryStorage(), accounts, WithEscrow(0))
+	report, _ := m.Bid(Bid{Symbol: sym, Account: 1, Amount: 10, BidType: OrderTypeLimit, Price: 5})
+	id := report.OrderID
+	if err := m.AmendBid(1, id, 4, 5); err != nil {
+		t.Fatal(err)
+	}
+	if accounts.held[1] != 20 {
+		t.Fatalf("%+v", accounts.held)
+	}
+	accounts.rejects[1] = true
+	if err := m.AmendBid(1, id, 10, 10); err != ErrInsufficientFunds {
+		t.Fatalf("Expected ErrInsufficientFunds, got %v", err)
+	}
+	if b, _ := m.FindBid(id); b.Price != 4 || b.Amount != 5 {
+		t.Fatalf("%+v", b)
+	}
+}
+
+func TestEscrowAmendFailureKeepsHold(t *testing.T) {
+	accounts := makeMockAccounts()
+	m := MakeMarket(time.Now, failingUpdates{MakeMemoryStorage()}, accounts, WithEscrow(0))
+	report, err := m.Bid(Bid{Symbol: sym, Account: 1, Amount: 10, BidType: OrderTypeLimit, Price: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, amount := range []int64{20, 4} {
+		if err = m.AmendBid(1, report.OrderID, 5, amount); err == nil {
+			t.Fatal("Expected the update to fail")
+		}
+		if accounts.held[1] != 50 || accounts.accounts[1] != -50 {
+			t.Fatalf("Amending to %d: %+v %+v", amount, accounts.held, accounts.accounts)
+		}
+	}
+}
+
+func TestEscrowDebitsShortfallForMarketBid(t *testing.T) {
+	accounts := makeMockAccounts()
+	storage := MakeMemoryStorage()
+	storage.SetLastPrice(sym, 10)
+	m := MakeMarket(time.Now, storage, accounts, WithEscrow(0))
+	report, _ := m.Bid(Bid{Symbol: sym, Account: 1, Amount: 10, BidType: OrderTypeMarket})
+	id := report.OrderID
+	storage.SetLastPrice(sym, 12)
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 10, OfferType: OrderTypeMarket})
+	if b, _ := m.FindBid(id); b.IsActive() || b.Held != 0 {
+		t.Fatalf("%+v", b)
+	}
+	if accounts.held[1] != 0 || accounts.accounts[1] != -120 {
+		t.Fatalf("%+v %+v", accounts.held, accounts.accounts)
+	}
+}
+
+// plainAccounts can't hold funds
+type plainAccounts struct {
+	accounts *mockAccounts
+}
+
+func (a plainAccounts) Credit(accountID, funds int64) {
+	a.accounts.Credit(accountID, funds)
+}
+
+func (a plainAccounts) DebitIfPossible(accountID, funds int64) bool {
+	return a.accounts.DebitIfPossible(accountID, funds)
+}
+
+func TestEscrowNeedsEscrowAccounts(t *testing.T) {
+	m := MakeMarket(time.Now, MakeMemoryStorage(), plainAccounts{makeMockAccounts()}, WithEscrow(0))
+	if _, err := m.Bid(Bid{Symbol: sym, Account: 1, Amount: 10, BidType: OrderTypeLimit, Price: 5}); err != ErrEscrowUnsupported {
+		t.Fatalf("Expected ErrEscrowUnsupported, got %v", err)
+	}
+	if _, err := m.Offer(Offer{Symbol: sym, Account: 2, Amount: 10, OfferType: OrderTypeLimit, Price: 5}); err != nil {
+		t.Fatal(err)
+	}
+}
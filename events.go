@@ -0,0 +1,208 @@
+package economy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type EventType byte
+
+const (
+	// EventOrderAccepted is sent when a bid or offer is
+	// added to the market, before any attempt to fill it
+	EventOrderAccepted EventType = 0
+	// EventPartiallyFilled is sent for each side of a fill
+	// that leaves part of the order unfilled
+	EventPartiallyFilled EventType = 1
+	// EventFilled is sent for each side of a fill that
+	// completes the order
+	EventFilled EventType = 2
+	// EventNSF is sent when a bid can't be paid for and
+	// is marked NSF
+	EventNSF EventType = 3
+	// EventCancelled is sent when an order is cancelled
+	EventCancelled EventType = 4
+	// EventAmended is sent when an order's price or amount
+	// is changed, before any attempt to fill it
+	EventAmended EventType = 5
+	// EventLastPrice is sent when a fill changes the last
+	// price of a symbol
+	EventLastPrice EventType = 6
+	// EventExpired is sent when a good till date order is
+	// cancelled because its time has passed
+	EventExpired EventType = 7
+	// EventTriggered is sent when the last price reaches a
+	// stop order's StopPrice and it becomes a market or
+	// limit order, before any attempt to fill it
+	EventTriggered EventType = 8
+	// EventHalted is sent when trading in a symbol halts.
+	// Price is the trade that would have been outside the
+	// band, or zero if the halt was called with Halt.
+	EventHalted EventType = 9
+	// EventResumed is sent when trading in a symbol
+	// resumes. Price is its new reference price.
+	EventResumed EventType = 10
+	// EventAuctionStarted is sent when a symbol starts
+	// collecting orders for a call auction
+	EventAuctionStarted EventType = 11
+	// EventAuctionUncrossed is sent when an auction ends.
+	// Price is the clearing price and Amount the total
+	// traded, both zero if nothing crossed.
+	EventAuctionUncrossed EventType = 12
+)
+
+type Side byte
+
+const (
+	SideBid   Side = 0
+	SideOffer Side = 1
+)
+
+// Event describes something that happened in a Market
+type Event struct {
+	Type   EventType
+	Date   time.Time
+	Symbol string
+	// Side, OrderID and Account identify the order the
+	// event is about. They are not set for EventLastPrice.
+	Side    Side
+	OrderID uuid.UUID
+	Account int64
+	// CounterID is the order on the other side of a fill
+	CounterID uuid.UUID
+	// Price is the price of a fill or the new last price
+	Price int64
+	// Amount is the amount traded by a fill
+	Amount int64
+	// Remaining is the amount of the order still unfilled
+	Remaining int64
+}
+
+// publisher delivers events to subscribers. Subscribers
+// are called while the market's storage is locked, so they
+// must not call back into the Market.
+type publisher struct {
+	mutex       sync.Mutex
+	subscribers []subscriber
+	next        int
+}
+
+type subscriber struct {
+	id int
+	f  func(Event)
+}
+
+func (p *publisher) subscribe(f func(Event)) func() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	id := p.next
+	p.next++
+	p.subscribers = append(p.subscribers, subscriber{id: id, f: f})
+	return func() {
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+		for i, s := range p.subscribers {
+			if s.id == id {
+				p.subscribers = append(p.subscribers[:i:i], p.subscribers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+func (p *publisher) publish(e Event) {
+	if p == nil {
+		return
+	}
+	p.mutex.Lock()
+	subscribers := p.subscribers
+	p.mutex.Unlock()
+	for _, s := range subscribers {
+		s.f(e)
+	}
+}
+
+func bidEvent(t EventType, ts time.Time, b Bid) Event {
+	return Event{
+		Type:      t,
+		Date:      ts,
+		Symbol:    b.Symbol,
+		Side:      SideBid,
+		OrderID:   b.ID,
+		Account:   b.Account,
+		Price:     b.Price,
+		Remaining: b.Amount,
+	}
+}
+
+func offerEvent(t EventType, ts time.Time, o Offer) Event {
+	return Event{
+		Type:      t,
+		Date:      ts,
+		Symbol:    o.Symbol,
+		Side:      SideOffer,
+		OrderID:   o.ID,
+		Account:   o.Account,
+		Price:     o.Price,
+		Remaining: o.Amount,
+	}
+}
+
+// publishFill sends the events for both sides of a fill
+func (p *publisher) publishFill(ts time.Time, bid Bid, off Offer, price, amount int64) {
+	e := bidEvent(EventPartiallyFilled, ts, bid)
+	if bid.Amount == 0 {
+		e.Type = EventFilled
+	}
+	e.CounterID, e.Price, e.Amount = off.ID, price, amount
+	p.publish(e)
+	e = offerEvent(EventPartiallyFilled, ts, off)
+	if off.Amount == 0 {
+		e.Type = EventFilled
+	}
+	e.CounterID, e.Price, e.Amount = bid.ID, price, amount
+	p.publish(e)
+}
+
+// Subscribe arranges for f to be called with every event
+// in the Market and returns a function that cancels the
+// subscription. f is called synchronously while the
+// Market is locked, so it must return quickly and must
+// not call any Market methods.
+func (m *Market) Subscribe(f func(Event)) func() {
+	return m.settlement.events.subscribe(f)
+}
+
+// SubscribeChannel returns a channel that receives every
+// event in the Market, and a function that cancels the
+// subscription and closes the channel. Events are dropped
+// rather than stalling the Market when the channel's
+// buffer is full.
+func (m *Market) SubscribeChannel(buffer int) (<-chan Event, func()) {
+	c := make(chan Event, buffer)
+	var once sync.Once
+	var mutex sync.Mutex
+	closed := false
+	unsubscribe := m.Subscribe(func(e Event) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if closed {
+			return
+		}
+		select {
+		case c <- e:
+		default:
+		}
+	})
+	return c, func() {
+		once.Do(func() {
+			unsubscribe()
+			mutex.Lock()
+			defer mutex.Unlock()
+			closed = true
+			close(c)
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package economy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventsForFill(t *testing.T) {
+	m := MakeMarket(time.Now, MakeMemoryStorage(), makeMockAccounts())
+	var events []Event
+	m.Subscribe(func(e Event) { events = append(events, e) })
+	report, _ := m.Offer(Offer{Symbol: sym, Account: 2, Amount: 10, OfferType: OrderTypeLimit, Price: 5})
+	offerID := report.OrderID
+	report, _ = m.Bid(Bid{Symbol: sym, Account: 1, Amount: 4, BidType: OrderTypeLimit, Price: 5})
+	bidID := report.OrderID
+	expected := []Event{
+		{Type: EventOrderAccepted, Side: SideOffer, OrderID: offerID, Remaining: 10},
+		{Type: EventOrderAccepted, Side: SideBid, OrderID: bidID, Remaining: 4},
+		{Type: EventFilled, Side: SideBid, OrderID: bidID, CounterID: offerID, Amount: 4},
+		{Type: EventPartiallyFilled, Side: SideOffer, OrderID: offerID, CounterID: bidID, Amount: 4, Remaining: 6},
+		{Type: EventLastPrice, Price: 5},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("%+v", events)
+	}
+	for i, e := range expected {
+		r := events[i]
+		if r.Type != e.Type || r.Side != e.Side || r.OrderID != e.OrderID ||
+			r.CounterID != e.CounterID || r.Amount != e.Amount || r.Remaining != e.Remaining {
+			t.Fatalf("%d: %+v != %+v", i, r, e)
+		}
+		if e.Type == EventLastPrice && r.Price != e.Price {
+			t.Fatalf("%d: %+v != %+v", i, r, e)
+		}
+	}
+}
+
+func TestEventsForNSF(t *testing.T) {
+	accounts := makeMockAccounts()
+	accounts.rejects[1] = true
+	m := MakeMarket(time.Now, MakeMemoryStorage(), accounts)
+	var events []Event
+	m.Subscribe(func(e Event) { events = append(events, e) })
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 10, OfferType: OrderTypeLimit, Price: 5})
+	report, _ := m.Bid(Bid{Symbol: sym, Account: 1, Amount: 4, BidType: OrderTypeLimit, Price: 5})
+	id := report.OrderID
+	last := events[len(events)-1]
+	if last.Type != EventNSF || last.OrderID != id {
+		t.Fatalf("%+v", events)
+	}
+}
+
+func TestEventsForCancel(t *testing.T) {
+	m := MakeMarket(time.Now, MakeMemoryStorage(), makeMockAccounts())
+	var events []Event
+	m.Subscribe(func(e Event) { events = append(events, e) })
+	report, _ := m.Offer(Offer{Symbol: sym, Account: 2, Amount: 10, OfferType: OrderTypeLimit, Price: 5})
+	id := report.OrderID
+	m.CancelOffer(2, id)
+	if len(events) != 2 || events[1].Type != EventCancelled || events[1].OrderID != id {
+		t.Fatalf("%+v", events)
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	m := MakeMarket(time.Now, MakeMemoryStorage(), makeMockAccounts())
+	count := 0
+	unsubscribe := m.Subscribe(func(e Event) { count++ })
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 10, OfferType: OrderTypeLimit, Price: 5})
+	unsubscribe()
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 10, OfferType: OrderTypeLimit, Price: 5})
+	if count != 1 {
+		t.Fatalf("%d events", count)
+	}
+}
+
+func TestSubscribeChannelDropsWhenFull(t *testing.T) {
+	m := MakeMarket(time.Now, MakeMemoryStorage(), makeMockAccounts())
+	c, unsubscribe := m.SubscribeChannel(1)
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 10, OfferType: OrderTypeLimit, Price: 5})
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 10, OfferType: OrderTypeLimit, Price: 6})
+	e := <-c
+	if e.Type != EventOrderAccepted || e.Price != 5 {
+		t.Fatalf("%+v", e)
+	}
+	unsubscribe()
+	if _, ok := <-c; ok {
+		t.Fatal("Channel not closed")
+	}
+}
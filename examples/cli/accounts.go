@@ -23,3 +23,7 @@ func (ma *accounts) DebitIfPossible(accountID, funds int64) bool {
 	ma.accounts[accountID] = cur - funds
 	return true
 }
+
+func (ma *accounts) CanDebit(accountID, funds int64) bool {
+	return ma.accounts[accountID] >= funds
+}
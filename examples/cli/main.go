@@ -13,16 +13,17 @@ import (
 	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/williammoran/economy"
 )
 
 const help = `
 Use this CLI to experiment with the market library.
 
-This program persists market data to CSV files in the
-current directory. Thus your market activity persists
-across multiple executions, unless you delete the
-CSV files.
+This program journals market data to the economy.journal
+directory under the current directory. Thus your market
+activity persists across multiple executions, unless you
+delete the directory.
 
 Commands are as follows:
 help - this message
@@ -38,12 +39,20 @@ bid $account $symbol $volume - Put in an order for $account
 bid $account $symbol $volume limit $price - Put in an
  order for $account to purchase $volume of $symbol at any
  price at or below $price
+cancel bid $account $id - Cancel the bid with $id
+cancel offer $account $id - Cancel the offer with $id
 market - List current prices of all known symbols
+trades $account - List the trades made by $account
+depth $symbol - Show the top price levels for $symbol
 `
 
 func main() {
 	accounts := makeAccounts()
 	storage := economy.MakeMemoryStorage()
+	if err := open(storage); err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
 	market := economy.MakeMarket(time.Now, storage, accounts)
 	reader := bufio.NewReader(os.Stdin)
 	for {
@@ -60,16 +69,17 @@ func main() {
 		case "accounts":
 			showAccounts(accounts)
 		case "bid":
-			load(storage)
 			bid(tokens[1:], market)
-			save(storage)
 		case "offer":
-			load(storage)
 			offer(tokens[1:], market)
-			save(storage)
+		case "cancel":
+			cancel(tokens[1:], market)
 		case "market":
-			load(storage)
 			showMarket(market)
+		case "trades":
+			showTrades(tokens[1:], market)
+		case "depth":
+			showDepth(tokens[1:], market)
 		default:
 			fmt.Printf("Unrecognized command '%s'\n", command)
 		}
@@ -130,8 +140,14 @@ func bid(c []string, market *economy.Market) {
 		Price:   price,
 		Amount:  volume,
 	}
-	market.Bid(bid)
+	report, err := market.Bid(bid)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	bid.ID = report.OrderID
 	fmt.Printf("Made bid %+v\n", bid)
+	showReport(report)
 }
 
 // offer $account $symbol $volume
@@ -148,8 +164,53 @@ func offer(c []string, market *economy.Market) {
 		Price:     price,
 		Amount:    volume,
 	}
-	market.Offer(offer)
+	report, err := market.Offer(offer)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	offer.ID = report.OrderID
 	fmt.Printf("Made offer %+v\n", offer)
+	showReport(report)
+}
+
+func showReport(report economy.ExecutionReport) {
+	for _, tx := range report.Transactions {
+		fmt.Printf("Traded %d at %d\n", tx.Amount, tx.Price)
+	}
+	fmt.Printf("Order is %s with %d remaining\n", report.Status, report.Remaining)
+}
+
+// cancel bid $account $id
+// cancel offer $account $id
+func cancel(c []string, market *economy.Market) {
+	if len(c) != 3 {
+		fmt.Printf("Invalid cancel %+v\n", c)
+		return
+	}
+	account, ok := parseAccount(c[1])
+	if !ok {
+		return
+	}
+	id, err := uuid.Parse(c[2])
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	switch strings.ToLower(c[0]) {
+	case "bid":
+		err = market.CancelBid(account, id)
+	case "offer":
+		err = market.CancelOffer(account, id)
+	default:
+		fmt.Printf("Can only cancel a bid or an offer, not '%s'\n", c[0])
+		return
+	}
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	fmt.Printf("Cancelled %s %s\n", c[0], id)
 }
 
 // $_ $account $symbol $volume
@@ -185,14 +246,80 @@ func parseBidOrOffer(c []string) (economy.OrderType, int64, string, int64, int64
 }
 
 func showMarket(market *economy.Market) {
-	symbols := market.AllSymbols()
+	symbols, err := market.Symbols()
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
 	fmt.Println("Symbol Last Price")
 	for _, symbol := range symbols {
-		price := market.LastPrice(symbol)
+		price, err := market.Price(symbol)
+		if err != nil {
+			fmt.Println(err.Error())
+			return
+		}
 		fmt.Printf("%6s %10d\n", symbol, price)
 	}
 }
 
+// trades $account
+func showTrades(c []string, market *economy.Market) {
+	if len(c) != 1 {
+		fmt.Printf("Invalid trades %+v\n", c)
+		return
+	}
+	account, ok := parseAccount(c[0])
+	if !ok {
+		return
+	}
+	txs, err := market.Transactions(
+		economy.TransactionFilter{Accounts: []int64{account}},
+	)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	fmt.Println("Date                 Symbol   Side    Amount     Price")
+	for _, tx := range txs {
+		side := "buy"
+		if tx.OfferAccount == account {
+			side = "sell"
+		}
+		fmt.Printf(
+			"%s %6s %6s %9d %9d\n",
+			tx.Date.Format("2006-01-02 15:04:05"), tx.Symbol, side, tx.Amount, tx.Price,
+		)
+	}
+}
+
+// depth $symbol
+func showDepth(c []string, market *economy.Market) {
+	if len(c) != 1 {
+		fmt.Printf("Invalid depth %+v\n", c)
+		return
+	}
+	depth, err := market.Depth(c[0], 10)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	fmt.Printf("%s last price %d\n", depth.Symbol, depth.LastPrice)
+	fmt.Println("Orders    Amount       Bid     Offer    Amount    Orders")
+	for i := 0; i < len(depth.Bids) || i < len(depth.Offers); i++ {
+		if i < len(depth.Bids) {
+			l := depth.Bids[i]
+			fmt.Printf("%6d %9d %9d", l.Orders, l.Amount, l.Price)
+		} else {
+			fmt.Printf("%26s", "")
+		}
+		if i < len(depth.Offers) {
+			l := depth.Offers[i]
+			fmt.Printf(" %9d %9d %9d", l.Price, l.Amount, l.Orders)
+		}
+		fmt.Println()
+	}
+}
+
 func parseAccount(in string) (int64, bool) {
 	return parseInt64(in, "Account ID must be an int64")
 }
@@ -215,7 +342,21 @@ func parseInt64(in, msg string) (int64, bool) {
 	return id, true
 }
 
-const filename = "economy.data"
+const (
+	journalDir = "economy.journal"
+	// filename is where earlier versions of this program
+	// saved the market
+	filename = "economy.data"
+)
+
+// open recovers the market from its journal, importing
+// data saved by earlier versions the first time
+func open(s *economy.MemoryStorage) error {
+	if _, err := os.Stat(journalDir); os.IsNotExist(err) {
+		load(s)
+	}
+	return s.OpenJournal(journalDir, economy.JournalOptions{CompactEvery: 1000})
+}
 
 func load(s *economy.MemoryStorage) {
 	f, err := os.Open(filename)
@@ -223,14 +364,7 @@ func load(s *economy.MemoryStorage) {
 		return
 	}
 	defer f.Close()
-	s.UnMarshal(f)
-}
-
-func save(s *economy.MemoryStorage) {
-	f, err := os.Create(filename)
-	if err != nil {
-		return
+	if err := s.UnMarshal(f); err != nil {
+		fmt.Println(err.Error())
 	}
-	defer f.Close()
-	s.Marshal(f)
 }
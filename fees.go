@@ -0,0 +1,89 @@
+package economy
+
+// FeeRates are the fees charged on each fill in a symbol.
+// The taker is the side whose order was being placed,
+// amended or triggered, and the maker is the order it
+// traded with.
+type FeeRates struct {
+	// MakerRate and TakerRate are charged on the value of
+	// each fill, in hundredths of a percent
+	MakerRate int64
+	TakerRate int64
+	// MakerFlat and TakerFlat are added to the fee for
+	// each fill
+	MakerFlat int64
+	TakerFlat int64
+	// Minimum is the least fee charged to either side of a
+	// fill
+	Minimum int64
+}
+
+func (r FeeRates) fee(value int64, taker bool) int64 {
+	var fee int64
+	if taker {
+		fee = value*r.TakerRate/10000 + r.TakerFlat
+	} else {
+		fee = value*r.MakerRate/10000 + r.MakerFlat
+	}
+	if fee < r.Minimum {
+		fee = r.Minimum
+	}
+	return fee
+}
+
+// FeeSchedule is the market tax. The buyer pays their fee
+// on top of the price, so it is included when deciding if
+// a bid is NSF. The seller's fee is taken from what they
+// are paid, and is never more than that.
+type FeeSchedule struct {
+	// House is the account that is credited with all fees
+	House int64
+	// Default applies to symbols that aren't in Symbols
+	Default FeeRates
+	Symbols map[string]FeeRates
+}
+
+// Rates returns the fees charged for the symbol
+func (f *FeeSchedule) Rates(symbol string) FeeRates {
+	if r, found := f.Symbols[symbol]; found {
+		return r
+	}
+	return f.Default
+}
+
+// charge returns the fees owed by each side of a fill
+// worth value
+func (f *FeeSchedule) charge(symbol string, value int64, taker Side) (int64, int64) {
+	if f == nil {
+		return 0, 0
+	}
+	r := f.Rates(symbol)
+	bidFee := r.fee(value, taker == SideBid)
+	offerFee := r.fee(value, taker == SideOffer)
+	if offerFee > value {
+		offerFee = value
+	}
+	return bidFee, offerFee
+}
+
+// maxFee returns the most a buyer could be charged for a
+// single fill worth value, for holding in escrow
+func (f *FeeSchedule) maxFee(symbol string, value int64) int64 {
+	if f == nil {
+		return 0
+	}
+	r := f.Rates(symbol)
+	maker, taker := r.fee(value, false), r.fee(value, true)
+	if maker > taker {
+		return maker
+	}
+	return taker
+}
+
+// WithFees makes the Market charge fees on every fill and
+// credit them to the schedule's house account
+func WithFees(f FeeSchedule) Option {
+	return func(m *Market) {
+		m.settlement.fees = &f
+	}
+}
@@ -0,0 +1,93 @@
+package economy
+
+import (
+	"testing"
+	"time"
+)
+
+const house = 99
+
+// strictAccounts rejects debits that would overdraw
+type strictAccounts struct {
+	*mockAccounts
+}
+
+func (a strictAccounts) DebitIfPossible(accountID, funds int64) bool {
+	if a.accounts[accountID] < funds {
+		return false
+	}
+	return a.mockAccounts.DebitIfPossible(accountID, funds)
+}
+
+func TestMakerTakerFees(t *testing.T) {
+	accounts := makeMockAccounts()
+	fees := FeeSchedule{
+		House:   house,
+		Default: FeeRates{MakerRate: 100, TakerRate: 200},
+		Symbols: map[string]FeeRates{"free": {}},
+	}
+	m := MakeMarket(time.Now, MakeMemoryStorage(), accounts, WithFees(fees))
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 10, OfferType: OrderTypeLimit, Price: 100})
+	report, _ := m.Bid(Bid{Symbol: sym, Account: 1, Amount: 10, BidType: OrderTypeLimit, Price: 100})
+	tx := report.Transactions[0]
+	if tx.BidFee != 20 || tx.OfferFee != 10 {
+		t.Fatalf("%+v", tx)
+	}
+	if accounts.accounts[1] != -1020 || accounts.accounts[2] != 990 || accounts.accounts[house] != 30 {
+		t.Fatalf("%+v", accounts.accounts)
+	}
+	m.Bid(Bid{Symbol: sym, Account: 1, Amount: 10, BidType: OrderTypeLimit, Price: 100})
+	report, _ = m.Offer(Offer{Symbol: sym, Account: 2, Amount: 10, OfferType: OrderTypeLimit, Price: 100})
+	tx = report.Transactions[0]
+	if tx.BidFee != 10 || tx.OfferFee != 20 {
+		t.Fatalf("Taker should be the seller: %+v", tx)
+	}
+	m.Offer(Offer{Symbol: "free", Account: 2, Amount: 1, OfferType: OrderTypeLimit, Price: 100})
+	report, _ = m.Bid(Bid{Symbol: "free", Account: 1, Amount: 1, BidType: OrderTypeLimit, Price: 100})
+	if tx = report.Transactions[0]; tx.BidFee != 0 || tx.OfferFee != 0 {
+		t.Fatalf("%+v", tx)
+	}
+}
+
+func TestFlatAndMinimumFees(t *testing.T) {
+	r := FeeRates{TakerRate: 10, TakerFlat: 3, MakerFlat: 1, Minimum: 2}
+	if fee := r.fee(1000, true); fee != 4 {
+		t.Fatalf("Taker fee %d", fee)
+	}
+	if fee := r.fee(1000, false); fee != 2 {
+		t.Fatalf("Maker fee %d", fee)
+	}
+	f := &FeeSchedule{Default: FeeRates{Minimum: 5}}
+	if bidFee, offerFee := f.charge(sym, 3, SideBid); bidFee != 5 || offerFee != 3 {
+		t.Fatalf("%d %d", bidFee, offerFee)
+	}
+}
+
+func TestFeesIncludedInNSF(t *testing.T) {
+	accounts := strictAccounts{makeMockAccounts()}
+	accounts.accounts[1] = 100
+	fees := FeeSchedule{House: house, Default: FeeRates{TakerFlat: 1}}
+	m := MakeMarket(time.Now, MakeMemoryStorage(), accounts, WithFees(fees))
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 10, OfferType: OrderTypeLimit, Price: 10})
+	report, _ := m.Bid(Bid{Symbol: sym, Account: 1, Amount: 10, BidType: OrderTypeLimit, Price: 10})
+	if report.Status != OrderStatusNSF {
+		t.Fatalf("%+v", report)
+	}
+	if accounts.accounts[1] != 100 || accounts.accounts[house] != 0 {
+		t.Fatalf("%+v", accounts.accounts)
+	}
+}
+
+func TestEscrowHoldsFees(t *testing.T) {
+	accounts := makeMockAccounts()
+	fees := FeeSchedule{House: house, Default: FeeRates{MakerRate: 100, TakerRate: 200}}
+	m := MakeMarket(time.Now, MakeMemoryStorage(), accounts, WithFees(fees), WithEscrow(0))
+	report, _ := m.Bid(Bid{Symbol: sym, Account: 1, Amount: 10, BidType: OrderTypeLimit, Price: 100})
+	if b, _ := m.FindBid(report.OrderID); b.Held != 1020 {
+		t.Fatalf("%+v", b)
+	}
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 10, OfferType: OrderTypeLimit, Price: 100})
+	if accounts.accounts[1] != -1010 || accounts.held[1] != 0 {
+		t.Fatalf("%+v %+v", accounts.accounts, accounts.held)
+	}
+}
@@ -0,0 +1,140 @@
+package fix
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/williammoran/economy"
+)
+
+// Client is a simple FIX session with a Gateway, for tests
+// and tools. It doesn't send heartbeats, so it logs on with
+// a HeartBtInt of zero.
+type Client struct {
+	conn *conn
+}
+
+// Dial connects to a Gateway at addr and logs on
+func Dial(addr, senderCompID, targetCompID string) (*Client, error) {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	client := &Client{conn: makeConn(c, time.Now, senderCompID)}
+	client.conn.target = targetCompID
+	err = client.Send(MakeMessage(MsgLogon).Set(TagEncryptMethod, "0").SetInt(TagHeartBtInt, 0))
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	m, err := client.Receive()
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	if m.Type() != MsgLogon {
+		c.Close()
+		text, _ := m.Get(TagText)
+		return nil, errors.New("logon refused: " + text)
+	}
+	return client, nil
+}
+
+// Send sends m with the next MsgSeqNum
+func (c *Client) Send(m Message) error {
+	return c.conn.send(m)
+}
+
+// Receive returns the next message from the Gateway. Test
+// requests are answered rather than returned.
+func (c *Client) Receive() (Message, error) {
+	for {
+		m, err := c.conn.receive()
+		if err != nil {
+			return nil, err
+		}
+		if m.Type() != MsgTestRequest {
+			return m, nil
+		}
+		id, _ := m.Get(TagTestReqID)
+		if err = c.Send(MakeMessage(MsgHeartbeat).Set(TagTestReqID, id)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// SetDeadline limits how long Send and Receive can take
+func (c *Client) SetDeadline(t time.Time) error {
+	return c.conn.net.SetDeadline(t)
+}
+
+// NewOrder sends a NewOrderSingle. A price of zero makes
+// it a market order.
+func (c *Client) NewOrder(clOrdID string, account int64, symbol string, side economy.Side, quantity, price int64) error {
+	m := MakeMessage(MsgNewOrderSingle).
+		Set(TagClOrdID, clOrdID).
+		SetInt(TagAccount, account).
+		Set(TagSymbol, symbol).
+		Set(TagSide, sideCode(side)).
+		SetInt(TagOrderQty, quantity)
+	if price == 0 {
+		m = m.Set(TagOrdType, "1")
+	} else {
+		m = m.Set(TagOrdType, "2").SetInt(TagPrice, price)
+	}
+	return c.Send(m)
+}
+
+// Cancel sends an OrderCancelRequest for the order sent
+// as origClOrdID
+func (c *Client) Cancel(clOrdID, origClOrdID string, symbol string, side economy.Side) error {
+	return c.Send(MakeMessage(MsgOrderCancelRequest).
+		Set(TagOrigClOrdID, origClOrdID).
+		Set(TagClOrdID, clOrdID).
+		Set(TagSymbol, symbol).
+		Set(TagSide, sideCode(side)))
+}
+
+// RequestMarketData asks for a snapshot of each symbol, to
+// depth price levels. A depth of zero is the full book.
+func (c *Client) RequestMarketData(reqID string, depth int64, symbols ...string) error {
+	m := MakeMessage(MsgMarketDataRequest).
+		Set(TagMDReqID, reqID).
+		Set(TagSubscriptionRequestType, "0").
+		SetInt(TagMarketDepth, depth).
+		SetInt(TagNoRelatedSym, int64(len(symbols)))
+	for _, s := range symbols {
+		m = m.Set(TagSymbol, s)
+	}
+	return c.Send(m)
+}
+
+// Logout ends the session and waits for the Gateway to
+// confirm
+func (c *Client) Logout() error {
+	if err := c.Send(MakeMessage(MsgLogout)); err != nil {
+		return err
+	}
+	for {
+		m, err := c.Receive()
+		if err != nil {
+			return err
+		}
+		if m.Type() == MsgLogout {
+			return nil
+		}
+	}
+}
+
+// Close disconnects
+func (c *Client) Close() error {
+	return c.conn.close()
+}
+
+func sideCode(s economy.Side) string {
+	if s == economy.SideOffer {
+		return "2"
+	}
+	return "1"
+}
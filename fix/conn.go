@@ -0,0 +1,85 @@
+package fix
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"time"
+)
+
+// writeTimeout limits how long a peer that doesn't read
+// can hold up its own session
+const writeTimeout = 10 * time.Second
+
+// conn is one end of a FIX session over a connection,
+// numbering the messages it sends
+type conn struct {
+	net    net.Conn
+	reader *bufio.Reader
+	now    func() time.Time
+	// sender and target are the CompIDs of this end and
+	// the other
+	sender string
+	target string
+	mutex  sync.Mutex
+	// nextOut is the MsgSeqNum of the next message sent,
+	// and nextIn the one expected next
+	nextOut  int64
+	nextIn   int64
+	lastSent time.Time
+}
+
+func makeConn(c net.Conn, now func() time.Time, sender string) *conn {
+	return &conn{
+		net:     c,
+		reader:  bufio.NewReader(c),
+		now:     now,
+		sender:  sender,
+		nextOut: 1,
+		nextIn:  1,
+	}
+}
+
+func (c *conn) send(m Message) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.write(m)
+}
+
+// write sends m with the mutex held
+func (c *conn) write(m Message) error {
+	ts := c.now()
+	data := m.encode(c.sender, c.target, c.nextOut, ts)
+	c.net.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := c.net.Write(data); err != nil {
+		return err
+	}
+	c.nextOut++
+	c.lastSent = ts
+	return nil
+}
+
+// idle returns how long it has been since a message was
+// sent
+func (c *conn) idle() time.Duration {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now().Sub(c.lastSent)
+}
+
+// sendReset answers a ResendRequest with a SequenceReset
+// to the number after its own, since sent messages aren't
+// kept
+func (c *conn) sendReset() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.write(MakeMessage(MsgSequenceReset).Set(TagGapFillFlag, "N").SetInt(TagNewSeqNo, c.nextOut+1))
+}
+
+func (c *conn) receive() (Message, error) {
+	return readMessage(c.reader)
+}
+
+func (c *conn) close() error {
+	return c.net.Close()
+}
@@ -0,0 +1,676 @@
+// Package fix is a gateway that lets trading bots reach a
+// Market over TCP with a subset of FIX 4.4:
+//
+//   - NewOrderSingle (D) places a bid for Side 1 or an
+//     offer for Side 2, as a market (OrdType 1) or limit
+//     (OrdType 2) order. TimeInForce 0 and 1 are good till
+//     cancelled, 3 is IOC and 4 is FOK.
+//   - OrderCancelRequest (F) cancels an order placed in the
+//     same session, by its OrigClOrdID.
+//   - ExecutionReport (8) is sent when an order is
+//     accepted, rejected, filled, cancelled or expires,
+//     including when it is filled by someone else's order.
+//   - MarketDataRequest (V) is answered with a
+//     MarketDataSnapshotFullRefresh (W) per symbol, with
+//     the bid and offer levels and the last trade price.
+//     Only snapshots are supported.
+//
+// The session layer handles Logon, Heartbeat, TestRequest,
+// ResendRequest, SequenceReset, Reject and Logout. Every
+// connection is a new session whose sequence numbers start
+// at 1. Messages aren't kept for resending, so a
+// ResendRequest is answered by resetting the sequence
+// number. Prices and quantities are whole numbers, as they
+// are in the Market. Accounts aren't authenticated.
+package fix
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/williammoran/economy"
+)
+
+// logonTimeout is how long a new connection has to log on
+const logonTimeout = 10 * time.Second
+
+// maxQueuedEvents is how many market events a session can
+// fall behind by before it is logged out
+const maxQueuedEvents = 10000
+
+// ErrGatewayClosed is returned by Serve once the Gateway
+// has been closed
+var ErrGatewayClosed = errors.New("gateway closed")
+
+// Gateway accepts FIX sessions for a Market
+type Gateway struct {
+	market    *economy.Market
+	compID    string
+	mutex     sync.Mutex
+	listeners map[net.Listener]bool
+	sessions  map[*session]bool
+	closed    bool
+}
+
+// MakeGateway returns a Gateway for m, which sessions must
+// log on to with compID as their TargetCompID
+func MakeGateway(m *economy.Market, compID string) *Gateway {
+	return &Gateway{
+		market:    m,
+		compID:    compID,
+		listeners: make(map[net.Listener]bool),
+		sessions:  make(map[*session]bool),
+	}
+}
+
+// ListenAndServe listens on the TCP address and serves
+// sessions until the Gateway is closed
+func (g *Gateway) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return g.Serve(l)
+}
+
+// Serve accepts connections on l and runs a session for
+// each of them until the Gateway is closed, when it returns
+// ErrGatewayClosed
+func (g *Gateway) Serve(l net.Listener) error {
+	g.mutex.Lock()
+	if g.closed {
+		g.mutex.Unlock()
+		l.Close()
+		return ErrGatewayClosed
+	}
+	g.listeners[l] = true
+	g.mutex.Unlock()
+	defer func() {
+		g.mutex.Lock()
+		defer g.mutex.Unlock()
+		delete(g.listeners, l)
+	}()
+	for {
+		c, err := l.Accept()
+		g.mutex.Lock()
+		closed := g.closed
+		if err == nil && !closed {
+			s := makeSession(g, c)
+			g.sessions[s] = true
+			go s.run()
+		}
+		g.mutex.Unlock()
+		if closed {
+			if c != nil {
+				c.Close()
+			}
+			return ErrGatewayClosed
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Close stops the Gateway's listeners and disconnects all
+// its sessions
+func (g *Gateway) Close() error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.closed = true
+	for l := range g.listeners {
+		l.Close()
+	}
+	for s := range g.sessions {
+		s.conn.close()
+	}
+	return nil
+}
+
+func (g *Gateway) remove(s *session) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	delete(g.sessions, s)
+}
+
+// session is one logged on connection
+type session struct {
+	gateway    *Gateway
+	conn       *conn
+	heartBtInt time.Duration
+	// mutex is held while an order message is handled and
+	// while events are reported, so that the session knows
+	// about an order before reporting any of its events
+	mutex    sync.Mutex
+	orders   map[uuid.UUID]*order
+	clOrdIDs map[string]uuid.UUID
+	events   eventQueue
+	done     chan struct{}
+}
+
+// order is an order placed by the session that is still
+// active
+type order struct {
+	id      uuid.UUID
+	clOrdID string
+	// cancelClOrdID is the ClOrdID of a cancel request
+	// being made for the order
+	cancelClOrdID string
+	side          economy.Side
+	symbol        string
+	account       int64
+	quantity      int64
+	// filled and value are the total amount and cost of
+	// its fills
+	filled int64
+	value  int64
+}
+
+// eventQueue holds market events until the session can
+// report them. The Market publishes events with its
+// storage locked, so they are queued without waiting for
+// the session. Once limit events are waiting the queue
+// overflows and drops them, and the session is logged out.
+type eventQueue struct {
+	mutex    sync.Mutex
+	events   []economy.Event
+	limit    int
+	overflow bool
+	signal   chan struct{}
+}
+
+func (q *eventQueue) push(e economy.Event) {
+	q.mutex.Lock()
+	switch {
+	case q.overflow:
+	case len(q.events) >= q.limit:
+		q.events, q.overflow = nil, true
+	default:
+		q.events = append(q.events, e)
+	}
+	q.mutex.Unlock()
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+}
+
+// take returns the queued events, and true if the queue
+// has overflowed
+func (q *eventQueue) take() ([]economy.Event, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	events := q.events
+	q.events = nil
+	return events, q.overflow
+}
+
+func makeSession(g *Gateway, c net.Conn) *session {
+	return &session{
+		gateway:  g,
+		conn:     makeConn(c, time.Now, g.compID),
+		orders:   make(map[uuid.UUID]*order),
+		clOrdIDs: make(map[string]uuid.UUID),
+		events:   eventQueue{limit: maxQueuedEvents, signal: make(chan struct{}, 1)},
+		done:     make(chan struct{}),
+	}
+}
+
+func (s *session) run() {
+	defer s.gateway.remove(s)
+	defer s.conn.close()
+	if err := s.logon(); err != nil {
+		return
+	}
+	unsubscribe := s.gateway.market.Subscribe(s.events.push)
+	defer unsubscribe()
+	go s.report()
+	defer close(s.done)
+	for {
+		if s.heartBtInt > 0 {
+			s.conn.net.SetReadDeadline(time.Now().Add(2*s.heartBtInt + time.Second))
+		}
+		m, err := s.conn.receive()
+		if err != nil {
+			return
+		}
+		process, ok := s.sequence(m)
+		if !ok {
+			return
+		}
+		if process && !s.handle(m) {
+			return
+		}
+	}
+}
+
+func (s *session) logon() error {
+	s.conn.net.SetReadDeadline(time.Now().Add(logonTimeout))
+	m, err := s.conn.receive()
+	if err != nil {
+		return err
+	}
+	s.conn.target, _ = m.Get(TagSenderCompID)
+	fail := func(text string) error {
+		s.conn.send(MakeMessage(MsgLogout).Set(TagText, text))
+		return errors.New(text)
+	}
+	if m.Type() != MsgLogon {
+		return fail("first message must be Logon")
+	}
+	if s.conn.target == "" {
+		return fail("SenderCompID is required")
+	}
+	if target, _ := m.Get(TagTargetCompID); target != s.gateway.compID {
+		return fail("unknown TargetCompID")
+	}
+	if seq, err := m.Int(TagMsgSeqNum); err != nil || seq != 1 {
+		return fail("MsgSeqNum must be 1")
+	}
+	heartBtInt, err := m.Int(TagHeartBtInt)
+	if err != nil || heartBtInt < 0 {
+		return fail("HeartBtInt is required")
+	}
+	s.heartBtInt = time.Duration(heartBtInt) * time.Second
+	s.conn.nextIn = 2
+	s.conn.net.SetReadDeadline(time.Time{})
+	return s.conn.send(MakeMessage(MsgLogon).Set(TagEncryptMethod, "0").SetInt(TagHeartBtInt, heartBtInt))
+}
+
+// sequence checks the MsgSeqNum of m. It returns true if m
+// should be processed, and false for ok if the session
+// must end.
+func (s *session) sequence(m Message) (bool, bool) {
+	seq, err := m.Int(TagMsgSeqNum)
+	if err != nil {
+		s.conn.send(MakeMessage(MsgLogout).Set(TagText, "MsgSeqNum is required"))
+		return false, false
+	}
+	if m.Type() == MsgSequenceReset {
+		if next, err := m.Int(TagNewSeqNo); err == nil && next > s.conn.nextIn {
+			s.conn.nextIn = next
+		}
+		return false, true
+	}
+	switch {
+	case seq == s.conn.nextIn:
+		s.conn.nextIn++
+		return true, true
+	case seq < s.conn.nextIn:
+		if dup, _ := m.Get(TagPossDupFlag); dup == "Y" {
+			return false, true
+		}
+		text := "MsgSeqNum too low, expecting " + strconv.FormatInt(s.conn.nextIn, 10)
+		s.conn.send(MakeMessage(MsgLogout).Set(TagText, text))
+		return false, false
+	}
+	s.conn.send(MakeMessage(MsgResendRequest).SetInt(TagBeginSeqNo, s.conn.nextIn).SetInt(TagEndSeqNo, 0))
+	return false, true
+}
+
+// handle processes m, and returns false if the session is
+// over
+func (s *session) handle(m Message) bool {
+	switch m.Type() {
+	case MsgHeartbeat:
+	case MsgTestRequest:
+		id, _ := m.Get(TagTestReqID)
+		s.conn.send(MakeMessage(MsgHeartbeat).Set(TagTestReqID, id))
+	case MsgResendRequest:
+		s.conn.sendReset()
+	case MsgLogout:
+		s.conn.send(MakeMessage(MsgLogout))
+		return false
+	case MsgNewOrderSingle:
+		s.newOrder(m)
+	case MsgOrderCancelRequest:
+		s.cancelOrder(m)
+	case MsgMarketDataRequest:
+		s.marketData(m)
+	default:
+		s.reject(m, 11, "unsupported MsgType")
+	}
+	return true
+}
+
+// reject sends a session level Reject for m
+func (s *session) reject(m Message, reason int64, text string) {
+	seq, _ := m.Get(TagMsgSeqNum)
+	s.conn.send(MakeMessage(MsgReject).
+		Set(TagRefSeqNum, seq).
+		Set(TagRefMsgType, m.Type()).
+		SetInt(TagSessionRejectReason, reason).
+		Set(TagText, text))
+}
+
+func (s *session) newOrder(m Message) {
+	clOrdID, _ := m.Get(TagClOrdID)
+	if clOrdID == "" {
+		s.reject(m, 1, "ClOrdID is required")
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, found := s.clOrdIDs[clOrdID]; found {
+		s.rejectOrder(m, "duplicate ClOrdID")
+		return
+	}
+	o, bid, err := parseOrder(m)
+	if err != nil {
+		s.rejectOrder(m, err.Error())
+		return
+	}
+	var report economy.ExecutionReport
+	if o.side == economy.SideBid {
+		report, err = s.gateway.market.Bid(bid)
+	} else {
+		report, err = s.gateway.market.Offer(economy.Offer{
+			OfferType:   bid.BidType,
+			Account:     bid.Account,
+			Symbol:      bid.Symbol,
+			Price:       bid.Price,
+			Amount:      bid.Amount,
+			TimeInForce: bid.TimeInForce,
+		})
+	}
+	if err != nil {
+		s.rejectOrder(m, err.Error())
+		return
+	}
+	// The events for the order have been queued, and are
+	// reported once the session knows about it
+	o.id = report.OrderID
+	s.orders[o.id] = o
+	s.clOrdIDs[o.clOrdID] = o.id
+}
+
+// parseOrder returns the order a NewOrderSingle asks for,
+// as a bid whatever its side
+func parseOrder(m Message) (*order, economy.Bid, error) {
+	o := &order{}
+	o.clOrdID, _ = m.Get(TagClOrdID)
+	var b economy.Bid
+	var err error
+	switch side, _ := m.Get(TagSide); side {
+	case "1":
+		o.side = economy.SideBid
+	case "2":
+		o.side = economy.SideOffer
+	default:
+		return o, b, errors.New("Side must be 1 or 2")
+	}
+	if b.Account, err = m.Int(TagAccount); err != nil {
+		return o, b, err
+	}
+	if b.Symbol, _ = m.Get(TagSymbol); b.Symbol == "" {
+		return o, b, errors.New("Symbol is required")
+	}
+	if b.Amount, err = m.Int(TagOrderQty); err != nil {
+		return o, b, err
+	}
+	if b.Amount < 1 {
+		return o, b, errors.New("OrderQty must be greater than zero")
+	}
+	switch ordType, _ := m.Get(TagOrdType); ordType {
+	case "1":
+		b.BidType = economy.OrderTypeMarket
+	case "2":
+		b.BidType = economy.OrderTypeLimit
+		if b.Price, err = m.Int(TagPrice); err != nil {
+			return o, b, err
+		}
+		if b.Price < 1 {
+			return o, b, errors.New("Price must be greater than zero")
+		}
+	default:
+		return o, b, errors.New("OrdType must be 1 or 2")
+	}
+	switch tif, _ := m.Get(TagTimeInForce); tif {
+	case "", "0", "1":
+		b.TimeInForce = economy.TimeInForceGTC
+	case "3":
+		b.TimeInForce = economy.TimeInForceIOC
+	case "4":
+		b.TimeInForce = economy.TimeInForceFOK
+	default:
+		return o, b, errors.New("TimeInForce must be 0, 1, 3 or 4")
+	}
+	o.symbol, o.account, o.quantity = b.Symbol, b.Account, b.Amount
+	return o, b, nil
+}
+
+// rejectOrder sends an ExecutionReport rejecting a
+// NewOrderSingle
+func (s *session) rejectOrder(m Message, text string) {
+	clOrdID, _ := m.Get(TagClOrdID)
+	symbol, _ := m.Get(TagSymbol)
+	side, _ := m.Get(TagSide)
+	s.conn.send(MakeMessage(MsgExecutionReport).
+		Set(TagOrderID, "NONE").
+		Set(TagClOrdID, clOrdID).
+		Set(TagExecID, uuid.NewString()).
+		Set(TagExecType, "8").
+		Set(TagOrdStatus, "8").
+		Set(TagSymbol, symbol).
+		Set(TagSide, side).
+		Set(TagLeavesQty, "0").
+		Set(TagCumQty, "0").
+		Set(TagAvgPx, "0").
+		Set(TagText, text))
+}
+
+func (s *session) cancelOrder(m Message) {
+	clOrdID, _ := m.Get(TagClOrdID)
+	orig, _ := m.Get(TagOrigClOrdID)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	id, found := s.clOrdIDs[orig]
+	if !found {
+		s.rejectCancel(clOrdID, orig, nil, "1", "unknown order")
+		return
+	}
+	o := s.orders[id]
+	o.cancelClOrdID = clOrdID
+	var err error
+	if o.side == economy.SideBid {
+		err = s.gateway.market.CancelBid(o.account, id)
+	} else {
+		err = s.gateway.market.CancelOffer(o.account, id)
+	}
+	if err != nil {
+		o.cancelClOrdID = ""
+		reason := "99"
+		if err == economy.ErrOrderInactive {
+			reason = "0"
+		}
+		s.rejectCancel(clOrdID, orig, o, reason, err.Error())
+	}
+}
+
+// rejectCancel sends an OrderCancelReject. o is nil if the
+// order isn't known.
+func (s *session) rejectCancel(clOrdID, orig string, o *order, reason, text string) {
+	orderID, status := "NONE", "8"
+	if o != nil {
+		orderID, status = o.id.String(), o.status()
+	}
+	s.conn.send(MakeMessage(MsgOrderCancelReject).
+		Set(TagOrderID, orderID).
+		Set(TagClOrdID, clOrdID).
+		Set(TagOrigClOrdID, orig).
+		Set(TagOrdStatus, status).
+		Set(TagCxlRejResponseTo, "1").
+		Set(TagCxlRejReason, reason).
+		Set(TagText, text))
+}
+
+// status is the OrdStatus of an active order
+func (o *order) status() string {
+	if o.filled > 0 {
+		return "1"
+	}
+	return "0"
+}
+
+func (s *session) marketData(m Message) {
+	reqID, _ := m.Get(TagMDReqID)
+	if t, _ := m.Get(TagSubscriptionRequestType); t != "0" {
+		s.reject(m, 5, "only snapshots are supported")
+		return
+	}
+	var depth int64
+	if _, found := m.Get(TagMarketDepth); found {
+		var err error
+		if depth, err = m.Int(TagMarketDepth); err != nil {
+			s.reject(m, 6, err.Error())
+			return
+		}
+	}
+	symbols := m.All(TagSymbol)
+	if len(symbols) == 0 {
+		s.reject(m, 1, "Symbol is required")
+		return
+	}
+	for _, symbol := range symbols {
+		d, err := s.gateway.market.Depth(symbol, int(depth))
+		if err != nil {
+			s.reject(m, 99, err.Error())
+			return
+		}
+		// Storage has a last price for symbols that have
+		// never traded, so only report it after a trade
+		traded, err := s.gateway.market.Transactions(economy.TransactionFilter{Symbol: symbol, Limit: 1})
+		if err != nil {
+			s.reject(m, 99, err.Error())
+			return
+		}
+		entries := len(d.Bids) + len(d.Offers)
+		if len(traded) > 0 {
+			entries++
+		}
+		w := MakeMessage(MsgMarketDataSnapshotFullRefresh).
+			Set(TagMDReqID, reqID).
+			Set(TagSymbol, symbol).
+			SetInt(TagNoMDEntries, int64(entries))
+		for _, l := range d.Bids {
+			w = w.Set(TagMDEntryType, "0").SetInt(TagMDEntryPx, l.Price).SetInt(TagMDEntrySize, l.Amount)
+		}
+		for _, l := range d.Offers {
+			w = w.Set(TagMDEntryType, "1").SetInt(TagMDEntryPx, l.Price).SetInt(TagMDEntrySize, l.Amount)
+		}
+		if len(traded) > 0 {
+			w = w.Set(TagMDEntryType, "2").SetInt(TagMDEntryPx, d.LastPrice)
+		}
+		s.conn.send(w)
+	}
+}
+
+// report sends ExecutionReports for queued events, and
+// heartbeats when nothing else has been sent for a while
+func (s *session) report() {
+	var tick <-chan time.Time
+	if s.heartBtInt > 0 {
+		t := time.NewTicker(s.heartBtInt / 2)
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-tick:
+			if s.conn.idle() >= s.heartBtInt {
+				s.conn.send(MakeMessage(MsgHeartbeat))
+			}
+		case <-s.events.signal:
+			events, overflow := s.events.take()
+			if overflow {
+				s.conn.send(MakeMessage(MsgLogout).Set(TagText, "too many events queued"))
+				s.conn.close()
+				return
+			}
+			s.mutex.Lock()
+			for _, e := range events {
+				if err := s.reportEvent(e); err != nil {
+					s.conn.close()
+					break
+				}
+			}
+			s.mutex.Unlock()
+		}
+	}
+}
+
+// reportEvent sends an ExecutionReport if e is about one
+// of the session's orders
+func (s *session) reportEvent(e economy.Event) error {
+	o, found := s.orders[e.OrderID]
+	if !found {
+		return nil
+	}
+	clOrdID, leaves := o.clOrdID, e.Remaining
+	var execType, status, text string
+	var last economy.Event
+	finished := false
+	switch e.Type {
+	case economy.EventOrderAccepted:
+		execType, status = "0", "0"
+	case economy.EventPartiallyFilled, economy.EventFilled:
+		o.filled += e.Amount
+		o.value += e.Amount * e.Price
+		execType, status, last = "F", "1", e
+		if e.Type == economy.EventFilled {
+			status, finished = "2", true
+		}
+	case economy.EventAmended:
+		o.quantity = o.filled + e.Remaining
+		execType, status = "D", o.status()
+	case economy.EventCancelled, economy.EventNSF:
+		execType, status, finished = "4", "4", true
+		if o.cancelClOrdID != "" {
+			clOrdID = o.cancelClOrdID
+		}
+		if e.Type == economy.EventNSF {
+			text = "insufficient funds"
+		}
+	case economy.EventExpired:
+		execType, status, finished = "C", "C", true
+	default:
+		return nil
+	}
+	if finished {
+		leaves = 0
+		delete(s.orders, o.id)
+		delete(s.clOrdIDs, o.clOrdID)
+	}
+	avgPx := "0"
+	if o.filled > 0 {
+		avgPx = strconv.FormatFloat(float64(o.value)/float64(o.filled), 'f', -1, 64)
+	}
+	er := MakeMessage(MsgExecutionReport).
+		Set(TagOrderID, o.id.String()).
+		Set(TagClOrdID, clOrdID).
+		Set(TagExecID, uuid.NewString()).
+		Set(TagExecType, execType).
+		Set(TagOrdStatus, status).
+		Set(TagSymbol, o.symbol).
+		Set(TagSide, sideCode(o.side)).
+		SetInt(TagOrderQty, o.quantity).
+		SetInt(TagLeavesQty, leaves).
+		SetInt(TagCumQty, o.filled).
+		Set(TagAvgPx, avgPx)
+	if clOrdID != o.clOrdID {
+		er = er.Set(TagOrigClOrdID, o.clOrdID)
+	}
+	if last.Amount > 0 {
+		er = er.SetInt(TagLastPx, last.Price).SetInt(TagLastQty, last.Amount)
+	}
+	if text != "" {
+		er = er.Set(TagText, text)
+	}
+	return s.conn.send(er)
+}
@@ -0,0 +1,217 @@
+package fix
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/williammoran/economy"
+)
+
+type testAccounts map[int64]int64
+
+func (a testAccounts) Credit(accountID, funds int64) {
+	a[accountID] += funds
+}
+
+func (a testAccounts) DebitIfPossible(accountID, funds int64) bool {
+	if a[accountID] < funds {
+		return false
+	}
+	a[accountID] -= funds
+	return true
+}
+
+func makeTestGateway(t *testing.T) (string, *economy.Market) {
+	m := economy.MakeMarket(time.Now, economy.MakeMemoryStorage(), testAccounts{1: 100, 2: 100})
+	g := MakeGateway(m, "MARKET")
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go g.Serve(l)
+	t.Cleanup(func() { g.Close() })
+	return l.Addr().String(), m
+}
+
+func dial(t *testing.T, addr, sender string) *Client {
+	c, err := Dial(addr, sender, "MARKET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// expect receives the next message and checks its type and
+// fields
+func expect(t *testing.T, c *Client, msgType string, fields map[int]string) Message {
+	t.Helper()
+	m, err := c.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Type() != msgType {
+		t.Fatalf("Expected %s got %+v", msgType, m)
+	}
+	for tag, value := range fields {
+		if v, _ := m.Get(tag); v != value {
+			t.Fatalf("Tag %d expected %q got %q in %+v", tag, value, v, m)
+		}
+	}
+	return m
+}
+
+func TestGatewayLogonRefused(t *testing.T) {
+	addr, _ := makeTestGateway(t)
+	if _, err := Dial(addr, "BOT", "ELSEWHERE"); err == nil {
+		t.Fatal("Logged on to the wrong CompID")
+	}
+}
+
+func TestGatewayOrderFilled(t *testing.T) {
+	addr, _ := makeTestGateway(t)
+	seller := dial(t, addr, "SELLER")
+	buyer := dial(t, addr, "BUYER")
+	seller.NewOrder("s1", 2, "S", economy.SideOffer, 5, 10)
+	expect(t, seller, MsgExecutionReport, map[int]string{
+		TagClOrdID: "s1", TagExecType: "0", TagOrdStatus: "0", TagSide: "2", TagLeavesQty: "5",
+	})
+	buyer.NewOrder("b1", 1, "S", economy.SideBid, 2, 0)
+	expect(t, buyer, MsgExecutionReport, map[int]string{
+		TagClOrdID: "b1", TagExecType: "0",
+	})
+	expect(t, buyer, MsgExecutionReport, map[int]string{
+		TagClOrdID: "b1", TagExecType: "F", TagOrdStatus: "2",
+		TagLastPx: "10", TagLastQty: "2", TagCumQty: "2", TagLeavesQty: "0", TagAvgPx: "10",
+	})
+	expect(t, seller, MsgExecutionReport, map[int]string{
+		TagClOrdID: "s1", TagExecType: "F", TagOrdStatus: "1",
+		TagLastQty: "2", TagCumQty: "2", TagLeavesQty: "3",
+	})
+}
+
+func TestGatewayOrderRejected(t *testing.T) {
+	addr, _ := makeTestGateway(t)
+	c := dial(t, addr, "BOT")
+	c.NewOrder("1", 1, "S", economy.SideBid, 0, 10)
+	expect(t, c, MsgExecutionReport, map[int]string{
+		TagClOrdID: "1", TagOrderID: "NONE", TagExecType: "8", TagOrdStatus: "8",
+	})
+	c.NewOrder("2", 1, "S", economy.SideBid, 1, 10)
+	expect(t, c, MsgExecutionReport, map[int]string{TagClOrdID: "2", TagExecType: "0"})
+	c.NewOrder("2", 1, "S", economy.SideBid, 1, 10)
+	expect(t, c, MsgExecutionReport, map[int]string{
+		TagClOrdID: "2", TagExecType: "8", TagText: "duplicate ClOrdID",
+	})
+}
+
+func TestGatewayCancel(t *testing.T) {
+	addr, m := makeTestGateway(t)
+	c := dial(t, addr, "BOT")
+	c.NewOrder("1", 1, "S", economy.SideBid, 5, 10)
+	er := expect(t, c, MsgExecutionReport, map[int]string{TagExecType: "0"})
+	c.Cancel("2", "1", "S", economy.SideBid)
+	expect(t, c, MsgExecutionReport, map[int]string{
+		TagClOrdID: "2", TagOrigClOrdID: "1", TagExecType: "4", TagOrdStatus: "4", TagLeavesQty: "0",
+	})
+	orderID, _ := er.Get(TagOrderID)
+	if bid, _ := m.FindBid(uuid.MustParse(orderID)); !bid.Cancelled {
+		t.Fatalf("%+v", bid)
+	}
+	c.Cancel("3", "1", "S", economy.SideBid)
+	expect(t, c, MsgOrderCancelReject, map[int]string{
+		TagClOrdID: "3", TagOrigClOrdID: "1", TagCxlRejReason: "1", TagCxlRejResponseTo: "1",
+	})
+}
+
+func TestGatewayMarketData(t *testing.T) {
+	addr, m := makeTestGateway(t)
+	m.Offer(economy.Offer{Symbol: "S", Account: 2, Amount: 5, OfferType: economy.OrderTypeLimit, Price: 10})
+	m.Offer(economy.Offer{Symbol: "S", Account: 2, Amount: 5, OfferType: economy.OrderTypeLimit, Price: 12})
+	m.Bid(economy.Bid{Symbol: "S", Account: 1, Amount: 2, BidType: economy.OrderTypeLimit, Price: 10})
+	m.Bid(economy.Bid{Symbol: "S", Account: 1, Amount: 4, BidType: economy.OrderTypeLimit, Price: 8})
+	c := dial(t, addr, "BOT")
+	c.RequestMarketData("r", 1, "S")
+	w := expect(t, c, MsgMarketDataSnapshotFullRefresh, map[int]string{
+		TagMDReqID: "r", TagSymbol: "S", TagNoMDEntries: "3",
+	})
+	types, prices, sizes := w.All(TagMDEntryType), w.All(TagMDEntryPx), w.All(TagMDEntrySize)
+	if len(types) != 3 || types[0] != "0" || prices[0] != "8" || sizes[0] != "4" ||
+		types[1] != "1" || prices[1] != "10" || sizes[1] != "3" ||
+		types[2] != "2" || prices[2] != "10" {
+		t.Fatalf("%+v", w)
+	}
+}
+
+func TestGatewayMarketDataBeforeTrading(t *testing.T) {
+	addr, m := makeTestGateway(t)
+	m.Offer(economy.Offer{Symbol: "S", Account: 2, Amount: 5, OfferType: economy.OrderTypeLimit, Price: 10})
+	c := dial(t, addr, "BOT")
+	c.RequestMarketData("r", 1, "S")
+	w := expect(t, c, MsgMarketDataSnapshotFullRefresh, map[int]string{
+		TagMDReqID: "r", TagSymbol: "S", TagNoMDEntries: "1",
+	})
+	if types := w.All(TagMDEntryType); len(types) != 1 || types[0] != "1" {
+		t.Fatalf("%+v", w)
+	}
+}
+
+func TestEventQueueOverflow(t *testing.T) {
+	q := eventQueue{limit: 2, signal: make(chan struct{}, 1)}
+	for i := 0; i < 3; i++ {
+		q.push(economy.Event{Type: economy.EventOrderAccepted})
+	}
+	events, overflow := q.take()
+	if len(events) != 0 || !overflow {
+		t.Fatalf("Expected overflow got %d events", len(events))
+	}
+	q.push(economy.Event{Type: economy.EventOrderAccepted})
+	if events, _ := q.take(); len(events) != 0 {
+		t.Fatalf("Expected no events after overflow got %d", len(events))
+	}
+}
+
+func TestGatewayTestRequest(t *testing.T) {
+	addr, _ := makeTestGateway(t)
+	c := dial(t, addr, "BOT")
+	c.Send(MakeMessage(MsgTestRequest).Set(TagTestReqID, "ping"))
+	expect(t, c, MsgHeartbeat, map[int]string{TagTestReqID: "ping"})
+	if err := c.Logout(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGatewaySequenceGap(t *testing.T) {
+	addr, _ := makeTestGateway(t)
+	c := dial(t, addr, "BOT")
+	c.conn.nextOut = 5
+	c.Send(MakeMessage(MsgHeartbeat))
+	expect(t, c, MsgResendRequest, map[int]string{TagBeginSeqNo: "2", TagEndSeqNo: "0"})
+	c.Send(MakeMessage(MsgSequenceReset).Set(TagGapFillFlag, "N").SetInt(TagNewSeqNo, 7))
+	c.Send(MakeMessage(MsgTestRequest).Set(TagTestReqID, "after"))
+	expect(t, c, MsgHeartbeat, map[int]string{TagTestReqID: "after"})
+}
+
+func TestGatewaySequenceTooLow(t *testing.T) {
+	addr, _ := makeTestGateway(t)
+	c := dial(t, addr, "BOT")
+	c.conn.nextOut = 1
+	c.Send(MakeMessage(MsgHeartbeat).Set(TagPossDupFlag, "Y"))
+	c.conn.nextOut = 1
+	c.Send(MakeMessage(MsgHeartbeat))
+	expect(t, c, MsgLogout, nil)
+}
+
+func TestGatewayResendRequest(t *testing.T) {
+	addr, _ := makeTestGateway(t)
+	c := dial(t, addr, "BOT")
+	c.Send(MakeMessage(MsgResendRequest).SetInt(TagBeginSeqNo, 1).SetInt(TagEndSeqNo, 0))
+	m := expect(t, c, MsgSequenceReset, map[int]string{TagGapFillFlag: "N"})
+	seq, _ := m.Int(TagMsgSeqNum)
+	if next, _ := m.Int(TagNewSeqNo); next != seq+1 {
+		t.Fatalf("%+v", m)
+	}
+}
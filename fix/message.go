@@ -0,0 +1,277 @@
+package fix
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// BeginString is the protocol version spoken
+const BeginString = "FIX.4.4"
+
+const soh = '\x01'
+
+// Tags used by the gateway
+const (
+	TagAccount                 = 1
+	TagAvgPx                   = 6
+	TagBeginSeqNo              = 7
+	TagBeginString             = 8
+	TagBodyLength              = 9
+	TagCheckSum                = 10
+	TagClOrdID                 = 11
+	TagCumQty                  = 14
+	TagEndSeqNo                = 16
+	TagExecID                  = 17
+	TagLastPx                  = 31
+	TagLastQty                 = 32
+	TagMsgSeqNum               = 34
+	TagMsgType                 = 35
+	TagNewSeqNo                = 36
+	TagOrderID                 = 37
+	TagOrderQty                = 38
+	TagOrdStatus               = 39
+	TagOrdType                 = 40
+	TagOrigClOrdID             = 41
+	TagPossDupFlag             = 43
+	TagPrice                   = 44
+	TagRefSeqNum               = 45
+	TagSenderCompID            = 49
+	TagSendingTime             = 52
+	TagSide                    = 54
+	TagSymbol                  = 55
+	TagTargetCompID            = 56
+	TagText                    = 58
+	TagTimeInForce             = 59
+	TagEncryptMethod           = 98
+	TagCxlRejReason            = 102
+	TagHeartBtInt              = 108
+	TagTestReqID               = 112
+	TagGapFillFlag             = 123
+	TagNoRelatedSym            = 146
+	TagExecType                = 150
+	TagLeavesQty               = 151
+	TagMDReqID                 = 262
+	TagSubscriptionRequestType = 263
+	TagMarketDepth             = 264
+	TagNoMDEntries             = 268
+	TagMDEntryType             = 269
+	TagMDEntryPx               = 270
+	TagMDEntrySize             = 271
+	TagRefMsgType              = 372
+	TagSessionRejectReason     = 373
+	TagCxlRejResponseTo        = 434
+)
+
+// Message types used by the gateway
+const (
+	MsgHeartbeat                     = "0"
+	MsgTestRequest                   = "1"
+	MsgResendRequest                 = "2"
+	MsgReject                        = "3"
+	MsgSequenceReset                 = "4"
+	MsgLogout                        = "5"
+	MsgExecutionReport               = "8"
+	MsgOrderCancelReject             = "9"
+	MsgLogon                         = "A"
+	MsgNewOrderSingle                = "D"
+	MsgOrderCancelRequest            = "F"
+	MsgMarketDataRequest             = "V"
+	MsgMarketDataSnapshotFullRefresh = "W"
+)
+
+// ErrGarbled is returned for data that isn't a well
+// formed FIX message
+var ErrGarbled = errors.New("garbled FIX message")
+
+// Field is one tag=value pair
+type Field struct {
+	Tag   int
+	Value string
+}
+
+// Message is a FIX message as a list of fields in order,
+// which repeating groups need. The header fields that
+// depend on the session and the trailer are filled in
+// when it is sent, so a Message only holds MsgType and the
+// body.
+type Message []Field
+
+// MakeMessage starts a message of type msgType
+func MakeMessage(msgType string) Message {
+	return Message{{TagMsgType, msgType}}
+}
+
+// Type returns the MsgType
+func (m Message) Type() string {
+	t, _ := m.Get(TagMsgType)
+	return t
+}
+
+// Get returns the first value for tag, or false if the
+// message doesn't have it
+func (m Message) Get(tag int) (string, bool) {
+	for _, f := range m {
+		if f.Tag == tag {
+			return f.Value, true
+		}
+	}
+	return "", false
+}
+
+// Int returns the first value for tag as an integer
+func (m Message) Int(tag int) (int64, error) {
+	v, found := m.Get(tag)
+	if !found {
+		return 0, fmt.Errorf("missing tag %d", tag)
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("tag %d must be an integer", tag)
+	}
+	return n, nil
+}
+
+// All returns every value for tag, in order
+func (m Message) All(tag int) []string {
+	var rv []string
+	for _, f := range m {
+		if f.Tag == tag {
+			rv = append(rv, f.Value)
+		}
+	}
+	return rv
+}
+
+// Set appends a field
+func (m Message) Set(tag int, value string) Message {
+	return append(m, Field{tag, value})
+}
+
+// SetInt appends an integer field
+func (m Message) SetInt(tag int, value int64) Message {
+	return m.Set(tag, strconv.FormatInt(value, 10))
+}
+
+// encode returns the message on the wire, with its header
+// and trailer
+func (m Message) encode(sender, target string, seq int64, ts time.Time) []byte {
+	var body bytes.Buffer
+	field := func(tag int, value string) {
+		fmt.Fprintf(&body, "%d=%s%c", tag, value, soh)
+	}
+	field(TagMsgType, m.Type())
+	field(TagSenderCompID, sender)
+	field(TagTargetCompID, target)
+	field(TagMsgSeqNum, strconv.FormatInt(seq, 10))
+	field(TagSendingTime, ts.UTC().Format("20060102-15:04:05.000"))
+	for _, f := range m {
+		if f.Tag != TagMsgType {
+			field(f.Tag, f.Value)
+		}
+	}
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "%d=%s%c%d=%d%c", TagBeginString, BeginString, soh, TagBodyLength, body.Len(), soh)
+	out.Write(body.Bytes())
+	fmt.Fprintf(&out, "%d=%03d%c", TagCheckSum, checksum(out.Bytes()), soh)
+	return out.Bytes()
+}
+
+func checksum(data []byte) int {
+	var sum int
+	for _, b := range data {
+		sum += int(b)
+	}
+	return sum % 256
+}
+
+// readMessage reads one message, checking its begin
+// string, length and checksum. The returned message has
+// every field except those three.
+func readMessage(r *bufio.Reader) (Message, error) {
+	begin, err := readField(r)
+	if err != nil {
+		return nil, err
+	}
+	if string(begin) != fmt.Sprintf("%d=%s%c", TagBeginString, BeginString, soh) {
+		return nil, ErrGarbled
+	}
+	lengthField, err := readField(r)
+	if err != nil {
+		return nil, err
+	}
+	tag, value, err := parseField(lengthField)
+	if err != nil || tag != TagBodyLength {
+		return nil, ErrGarbled
+	}
+	length, err := strconv.Atoi(value)
+	if err != nil || length < 0 || length > maxBodyLength {
+		return nil, ErrGarbled
+	}
+	body := make([]byte, length)
+	if _, err = io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	trailer, err := readField(r)
+	if err != nil {
+		return nil, err
+	}
+	tag, value, err = parseField(trailer)
+	if err != nil || tag != TagCheckSum {
+		return nil, ErrGarbled
+	}
+	sum := checksum(begin) + checksum(lengthField) + checksum(body)
+	if value != fmt.Sprintf("%03d", sum%256) {
+		return nil, ErrGarbled
+	}
+	var m Message
+	for _, raw := range bytes.SplitAfter(body, []byte{soh}) {
+		if len(raw) == 0 {
+			continue
+		}
+		tag, value, err := parseField(raw)
+		if err != nil {
+			return nil, err
+		}
+		m = append(m, Field{tag, value})
+	}
+	if len(m) == 0 || m[0].Tag != TagMsgType {
+		return nil, ErrGarbled
+	}
+	return m, nil
+}
+
+// maxBodyLength limits the memory a peer can make the
+// gateway allocate for one message
+const maxBodyLength = 64 * 1024
+
+// readField reads up to the next SOH. Fields outside the
+// body are short, so one longer than the reader's buffer is
+// an error.
+func readField(r *bufio.Reader) ([]byte, error) {
+	raw, err := r.ReadSlice(soh)
+	if err == bufio.ErrBufferFull {
+		return nil, ErrGarbled
+	}
+	return append([]byte(nil), raw...), err
+}
+
+// parseField parses "tag=value\x01"
+func parseField(raw []byte) (int, string, error) {
+	if len(raw) < 3 || raw[len(raw)-1] != soh {
+		return 0, "", ErrGarbled
+	}
+	i := bytes.IndexByte(raw, '=')
+	if i < 1 {
+		return 0, "", ErrGarbled
+	}
+	tag, err := strconv.Atoi(string(raw[:i]))
+	if err != nil {
+		return 0, "", ErrGarbled
+	}
+	return tag, string(raw[i+1 : len(raw)-1]), nil
+}
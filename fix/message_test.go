@@ -0,0 +1,51 @@
+package fix
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	m := MakeMessage(MsgMarketDataRequest).
+		Set(TagMDReqID, "r").
+		SetInt(TagNoRelatedSym, 2).
+		Set(TagSymbol, "A").
+		Set(TagSymbol, "B")
+	data := m.encode("ME", "YOU", 7, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	if !bytes.HasPrefix(data, []byte("8=FIX.4.4\x019=")) {
+		t.Fatalf("%q", data)
+	}
+	got, err := readMessage(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Type() != MsgMarketDataRequest {
+		t.Fatalf("%+v", got)
+	}
+	if seq, _ := got.Int(TagMsgSeqNum); seq != 7 {
+		t.Fatalf("%+v", got)
+	}
+	if ts, _ := got.Get(TagSendingTime); ts != "20240102-03:04:05.000" {
+		t.Fatalf("%q", ts)
+	}
+	if symbols := got.All(TagSymbol); len(symbols) != 2 || symbols[1] != "B" {
+		t.Fatalf("%+v", symbols)
+	}
+}
+
+func TestMessageBadChecksum(t *testing.T) {
+	data := MakeMessage(MsgHeartbeat).encode("ME", "YOU", 1, time.Now())
+	data[len(data)-2]++
+	if _, err := readMessage(bufio.NewReader(bytes.NewReader(data))); err != ErrGarbled {
+		t.Fatal(err)
+	}
+}
+
+func TestMessageBadBeginString(t *testing.T) {
+	data := []byte("8=FIX.4.2\x019=5\x0135=0\x0110=000\x01")
+	if _, err := readMessage(bufio.NewReader(bytes.NewReader(data))); err != ErrGarbled {
+		t.Fatal(err)
+	}
+}
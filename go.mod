@@ -1,5 +1,5 @@
 module github.com/williammoran/economy
 
-go 1.17
+go 1.20
 
 require github.com/google/uuid v1.3.0
@@ -0,0 +1,94 @@
+package economy
+
+import "sync"
+
+// MakeMemoryHoldings creates an empty *MemoryHoldings
+func MakeMemoryHoldings() *MemoryHoldings {
+	return &MemoryHoldings{
+		available: make(map[holding]int64),
+		reserved:  make(map[holding]int64),
+	}
+}
+
+type holding struct {
+	account int64
+	symbol  string
+}
+
+// MemoryHoldings is a Holdings implementation that keeps
+// everything in memory.
+type MemoryHoldings struct {
+	mutex     sync.Mutex
+	available map[holding]int64
+	reserved  map[holding]int64
+}
+
+// Deposit adds units of symbol to the account, e.g. when
+// a player crafts or loots an item
+func (h *MemoryHoldings) Deposit(accountID int64, symbol string, amount int64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.available[holding{accountID, symbol}] += amount
+}
+
+// Withdraw removes units of symbol from the account if
+// enough are available, or returns false
+func (h *MemoryHoldings) Withdraw(accountID int64, symbol string, amount int64) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	k := holding{accountID, symbol}
+	if h.available[k] < amount {
+		return false
+	}
+	h.available[k] -= amount
+	return true
+}
+
+// Available returns the units of symbol the account owns
+// that are not reserved by an offer
+func (h *MemoryHoldings) Available(accountID int64, symbol string) int64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.available[holding{accountID, symbol}]
+}
+
+// Reserved returns the units of symbol the account has
+// set aside for offers
+func (h *MemoryHoldings) Reserved(accountID int64, symbol string) int64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.reserved[holding{accountID, symbol}]
+}
+
+func (h *MemoryHoldings) Reserve(accountID int64, symbol string, amount int64) int64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	k := holding{accountID, symbol}
+	if h.available[k] < amount {
+		amount = h.available[k]
+	}
+	if amount < 1 {
+		return 0
+	}
+	h.available[k] -= amount
+	h.reserved[k] += amount
+	return amount
+}
+
+func (h *MemoryHoldings) Release(accountID int64, symbol string, amount int64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	k := holding{accountID, symbol}
+	if h.reserved[k] < amount {
+		amount = h.reserved[k]
+	}
+	h.reserved[k] -= amount
+	h.available[k] += amount
+}
+
+func (h *MemoryHoldings) Transfer(from, to int64, symbol string, amount int64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.reserved[holding{from, symbol}] -= amount
+	h.available[holding{to, symbol}] += amount
+}
@@ -0,0 +1,144 @@
+package economy
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReserveLimitedToAvailable(t *testing.T) {
+	h := MakeMemoryHoldings()
+	h.Deposit(1, sym, 5)
+	if r := h.Reserve(1, sym, 8); r != 5 {
+		t.Fatalf("Reserved %d", r)
+	}
+	if h.Available(1, sym) != 0 || h.Reserved(1, sym) != 5 {
+		t.Fatalf("%+v", h)
+	}
+	if r := h.Reserve(1, sym, 1); r != 0 {
+		t.Fatalf("Reserved %d", r)
+	}
+}
+
+func TestReleaseReturnsUnits(t *testing.T) {
+	h := MakeMemoryHoldings()
+	h.Deposit(1, sym, 5)
+	h.Reserve(1, sym, 5)
+	h.Release(1, sym, 3)
+	if h.Available(1, sym) != 3 || h.Reserved(1, sym) != 2 {
+		t.Fatalf("%+v", h)
+	}
+}
+
+func TestTransferMovesReservedUnits(t *testing.T) {
+	h := MakeMemoryHoldings()
+	h.Deposit(1, sym, 5)
+	h.Reserve(1, sym, 5)
+	h.Transfer(1, 2, sym, 4)
+	if h.Reserved(1, sym) != 1 || h.Available(2, sym) != 4 {
+		t.Fatalf("%+v", h)
+	}
+}
+
+func TestWithdrawRequiresAvailable(t *testing.T) {
+	h := MakeMemoryHoldings()
+	h.Deposit(1, sym, 5)
+	h.Reserve(1, sym, 3)
+	if h.Withdraw(1, sym, 3) {
+		t.Fatal("Withdrew reserved units")
+	}
+	if !h.Withdraw(1, sym, 2) {
+		t.Fatal("Could not withdraw available units")
+	}
+}
+
+func TestMarketRejectsOfferWithoutHoldings(t *testing.T) {
+	storage := MakeMemoryStorage()
+	m := MakeMarket(time.Now, storage, makeMockAccounts(), WithHoldings(MakeMemoryHoldings()))
+	_, err := m.Offer(Offer{Symbol: sym, Account: 1, Amount: 10, OfferType: OrderTypeLimit, Price: 5})
+	if err != ErrInsufficientHoldings {
+		t.Fatalf("Expected ErrInsufficientHoldings, got %v", err)
+	}
+	if len(storage.offers[sym]) != 0 {
+		t.Fatalf("%+v", storage.offers)
+	}
+}
+
+func TestMarketReducesOfferToHoldings(t *testing.T) {
+	h := MakeMemoryHoldings()
+	h.Deposit(1, sym, 4)
+	m := MakeMarket(time.Now, MakeMemoryStorage(), makeMockAccounts(), WithHoldings(h))
+	report, err := m.Offer(Offer{Symbol: sym, Account: 1, Amount: 10, OfferType: OrderTypeLimit, Price: 5})
+	id := report.OrderID
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o, _ := m.FindOffer(id); o.Amount != 4 {
+		t.Fatalf("%+v", o)
+	}
+}
+
+func TestMarketFillMovesHoldings(t *testing.T) {
+	h := MakeMemoryHoldings()
+	h.Deposit(1, sym, 10)
+	m := MakeMarket(time.Now, MakeMemoryStorage(), makeMockAccounts(), WithHoldings(h))
+	report, _ := m.Offer(Offer{Symbol: sym, Account: 1, Amount: 10, OfferType: OrderTypeLimit, Price: 5})
+	offerID := report.OrderID
+	m.Bid(Bid{Symbol: sym, Account: 2, Amount: 6, BidType: OrderTypeLimit, Price: 5})
+	if h.Available(2, sym) != 6 {
+		t.Fatalf("Buyer has %d", h.Available(2, sym))
+	}
+	if h.Reserved(1, sym) != 4 {
+		t.Fatalf("Seller has %d reserved", h.Reserved(1, sym))
+	}
+	if err := m.CancelOffer(1, offerID); err != nil {
+		t.Fatal(err)
+	}
+	if h.Reserved(1, sym) != 0 || h.Available(1, sym) != 4 {
+		t.Fatalf("%+v", h)
+	}
+}
+
+func TestMarketNSFKeepsHoldings(t *testing.T) {
+	h := MakeMemoryHoldings()
+	h.Deposit(1, sym, 10)
+	accounts := makeMockAccounts()
+	accounts.rejects[2] = true
+	m := MakeMarket(time.Now, MakeMemoryStorage(), accounts, WithHoldings(h))
+	m.Offer(Offer{Symbol: sym, Account: 1, Amount: 10, OfferType: OrderTypeLimit, Price: 5})
+	m.Bid(Bid{Symbol: sym, Account: 2, Amount: 6, BidType: OrderTypeLimit, Price: 5})
+	if h.Available(2, sym) != 0 || h.Reserved(1, sym) != 10 {
+		t.Fatalf("%+v", h)
+	}
+}
+
+// failingUpdates is storage that can't update orders
+type failingUpdates struct {
+	*MemoryStorage
+}
+
+func (s failingUpdates) UpdateOffer(Offer) error {
+	return errors.New("failed")
+}
+
+func (s failingUpdates) UpdateBid(Bid) error {
+	return errors.New("failed")
+}
+
+func TestMarketAmendFailureKeepsReservation(t *testing.T) {
+	h := MakeMemoryHoldings()
+	h.Deposit(1, sym, 10)
+	m := MakeMarket(time.Now, failingUpdates{MakeMemoryStorage()}, makeMockAccounts(), WithHoldings(h))
+	report, err := m.Offer(Offer{Symbol: sym, Account: 1, Amount: 5, OfferType: OrderTypeLimit, Price: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, amount := range []int64{8, 2} {
+		if err = m.AmendOffer(1, report.OrderID, 5, amount); err == nil {
+			t.Fatal("Expected the update to fail")
+		}
+		if h.Reserved(1, sym) != 5 || h.Available(1, sym) != 5 {
+			t.Fatalf("Amending to %d: %+v", amount, h)
+		}
+	}
+}
@@ -0,0 +1,510 @@
+package economy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SyncPolicy decides how often the journal is written to
+// disk with fsync
+type SyncPolicy int
+
+const (
+	// SyncEachOperation syncs at every Unlock, so everything
+	// the Market did with an order is durable when the call
+	// returns
+	SyncEachOperation SyncPolicy = iota
+	// SyncEachRecord syncs after every change
+	SyncEachRecord
+	// SyncNever writes changes to the file at every Unlock
+	// but leaves syncing to the operating system. Changes
+	// survive the program crashing but may be lost if the
+	// machine does.
+	SyncNever
+)
+
+// JournalOptions configure MemoryStorage.OpenJournal
+type JournalOptions struct {
+	Sync SyncPolicy
+	// CompactEvery compacts the journal into a new snapshot
+	// at the first Unlock after that many changes. Zero
+	// leaves compaction to Compact.
+	CompactEvery int
+	// Encoding is the encoding of the snapshots
+	Encoding SnapshotEncoding
+}
+
+// Journal records are CSV with the kind of record first,
+// followed by the same fields as in a snapshot
+const (
+	recordOffer       = "offer"
+	recordBid         = "bid"
+	recordTransaction = "transaction"
+	recordPrice       = "price"
+	recordCandle      = "candle"
+	// recordDeleteCandles has only the symbol
+	recordDeleteCandles = "delete candles"
+)
+
+const (
+	snapshotFile = "snapshot."
+	journalFile  = "journal."
+	tempSuffix   = ".tmp"
+)
+
+// journal is an append-only file of the changes made to a
+// MemoryStorage since the snapshot of the same generation
+type journal struct {
+	dir    string
+	opts   JournalOptions
+	gen    uint64
+	file   *os.File
+	writer *csv.Writer
+	// records counts changes since the last compaction
+	records int
+	// unsynced is true when changes have been written since
+	// the last sync
+	unsynced bool
+	// err is the first error writing the journal
+	err error
+}
+
+func (j *journal) path(prefix string, gen uint64) string {
+	return filepath.Join(j.dir, prefix+strconv.FormatUint(gen, 10))
+}
+
+func (j *journal) fail(err error) error {
+	if j.err == nil {
+		j.err = err
+	}
+	return j.err
+}
+
+func (j *journal) write(kind string, r []string, err error) error {
+	if j.err != nil {
+		return j.err
+	}
+	if err != nil {
+		return j.fail(err)
+	}
+	j.writer.Write(append([]string{kind}, r...))
+	j.records++
+	j.unsynced = true
+	if j.opts.Sync == SyncEachRecord {
+		j.sync()
+	}
+	return j.err
+}
+
+func (j *journal) flush() {
+	if j.err != nil {
+		return
+	}
+	j.writer.Flush()
+	j.fail(j.writer.Error())
+}
+
+func (j *journal) sync() {
+	j.flush()
+	if j.err != nil || !j.unsynced {
+		return
+	}
+	j.unsynced = false
+	j.fail(j.file.Sync())
+}
+
+// OpenJournal recovers storage from dir and then records
+// every change to storage there, so that a crash loses at
+// most what the SyncPolicy allows. Recovery loads the
+// newest snapshot that isn't corrupt and replays the
+// journals written since, then compacts everything into a
+// new snapshot. If dir doesn't exist or is empty, the data
+// already in storage is kept and becomes the first
+// snapshot.
+//
+// Order types must be registered before OpenJournal so that
+// orders of those types can be recovered. Changes made
+// without Lock are written at the next Unlock, Compact or
+// CloseJournal.
+func (s *MemoryStorage) OpenJournal(dir string, opts JournalOptions) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.journal != nil {
+		return ErrJournalOpen
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	j := &journal{dir: dir, opts: opts}
+	snapshots, journals, err := j.generations()
+	if err != nil {
+		return err
+	}
+	if len(snapshots) > 0 || len(journals) > 0 {
+		recovered := MakeMemoryStorage()
+		recovered.pricers = s.pricers
+		if err := recovered.recover(j, snapshots, journals); err != nil {
+			return err
+		}
+		s.replace(recovered)
+	}
+	if len(journals) > 0 {
+		j.gen = journals[len(journals)-1]
+	}
+	if len(snapshots) > 0 && snapshots[len(snapshots)-1] > j.gen {
+		j.gen = snapshots[len(snapshots)-1]
+	}
+	s.journal = j
+	if err := s.compact(); err != nil {
+		s.journal = nil
+		return err
+	}
+	return nil
+}
+
+// generations lists the snapshots and journals in the
+// directory, oldest first
+func (j *journal) generations() ([]uint64, []uint64, error) {
+	entries, err := os.ReadDir(j.dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	var snapshots, journals []uint64
+	for _, e := range entries {
+		if gen, ok := parseGeneration(e.Name(), snapshotFile); ok {
+			snapshots = append(snapshots, gen)
+		}
+		if gen, ok := parseGeneration(e.Name(), journalFile); ok {
+			journals = append(journals, gen)
+		}
+	}
+	sort.Slice(snapshots, func(a, b int) bool { return snapshots[a] < snapshots[b] })
+	sort.Slice(journals, func(a, b int) bool { return journals[a] < journals[b] })
+	return snapshots, journals, nil
+}
+
+func parseGeneration(name, prefix string) (uint64, bool) {
+	if !strings.HasPrefix(name, prefix) {
+		return 0, false
+	}
+	gen, err := strconv.ParseUint(name[len(prefix):], 10, 64)
+	return gen, err == nil
+}
+
+// recover replaces the data in storage with the newest
+// snapshot that loads, followed by every journal from the
+// same generation on
+func (s *MemoryStorage) recover(j *journal, snapshots, journals []uint64) error {
+	loaded := false
+	var base uint64
+	for i := len(snapshots) - 1; i >= 0 && !loaded; i-- {
+		err := s.loadSnapshot(j.path(snapshotFile, snapshots[i]))
+		if err == nil {
+			base, loaded = snapshots[i], true
+		} else if !errors.Is(err, ErrCorruptSnapshot) {
+			return err
+		}
+	}
+	if !loaded {
+		return fmt.Errorf("%w: no snapshot in %s can be loaded", ErrCorruptJournal, j.dir)
+	}
+	next := base
+	for _, gen := range journals {
+		if gen < base {
+			continue
+		}
+		if gen != next {
+			return fmt.Errorf("%w: journal %d is missing", ErrCorruptJournal, next)
+		}
+		if err := s.replay(j.path(journalFile, gen)); err != nil {
+			return fmt.Errorf("%w: journal %d %s", ErrCorruptJournal, gen, err)
+		}
+		next++
+	}
+	return nil
+}
+
+func (s *MemoryStorage) loadSnapshot(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return s.unmarshal(bufio.NewReader(f))
+}
+
+// replay applies the records in a journal file. Nothing
+// is recorded while replaying, because storage has no
+// journal until recovery is finished.
+func (s *MemoryStorage) replay(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	// A crash while appending can leave part of a record
+	// after the last newline. It was never synced, so it
+	// is dropped.
+	data = data[:bytes.LastIndexByte(data, '\n')+1]
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err == nil {
+			err = s.replayRecord(record)
+		}
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			return fmt.Errorf("line %d: %s", line, err)
+		}
+	}
+}
+
+func (s *MemoryStorage) replayRecord(record []string) error {
+	p := &fieldParser{record: record[1:]}
+	var err error
+	switch record[0] {
+	case recordOffer:
+		o := parseOffer(p)
+		if p.err == nil {
+			err = s.UpdateOffer(o)
+		}
+	case recordBid:
+		b := parseBid(p)
+		if p.err == nil {
+			err = s.UpdateBid(b)
+		}
+	case recordTransaction:
+		s.transactions = append(s.transactions, parseTransaction(p))
+	case recordPrice:
+		err = s.SetLastPrice(p.field(0), p.int64(1))
+	case recordCandle:
+		c := parseCandle(p)
+		if p.err == nil {
+			err = s.SetCandle(c)
+		}
+	case recordDeleteCandles:
+		err = s.DeleteCandles(p.field(0))
+	default:
+		return fmt.Errorf("unknown record %q", record[0])
+	}
+	if p.err != nil {
+		return p.err
+	}
+	return err
+}
+
+// Compact writes a snapshot of storage and starts a new,
+// empty journal. The previous snapshot and journal are
+// kept in case the new snapshot is damaged, and older ones
+// are removed. Compact does nothing without a journal.
+func (s *MemoryStorage) Compact() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.journal == nil {
+		return nil
+	}
+	return s.compact()
+}
+
+// compact starts the next generation. The new journal is
+// created before the snapshot, so that recovery always
+// finds a journal for the newest snapshot.
+func (s *MemoryStorage) compact() error {
+	j := s.journal
+	if j.file != nil {
+		j.sync()
+	}
+	if j.err != nil {
+		return j.err
+	}
+	gen := j.gen + 1
+	file, err := os.OpenFile(
+		j.path(journalFile, gen), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644,
+	)
+	if err != nil {
+		return j.fail(err)
+	}
+	if err := s.writeSnapshot(j.path(snapshotFile, gen)); err != nil {
+		file.Close()
+		return j.fail(err)
+	}
+	if j.file != nil {
+		j.file.Close()
+	}
+	j.file, j.writer, j.gen, j.records = file, csv.NewWriter(file), gen, 0
+	j.removeBefore(gen - 1)
+	return nil
+}
+
+// writeSnapshot saves storage to a temporary file that is
+// renamed once it is safely on disk, so a crash never
+// leaves a partly written snapshot
+func (s *MemoryStorage) writeSnapshot(path string) error {
+	temp := path + tempSuffix
+	f, err := os.Create(temp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	err = s.marshal(w, s.journal.opts.Encoding)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp, path)
+	}
+	if err != nil {
+		os.Remove(temp)
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir makes the files created and renamed in dir
+// durable. Not every platform can sync a directory, so
+// failing to is ignored.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	d.Sync()
+	return d.Close()
+}
+
+// removeBefore deletes the snapshots and journals older
+// than gen, and any temporary files. Failing to remove one
+// only wastes space, so errors are ignored.
+func (j *journal) removeBefore(gen uint64) {
+	entries, err := os.ReadDir(j.dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		name := e.Name()
+		old := strings.HasSuffix(name, tempSuffix)
+		for _, prefix := range []string{snapshotFile, journalFile} {
+			if g, ok := parseGeneration(name, prefix); ok && g < gen {
+				old = true
+			}
+		}
+		if old {
+			os.Remove(filepath.Join(j.dir, name))
+		}
+	}
+}
+
+// endOperation writes the journal at Unlock, as the
+// SyncPolicy requires, and compacts it when it is due
+func (s *MemoryStorage) endOperation() {
+	j := s.journal
+	if j == nil {
+		return
+	}
+	if j.opts.Sync == SyncNever {
+		j.flush()
+	} else {
+		j.sync()
+	}
+	if j.opts.CompactEvery > 0 && j.records >= j.opts.CompactEvery {
+		// There's no caller to return a failure to, so it
+		// is kept for Err and the next change
+		if err := s.compact(); err != nil {
+			j.fail(err)
+		}
+	}
+}
+
+// CloseJournal writes any changes still buffered and
+// closes the journal. Storage keeps working afterwards,
+// but changes are no longer recorded.
+func (s *MemoryStorage) CloseJournal() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	j := s.journal
+	if j == nil {
+		return nil
+	}
+	s.journal = nil
+	j.sync()
+	if err := j.file.Close(); err != nil {
+		j.fail(err)
+	}
+	return j.err
+}
+
+// Err returns the first error writing the journal. Once
+// there has been one, every change to storage returns it,
+// because the change would not survive a restart.
+func (s *MemoryStorage) Err() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.journal == nil {
+		return nil
+	}
+	return s.journal.err
+}
+
+func (s *MemoryStorage) journalOffer(o Offer) error {
+	if s.journal == nil {
+		return nil
+	}
+	r, err := offerRecord(o)
+	return s.journal.write(recordOffer, r, err)
+}
+
+func (s *MemoryStorage) journalBid(b Bid) error {
+	if s.journal == nil {
+		return nil
+	}
+	r, err := bidRecord(b)
+	return s.journal.write(recordBid, r, err)
+}
+
+func (s *MemoryStorage) journalTransaction(tx Transaction) error {
+	if s.journal == nil {
+		return nil
+	}
+	r, err := transactionRecord(tx)
+	return s.journal.write(recordTransaction, r, err)
+}
+
+func (s *MemoryStorage) journalPrice(symbol string, price int64) error {
+	if s.journal == nil {
+		return nil
+	}
+	return s.journal.write(recordPrice, priceRecord(symbol, price), nil)
+}
+
+func (s *MemoryStorage) journalCandle(c Candle) error {
+	if s.journal == nil {
+		return nil
+	}
+	r, err := candleRecord(c)
+	return s.journal.write(recordCandle, r, err)
+}
+
+func (s *MemoryStorage) journalDeleteCandles(symbol string) error {
+	if s.journal == nil {
+		return nil
+	}
+	return s.journal.write(recordDeleteCandles, []string{symbol}, nil)
+}
@@ -0,0 +1,222 @@
+package economy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openJournaled(t *testing.T, dir string, opts JournalOptions) *MemoryStorage {
+	s := MakeMemoryStorage()
+	if err := s.OpenJournal(dir, opts); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.CloseJournal() })
+	return s
+}
+
+func TestJournalRecoversAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+	s := openJournaled(t, dir, JournalOptions{})
+	m := MakeMarket(time.Now, s, makeMockAccounts())
+	first, _ := m.Offer(Offer{Symbol: sym, Account: 2, Amount: 10, OfferType: OrderTypeLimit, Price: 5})
+	m.Offer(Offer{Symbol: sym, Account: 3, Amount: 10, OfferType: OrderTypeLimit, Price: 5})
+	m.Bid(Bid{Symbol: sym, Account: 1, Amount: 4, BidType: OrderTypeLimit, Price: 5})
+	cancelled, _ := m.Bid(Bid{Symbol: sym, Account: 1, Amount: 4, BidType: OrderTypeLimit, Price: 3})
+	m.CancelBid(1, cancelled.OrderID)
+	// The first storage is abandoned without closing its
+	// journal, as if the program crashed
+	recovered := openJournaled(t, dir, JournalOptions{})
+	o, found, _ := recovered.BestOffer(sym)
+	if !found || o.ID != first.OrderID || o.Amount != 6 {
+		t.Fatalf("%+v", o)
+	}
+	if b, _ := recovered.GetBid(cancelled.OrderID); !b.Cancelled {
+		t.Fatalf("%+v", b)
+	}
+	if txs, _ := recovered.Transactions(TransactionFilter{}); len(txs) != 1 || txs[0].Amount != 4 {
+		t.Fatalf("%+v", txs)
+	}
+	if price, _ := recovered.LastPrice(sym); price != 5 {
+		t.Fatalf("Last price %d", price)
+	}
+	if candles, _ := recovered.Candles(sym, CandleMinute, time.Time{}, time.Time{}); len(candles) != 1 {
+		t.Fatalf("%+v", candles)
+	}
+}
+
+func TestJournalRecoversDeletedCandles(t *testing.T) {
+	dir := t.TempDir()
+	s := openJournaled(t, dir, JournalOptions{})
+	m := MakeMarket(time.Now, s, makeMockAccounts())
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 4, OfferType: OrderTypeLimit, Price: 5})
+	m.Bid(Bid{Symbol: sym, Account: 1, Amount: 4, BidType: OrderTypeLimit, Price: 5})
+	s.Lock()
+	s.SetCandle(Candle{Symbol: sym, Interval: CandleMinute, Start: time.Now().Add(-time.Hour), Volume: 7})
+	s.Unlock()
+	if err := m.RebuildCandles(); err != nil {
+		t.Fatal(err)
+	}
+	recovered := openJournaled(t, dir, JournalOptions{})
+	if candles, _ := recovered.Candles(sym, CandleMinute, time.Time{}, time.Time{}); len(candles) != 1 || candles[0].Volume != 4 {
+		t.Fatalf("%+v", candles)
+	}
+}
+
+func TestJournalDropsTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	s := openJournaled(t, dir, JournalOptions{})
+	s.Lock()
+	s.SetLastPrice(sym, 7)
+	s.Unlock()
+	f, err := os.OpenFile(filepath.Join(dir, journalFile+"1"), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("price," + sym + ",9")
+	f.Close()
+	recovered := openJournaled(t, dir, JournalOptions{})
+	if price, _ := recovered.LastPrice(sym); price != 7 {
+		t.Fatalf("Last price %d", price)
+	}
+}
+
+func TestJournalFallsBackToPreviousSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	s := openJournaled(t, dir, JournalOptions{})
+	s.Lock()
+	s.SetLastPrice(sym, 7)
+	s.Unlock()
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	s.Lock()
+	s.SetLastPrice("other", 8)
+	s.Unlock()
+	if err := os.WriteFile(filepath.Join(dir, snapshotFile+"2"), []byte("garbage"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	recovered := openJournaled(t, dir, JournalOptions{})
+	if price, _ := recovered.LastPrice(sym); price != 7 {
+		t.Fatalf("Last price %d", price)
+	}
+	if price, _ := recovered.LastPrice("other"); price != 8 {
+		t.Fatalf("Last price %d", price)
+	}
+}
+
+func TestJournalMissingGenerationIsCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	s := openJournaled(t, dir, JournalOptions{})
+	s.Compact()
+	s.CloseJournal()
+	os.WriteFile(filepath.Join(dir, snapshotFile+"2"), []byte("garbage"), 0o644)
+	os.Remove(filepath.Join(dir, journalFile+"1"))
+	err := MakeMemoryStorage().OpenJournal(dir, JournalOptions{})
+	if !errors.Is(err, ErrCorruptJournal) {
+		t.Fatalf("Expected ErrCorruptJournal, got %v", err)
+	}
+}
+
+func TestJournalCompactsAutomatically(t *testing.T) {
+	dir := t.TempDir()
+	s := openJournaled(t, dir, JournalOptions{CompactEvery: 2})
+	for i := int64(1); i <= 6; i++ {
+		s.Lock()
+		s.SetLastPrice(sym, i)
+		s.Unlock()
+	}
+	names, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(names) != 4 {
+		t.Fatalf("Expected two generations, got %v", names)
+	}
+	if _, err := os.Stat(filepath.Join(dir, snapshotFile+"4")); err != nil {
+		t.Fatal(err)
+	}
+	recovered := openJournaled(t, dir, JournalOptions{})
+	if price, _ := recovered.LastPrice(sym); price != 6 {
+		t.Fatalf("Last price %d", price)
+	}
+}
+
+func TestJournalCompactionFailureReported(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "journal")
+	s := openJournaled(t, dir, JournalOptions{CompactEvery: 1})
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	s.Lock()
+	s.SetLastPrice(sym, 7)
+	s.Unlock()
+	if s.Err() == nil {
+		t.Fatal("Expected the failed compaction to be reported")
+	}
+	s.Lock()
+	defer s.Unlock()
+	if err := s.SetLastPrice(sym, 8); err == nil {
+		t.Fatal("Expected changes to fail after the compaction")
+	}
+}
+
+func TestJournalErrWhileChanging(t *testing.T) {
+	s := openJournaled(t, t.TempDir(), JournalOptions{CompactEvery: 2})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := int64(1); i <= 20; i++ {
+			s.Lock()
+			s.SetLastPrice(sym, i)
+			s.Unlock()
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		if err := s.Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+}
+
+func TestJournalKeepsExistingData(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "journal")
+	s := MakeMemoryStorage()
+	s.SetLastPrice(sym, 7)
+	if err := s.OpenJournal(dir, JournalOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.OpenJournal(dir, JournalOptions{}); err != ErrJournalOpen {
+		t.Fatalf("Expected ErrJournalOpen, got %v", err)
+	}
+	s.CloseJournal()
+	recovered := openJournaled(t, dir, JournalOptions{})
+	if price, _ := recovered.LastPrice(sym); price != 7 {
+		t.Fatalf("Last price %d", price)
+	}
+}
+
+func TestJournalSyncEachRecord(t *testing.T) {
+	dir := t.TempDir()
+	s := openJournaled(t, dir, JournalOptions{Sync: SyncEachRecord})
+	s.Lock()
+	defer s.Unlock()
+	s.SetLastPrice(sym, 7)
+	data, _ := os.ReadFile(filepath.Join(dir, journalFile+"1"))
+	if string(data) != "price,"+sym+",7\n" {
+		t.Fatalf("%q", data)
+	}
+}
+
+func TestJournalSnapshotsAsJSON(t *testing.T) {
+	dir := t.TempDir()
+	s := openJournaled(t, dir, JournalOptions{Encoding: SnapshotJSON})
+	s.Lock()
+	s.SetLastPrice(sym, 7)
+	s.Unlock()
+	s.Compact()
+	recovered := openJournaled(t, dir, JournalOptions{})
+	if price, _ := recovered.LastPrice(sym); price != 7 {
+		t.Fatalf("Last price %d", price)
+	}
+}
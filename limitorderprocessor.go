@@ -8,68 +8,159 @@ type limitOrderProcessor struct {
 
 func (m *limitOrderProcessor) TryFillBid(
 	ms MarketStorage,
-	accounts Accounts,
-	opl map[OrderType]orderProcessor,
+	s *Settlement,
+	opl map[OrderType]OrderProcessor,
 	bid Bid,
-) {
+) error {
+	ok, err := s.admitBid(ms, opl, m.now(), bid, func(last, ask int64) (int64, bool) {
+		return limitFillPrice(last, ask, bid.Price)
+	})
+	if err != nil || !ok {
+		return err
+	}
+	if err = m.matchBid(ms, s, opl, bid); err != nil {
+		return err
+	}
+	return s.finishBid(ms, m.now(), bid)
+}
+
+func (m *limitOrderProcessor) matchBid(
+	ms MarketStorage,
+	s *Settlement,
+	opl map[OrderType]OrderProcessor,
+	bid Bid,
+) error {
 	for {
-		if bid.Amount < 1 {
-			return
+		if !bid.IsActive() || s.Halted(bid.Symbol) {
+			return nil
 		}
-		off, found := ms.BestOffer(bid.Symbol)
+		off, found, err := ms.BestOffer(bid.Symbol)
+		if err != nil || !found {
+			return err
+		}
+		ts := m.now()
+		if off.Expired(ts) {
+			if err = s.cancelOffer(ms, ts, off, EventExpired); err != nil {
+				return err
+			}
+			continue
+		}
+		p, found := opl[off.OfferType]
 		if !found {
-			return
+			return ErrUnknownOrderType
+		}
+		askPrice, err := p.GetAskingPrice(ms, off)
+		if err != nil {
+			return err
 		}
-		askPrice := opl[off.OfferType].GetAskingPrice(ms, off)
 		if askPrice > bid.Price {
-			return
-		}
-		marketPrice := ms.LastPrice(bid.Symbol)
-		var price int64
-		if marketPrice >= askPrice {
-			if marketPrice <= bid.Price {
-				price = marketPrice
-			} else {
-				price = bid.Price
-			}
-		} else {
-			price = askPrice
+			return nil
+		}
+		var prevented bool
+		if bid, _, prevented, err = s.PreventSelfTrade(ms, ts, bid, off); err != nil {
+			return err
 		}
+		if prevented {
+			continue
+		}
+		marketPrice, err := ms.LastPrice(bid.Symbol)
+		if err != nil {
+			return err
+		}
+		price, _ := limitFillPrice(marketPrice, askPrice, bid.Price)
 		var filled bool
-		bid, _, filled = fillBid(ms, accounts, m.now(), bid, off, price)
-		if !filled {
-			return
+		bid, _, filled, err = fillBid(ms, s, ts, bid, off, price)
+		if err != nil || !filled {
+			return err
 		}
 	}
 }
 
 func (m *limitOrderProcessor) TrySell(
 	ms MarketStorage,
-	accounts Accounts,
-	opl map[OrderType]orderProcessor,
+	s *Settlement,
+	opl map[OrderType]OrderProcessor,
+	offer Offer,
+) error {
+	ok, err := s.admitOffer(ms, opl, m.now(), offer, func(last, price int64) (int64, bool) {
+		return price, price >= offer.Price
+	})
+	if err != nil || !ok {
+		return err
+	}
+	if err = m.matchOffer(ms, s, opl, offer); err != nil {
+		return err
+	}
+	return s.finishOffer(ms, m.now(), offer)
+}
+
+func (m *limitOrderProcessor) matchOffer(
+	ms MarketStorage,
+	s *Settlement,
+	opl map[OrderType]OrderProcessor,
 	offer Offer,
-) {
+) error {
 	for {
-		if offer.Amount < 1 {
-			return
+		if !offer.IsActive() || s.Halted(offer.Symbol) {
+			return nil
+		}
+		bid, found, err := ms.BestBid(offer.Symbol)
+		if err != nil || !found {
+			return err
+		}
+		ts := m.now()
+		if bid.Expired(ts) {
+			if err = s.cancelBid(ms, ts, bid, EventExpired); err != nil {
+				return err
+			}
+			continue
 		}
-		bid, found := ms.BestBid(offer.Symbol)
+		p, found := opl[bid.BidType]
 		if !found {
-			return
+			return ErrUnknownOrderType
 		}
-		price := opl[bid.BidType].GetBidPrice(ms, bid)
-		if price <= offer.Price {
-			_, offer, _ = fillBid(ms, accounts, m.now(), bid, offer, price)
-		} else {
-			return
+		price, err := p.GetBidPrice(ms, bid)
+		if err != nil {
+			return err
 		}
+		if price < offer.Price {
+			return nil
+		}
+		var prevented bool
+		if _, offer, prevented, err = s.PreventSelfTrade(ms, ts, bid, offer); err != nil {
+			return err
+		}
+		if prevented {
+			continue
+		}
+		_, offer, _, err = fillBid(ms, s, ts, bid, offer, price)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// limitFillPrice returns the price a limit bid at limit
+// pays for an offer asking ask when the last price is
+// last: the last price if it is between the two, or else
+// the nearer of them. It returns false if ask is above
+// limit.
+func limitFillPrice(last, ask, limit int64) (int64, bool) {
+	switch {
+	case ask > limit:
+		return 0, false
+	case last < ask:
+		return ask, true
+	case last > limit:
+		return limit, true
 	}
+	return last, true
 }
 
-func (m *limitOrderProcessor) GetAskingPrice(ms MarketStorage, o Offer) int64 {
-	return o.Price
+func (m *limitOrderProcessor) GetAskingPrice(ms MarketStorage, o Offer) (int64, error) {
+	return o.Price, nil
 }
 
-func (m *limitOrderProcessor) GetBidPrice(ms MarketStorage, b Bid) int64 {
-	return b.Price
+func (m *limitOrderProcessor) GetBidPrice(ms MarketStorage, b Bid) (int64, error) {
+	return b.Price, nil
 }
@@ -11,10 +11,10 @@ func TestNoTransactionWhenLimitPriceTooHigh(t *testing.T) {
 	o := Offer{OfferType: OrderTypeLimit, Symbol: "m", Amount: 10, Price: 20}
 	storage.AddOffer(o)
 	bid := Bid{Symbol: "m", Amount: 10, BidType: OrderTypeLimit, Price: 10}
-	id := storage.AddBid(bid)
+	id, _ := storage.AddBid(bid)
 	bid.ID = id
-	mop.TryFillBid(storage, makeMockAccounts(), map[OrderType]orderProcessor{OrderTypeLimit: &mop}, bid)
-	bid = storage.GetBid(id)
+	mop.TryFillBid(storage, &Settlement{accounts: makeMockAccounts()}, map[OrderType]OrderProcessor{OrderTypeLimit: &mop}, bid)
+	bid, _ = storage.GetBid(id)
 	if bid.Amount == 0 {
 		t.Fatalf("%+v", bid)
 	}
@@ -30,10 +30,10 @@ func TestSatisfiesAtMarketPriceInBetween(t *testing.T) {
 	storage.AddOffer(o)
 	bid := Bid{Symbol: "m", Amount: 10, BidType: OrderTypeLimit, Price: 20}
 	storage.SetLastPrice("m", 15)
-	id := storage.AddBid(bid)
+	id, _ := storage.AddBid(bid)
 	bid.ID = id
-	mop.TryFillBid(storage, makeMockAccounts(), map[OrderType]orderProcessor{OrderTypeLimit: &mop}, bid)
-	bid = storage.GetBid(id)
+	mop.TryFillBid(storage, &Settlement{accounts: makeMockAccounts()}, map[OrderType]OrderProcessor{OrderTypeLimit: &mop}, bid)
+	bid, _ = storage.GetBid(id)
 	if bid.Amount != 0 {
 		t.Fatalf("%+v", bid)
 	}
@@ -50,10 +50,10 @@ func TestSatisfiesAtBidWhenMarketHigh(t *testing.T) {
 	storage.AddOffer(o)
 	bid := Bid{Symbol: "m", Amount: 10, BidType: OrderTypeLimit, Price: 20}
 	storage.SetLastPrice("m", 25)
-	id := storage.AddBid(bid)
+	id, _ := storage.AddBid(bid)
 	bid.ID = id
-	mop.TryFillBid(storage, makeMockAccounts(), map[OrderType]orderProcessor{OrderTypeLimit: &mop}, bid)
-	bid = storage.GetBid(id)
+	mop.TryFillBid(storage, &Settlement{accounts: makeMockAccounts()}, map[OrderType]OrderProcessor{OrderTypeLimit: &mop}, bid)
+	bid, _ = storage.GetBid(id)
 	if bid.Amount != 0 {
 		t.Fatalf("%+v", bid)
 	}
@@ -70,10 +70,10 @@ func TestSatisfiesAtOfferWhenMarketLow(t *testing.T) {
 	storage.AddOffer(o)
 	bid := Bid{Symbol: "m", Amount: 10, BidType: OrderTypeLimit, Price: 20}
 	storage.SetLastPrice("m", 5)
-	id := storage.AddBid(bid)
+	id, _ := storage.AddBid(bid)
 	bid.ID = id
-	mop.TryFillBid(storage, makeMockAccounts(), map[OrderType]orderProcessor{OrderTypeLimit: &mop}, bid)
-	bid = storage.GetBid(id)
+	mop.TryFillBid(storage, &Settlement{accounts: makeMockAccounts()}, map[OrderType]OrderProcessor{OrderTypeLimit: &mop}, bid)
+	bid, _ = storage.GetBid(id)
 	if bid.Amount != 0 {
 		t.Fatalf("%+v", bid)
 	}
@@ -87,15 +87,15 @@ func TestLimitTrySellFillsExactMatch(t *testing.T) {
 	mop := limitOrderProcessor{now: func() time.Time { return time.Time{} }}
 	storage := MakeMemoryStorage()
 	bid := Bid{Symbol: "m", Amount: 10, BidType: OrderTypeLimit, Price: 5}
-	bid.ID = storage.AddBid(bid)
+	bid.ID, _ = storage.AddBid(bid)
 	offer := Offer{Symbol: "m", Amount: 10, OfferType: OrderTypeLimit, Price: 5}
-	offer.ID = storage.AddOffer(offer)
-	mop.TrySell(storage, makeMockAccounts(), map[OrderType]orderProcessor{OrderTypeLimit: &mop}, offer)
-	bid = storage.GetBid(bid.ID)
+	offer.ID, _ = storage.AddOffer(offer)
+	mop.TrySell(storage, &Settlement{accounts: makeMockAccounts()}, map[OrderType]OrderProcessor{OrderTypeLimit: &mop}, offer)
+	bid, _ = storage.GetBid(bid.ID)
 	if bid.IsActive() {
 		t.Fatalf("Bid still active: %+v", bid)
 	}
-	offer = storage.GetOffer(offer.ID)
+	offer, _ = storage.GetOffer(offer.ID)
 	if offer.IsActive() {
 		t.Fatalf("Offer still active: %+v", offer)
 	}
@@ -105,9 +105,9 @@ func TestLimitTrySellNoBidsCompletes(t *testing.T) {
 	mop := limitOrderProcessor{now: func() time.Time { return time.Time{} }}
 	storage := MakeMemoryStorage()
 	offer := Offer{Symbol: "m", Amount: 10, OfferType: OrderTypeLimit}
-	offer.ID = storage.AddOffer(offer)
-	mop.TrySell(storage, makeMockAccounts(), map[OrderType]orderProcessor{OrderTypeLimit: &mop}, offer)
-	offer = storage.GetOffer(offer.ID)
+	offer.ID, _ = storage.AddOffer(offer)
+	mop.TrySell(storage, &Settlement{accounts: makeMockAccounts()}, map[OrderType]OrderProcessor{OrderTypeLimit: &mop}, offer)
+	offer, _ = storage.GetOffer(offer.ID)
 	if !offer.IsActive() {
 		t.Fatal("Offer not active")
 	}
@@ -120,21 +120,21 @@ func TestLimitTrySell2BidsSell(t *testing.T) {
 	mop := limitOrderProcessor{now: func() time.Time { return time.Time{} }}
 	storage := MakeMemoryStorage()
 	bid0 := Bid{Symbol: "m", Amount: 10, BidType: OrderTypeLimit, Price: 1}
-	bid0.ID = storage.AddBid(bid0)
+	bid0.ID, _ = storage.AddBid(bid0)
 	bid1 := Bid{Symbol: "m", Amount: 10, BidType: OrderTypeLimit, Price: 1}
-	bid1.ID = storage.AddBid(bid1)
+	bid1.ID, _ = storage.AddBid(bid1)
 	offer := Offer{Symbol: "m", Amount: 20, OfferType: OrderTypeLimit, Price: 1}
-	offer.ID = storage.AddOffer(offer)
-	mop.TrySell(storage, makeMockAccounts(), map[OrderType]orderProcessor{OrderTypeLimit: &mop}, offer)
-	bid0 = storage.GetBid(bid0.ID)
+	offer.ID, _ = storage.AddOffer(offer)
+	mop.TrySell(storage, &Settlement{accounts: makeMockAccounts()}, map[OrderType]OrderProcessor{OrderTypeLimit: &mop}, offer)
+	bid0, _ = storage.GetBid(bid0.ID)
 	if bid0.IsActive() {
 		t.Fatalf("Bid0 still active: %+v", bid0)
 	}
-	bid1 = storage.GetBid(bid1.ID)
+	bid1, _ = storage.GetBid(bid1.ID)
 	if bid1.IsActive() {
 		t.Fatal("Bid1 still active")
 	}
-	offer = storage.GetOffer(offer.ID)
+	offer, _ = storage.GetOffer(offer.ID)
 	if offer.IsActive() {
 		t.Fatalf("Offer still active: %+v", offer)
 	}
@@ -144,15 +144,15 @@ func TestLimitTrySellPartialBidCompletesAndDecrimentsOffer(t *testing.T) {
 	mop := limitOrderProcessor{now: func() time.Time { return time.Time{} }}
 	storage := MakeMemoryStorage()
 	bid := Bid{Symbol: "m", Amount: 5, BidType: OrderTypeLimit, Price: 1}
-	bid.ID = storage.AddBid(bid)
+	bid.ID, _ = storage.AddBid(bid)
 	offer := Offer{Symbol: "m", Amount: 10, OfferType: OrderTypeLimit, Price: 1}
-	offer.ID = storage.AddOffer(offer)
-	mop.TrySell(storage, makeMockAccounts(), map[OrderType]orderProcessor{OrderTypeLimit: &mop}, offer)
-	bid = storage.GetBid(bid.ID)
+	offer.ID, _ = storage.AddOffer(offer)
+	mop.TrySell(storage, &Settlement{accounts: makeMockAccounts()}, map[OrderType]OrderProcessor{OrderTypeLimit: &mop}, offer)
+	bid, _ = storage.GetBid(bid.ID)
 	if bid.IsActive() {
 		t.Fatalf("Bid still active: %+v", bid)
 	}
-	offer = storage.GetOffer(offer.ID)
+	offer, _ = storage.GetOffer(offer.ID)
 	if !offer.IsActive() {
 		t.Fatal("Offer inactive")
 	}
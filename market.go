@@ -1,6 +1,8 @@
 package economy
 
 import (
+	"errors"
+	"log"
 	"time"
 
 	"github.com/google/uuid"
@@ -13,10 +15,19 @@ type Offer struct {
 	Symbol    string
 	Price     int64
 	Amount    int64
+	Cancelled bool
+	// TimeInForce defaults to good till cancelled. Expires
+	// is only used by TimeInForceGTD.
+	TimeInForce TimeInForce
+	Expires     time.Time
+	// StopPrice is the trigger for stop orders. Stop
+	// offers are triggered when the last price falls to it
+	// or below.
+	StopPrice int64
 }
 
 func (o Offer) IsActive() bool {
-	return o.Amount > 0
+	return o.Amount > 0 && !o.Cancelled
 }
 
 type OrderType byte
@@ -24,58 +35,197 @@ type OrderType byte
 const (
 	OrderTypeMarket OrderType = 0
 	OrderTypeLimit  OrderType = 1
+	// OrderTypeStopMarket orders become market orders when
+	// the last price reaches their StopPrice
+	OrderTypeStopMarket OrderType = 2
+	// OrderTypeStopLimit orders become limit orders at
+	// their Price when the last price reaches their
+	// StopPrice
+	OrderTypeStopLimit OrderType = 3
 )
 
 type Bid struct {
-	ID      uuid.UUID
-	BidType OrderType
-	Account int64
-	Symbol  string
-	Price   int64
-	Amount  int64
-	NSF     bool
+	ID        uuid.UUID
+	BidType   OrderType
+	Account   int64
+	Symbol    string
+	Price     int64
+	Amount    int64
+	NSF       bool
+	Cancelled bool
+	// Held is the funds currently held in escrow to pay
+	// for the rest of the bid
+	Held int64
+	// TimeInForce defaults to good till cancelled. Expires
+	// is only used by TimeInForceGTD.
+	TimeInForce TimeInForce
+	Expires     time.Time
+	// StopPrice is the trigger for stop orders. Stop bids
+	// are triggered when the last price rises to it or
+	// above.
+	StopPrice int64
 }
 
 func (b Bid) IsActive() bool {
-	return b.Amount > 0 && !b.NSF
+	return b.Amount > 0 && !b.NSF && !b.Cancelled
 }
 
 type Transaction struct {
-	ID      uuid.UUID
-	BidID   uuid.UUID
-	OfferID uuid.UUID
-	Price   int64
-	Amount  int64
-	Date    time.Time
+	ID           uuid.UUID
+	BidID        uuid.UUID
+	OfferID      uuid.UUID
+	Symbol       string
+	BidAccount   int64
+	OfferAccount int64
+	Price        int64
+	Amount       int64
+	Date         time.Time
+	// BidFee and OfferFee are the fees charged to the
+	// buyer and seller and credited to the house account
+	BidFee   int64
+	OfferFee int64
 }
 
+var (
+	// ErrNotOwner is returned when an account attempts to
+	// change an order that belongs to a different account
+	ErrNotOwner = errors.New("order belongs to another account")
+	// ErrOrderInactive is returned when attempting to change
+	// an order that is already filled, cancelled or NSF
+	ErrOrderInactive = errors.New("order is no longer active")
+	// ErrInvalidAmount is returned when an amendment would
+	// leave an order with nothing to trade
+	ErrInvalidAmount = errors.New("amount must be greater than zero")
+	// ErrInsufficientHoldings is returned when a seller does
+	// not own any of what they are trying to offer
+	ErrInsufficientHoldings = errors.New("insufficient holdings")
+	// ErrInsufficientFunds is returned when escrow is enabled
+	// and a buyer can't cover the cost of their bid
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	// ErrOrderNotFound is returned when there is no bid or
+	// offer with the requested ID
+	ErrOrderNotFound = errors.New("order not found")
+	// ErrUnknownOrderType is returned for bids and offers
+	// with an OrderType the Market can't process
+	ErrUnknownOrderType = errors.New("unknown order type")
+	// ErrCorruptSnapshot is returned when saved market data
+	// can't be loaded
+	ErrCorruptSnapshot = errors.New("corrupt snapshot")
+	// ErrOrderTypeInUse is returned when registering a
+	// processor for an OrderType that already has one
+	ErrOrderTypeInUse = errors.New("order type already registered")
+	// ErrCorruptJournal is returned when a journal can't be
+	// recovered
+	ErrCorruptJournal = errors.New("corrupt journal")
+	// ErrJournalOpen is returned when opening a journal for
+	// storage that is already recording one
+	ErrJournalOpen = errors.New("journal already open")
+	// ErrSymbolHalted is returned for orders in a symbol
+	// whose trading is halted
+	ErrSymbolHalted = errors.New("trading halted")
+	// ErrPriceOutsideBand is returned for orders priced
+	// too far from the symbol's reference price
+	ErrPriceOutsideBand = errors.New("price outside band")
+	// ErrAuctionInProgress is returned for immediate or
+	// cancel and fill or kill orders placed during an
+	// auction, which they can't take part in
+	ErrAuctionInProgress = errors.New("auction in progress")
+	// ErrNoAuction is returned when uncrossing a symbol
+	// that isn't in an auction
+	ErrNoAuction = errors.New("no auction in progress")
+	// ErrEscrowUnsupported is returned for bids in a Market
+	// created WithEscrow whose Accounts can't hold funds
+	ErrEscrowUnsupported = errors.New("accounts do not implement EscrowAccounts")
+	// ErrTimeOutOfRange is returned by SQLStorage for an
+	// order expiry, trade date or candle start outside the
+	// years 1678 to 2262, which it can't store
+	ErrTimeOutOfRange = errors.New("time out of range")
+)
+
 // MarketStorage interface must keep track of Bids, Offers,
-// and Transactions.
+// and Transactions. Methods return an error if the
+// underlying storage fails, or ErrOrderNotFound or
+// ErrUnknownOrderType as described.
 type MarketStorage interface {
 	// Lock ensures that concurrent activity is safe until
 	// Unlock is called
 	Lock()
 	// Unlock makes storage available for other threads
 	Unlock()
-	// AddOffer returns the UUID of the created offer
-	AddOffer(Offer) uuid.UUID
+	// AddOffer returns the UUID of the created offer, or
+	// ErrUnknownOrderType if it can't be ranked
+	AddOffer(Offer) (uuid.UUID, error)
 	// BestOffer returns the offer with the best price
 	// for the specified symbol, or false if there are
 	// no offers
-	BestOffer(string) (Offer, bool)
+	BestOffer(string) (Offer, bool, error)
 	// BestBid returns the bid with the highest price for
 	// the specified symbol, or false if no bids
-	BestBid(string) (Bid, bool)
-	UpdateOffer(Offer)
-	AddBid(Bid) uuid.UUID
-	UpdateBid(Bid)
-	GetBid(uuid.UUID) Bid
-	GetOffer(uuid.UUID) Offer
-	NewTransaction(Transaction)
-	LastPrice(string) int64
-	SetLastPrice(string, int64)
-	// Return all the known symbols
-	AllSymbols() []string
+	BestBid(string) (Bid, bool, error)
+	UpdateOffer(Offer) error
+	// AddBid returns the UUID of the created bid, or
+	// ErrUnknownOrderType if it can't be ranked
+	AddBid(Bid) (uuid.UUID, error)
+	UpdateBid(Bid) error
+	// GetBid returns ErrOrderNotFound if there is no bid
+	// with the specified ID
+	GetBid(uuid.UUID) (Bid, error)
+	// GetOffer returns ErrOrderNotFound if there is no
+	// offer with the specified ID
+	GetOffer(uuid.UUID) (Offer, error)
+	// NewTransaction returns the UUID of the created
+	// transaction
+	NewTransaction(Transaction) (uuid.UUID, error)
+	LastPrice(string) (int64, error)
+	SetLastPrice(string, int64) error
+	// AllSymbols returns every symbol that has a last
+	// price or any bids or offers
+	AllSymbols() ([]string, error)
+	// Transactions returns the transactions that match the
+	// filter, oldest first
+	Transactions(TransactionFilter) ([]Transaction, error)
+	// RestingBids returns the active bids for the symbol
+	// in the order they would be filled
+	RestingBids(string) ([]Bid, error)
+	// RestingOffers returns the active offers for the
+	// symbol in the order they would be sold
+	RestingOffers(string) ([]Offer, error)
+	// StopBids returns the active stop bids for the symbol
+	// that haven't been triggered, in the order they were
+	// placed
+	StopBids(string) ([]Bid, error)
+	// StopOffers returns the active stop offers for the
+	// symbol that haven't been triggered, in the order
+	// they were placed
+	StopOffers(string) ([]Offer, error)
+	// RegisterOrderType makes storage accept orders of a
+	// type it doesn't know, ranking them in the order book
+	// by the price the pricer gives
+	RegisterOrderType(OrderType, OrderPricer) error
+	// Candle returns the candle for the symbol and interval
+	// that starts at the specified time, or false if there
+	// isn't one
+	Candle(string, time.Duration, time.Time) (Candle, bool, error)
+	// SetCandle adds or replaces the candle with the same
+	// symbol, interval and start
+	SetCandle(Candle) error
+	// DeleteCandles removes every candle for the symbol
+	DeleteCandles(string) error
+	// Candles returns the candles for the symbol and
+	// interval starting in [since, until), oldest first. A
+	// zero time leaves that end open.
+	Candles(symbol string, interval time.Duration, since, until time.Time) ([]Candle, error)
+}
+
+// CommitStorage is MarketStorage that saves the changes
+// made while it is locked when it is unlocked, which can
+// fail. The Market ends the operations that change storage
+// with Commit instead of Unlock, and returns its error.
+type CommitStorage interface {
+	MarketStorage
+	// Commit must unlock storage like Unlock, and return an
+	// error if the changes made since Lock weren't saved
+	Commit() error
 }
 
 // Accounts provides a method for code to inject a callback
@@ -91,11 +241,138 @@ type Accounts interface {
 	DebitIfPossible(accountID, funds int64) bool
 }
 
-type orderProcessor interface {
-	TryFillBid(MarketStorage, Accounts, map[OrderType]orderProcessor, Bid)
-	GetAskingPrice(MarketStorage, Offer) int64
-	TrySell(MarketStorage, Accounts, map[OrderType]orderProcessor, Offer)
-	GetBidPrice(MarketStorage, Bid) int64
+// EscrowAccounts is Accounts that can also hold funds
+// aside for resting bids. It is required when a Market is
+// created with WithEscrow.
+type EscrowAccounts interface {
+	Accounts
+	// Hold must set aside the specified funds so they can
+	// only be spent by DebitHeld, or return false
+	Hold(accountID, funds int64) bool
+	// Release must return held funds to the account
+	Release(accountID, funds int64)
+	// DebitHeld must debit funds that were previously held
+	DebitHeld(accountID, funds int64)
+}
+
+// FundsAccounts is Accounts that can say whether a debit
+// would succeed without making it. Fill or kill bids use
+// it to make sure the buyer can pay for every fill before
+// the first one. With other Accounts only the funds held
+// by escrow are checked, and a fill or kill order can be
+// left partly filled if a buyer runs out of funds.
+type FundsAccounts interface {
+	Accounts
+	// CanDebit must return true if DebitIfPossible would
+	// succeed for the specified funds, without changing
+	// the account
+	CanDebit(accountID, funds int64) bool
+}
+
+// Holdings provides a method for code to inject a callback
+// for tracking the goods owned by each account, so that
+// sellers can only offer what they own. As with Accounts,
+// all functions must be transaction safe.
+type Holdings interface {
+	// Reserve must set aside up to amount units of symbol
+	// owned by the account so they can't be offered twice,
+	// and return the number of units actually reserved
+	Reserve(accountID int64, symbol string, amount int64) int64
+	// Release must return previously reserved units to
+	// the account
+	Release(accountID int64, symbol string, amount int64)
+	// Transfer must move previously reserved units from
+	// one account to another
+	Transfer(from, to int64, symbol string, amount int64)
+}
+
+// Settlement carries the collaborators needed to move
+// funds and goods between accounts when a bid is filled,
+// and to tell subscribers about it.
+type Settlement struct {
+	accounts Accounts
+	holdings Holdings
+	escrow   EscrowAccounts
+	events   *publisher
+	// trades collects the transactions made while the
+	// Market is handling a single order
+	trades []Transaction
+	// slippage is the percent above the last price that is
+	// held for market bids
+	slippage int64
+	// intervals are the candle intervals to keep
+	intervals []time.Duration
+	fees      *FeeSchedule
+	// taker is the side of the order being processed,
+	// which pays taker fees
+	taker     Side
+	selfTrade SelfTradePrevention
+	bands     *PriceBands
+	// reference is the price each symbol's band is centred
+	// on, and halts the symbols that can't be traded
+	reference map[string]int64
+	halts     map[string]halt
+}
+
+// OrderPricer prices the orders of one OrderType so that
+// they can be ranked against other orders
+type OrderPricer interface {
+	// GetAskingPrice returns the price an offer is
+	// currently asking
+	GetAskingPrice(MarketStorage, Offer) (int64, error)
+	// GetBidPrice returns the price a bid is currently
+	// offering to pay
+	GetBidPrice(MarketStorage, Bid) (int64, error)
+}
+
+// OrderProcessor matches the orders of one OrderType
+// against the other side of the market. The Market calls
+// it with storage locked, once when an order is placed or
+// amended. The map holds the processors for every order
+// type, for pricing the orders on the other side.
+// Processors move funds and goods with Settlement.Fill.
+type OrderProcessor interface {
+	OrderPricer
+	// TryFillBid fills as much of a bid that has just
+	// been stored as it should
+	TryFillBid(MarketStorage, *Settlement, map[OrderType]OrderProcessor, Bid) error
+	// TrySell sells as much of an offer that has just
+	// been stored as it should
+	TrySell(MarketStorage, *Settlement, map[OrderType]OrderProcessor, Offer) error
+}
+
+// Option changes the default behavior of a Market
+// created by MakeMarket
+type Option func(*Market)
+
+// WithHoldings makes the Market reserve goods from the
+// seller's holdings when an offer is made, and transfer
+// them to the buyer as the offer is filled. Offers larger
+// than the seller's holdings are reduced to what the
+// seller owns.
+func WithHoldings(h Holdings) Option {
+	return func(m *Market) {
+		m.settlement.holdings = h
+	}
+}
+
+// WithEscrow makes the Market hold the full cost of each
+// bid when it is made, so bids that can't be paid for are
+// rejected right away instead of going NSF when they are
+// filled. Market bids hold the last price plus slippage
+// percent per unit. The Market's Accounts must implement
+// EscrowAccounts, or every bid fails with
+// ErrEscrowUnsupported.
+func WithEscrow(slippage int64) Option {
+	return func(m *Market) {
+		escrow, ok := m.settlement.accounts.(EscrowAccounts)
+		if !ok {
+			m.err = ErrEscrowUnsupported
+			return
+		}
+		m.settlement.escrow = escrow
+		m.settlement.slippage = slippage
+	}
 }
 
 // MakeMarket creates an initiliazed *Market struct.
@@ -103,56 +380,452 @@ type orderProcessor interface {
 // return the current time. This allows the system to
 // act as a simulator if simulator time is not the same
 // as real time.
-func MakeMarket(t func() time.Time, s MarketStorage, a Accounts) *Market {
-	return &Market{
-		storage:  s,
-		accounts: a,
-		orderProcessors: map[OrderType]orderProcessor{
-			OrderTypeMarket: &marketOrderProcessor{now: t},
-			OrderTypeLimit:  &limitOrderProcessor{now: t},
+func MakeMarket(t func() time.Time, s MarketStorage, a Accounts, opts ...Option) *Market {
+	m := &Market{
+		now:     t,
+		storage: s,
+		settlement: &Settlement{
+			accounts:  a,
+			events:    &publisher{},
+			intervals: DefaultCandleIntervals,
 		},
+		orderProcessors: map[OrderType]OrderProcessor{
+			OrderTypeMarket:     &marketOrderProcessor{now: t},
+			OrderTypeLimit:      &limitOrderProcessor{now: t},
+			OrderTypeStopMarket: &stopOrderProcessor{now: t},
+			OrderTypeStopLimit:  &stopOrderProcessor{now: t},
+		},
+	}
+	for _, opt := range opts {
+		opt(m)
 	}
+	return m
 }
 
 type Market struct {
+	now             func() time.Time
 	storage         MarketStorage
-	accounts        Accounts
-	orderProcessors map[OrderType]orderProcessor
+	settlement      *Settlement
+	orderProcessors map[OrderType]OrderProcessor
+	auctions        *AuctionSchedule
+	// sessions are the symbols that have been checked for
+	// auctions
+	sessions map[string]*session
+	// err is an option that couldn't be applied, which
+	// stops the orders that depend on it
+	err error
 }
 
-func (m *Market) Offer(o Offer) {
+// RegisterOrderType makes the Market accept bids and
+// offers of type t, and use p to match and price them.
+// It returns ErrOrderTypeInUse if t is one of the built in
+// types or was registered before.
+func (m *Market) RegisterOrderType(t OrderType, p OrderProcessor) error {
 	m.storage.Lock()
 	defer m.storage.Unlock()
-	o.ID = m.storage.AddOffer(o)
-	m.orderProcessors[o.OfferType].TrySell(
-		m.storage, m.accounts, m.orderProcessors, o,
-	)
+	if _, found := m.orderProcessors[t]; found {
+		return ErrOrderTypeInUse
+	}
+	if err := m.storage.RegisterOrderType(t, p); err != nil {
+		return err
+	}
+	m.orderProcessors[t] = p
+	return nil
+}
+
+// tryFillBid has p process bid as the taker
+func (m *Market) tryFillBid(p OrderProcessor, bid Bid) error {
+	m.settlement.taker = SideBid
+	return p.TryFillBid(m.storage, m.settlement, m.orderProcessors, bid)
+}
+
+// trySell has p process offer as the taker
+func (m *Market) trySell(p OrderProcessor, offer Offer) error {
+	m.settlement.taker = SideOffer
+	return p.TrySell(m.storage, m.settlement, m.orderProcessors, offer)
 }
 
-func (m *Market) Bid(b Bid) uuid.UUID {
+// unlock unlocks storage at the end of an operation that
+// changes it. If storage is CommitStorage and the changes
+// weren't saved, err is set to why unless the operation
+// already failed.
+func (m *Market) unlock(err *error) {
+	c, ok := m.storage.(CommitStorage)
+	if !ok {
+		m.storage.Unlock()
+		return
+	}
+	if cerr := c.Commit(); cerr != nil && *err == nil {
+		*err = cerr
+	}
+}
+
+func (m *Market) processor(t OrderType) (OrderProcessor, error) {
+	p, found := m.orderProcessors[t]
+	if !found {
+		return nil, ErrUnknownOrderType
+	}
+	return p, nil
+}
+
+// Offer puts o up for sale and reports what happened to
+// it. When the Market tracks holdings, the offer is reduced
+// to what the seller owns, and ErrInsufficientHoldings is
+// returned if they own none.
+func (m *Market) Offer(o Offer) (_ ExecutionReport, err error) {
 	m.storage.Lock()
-	defer m.storage.Unlock()
-	b.ID = m.storage.AddBid(b)
-	m.orderProcessors[b.BidType].TryFillBid(
-		m.storage, m.accounts, m.orderProcessors, b,
-	)
-	return b.ID
+	defer m.unlock(&err)
+	p, err := m.processor(o.OfferType)
+	if err != nil {
+		return ExecutionReport{}, err
+	}
+	auction, err := m.updateSession(o.Symbol)
+	if err != nil {
+		return ExecutionReport{}, err
+	}
+	if auction && o.TimeInForce.immediate() {
+		return ExecutionReport{}, ErrAuctionInProgress
+	}
+	if err = m.checkOffer(p, o); err != nil {
+		return ExecutionReport{}, err
+	}
+	h := m.settlement.holdings
+	if h != nil {
+		o.Amount = h.Reserve(o.Account, o.Symbol, o.Amount)
+		if o.Amount < 1 {
+			return ExecutionReport{}, ErrInsufficientHoldings
+		}
+	}
+	o.ID, err = m.storage.AddOffer(o)
+	if err != nil {
+		if h != nil {
+			h.Release(o.Account, o.Symbol, o.Amount)
+		}
+		return ExecutionReport{}, err
+	}
+	m.settlement.events.publish(offerEvent(EventOrderAccepted, m.now(), o))
+	m.settlement.trades = nil
+	if auction {
+		return makeReport(o.ID, o.Amount, false, false, nil), nil
+	}
+	err = m.trySell(p, o)
+	if err != nil {
+		return ExecutionReport{}, err
+	}
+	if err = m.triggerStops(o.Symbol); err != nil {
+		return ExecutionReport{}, err
+	}
+	o, err = m.storage.GetOffer(o.ID)
+	if err != nil {
+		return ExecutionReport{}, err
+	}
+	return makeReport(o.ID, o.Amount, false, o.Cancelled, m.settlement.trades), nil
 }
 
-func (m *Market) GetBid(id uuid.UUID) Bid {
+// Bid puts in an order to buy and reports what happened
+// to it. When escrow is enabled, ErrInsufficientFunds is
+// returned if the buyer can't cover the cost of the bid.
+func (m *Market) Bid(b Bid) (_ ExecutionReport, err error) {
+	if m.err != nil {
+		return ExecutionReport{}, m.err
+	}
+	m.storage.Lock()
+	defer m.unlock(&err)
+	p, err := m.processor(b.BidType)
+	if err != nil {
+		return ExecutionReport{}, err
+	}
+	auction, err := m.updateSession(b.Symbol)
+	if err != nil {
+		return ExecutionReport{}, err
+	}
+	if auction && b.TimeInForce.immediate() {
+		return ExecutionReport{}, ErrAuctionInProgress
+	}
+	if err = m.checkBid(p, b); err != nil {
+		return ExecutionReport{}, err
+	}
+	b.Held = 0
+	err = m.settlement.hold(m.storage, &b)
+	if err != nil {
+		return ExecutionReport{}, err
+	}
+	b.ID, err = m.storage.AddBid(b)
+	if err != nil {
+		m.settlement.release(&b)
+		return ExecutionReport{}, err
+	}
+	m.settlement.events.publish(bidEvent(EventOrderAccepted, m.now(), b))
+	m.settlement.trades = nil
+	if auction {
+		return makeReport(b.ID, b.Amount, false, false, nil), nil
+	}
+	err = m.tryFillBid(p, b)
+	if err != nil {
+		return ExecutionReport{}, err
+	}
+	if err = m.triggerStops(b.Symbol); err != nil {
+		return ExecutionReport{}, err
+	}
+	b, err = m.storage.GetBid(b.ID)
+	if err != nil {
+		return ExecutionReport{}, err
+	}
+	return makeReport(b.ID, b.Amount, b.NSF, b.Cancelled, m.settlement.trades), nil
+}
+
+// FindBid returns the bid with the specified ID, or
+// ErrOrderNotFound
+func (m *Market) FindBid(id uuid.UUID) (Bid, error) {
 	m.storage.Lock()
 	defer m.storage.Unlock()
 	return m.storage.GetBid(id)
 }
 
-func (m *Market) AllSymbols() []string {
+// FindOffer returns the offer with the specified ID, or
+// ErrOrderNotFound
+func (m *Market) FindOffer(id uuid.UUID) (Offer, error) {
+	m.storage.Lock()
+	defer m.storage.Unlock()
+	return m.storage.GetOffer(id)
+}
+
+// GetBid is the same as FindBid, but panics on error.
+//
+// Deprecated: use FindBid
+func (m *Market) GetBid(id uuid.UUID) Bid {
+	b, err := m.FindBid(id)
+	if err != nil {
+		log.Panicf("Bid %s: %s", id, err)
+	}
+	return b
+}
+
+// GetOffer is the same as FindOffer, but panics on error.
+//
+// Deprecated: use FindOffer
+func (m *Market) GetOffer(id uuid.UUID) Offer {
+	o, err := m.FindOffer(id)
+	if err != nil {
+		log.Panicf("Offer %s: %s", id, err)
+	}
+	return o
+}
+
+// CancelBid withdraws a resting bid so that it can no
+// longer be filled. Only the account that placed the bid
+// may cancel it.
+func (m *Market) CancelBid(account int64, id uuid.UUID) (err error) {
+	m.storage.Lock()
+	defer m.unlock(&err)
+	b, err := m.storage.GetBid(id)
+	if err != nil {
+		return err
+	}
+	if b.Account != account {
+		return ErrNotOwner
+	}
+	if !b.IsActive() {
+		return ErrOrderInactive
+	}
+	return m.settlement.cancelBid(m.storage, m.now(), b, EventCancelled)
+}
+
+// CancelOffer withdraws a resting offer so that it can no
+// longer be sold. Only the account that placed the offer
+// may cancel it.
+func (m *Market) CancelOffer(account int64, id uuid.UUID) (err error) {
+	m.storage.Lock()
+	defer m.unlock(&err)
+	o, err := m.storage.GetOffer(id)
+	if err != nil {
+		return err
+	}
+	if o.Account != account {
+		return ErrNotOwner
+	}
+	if !o.IsActive() {
+		return ErrOrderInactive
+	}
+	return m.settlement.cancelOffer(m.storage, m.now(), o, EventCancelled)
+}
+
+// AmendBid changes the price and remaining amount of a
+// resting bid, then tries to fill it again in case the
+// new terms make it marketable. When escrow is enabled,
+// ErrInsufficientFunds is returned and the bid is left
+// unchanged if the buyer can't cover the new cost.
+func (m *Market) AmendBid(account int64, id uuid.UUID, price, amount int64) (err error) {
+	if m.err != nil {
+		return m.err
+	}
+	if amount < 1 {
+		return ErrInvalidAmount
+	}
+	m.storage.Lock()
+	defer m.unlock(&err)
+	b, err := m.storage.GetBid(id)
+	if err != nil {
+		return err
+	}
+	if b.Account != account {
+		return ErrNotOwner
+	}
+	if !b.IsActive() {
+		return ErrOrderInactive
+	}
+	p, err := m.processor(b.BidType)
+	if err != nil {
+		return err
+	}
+	auction, err := m.updateSession(b.Symbol)
+	if err != nil {
+		return err
+	}
+	amended := b
+	amended.Price = price
+	amended.Amount = amount
+	if err = m.checkBid(p, amended); err != nil {
+		return err
+	}
+	err = m.settlement.hold(m.storage, &amended)
+	if err != nil {
+		return err
+	}
+	err = m.storage.UpdateBid(amended)
+	if err != nil {
+		// Put the funds held back as they were for the bid
+		// that is still stored
+		if e := m.settlement.escrow; e != nil {
+			if diff := amended.Held - b.Held; diff > 0 {
+				e.Release(b.Account, diff)
+			} else if diff < 0 {
+				e.Hold(b.Account, -diff)
+			}
+		}
+		return err
+	}
+	b = amended
+	m.settlement.events.publish(bidEvent(EventAmended, m.now(), b))
+	if auction {
+		return nil
+	}
+	err = m.tryFillBid(p, b)
+	if err != nil {
+		return err
+	}
+	return m.triggerStops(b.Symbol)
+}
+
+// AmendOffer changes the price and remaining amount of a
+// resting offer, then tries to sell it again in case the
+// new terms make it marketable. When the Market tracks
+// holdings, an increased amount is limited to what the
+// seller owns.
+func (m *Market) AmendOffer(account int64, id uuid.UUID, price, amount int64) (err error) {
+	if amount < 1 {
+		return ErrInvalidAmount
+	}
+	m.storage.Lock()
+	defer m.unlock(&err)
+	o, err := m.storage.GetOffer(id)
+	if err != nil {
+		return err
+	}
+	if o.Account != account {
+		return ErrNotOwner
+	}
+	if !o.IsActive() {
+		return ErrOrderInactive
+	}
+	p, err := m.processor(o.OfferType)
+	if err != nil {
+		return err
+	}
+	auction, err := m.updateSession(o.Symbol)
+	if err != nil {
+		return err
+	}
+	amended := o
+	amended.Price = price
+	if err = m.checkOffer(p, amended); err != nil {
+		return err
+	}
+	h := m.settlement.holdings
+	if h != nil {
+		if amount > o.Amount {
+			amount = o.Amount + h.Reserve(o.Account, o.Symbol, amount-o.Amount)
+		} else {
+			h.Release(o.Account, o.Symbol, o.Amount-amount)
+		}
+	}
+	was := o.Amount
+	o.Price = price
+	o.Amount = amount
+	err = m.storage.UpdateOffer(o)
+	if err != nil {
+		// Put the reservation back as it was for the offer
+		// that is still stored
+		if h != nil {
+			if amount > was {
+				h.Release(o.Account, o.Symbol, amount-was)
+			} else {
+				h.Reserve(o.Account, o.Symbol, was-amount)
+			}
+		}
+		return err
+	}
+	m.settlement.events.publish(offerEvent(EventAmended, m.now(), o))
+	if auction {
+		return nil
+	}
+	err = m.trySell(p, o)
+	if err != nil {
+		return err
+	}
+	return m.triggerStops(o.Symbol)
+}
+
+// Symbols returns all the symbols known to the Market
+func (m *Market) Symbols() ([]string, error) {
 	m.storage.Lock()
 	defer m.storage.Unlock()
 	return m.storage.AllSymbols()
 }
 
-func (m *Market) LastPrice(s string) int64 {
+// Price returns the last price the symbol traded at
+func (m *Market) Price(s string) (int64, error) {
 	m.storage.Lock()
 	defer m.storage.Unlock()
 	return m.storage.LastPrice(s)
 }
+
+// Transactions returns the transactions that match the
+// filter, oldest first
+func (m *Market) Transactions(f TransactionFilter) ([]Transaction, error) {
+	m.storage.Lock()
+	defer m.storage.Unlock()
+	return m.storage.Transactions(f)
+}
+
+// AllSymbols is the same as Symbols, but panics on error.
+//
+// Deprecated: use Symbols
+func (m *Market) AllSymbols() []string {
+	symbols, err := m.Symbols()
+	if err != nil {
+		log.Panicf("AllSymbols: %s", err)
+	}
+	return symbols
+}
+
+// LastPrice is the same as Price, but panics on error.
+//
+// Deprecated: use Price
+func (m *Market) LastPrice(s string) int64 {
+	p, err := m.Price(s)
+	if err != nil {
+		log.Panicf("LastPrice %s: %s", s, err)
+	}
+	return p
+}
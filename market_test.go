@@ -1,8 +1,13 @@
 package economy
 
 import (
+	"bytes"
+	"errors"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 func TestOfferAddedToStorage(t *testing.T) {
@@ -18,12 +23,260 @@ func TestOfferAddedToStorage(t *testing.T) {
 func TestBidAddedToStorage(t *testing.T) {
 	storage := MakeMemoryStorage()
 	m := MakeMarket(time.Now, storage, makeMockAccounts())
-	m.orderProcessors = map[OrderType]orderProcessor{
+	m.orderProcessors = map[OrderType]OrderProcessor{
 		OrderTypeMarket: &mockOrderProcessor{},
 	}
 	b := Bid{}
-	id := m.Bid(b)
+	report, _ := m.Bid(b)
+	id := report.OrderID
 	if _, found := storage.bids[id]; !found {
 		t.Fatalf("%+v", storage)
 	}
 }
+
+func TestCancelBidStopsFill(t *testing.T) {
+	storage := MakeMemoryStorage()
+	m := MakeMarket(time.Now, storage, makeMockAccounts())
+	report, _ := m.Bid(Bid{Symbol: "m", Account: 1, Amount: 10, BidType: OrderTypeLimit, Price: 5})
+	id := report.OrderID
+	if err := m.CancelBid(1, id); err != nil {
+		t.Fatal(err)
+	}
+	m.Offer(Offer{Symbol: "m", Account: 2, Amount: 10, OfferType: OrderTypeLimit, Price: 5})
+	bid, _ := m.FindBid(id)
+	if bid.Amount != 10 || bid.IsActive() {
+		t.Fatalf("%+v", bid)
+	}
+	if len(storage.transactions) != 0 {
+		t.Fatalf("%+v", storage.transactions)
+	}
+}
+
+func TestCancelBidWrongAccount(t *testing.T) {
+	m := MakeMarket(time.Now, MakeMemoryStorage(), makeMockAccounts())
+	report, _ := m.Bid(Bid{Symbol: "m", Account: 1, Amount: 10, BidType: OrderTypeLimit, Price: 5})
+	id := report.OrderID
+	if err := m.CancelBid(2, id); err != ErrNotOwner {
+		t.Fatalf("Expected ErrNotOwner, got %v", err)
+	}
+	if !m.GetBid(id).IsActive() {
+		t.Fatal("Bid cancelled by wrong account")
+	}
+}
+
+func TestCancelOfferTwice(t *testing.T) {
+	m := MakeMarket(time.Now, MakeMemoryStorage(), makeMockAccounts())
+	report, _ := m.Offer(Offer{Symbol: "m", Account: 1, Amount: 10, OfferType: OrderTypeLimit, Price: 5})
+	id := report.OrderID
+	if err := m.CancelOffer(1, id); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.CancelOffer(1, id); err != ErrOrderInactive {
+		t.Fatalf("Expected ErrOrderInactive, got %v", err)
+	}
+}
+
+func TestAmendBidBecomesMarketable(t *testing.T) {
+	storage := MakeMemoryStorage()
+	m := MakeMarket(time.Now, storage, makeMockAccounts())
+	m.Offer(Offer{Symbol: "m", Account: 2, Amount: 10, OfferType: OrderTypeLimit, Price: 8})
+	report, _ := m.Bid(Bid{Symbol: "m", Account: 1, Amount: 10, BidType: OrderTypeLimit, Price: 5})
+	id := report.OrderID
+	if m.GetBid(id).Amount != 10 {
+		t.Fatal("Bid filled before amendment")
+	}
+	if err := m.AmendBid(1, id, 8, 6); err != nil {
+		t.Fatal(err)
+	}
+	bid, _ := m.FindBid(id)
+	if bid.Amount != 0 {
+		t.Fatalf("%+v", bid)
+	}
+	if len(storage.transactions) != 1 || storage.transactions[0].Amount != 6 {
+		t.Fatalf("%+v", storage.transactions)
+	}
+}
+
+func TestAmendOfferRejectsZeroAmount(t *testing.T) {
+	m := MakeMarket(time.Now, MakeMemoryStorage(), makeMockAccounts())
+	report, _ := m.Offer(Offer{Symbol: "m", Account: 1, Amount: 10, OfferType: OrderTypeLimit, Price: 5})
+	id := report.OrderID
+	if err := m.AmendOffer(1, id, 5, 0); err != ErrInvalidAmount {
+		t.Fatalf("Expected ErrInvalidAmount, got %v", err)
+	}
+	if m.GetOffer(id).Amount != 10 {
+		t.Fatal("Offer changed")
+	}
+}
+
+func TestBidReportFilled(t *testing.T) {
+	m := MakeMarket(time.Now, MakeMemoryStorage(), makeMockAccounts())
+	m.Offer(Offer{Symbol: "m", Account: 2, Amount: 6, OfferType: OrderTypeLimit, Price: 5})
+	m.Offer(Offer{Symbol: "m", Account: 3, Amount: 6, OfferType: OrderTypeLimit, Price: 6})
+	report, err := m.Bid(Bid{Symbol: "m", Account: 1, Amount: 10, BidType: OrderTypeLimit, Price: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Status != OrderStatusFilled || report.Remaining != 0 || report.Filled() != 10 {
+		t.Fatalf("%+v", report)
+	}
+	if len(report.Transactions) != 2 || report.Transactions[0].Price != 5 || report.Transactions[1].Price != 6 {
+		t.Fatalf("%+v", report.Transactions)
+	}
+	if report.Transactions[0].ID == uuid.Nil || report.Transactions[0].BidID != report.OrderID {
+		t.Fatalf("%+v", report.Transactions[0])
+	}
+}
+
+func TestOfferReportPartiallyFilled(t *testing.T) {
+	m := MakeMarket(time.Now, MakeMemoryStorage(), makeMockAccounts())
+	m.Bid(Bid{Symbol: "m", Account: 1, Amount: 4, BidType: OrderTypeLimit, Price: 5})
+	report, _ := m.Offer(Offer{Symbol: "m", Account: 2, Amount: 10, OfferType: OrderTypeLimit, Price: 5})
+	if report.Status != OrderStatusPartiallyFilled || report.Remaining != 6 || len(report.Transactions) != 1 {
+		t.Fatalf("%+v", report)
+	}
+}
+
+func TestBidReportResting(t *testing.T) {
+	m := MakeMarket(time.Now, MakeMemoryStorage(), makeMockAccounts())
+	report, _ := m.Bid(Bid{Symbol: "m", Account: 1, Amount: 4, BidType: OrderTypeLimit, Price: 5})
+	if report.Status != OrderStatusResting || report.Remaining != 4 || len(report.Transactions) != 0 {
+		t.Fatalf("%+v", report)
+	}
+	report, _ = m.Bid(Bid{Symbol: "m", Account: 1, Amount: 4, BidType: OrderTypeLimit, Price: 5})
+	if len(report.Transactions) != 0 {
+		t.Fatalf("Transactions from previous order: %+v", report)
+	}
+}
+
+func TestBidReportNSF(t *testing.T) {
+	accounts := makeMockAccounts()
+	accounts.rejects[1] = true
+	m := MakeMarket(time.Now, MakeMemoryStorage(), accounts)
+	m.Offer(Offer{Symbol: "m", Account: 2, Amount: 6, OfferType: OrderTypeLimit, Price: 5})
+	report, _ := m.Bid(Bid{Symbol: "m", Account: 1, Amount: 4, BidType: OrderTypeLimit, Price: 5})
+	if report.Status != OrderStatusNSF || report.Remaining != 4 {
+		t.Fatalf("%+v", report)
+	}
+}
+
+func TestFindBidNotFound(t *testing.T) {
+	m := MakeMarket(time.Now, MakeMemoryStorage(), makeMockAccounts())
+	if _, err := m.FindBid(uuid.New()); err != ErrOrderNotFound {
+		t.Fatalf("Expected ErrOrderNotFound, got %v", err)
+	}
+	if err := m.CancelOffer(1, uuid.New()); err != ErrOrderNotFound {
+		t.Fatalf("Expected ErrOrderNotFound, got %v", err)
+	}
+}
+
+func TestBidUnknownOrderType(t *testing.T) {
+	storage := MakeMemoryStorage()
+	m := MakeMarket(time.Now, storage, makeMockAccounts())
+	if _, err := m.Bid(Bid{Symbol: "m", Amount: 1, BidType: 99}); err != ErrUnknownOrderType {
+		t.Fatalf("Expected ErrUnknownOrderType, got %v", err)
+	}
+	if len(storage.bids) != 0 {
+		t.Fatalf("%+v", storage.bids)
+	}
+}
+
+const orderTypePegged OrderType = 10
+
+// peggedProcessor prices offers at their Price above the
+// last price, and leaves them resting until a bid comes
+type peggedProcessor struct{}
+
+func (p peggedProcessor) TryFillBid(MarketStorage, *Settlement, map[OrderType]OrderProcessor, Bid) error {
+	return nil
+}
+
+func (p peggedProcessor) TrySell(MarketStorage, *Settlement, map[OrderType]OrderProcessor, Offer) error {
+	return nil
+}
+
+func (p peggedProcessor) GetAskingPrice(ms MarketStorage, o Offer) (int64, error) {
+	lastPrice, err := ms.LastPrice(o.Symbol)
+	return lastPrice + o.Price, err
+}
+
+func (p peggedProcessor) GetBidPrice(ms MarketStorage, b Bid) (int64, error) {
+	lastPrice, err := ms.LastPrice(b.Symbol)
+	return lastPrice - b.Price, err
+}
+
+func TestRegisterOrderTypeCollisions(t *testing.T) {
+	m := MakeMarket(time.Now, MakeMemoryStorage(), makeMockAccounts())
+	if err := m.RegisterOrderType(OrderTypeLimit, peggedProcessor{}); err != ErrOrderTypeInUse {
+		t.Fatalf("Expected ErrOrderTypeInUse, got %v", err)
+	}
+	if err := m.RegisterOrderType(orderTypePegged, peggedProcessor{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RegisterOrderType(orderTypePegged, peggedProcessor{}); err != ErrOrderTypeInUse {
+		t.Fatalf("Expected ErrOrderTypeInUse, got %v", err)
+	}
+}
+
+func TestCustomOrderTypeIsPricedByProcessor(t *testing.T) {
+	s := MakeMemoryStorage()
+	s.SetLastPrice(sym, 5)
+	m := MakeMarket(time.Now, s, makeMockAccounts())
+	m.RegisterOrderType(orderTypePegged, peggedProcessor{})
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 1, OfferType: OrderTypeLimit, Price: 8})
+	pegged, err := m.Offer(Offer{Symbol: sym, Account: 3, Amount: 1, OfferType: orderTypePegged, Price: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, _ := m.Depth(sym, 0)
+	if len(d.Offers) != 2 || d.Offers[0].Price != 7 || d.Offers[1].Price != 8 {
+		t.Fatalf("%+v", d)
+	}
+	report, _ := m.Bid(Bid{Symbol: sym, Account: 1, Amount: 1, BidType: OrderTypeLimit, Price: 8})
+	if len(report.Transactions) != 1 || report.Transactions[0].OfferID != pegged.OrderID || report.Transactions[0].Price != 7 {
+		t.Fatalf("%+v", report)
+	}
+}
+
+func TestCustomOrderTypeSnapshot(t *testing.T) {
+	s := MakeMemoryStorage()
+	s.RegisterOrderType(orderTypePegged, peggedProcessor{})
+	s.AddBid(Bid{Symbol: sym, Account: 1, Amount: 1, BidType: orderTypePegged, Price: 1})
+	var buf bytes.Buffer
+	s.Marshal(&buf)
+	saved := buf.String()
+	loaded := MakeMemoryStorage()
+	if err := loaded.UnMarshal(strings.NewReader(saved)); !errors.Is(err, ErrCorruptSnapshot) {
+		t.Fatalf("Expected ErrCorruptSnapshot, got %v", err)
+	}
+	loaded.RegisterOrderType(orderTypePegged, peggedProcessor{})
+	if err := loaded.UnMarshal(strings.NewReader(saved)); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, _ := loaded.BestBid(sym); !found {
+		t.Fatal("Pegged bid not loaded")
+	}
+}
+
+// uncommitted is storage whose changes are never saved
+type uncommitted struct {
+	*MemoryStorage
+}
+
+func (s uncommitted) Commit() error {
+	s.Unlock()
+	return errors.New("not saved")
+}
+
+func TestMarketReportsUnsavedChanges(t *testing.T) {
+	m := MakeMarket(time.Now, uncommitted{MakeMemoryStorage()}, makeMockAccounts())
+	if _, err := m.Offer(Offer{Symbol: sym, Account: 2, Amount: 3, OfferType: OrderTypeLimit, Price: 5}); err == nil {
+		t.Fatal("Expected the offer to fail")
+	}
+	if _, err := m.Bid(Bid{Symbol: sym, Account: 1, Amount: 3, BidType: OrderTypeLimit, Price: 5}); err == nil {
+		t.Fatal("Expected the bid to fail")
+	}
+	if _, err := m.Depth(sym, 0); err != nil {
+		t.Fatal(err)
+	}
+}
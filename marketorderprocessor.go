@@ -6,52 +6,135 @@ type marketOrderProcessor struct {
 	now func() time.Time
 }
 
+// fillAtCounterPrice trades at whatever the counter-order
+// is priced at
+func fillAtCounterPrice(last, price int64) (int64, bool) {
+	return price, true
+}
+
 func (m *marketOrderProcessor) TryFillBid(
 	ms MarketStorage,
-	accounts Accounts,
-	opl map[OrderType]orderProcessor,
+	s *Settlement,
+	opl map[OrderType]OrderProcessor,
 	bid Bid,
-) {
+) error {
+	ok, err := s.admitBid(ms, opl, m.now(), bid, fillAtCounterPrice)
+	if err != nil || !ok {
+		return err
+	}
+	if err = m.matchBid(ms, s, opl, bid); err != nil {
+		return err
+	}
+	return s.finishBid(ms, m.now(), bid)
+}
+
+func (m *marketOrderProcessor) matchBid(
+	ms MarketStorage,
+	s *Settlement,
+	opl map[OrderType]OrderProcessor,
+	bid Bid,
+) error {
 	for {
-		if bid.Amount < 1 {
-			return
+		if !bid.IsActive() || s.Halted(bid.Symbol) {
+			return nil
+		}
+		off, found, err := ms.BestOffer(bid.Symbol)
+		if err != nil || !found {
+			return err
+		}
+		ts := m.now()
+		if off.Expired(ts) {
+			if err = s.cancelOffer(ms, ts, off, EventExpired); err != nil {
+				return err
+			}
+			continue
 		}
-		off, found := ms.BestOffer(bid.Symbol)
+		p, found := opl[off.OfferType]
 		if !found {
-			return
+			return ErrUnknownOrderType
+		}
+		price, err := p.GetAskingPrice(ms, off)
+		if err != nil {
+			return err
+		}
+		var prevented bool
+		if bid, _, prevented, err = s.PreventSelfTrade(ms, ts, bid, off); err != nil {
+			return err
+		}
+		if prevented {
+			continue
 		}
-		price := opl[off.OfferType].GetAskingPrice(ms, off)
 		var filled bool
-		bid, _, filled = fillBid(ms, accounts, m.now(), bid, off, price)
-		if !filled {
-			return
+		bid, _, filled, err = fillBid(ms, s, ts, bid, off, price)
+		if err != nil || !filled {
+			return err
 		}
 	}
 }
 
 func (m *marketOrderProcessor) TrySell(
 	ms MarketStorage,
-	accounts Accounts,
-	opl map[OrderType]orderProcessor,
+	s *Settlement,
+	opl map[OrderType]OrderProcessor,
 	offer Offer,
-) {
+) error {
+	ok, err := s.admitOffer(ms, opl, m.now(), offer, fillAtCounterPrice)
+	if err != nil || !ok {
+		return err
+	}
+	if err = m.matchOffer(ms, s, opl, offer); err != nil {
+		return err
+	}
+	return s.finishOffer(ms, m.now(), offer)
+}
+
+func (m *marketOrderProcessor) matchOffer(
+	ms MarketStorage,
+	s *Settlement,
+	opl map[OrderType]OrderProcessor,
+	offer Offer,
+) error {
 	for {
-		if offer.Amount < 1 {
-			return
+		if !offer.IsActive() || s.Halted(offer.Symbol) {
+			return nil
 		}
-		bid, found := ms.BestBid(offer.Symbol)
+		bid, found, err := ms.BestBid(offer.Symbol)
+		if err != nil || !found {
+			return err
+		}
+		ts := m.now()
+		if bid.Expired(ts) {
+			if err = s.cancelBid(ms, ts, bid, EventExpired); err != nil {
+				return err
+			}
+			continue
+		}
+		p, found := opl[bid.BidType]
 		if !found {
-			return
+			return ErrUnknownOrderType
+		}
+		price, err := p.GetBidPrice(ms, bid)
+		if err != nil {
+			return err
+		}
+		var prevented bool
+		if _, offer, prevented, err = s.PreventSelfTrade(ms, ts, bid, offer); err != nil {
+			return err
+		}
+		if prevented {
+			continue
+		}
+		_, offer, _, err = fillBid(ms, s, ts, bid, offer, price)
+		if err != nil {
+			return err
 		}
-		price := opl[bid.BidType].GetBidPrice(ms, bid)
-		_, offer, _ = fillBid(ms, accounts, m.now(), bid, offer, price)
 	}
 }
 
-func (m *marketOrderProcessor) GetAskingPrice(ms MarketStorage, o Offer) int64 {
+func (m *marketOrderProcessor) GetAskingPrice(ms MarketStorage, o Offer) (int64, error) {
 	return ms.LastPrice(o.Symbol)
 }
 
-func (m *marketOrderProcessor) GetBidPrice(ms MarketStorage, b Bid) int64 {
+func (m *marketOrderProcessor) GetBidPrice(ms MarketStorage, b Bid) (int64, error) {
 	return ms.LastPrice(b.Symbol)
 }
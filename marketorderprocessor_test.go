@@ -12,16 +12,16 @@ func TestTryFillFilledByExactOffer(t *testing.T) {
 	storage.AddOffer(o)
 	t.Logf("%+v", storage.offers)
 	bid := Bid{Symbol: "m", Amount: 10, BidType: OrderTypeMarket}
-	id := storage.AddBid(bid)
+	id, _ := storage.AddBid(bid)
 	bid.ID = id
 	storage.SetLastPrice("m", 7)
-	mop.TryFillBid(storage, makeMockAccounts(), map[OrderType]orderProcessor{OrderTypeMarket: &mop}, bid)
-	bid = storage.GetBid(id)
+	mop.TryFillBid(storage, &Settlement{accounts: makeMockAccounts()}, map[OrderType]OrderProcessor{OrderTypeMarket: &mop}, bid)
+	bid, _ = storage.GetBid(id)
 	if bid.Amount != 0 {
 		t.Fatalf("%+v", bid)
 	}
-	if storage.LastPrice("m") != 7 {
-		t.Fatalf("%d != 7", storage.LastPrice("m"))
+	if storage.lastPriceOf("m") != 7 {
+		t.Fatalf("%d != 7", storage.lastPriceOf("m"))
 	}
 }
 
@@ -32,11 +32,11 @@ func TestTryFillFilledByLargerOffer(t *testing.T) {
 	storage.AddOffer(o)
 	t.Logf("%+v", storage.offers)
 	bid := Bid{Symbol: "m", Amount: 10, BidType: OrderTypeMarket}
-	id := storage.AddBid(bid)
+	id, _ := storage.AddBid(bid)
 	bid.ID = id
 	storage.SetLastPrice("m", 7)
-	mop.TryFillBid(storage, makeMockAccounts(), map[OrderType]orderProcessor{OrderTypeMarket: &mop}, bid)
-	bid = storage.GetBid(id)
+	mop.TryFillBid(storage, &Settlement{accounts: makeMockAccounts()}, map[OrderType]OrderProcessor{OrderTypeMarket: &mop}, bid)
+	bid, _ = storage.GetBid(id)
 	if bid.Amount != 0 {
 		t.Fatalf("%+v", bid)
 	}
@@ -45,8 +45,8 @@ func TestTryFillFilledByLargerOffer(t *testing.T) {
 			t.Fatalf("%+v", o)
 		}
 	}
-	if storage.LastPrice("m") != 7 {
-		t.Fatalf("%d != 7", storage.LastPrice("m"))
+	if storage.lastPriceOf("m") != 7 {
+		t.Fatalf("%d != 7", storage.lastPriceOf("m"))
 	}
 }
 
@@ -57,19 +57,19 @@ func TestTryFillPartiallyFilled(t *testing.T) {
 	storage.AddOffer(o)
 	t.Logf("%+v", storage.offers)
 	bid := Bid{Symbol: "m", Amount: 10, BidType: OrderTypeMarket}
-	id := storage.AddBid(bid)
+	id, _ := storage.AddBid(bid)
 	bid.ID = id
 	storage.SetLastPrice("m", 7)
-	mop.TryFillBid(storage, makeMockAccounts(), map[OrderType]orderProcessor{OrderTypeMarket: &mop}, bid)
-	bid = storage.GetBid(id)
+	mop.TryFillBid(storage, &Settlement{accounts: makeMockAccounts()}, map[OrderType]OrderProcessor{OrderTypeMarket: &mop}, bid)
+	bid, _ = storage.GetBid(id)
 	if bid.Amount != 5 {
 		t.Fatalf("%+v", bid)
 	}
 	if bid.Amount != 5 {
 		t.Fatalf("%+v", bid)
 	}
-	if storage.LastPrice("m") != 7 {
-		t.Fatalf("%d != 7", storage.LastPrice("m"))
+	if storage.lastPriceOf("m") != 7 {
+		t.Fatalf("%d != 7", storage.lastPriceOf("m"))
 	}
 }
 
@@ -82,10 +82,10 @@ func TestTryFillFilledBy2Offers(t *testing.T) {
 	storage.AddOffer(o)
 	t.Logf("%+v", storage.offers)
 	bid := Bid{Symbol: "m", Amount: 10, BidType: OrderTypeMarket}
-	id := storage.AddBid(bid)
+	id, _ := storage.AddBid(bid)
 	bid.ID = id
-	mop.TryFillBid(storage, makeMockAccounts(), map[OrderType]orderProcessor{OrderTypeMarket: &mop}, bid)
-	bid = storage.GetBid(id)
+	mop.TryFillBid(storage, &Settlement{accounts: makeMockAccounts()}, map[OrderType]OrderProcessor{OrderTypeMarket: &mop}, bid)
+	bid, _ = storage.GetBid(id)
 	if bid.Amount != 0 {
 		t.Fatalf("%+v", bid)
 	}
@@ -105,15 +105,15 @@ func TestTrySellFillsExactMatch(t *testing.T) {
 	mop := marketOrderProcessor{now: func() time.Time { return time.Time{} }}
 	storage := MakeMemoryStorage()
 	bid := Bid{Symbol: "m", Amount: 10, BidType: OrderTypeMarket}
-	bid.ID = storage.AddBid(bid)
+	bid.ID, _ = storage.AddBid(bid)
 	offer := Offer{Symbol: "m", Amount: 10, OfferType: OrderTypeMarket}
-	offer.ID = storage.AddOffer(offer)
-	mop.TrySell(storage, makeMockAccounts(), map[OrderType]orderProcessor{OrderTypeMarket: &mop}, offer)
-	bid = storage.GetBid(bid.ID)
+	offer.ID, _ = storage.AddOffer(offer)
+	mop.TrySell(storage, &Settlement{accounts: makeMockAccounts()}, map[OrderType]OrderProcessor{OrderTypeMarket: &mop}, offer)
+	bid, _ = storage.GetBid(bid.ID)
 	if bid.IsActive() {
 		t.Fatal("Bid still active")
 	}
-	offer = storage.GetOffer(offer.ID)
+	offer, _ = storage.GetOffer(offer.ID)
 	if offer.IsActive() {
 		t.Fatal("Offer still active")
 	}
@@ -123,9 +123,9 @@ func TestTrySellNoBidsCompletes(t *testing.T) {
 	mop := marketOrderProcessor{now: func() time.Time { return time.Time{} }}
 	storage := MakeMemoryStorage()
 	offer := Offer{Symbol: "m", Amount: 10, OfferType: OrderTypeMarket}
-	offer.ID = storage.AddOffer(offer)
-	mop.TrySell(storage, makeMockAccounts(), map[OrderType]orderProcessor{OrderTypeMarket: &mop}, offer)
-	offer = storage.GetOffer(offer.ID)
+	offer.ID, _ = storage.AddOffer(offer)
+	mop.TrySell(storage, &Settlement{accounts: makeMockAccounts()}, map[OrderType]OrderProcessor{OrderTypeMarket: &mop}, offer)
+	offer, _ = storage.GetOffer(offer.ID)
 	if !offer.IsActive() {
 		t.Fatal("Offer not active")
 	}
@@ -138,21 +138,21 @@ func TestTrySell2BidsSell(t *testing.T) {
 	mop := marketOrderProcessor{now: func() time.Time { return time.Time{} }}
 	storage := MakeMemoryStorage()
 	bid0 := Bid{Symbol: "m", Amount: 10, BidType: OrderTypeMarket}
-	bid0.ID = storage.AddBid(bid0)
+	bid0.ID, _ = storage.AddBid(bid0)
 	bid1 := Bid{Symbol: "m", Amount: 10, BidType: OrderTypeMarket}
-	bid1.ID = storage.AddBid(bid1)
+	bid1.ID, _ = storage.AddBid(bid1)
 	offer := Offer{Symbol: "m", Amount: 20, OfferType: OrderTypeMarket}
-	offer.ID = storage.AddOffer(offer)
-	mop.TrySell(storage, makeMockAccounts(), map[OrderType]orderProcessor{OrderTypeMarket: &mop}, offer)
-	bid0 = storage.GetBid(bid0.ID)
+	offer.ID, _ = storage.AddOffer(offer)
+	mop.TrySell(storage, &Settlement{accounts: makeMockAccounts()}, map[OrderType]OrderProcessor{OrderTypeMarket: &mop}, offer)
+	bid0, _ = storage.GetBid(bid0.ID)
 	if bid0.IsActive() {
 		t.Fatal("Bid0 still active")
 	}
-	bid1 = storage.GetBid(bid1.ID)
+	bid1, _ = storage.GetBid(bid1.ID)
 	if bid1.IsActive() {
 		t.Fatal("Bid1 still active")
 	}
-	offer = storage.GetOffer(offer.ID)
+	offer, _ = storage.GetOffer(offer.ID)
 	if offer.IsActive() {
 		t.Fatal("Offer still active")
 	}
@@ -162,15 +162,15 @@ func TestTrySellPartialBidCompletesAndDecrimentsOffer(t *testing.T) {
 	mop := marketOrderProcessor{now: func() time.Time { return time.Time{} }}
 	storage := MakeMemoryStorage()
 	bid := Bid{Symbol: "m", Amount: 5, BidType: OrderTypeMarket}
-	bid.ID = storage.AddBid(bid)
+	bid.ID, _ = storage.AddBid(bid)
 	offer := Offer{Symbol: "m", Amount: 10, OfferType: OrderTypeMarket}
-	offer.ID = storage.AddOffer(offer)
-	mop.TrySell(storage, makeMockAccounts(), map[OrderType]orderProcessor{OrderTypeMarket: &mop}, offer)
-	bid = storage.GetBid(bid.ID)
+	offer.ID, _ = storage.AddOffer(offer)
+	mop.TrySell(storage, &Settlement{accounts: makeMockAccounts()}, map[OrderType]OrderProcessor{OrderTypeMarket: &mop}, offer)
+	bid, _ = storage.GetBid(bid.ID)
 	if bid.IsActive() {
 		t.Fatal("Bid still active")
 	}
-	offer = storage.GetOffer(offer.ID)
+	offer, _ = storage.GetOffer(offer.ID)
 	if !offer.IsActive() {
 		t.Fatal("Offer inactive")
 	}
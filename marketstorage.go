@@ -4,7 +4,7 @@ import (
 	"encoding/csv"
 	"fmt"
 	"io"
-	"log"
+	"sort"
 	"strconv"
 	"sync"
 	"time"
@@ -17,6 +17,10 @@ func MakeMemoryStorage() *MemoryStorage {
 		offers:    make(map[string]map[uuid.UUID]Offer),
 		bids:      make(map[uuid.UUID]Bid),
 		lastPrice: make(map[string]int64),
+		books:     make(map[string]*orderBook),
+		priority:  make(map[uuid.UUID]uint64),
+		candles:   make(map[candleSeries][]Candle),
+		pricers:   make(map[OrderType]OrderPricer),
 	}
 }
 
@@ -28,6 +32,24 @@ type MemoryStorage struct {
 	bids         map[uuid.UUID]Bid
 	transactions []Transaction
 	lastPrice    map[string]int64
+	books        map[string]*orderBook
+	// priority holds the sequence number of each active
+	// order's entry in the order book
+	priority map[uuid.UUID]uint64
+	seq      uint64
+	// candles holds each series of candles sorted by start
+	candles map[candleSeries][]Candle
+	// pricers price orders of the types added with
+	// RegisterOrderType
+	pricers map[OrderType]OrderPricer
+	// journal records every change once OpenJournal is
+	// called
+	journal *journal
+}
+
+type candleSeries struct {
+	symbol   string
+	interval time.Duration
 }
 
 func (s *MemoryStorage) Lock() {
@@ -35,160 +57,395 @@ func (s *MemoryStorage) Lock() {
 }
 
 func (s *MemoryStorage) Unlock() {
+	s.endOperation()
 	s.mutex.Unlock()
 }
 
-func (s *MemoryStorage) Marshal(w io.Writer) {
+// Marshal writes all the data in storage to w as a CSV
+// snapshot that UnMarshal can load
+func (s *MemoryStorage) Marshal(w io.Writer) error {
+	return s.MarshalEncoding(w, SnapshotCSV)
+}
+
+// MarshalEncoding writes all the data in storage to w as a
+// snapshot in encoding e, which UnMarshal can load
+func (s *MemoryStorage) MarshalEncoding(w io.Writer, e SnapshotEncoding) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	offers := make(map[uuid.UUID]Offer)
+	return s.marshal(w, e)
+}
+
+func (s *MemoryStorage) marshal(w io.Writer, e SnapshotEncoding) error {
+	snap := snapshot{
+		Prices:       s.lastPrice,
+		Transactions: s.transactions,
+		Candles:      s.allCandles(),
+	}
 	for _, oList := range s.offers {
 		for _, o := range oList {
-			offers[o.ID] = o
+			snap.Offers = append(snap.Offers, o)
 		}
 	}
-	saveOffers(w, offers)
-	writeEOF(w)
-	savePrices(w, s.lastPrice)
-	writeEOF(w)
-	saveBids(w, s.bids)
-	writeEOF(w)
-	saveTransactions(w, s.transactions)
-	writeEOF(w)
+	// Orders are saved in the order they are queued in the
+	// order book so that loading them restores priority
+	sort.Slice(snap.Offers, func(i, j int) bool {
+		return s.queuedBefore(snap.Offers[i].ID, snap.Offers[j].ID)
+	})
+	for _, b := range s.bids {
+		snap.Bids = append(snap.Bids, b)
+	}
+	sort.Slice(snap.Bids, func(i, j int) bool {
+		return s.queuedBefore(snap.Bids[i].ID, snap.Bids[j].ID)
+	})
+	switch e {
+	case SnapshotCSV:
+		return encodeCSV(w, snap)
+	case SnapshotJSON:
+		return encodeJSON(w, snap)
+	}
+	return fmt.Errorf("unknown snapshot encoding %d", e)
 }
 
-func writeEOF(w io.Writer) {
-	fmt.Fprint(w, eof+"\n")
+func writeEOF(w io.Writer) error {
+	_, err := fmt.Fprint(w, eof+"\n")
+	return err
 }
 
-func (s *MemoryStorage) UnMarshal(r io.Reader) {
+// UnMarshal replaces the data in storage with a snapshot
+// read from r, in any encoding or version that Marshal has
+// written. If r can't be loaded, the storage is left
+// unchanged and the error returned wraps
+// ErrCorruptSnapshot. With a journal open, the loaded data
+// is compacted into a new snapshot.
+func (s *MemoryStorage) UnMarshal(r io.Reader) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	reader := csv.NewReader(r)
-	reader.FieldsPerRecord = -1
-	offers := loadOffers(reader)
-	s.offers = make(map[string]map[uuid.UUID]Offer)
-	for _, o := range offers {
-		oList := s.offers[o.Symbol]
+	if err := s.unmarshal(r); err != nil {
+		return err
+	}
+	if s.journal == nil {
+		return nil
+	}
+	return s.compact()
+}
+
+func (s *MemoryStorage) unmarshal(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return corrupt(err, "snapshot")
+	}
+	snap, err := decodeSnapshot(data)
+	if err != nil {
+		return err
+	}
+	loaded := MakeMemoryStorage()
+	loaded.pricers = s.pricers
+	for _, o := range snap.Offers {
+		if err := loaded.queueOffer(o); err != nil {
+			return corrupt(err, "offer %s", o.ID)
+		}
+		oList := loaded.offers[o.Symbol]
 		if oList == nil {
 			oList = make(map[uuid.UUID]Offer)
 		}
 		oList[o.ID] = o
-		s.offers[o.Symbol] = oList
+		loaded.offers[o.Symbol] = oList
+	}
+	for symbol, price := range snap.Prices {
+		loaded.lastPrice[symbol] = price
+	}
+	for _, b := range snap.Bids {
+		if err := loaded.queueBid(b); err != nil {
+			return corrupt(err, "bid %s", b.ID)
+		}
+		loaded.bids[b.ID] = b
+	}
+	loaded.transactions = snap.Transactions
+	for _, c := range snap.Candles {
+		loaded.SetCandle(c)
 	}
-	s.lastPrice = loadPrices(reader)
-	s.bids = loadBids(reader)
-	s.transactions = loadTransactions(reader)
+	s.replace(loaded)
+	return nil
 }
 
-func (s *MemoryStorage) AddOffer(o Offer) uuid.UUID {
+// replace swaps the data in storage for the data in
+// loaded, keeping the registered order types and journal
+func (s *MemoryStorage) replace(loaded *MemoryStorage) {
+	s.offers = loaded.offers
+	s.bids = loaded.bids
+	s.transactions = loaded.transactions
+	s.lastPrice = loaded.lastPrice
+	s.books = loaded.books
+	s.priority = loaded.priority
+	s.seq = loaded.seq
+	s.candles = loaded.candles
+}
+
+// corrupt returns an error wrapping ErrCorruptSnapshot
+// that describes what could not be loaded and why
+func corrupt(err error, format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s: %s", ErrCorruptSnapshot, fmt.Sprintf(format, args...), err)
+}
+
+// queuedBefore orders IDs by their position in the order
+// book, with inactive orders last
+func (s *MemoryStorage) queuedBefore(a, b uuid.UUID) bool {
+	pa, pb := s.priority[a], s.priority[b]
+	if pa == pb {
+		return a.String() < b.String()
+	}
+	if pa == 0 || pb == 0 {
+		return pb == 0
+	}
+	return pa < pb
+}
+
+func (s *MemoryStorage) book(symbol string) *orderBook {
+	b := s.books[symbol]
+	if b == nil {
+		b = makeOrderBook()
+		s.books[symbol] = b
+	}
+	return b
+}
+
+// queueOffer puts o at the back of the queue for its price
+// in the order book, or takes it out of the book if it is
+// no longer active. Nothing changes if o can't be queued.
+func (s *MemoryStorage) queueOffer(o Offer) error {
+	if o.IsActive() && !s.knownType(o.OfferType) {
+		return ErrUnknownOrderType
+	}
+	if b := s.books[o.Symbol]; b != nil {
+		b.remove(o.ID)
+	}
+	if !o.IsActive() {
+		delete(s.priority, o.ID)
+		return nil
+	}
+	s.seq++
+	s.priority[o.ID] = s.seq
+	e := bookEntry{id: o.ID, seq: s.seq}
+	b := s.book(o.Symbol)
+	switch o.OfferType {
+	case OrderTypeLimit:
+		b.pushLimit(&b.limitOffers, o.Price, e)
+	case OrderTypeMarket:
+		b.push(&b.marketOffers, e)
+	case OrderTypeStopMarket, OrderTypeStopLimit:
+		b.push(&b.stopOffers, e)
+	default:
+		b.push(&b.customOffers, e)
+	}
+	return nil
+}
+
+// queueBid puts b at the back of the queue for its price
+// in the order book, or takes it out of the book if it is
+// no longer active. Nothing changes if b can't be queued.
+func (s *MemoryStorage) queueBid(b Bid) error {
+	if b.IsActive() && !s.knownType(b.BidType) {
+		return ErrUnknownOrderType
+	}
+	if book := s.books[b.Symbol]; book != nil {
+		book.remove(b.ID)
+	}
+	if !b.IsActive() {
+		delete(s.priority, b.ID)
+		return nil
+	}
+	s.seq++
+	s.priority[b.ID] = s.seq
+	e := bookEntry{id: b.ID, seq: s.seq}
+	book := s.book(b.Symbol)
+	switch b.BidType {
+	case OrderTypeLimit:
+		book.pushLimit(&book.limitBids, b.Price, e)
+	case OrderTypeMarket:
+		book.push(&book.marketBids, e)
+	case OrderTypeStopMarket, OrderTypeStopLimit:
+		book.push(&book.stopBids, e)
+	default:
+		book.push(&book.customBids, e)
+	}
+	return nil
+}
+
+func (s *MemoryStorage) knownType(t OrderType) bool {
+	if t <= OrderTypeStopLimit {
+		return true
+	}
+	_, found := s.pricers[t]
+	return found
+}
+
+// RegisterOrderType returns ErrOrderTypeInUse if t is a
+// built in type or is already registered
+func (s *MemoryStorage) RegisterOrderType(t OrderType, p OrderPricer) error {
+	if s.knownType(t) {
+		return ErrOrderTypeInUse
+	}
+	s.pricers[t] = p
+	return nil
+}
+
+// customOffers prices the offers for sym that have
+// registered types
+func (s *MemoryStorage) customOffers(sym string, b *orderBook) ([]pricedEntry, error) {
+	var rv []pricedEntry
+	for _, e := range entries(&b.customOffers) {
+		o := s.offers[sym][e.id]
+		price, err := s.pricers[o.OfferType].GetAskingPrice(s, o)
+		if err != nil {
+			return nil, err
+		}
+		rv = append(rv, pricedEntry{price: price, e: e})
+	}
+	return rv, nil
+}
+
+// customBids prices the bids for sym that have registered
+// types
+func (s *MemoryStorage) customBids(b *orderBook) ([]pricedEntry, error) {
+	var rv []pricedEntry
+	for _, e := range entries(&b.customBids) {
+		bid := s.bids[e.id]
+		price, err := s.pricers[bid.BidType].GetBidPrice(s, bid)
+		if err != nil {
+			return nil, err
+		}
+		rv = append(rv, pricedEntry{price: price, e: e})
+	}
+	return rv, nil
+}
+
+func (s *MemoryStorage) AddOffer(o Offer) (uuid.UUID, error) {
 	o.ID = uuid.New()
+	if err := s.queueOffer(o); err != nil {
+		return uuid.Nil, err
+	}
 	offers := s.offers[o.Symbol]
 	if offers == nil {
 		offers = make(map[uuid.UUID]Offer)
 	}
 	offers[o.ID] = o
 	s.offers[o.Symbol] = offers
-	return o.ID
-}
-
-func (s *MemoryStorage) BestOffer(sym string) (Offer, bool) {
-	l := s.offers[sym]
-	if len(l) == 0 {
-		return Offer{}, false
-	}
-	o := Offer{Price: 2 ^ 60}
-	marketPrice := s.LastPrice(sym)
-	for _, offer := range l {
-		if offer.IsActive() {
-			switch offer.OfferType {
-			case OrderTypeLimit:
-				if offer.Price < o.Price {
-					o = offer
-				}
-			case OrderTypeMarket:
-				if marketPrice < o.Price {
-					o = offer
-				}
-			default:
-				log.Panicf("Unknown offer type %d", offer.OfferType)
-			}
+	return o.ID, s.journalOffer(o)
+}
+
+func (s *MemoryStorage) BestOffer(sym string) (Offer, bool, error) {
+	b := s.books[sym]
+	if b == nil {
+		return Offer{}, false, nil
+	}
+	best, found := b.bestOffer(s.lastPriceOf(sym))
+	custom, err := s.customOffers(sym, b)
+	if err != nil {
+		return Offer{}, false, err
+	}
+	for _, c := range custom {
+		if !found || b.limitOffers.beats(c, best) {
+			best, found = c, true
 		}
 	}
-	if o.Amount > 0 {
-		return o, true
-	}
-	return Offer{}, false
-}
-
-func (s *MemoryStorage) BestBid(sym string) (Bid, bool) {
-	result := Bid{Price: 0}
-	marketPrice := s.LastPrice(sym)
-	for _, bid := range s.bids {
-		if bid.IsActive() {
-			switch bid.BidType {
-			case OrderTypeLimit:
-				if bid.Price > result.Price {
-					result = bid
-				}
-			case OrderTypeMarket:
-				if marketPrice > result.Price {
-					result = bid
-				}
-			default:
-				log.Panicf("Unknown bid type %d", bid.BidType)
-			}
+	if !found {
+		return Offer{}, false, nil
+	}
+	return s.offers[sym][best.e.id], true, nil
+}
+
+func (s *MemoryStorage) BestBid(sym string) (Bid, bool, error) {
+	b := s.books[sym]
+	if b == nil {
+		return Bid{}, false, nil
+	}
+	best, found := b.bestBid(s.lastPriceOf(sym))
+	custom, err := s.customBids(b)
+	if err != nil {
+		return Bid{}, false, err
+	}
+	for _, c := range custom {
+		if !found || b.limitBids.beats(c, best) {
+			best, found = c, true
 		}
 	}
-	if result.Amount > 0 {
-		return result, true
+	if !found {
+		return Bid{}, false, nil
 	}
-	return Bid{}, false
+	return s.bids[best.e.id], true, nil
 }
 
-func (s *MemoryStorage) UpdateOffer(o Offer) {
+func (s *MemoryStorage) UpdateOffer(o Offer) error {
 	l := s.offers[o.Symbol]
 	if l == nil {
 		l = make(map[uuid.UUID]Offer)
 	}
+	old := l[o.ID]
+	// Partial fills and reduced amounts keep their place in
+	// the queue, any other change goes to the back
+	_, queued := s.priority[o.ID]
+	if !queued || !o.IsActive() || o.OfferType != old.OfferType ||
+		o.Price != old.Price || o.Amount > old.Amount {
+		if err := s.queueOffer(o); err != nil {
+			return err
+		}
+	}
 	l[o.ID] = o
 	s.offers[o.Symbol] = l
+	return s.journalOffer(o)
 }
 
-func (s *MemoryStorage) AddBid(b Bid) uuid.UUID {
+func (s *MemoryStorage) AddBid(b Bid) (uuid.UUID, error) {
 	b.ID = uuid.New()
+	if err := s.queueBid(b); err != nil {
+		return uuid.Nil, err
+	}
 	s.bids[b.ID] = b
-	return b.ID
+	return b.ID, s.journalBid(b)
 }
 
-func (s *MemoryStorage) UpdateBid(b Bid) {
+func (s *MemoryStorage) UpdateBid(b Bid) error {
+	old := s.bids[b.ID]
+	_, queued := s.priority[b.ID]
+	if !queued || !b.IsActive() || b.BidType != old.BidType ||
+		b.Price != old.Price || b.Amount > old.Amount {
+		if err := s.queueBid(b); err != nil {
+			return err
+		}
+	}
 	s.bids[b.ID] = b
+	return s.journalBid(b)
 }
 
-func (s *MemoryStorage) GetBid(id uuid.UUID) Bid {
+func (s *MemoryStorage) GetBid(id uuid.UUID) (Bid, error) {
 	bid, found := s.bids[id]
 	if !found {
-		log.Panicf("Bid %d not found", id)
+		return Bid{}, ErrOrderNotFound
 	}
-	return bid
+	return bid, nil
 }
 
-func (s *MemoryStorage) GetOffer(id uuid.UUID) Offer {
+func (s *MemoryStorage) GetOffer(id uuid.UUID) (Offer, error) {
 	for _, l := range s.offers {
-		bid, found := l[id]
+		offer, found := l[id]
 		if found {
-			return bid
+			return offer, nil
 		}
 	}
-	panic(fmt.Sprintf("Offer %d not found", id))
+	return Offer{}, ErrOrderNotFound
 }
 
-func (s *MemoryStorage) NewTransaction(t Transaction) {
+func (s *MemoryStorage) NewTransaction(t Transaction) (uuid.UUID, error) {
 	t.ID = uuid.New()
 	s.transactions = append(s.transactions, t)
+	return t.ID, s.journalTransaction(t)
 }
 
-func (s *MemoryStorage) LastPrice(symbol string) int64 {
+func (s *MemoryStorage) LastPrice(symbol string) (int64, error) {
+	return s.lastPriceOf(symbol), nil
+}
+
+func (s *MemoryStorage) lastPriceOf(symbol string) int64 {
 	p, found := s.lastPrice[symbol]
 	if found {
 		return p
@@ -198,11 +455,12 @@ func (s *MemoryStorage) LastPrice(symbol string) int64 {
 
 func (s *MemoryStorage) SetLastPrice(
 	symbol string, price int64,
-) {
+) error {
 	s.lastPrice[symbol] = price
+	return s.journalPrice(symbol, price)
 }
 
-func (s *MemoryStorage) AllSymbols() []string {
+func (s *MemoryStorage) AllSymbols() ([]string, error) {
 	l := make(map[string]bool)
 	for s := range s.lastPrice {
 		l[s] = true
@@ -210,193 +468,550 @@ func (s *MemoryStorage) AllSymbols() []string {
 	for s := range s.offers {
 		l[s] = true
 	}
+	for s := range s.books {
+		l[s] = true
+	}
 	var rv []string
 	for s := range l {
 		rv = append(rv, s)
 	}
-	return rv
+	return rv, nil
 }
 
-func loadOffers(reader *csv.Reader) map[uuid.UUID]Offer {
-	offers := make(map[uuid.UUID]Offer)
-	for {
-		record, err := reader.Read()
-		if err != nil {
-			panic(err.Error())
+func (s *MemoryStorage) RestingBids(sym string) ([]Bid, error) {
+	b := s.books[sym]
+	if b == nil {
+		return nil, nil
+	}
+	custom, err := s.customBids(b)
+	if err != nil {
+		return nil, err
+	}
+	var rv []Bid
+	for _, id := range b.rankedBids(s.lastPriceOf(sym), custom) {
+		rv = append(rv, s.bids[id])
+	}
+	return rv, nil
+}
+
+func (s *MemoryStorage) RestingOffers(sym string) ([]Offer, error) {
+	b := s.books[sym]
+	if b == nil {
+		return nil, nil
+	}
+	custom, err := s.customOffers(sym, b)
+	if err != nil {
+		return nil, err
+	}
+	var rv []Offer
+	for _, id := range b.rankedOffers(s.lastPriceOf(sym), custom) {
+		rv = append(rv, s.offers[sym][id])
+	}
+	return rv, nil
+}
+
+func (s *MemoryStorage) StopBids(sym string) ([]Bid, error) {
+	b := s.books[sym]
+	if b == nil {
+		return nil, nil
+	}
+	var rv []Bid
+	for _, e := range entries(&b.stopBids) {
+		rv = append(rv, s.bids[e.id])
+	}
+	return rv, nil
+}
+
+func (s *MemoryStorage) StopOffers(sym string) ([]Offer, error) {
+	b := s.books[sym]
+	if b == nil {
+		return nil, nil
+	}
+	var rv []Offer
+	for _, e := range entries(&b.stopOffers) {
+		rv = append(rv, s.offers[sym][e.id])
+	}
+	return rv, nil
+}
+
+func (s *MemoryStorage) Transactions(f TransactionFilter) ([]Transaction, error) {
+	var rv []Transaction
+	skip := f.Offset
+	for _, tx := range s.transactions {
+		if !f.Matches(tx) {
+			continue
 		}
-		if len(record) == 1 {
-			if record[0] == eof {
-				return offers
-			}
-			log.Panicf("Invalid record %+v", record)
+		if skip > 0 {
+			skip--
+			continue
 		}
-		offer := Offer{
-			ID:        uuid.MustParse(record[0]),
-			OfferType: OrderType(mustParseByte(record[1])),
-			Account:   mustParseInt64(record[2]),
-			Symbol:    record[3],
-			Price:     mustParseInt64(record[4]),
-			Amount:    mustParseInt64(record[5]),
+		if f.Limit > 0 && len(rv) == f.Limit {
+			break
 		}
-		offers[offer.ID] = offer
+		rv = append(rv, tx)
 	}
+	return rv, nil
 }
 
-func saveOffers(w io.Writer, offers map[uuid.UUID]Offer) {
-	writer := csv.NewWriter(w)
-	defer writer.Flush()
-	for _, offer := range offers {
-		var r []string
-		r = append(r, offer.ID.String())
-		r = append(r, fmt.Sprintf("%d", offer.OfferType))
-		r = append(r, fmt.Sprintf("%d", offer.Account))
-		r = append(r, offer.Symbol)
-		r = append(r, fmt.Sprintf("%d", offer.Price))
-		r = append(r, fmt.Sprintf("%d", offer.Amount))
-		writer.Write(r)
+// findCandle returns the index of the first candle in
+// the series that starts at or after start
+func findCandle(series []Candle, start time.Time) int {
+	return sort.Search(len(series), func(i int) bool {
+		return !series[i].Start.Before(start)
+	})
+}
+
+func (s *MemoryStorage) Candle(
+	symbol string, interval time.Duration, start time.Time,
+) (Candle, bool, error) {
+	series := s.candles[candleSeries{symbol, interval}]
+	i := findCandle(series, start)
+	if i < len(series) && series[i].Start.Equal(start) {
+		return series[i], true, nil
 	}
+	return Candle{}, false, nil
 }
 
-func loadPrices(reader *csv.Reader) map[string]int64 {
-	prices := make(map[string]int64)
-	for {
-		record, err := reader.Read()
-		if err != nil {
-			panic(err.Error())
+func (s *MemoryStorage) SetCandle(c Candle) error {
+	k := candleSeries{c.Symbol, c.Interval}
+	series := s.candles[k]
+	i := findCandle(series, c.Start)
+	if i < len(series) && series[i].Start.Equal(c.Start) {
+		series[i] = c
+		return s.journalCandle(c)
+	}
+	series = append(series, Candle{})
+	copy(series[i+1:], series[i:])
+	series[i] = c
+	s.candles[k] = series
+	return s.journalCandle(c)
+}
+
+func (s *MemoryStorage) DeleteCandles(symbol string) error {
+	for k := range s.candles {
+		if k.symbol == symbol {
+			delete(s.candles, k)
+		}
+	}
+	return s.journalDeleteCandles(symbol)
+}
+
+func (s *MemoryStorage) Candles(
+	symbol string, interval time.Duration, since, until time.Time,
+) ([]Candle, error) {
+	series := s.candles[candleSeries{symbol, interval}]
+	first, last := 0, len(series)
+	if !since.IsZero() {
+		first = findCandle(series, since)
+	}
+	if !until.IsZero() {
+		last = findCandle(series, until)
+	}
+	if first >= last {
+		return nil, nil
+	}
+	return append([]Candle(nil), series[first:last]...), nil
+}
+
+// allCandles returns every candle, ordered by symbol,
+// interval and start
+func (s *MemoryStorage) allCandles() []Candle {
+	var keys []candleSeries
+	for k := range s.candles {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].symbol != keys[j].symbol {
+			return keys[i].symbol < keys[j].symbol
 		}
-		if len(record) == 1 {
-			if record[0] == eof {
-				return prices
-			}
-			log.Panicf("Invalid record %+v", record)
+		return keys[i].interval < keys[j].interval
+	})
+	var rv []Candle
+	for _, k := range keys {
+		rv = append(rv, s.candles[k]...)
+	}
+	return rv
+}
+
+// fieldParser parses the fields of a CSV record, keeping
+// the first error so that every field doesn't need to be
+// checked separately
+type fieldParser struct {
+	record []string
+	// columns holds the position in the record of each
+	// field, or -1 if the record doesn't have it, for
+	// sections that name their columns. Without it, fields
+	// are in the record in order.
+	columns []int
+	err     error
+}
+
+func (p *fieldParser) position(i int) int {
+	if p.columns == nil {
+		return i
+	}
+	if i >= len(p.columns) {
+		return -1
+	}
+	return p.columns[i]
+}
+
+func (p *fieldParser) field(i int) string {
+	if !p.optional(i) {
+		if p.err == nil {
+			p.err = fmt.Errorf("missing field %d", i)
 		}
-		symbol := record[0]
-		prices[symbol] = mustParseInt64(record[1])
+		return ""
 	}
+	return p.record[p.position(i)]
 }
 
-func savePrices(w io.Writer, prices map[string]int64) {
-	writer := csv.NewWriter(w)
-	defer writer.Flush()
-	for symbol, price := range prices {
-		var r []string
-		r = append(r, symbol)
-		r = append(r, fmt.Sprintf("%d", price))
-		writer.Write(r)
+// optional returns true if the record has field i. It is
+// used for fields added after the format was first saved.
+func (p *fieldParser) optional(i int) bool {
+	pos := p.position(i)
+	return pos >= 0 && pos < len(p.record)
+}
+
+func (p *fieldParser) check(err error) {
+	if p.err == nil {
+		p.err = err
 	}
 }
 
-func loadBids(reader *csv.Reader) map[uuid.UUID]Bid {
-	bids := make(map[uuid.UUID]Bid)
-	for {
+func (p *fieldParser) uuid(i int) uuid.UUID {
+	v, err := uuid.Parse(p.field(i))
+	p.check(err)
+	return v
+}
+
+func (p *fieldParser) byte(i int) byte {
+	v, err := strconv.ParseInt(p.field(i), 10, 8)
+	p.check(err)
+	return byte(v)
+}
+
+func (p *fieldParser) int64(i int) int64 {
+	v, err := strconv.ParseInt(p.field(i), 10, 64)
+	p.check(err)
+	return v
+}
+
+func (p *fieldParser) bool(i int) bool {
+	v, err := strconv.ParseBool(p.field(i))
+	p.check(err)
+	return v
+}
+
+func (p *fieldParser) time(i int) time.Time {
+	v := time.Time{}
+	p.check(v.UnmarshalText([]byte(p.field(i))))
+	return v
+}
+
+// loadSection calls parse for each record up to the EOF
+// line that ends the section
+func loadSection(reader *csv.Reader, section string, parse func(*fieldParser)) error {
+	return readSection(reader, section, false, parse)
+}
+
+// loadOptionalSection is like loadSection, but treats the
+// end of the input as an empty section, for sections that
+// older saves don't have
+func loadOptionalSection(reader *csv.Reader, section string, parse func(*fieldParser)) error {
+	return readSection(reader, section, true, parse)
+}
+
+func readSection(reader *csv.Reader, section string, optional bool, parse func(*fieldParser)) error {
+	for first := true; ; first = false {
 		record, err := reader.Read()
+		if err == io.EOF && optional && first {
+			return nil
+		}
 		if err != nil {
-			panic(err.Error())
+			return corrupt(err, "%s", section)
 		}
-		if len(record) == 1 {
-			if record[0] == eof {
-				return bids
-			}
-			log.Panicf("Unrecognized line: %+v", record)
+		if len(record) == 1 && record[0] == eof {
+			return nil
 		}
-		bid := Bid{
-			ID:      uuid.MustParse(record[0]),
-			BidType: OrderType(mustParseByte(record[1])),
-			Account: mustParseInt64(record[2]),
-			Symbol:  record[3],
-			Price:   mustParseInt64(record[4]),
-			Amount:  mustParseInt64(record[5]),
-			NSF:     mustParseBool(record[6]),
+		p := &fieldParser{record: record}
+		parse(p)
+		if p.err != nil {
+			line, _ := reader.FieldPos(0)
+			return corrupt(p.err, "%s line %d", section, line)
 		}
-		bids[bid.ID] = bid
 	}
 }
 
-func saveBids(w io.Writer, bids map[uuid.UUID]Bid) {
-	writer := csv.NewWriter(w)
-	defer writer.Flush()
-	for _, bid := range bids {
-		var r []string
-		r = append(r, bid.ID.String())
-		r = append(r, fmt.Sprintf("%d", bid.BidType))
-		r = append(r, fmt.Sprintf("%d", bid.Account))
-		r = append(r, bid.Symbol)
-		r = append(r, fmt.Sprintf("%d", bid.Price))
-		r = append(r, fmt.Sprintf("%d", bid.Amount))
-		r = append(r, fmt.Sprintf("%t", bid.NSF))
-		writer.Write(r)
+func loadOffers(reader *csv.Reader) ([]Offer, error) {
+	var offers []Offer
+	err := loadSection(reader, "offers", func(p *fieldParser) {
+		offers = append(offers, parseOffer(p))
+	})
+	return offers, err
+}
+
+func parseOffer(p *fieldParser) Offer {
+	offer := Offer{
+		ID:        p.uuid(0),
+		OfferType: OrderType(p.byte(1)),
+		Account:   p.int64(2),
+		Symbol:    p.field(3),
+		Price:     p.int64(4),
+		Amount:    p.int64(5),
+	}
+	// Saves made before cancellation existed have
+	// no cancelled column
+	if p.optional(6) {
+		offer.Cancelled = p.bool(6)
+	}
+	if p.optional(7) {
+		offer.TimeInForce = TimeInForce(p.byte(7))
+		offer.Expires = p.time(8)
+	}
+	if p.optional(9) {
+		offer.StopPrice = p.int64(9)
 	}
+	return offer
 }
 
-func loadTransactions(reader *csv.Reader) []Transaction {
-	var txs []Transaction
-	for {
-		record, err := reader.Read()
+func saveOffers(w io.Writer, offers []Offer) error {
+	writer := csv.NewWriter(w)
+	for _, offer := range offers {
+		r, err := offerRecord(offer)
 		if err != nil {
-			panic(err.Error())
-		}
-		if len(record) == 1 {
-			if record[0] == eof {
-				return txs
-			}
-			log.Panicf("Invalid record %+v", record)
+			return err
 		}
-		date := time.Time{}
-		err = date.UnmarshalText([]byte(record[5]))
+		writer.Write(r)
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func offerRecord(offer Offer) ([]string, error) {
+	expiresText, err := offer.Expires.MarshalText()
+	if err != nil {
+		return nil, err
+	}
+	var r []string
+	r = append(r, offer.ID.String())
+	r = append(r, fmt.Sprintf("%d", offer.OfferType))
+	r = append(r, fmt.Sprintf("%d", offer.Account))
+	r = append(r, offer.Symbol)
+	r = append(r, fmt.Sprintf("%d", offer.Price))
+	r = append(r, fmt.Sprintf("%d", offer.Amount))
+	r = append(r, fmt.Sprintf("%t", offer.Cancelled))
+	r = append(r, fmt.Sprintf("%d", offer.TimeInForce))
+	r = append(r, string(expiresText))
+	r = append(r, fmt.Sprintf("%d", offer.StopPrice))
+	return r, nil
+}
+
+func loadPrices(reader *csv.Reader) (map[string]int64, error) {
+	prices := make(map[string]int64)
+	err := loadSection(reader, "prices", func(p *fieldParser) {
+		prices[p.field(0)] = p.int64(1)
+	})
+	return prices, err
+}
+
+func savePrices(w io.Writer, prices map[string]int64) error {
+	var symbols []string
+	for symbol := range prices {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	writer := csv.NewWriter(w)
+	for _, symbol := range symbols {
+		writer.Write(priceRecord(symbol, prices[symbol]))
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func priceRecord(symbol string, price int64) []string {
+	return []string{symbol, fmt.Sprintf("%d", price)}
+}
+
+func loadBids(reader *csv.Reader) ([]Bid, error) {
+	var bids []Bid
+	err := loadSection(reader, "bids", func(p *fieldParser) {
+		bids = append(bids, parseBid(p))
+	})
+	return bids, err
+}
+
+func parseBid(p *fieldParser) Bid {
+	bid := Bid{
+		ID:      p.uuid(0),
+		BidType: OrderType(p.byte(1)),
+		Account: p.int64(2),
+		Symbol:  p.field(3),
+		Price:   p.int64(4),
+		Amount:  p.int64(5),
+		NSF:     p.bool(6),
+	}
+	if p.optional(7) {
+		bid.Cancelled = p.bool(7)
+	}
+	if p.optional(8) {
+		bid.Held = p.int64(8)
+	}
+	if p.optional(9) {
+		bid.TimeInForce = TimeInForce(p.byte(9))
+		bid.Expires = p.time(10)
+	}
+	if p.optional(11) {
+		bid.StopPrice = p.int64(11)
+	}
+	return bid
+}
+
+func saveBids(w io.Writer, bids []Bid) error {
+	writer := csv.NewWriter(w)
+	for _, bid := range bids {
+		r, err := bidRecord(bid)
 		if err != nil {
-			panic(err.Error())
+			return err
 		}
-		tx := Transaction{
-			ID:      uuid.MustParse(record[0]),
-			BidID:   uuid.MustParse(record[1]),
-			OfferID: uuid.MustParse(record[2]),
-			Price:   mustParseInt64(record[3]),
-			Amount:  mustParseInt64(record[4]),
-			Date:    date,
-		}
-		txs = append(txs, tx)
+		writer.Write(r)
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func bidRecord(bid Bid) ([]string, error) {
+	expiresText, err := bid.Expires.MarshalText()
+	if err != nil {
+		return nil, err
+	}
+	var r []string
+	r = append(r, bid.ID.String())
+	r = append(r, fmt.Sprintf("%d", bid.BidType))
+	r = append(r, fmt.Sprintf("%d", bid.Account))
+	r = append(r, bid.Symbol)
+	r = append(r, fmt.Sprintf("%d", bid.Price))
+	r = append(r, fmt.Sprintf("%d", bid.Amount))
+	r = append(r, fmt.Sprintf("%t", bid.NSF))
+	r = append(r, fmt.Sprintf("%t", bid.Cancelled))
+	r = append(r, fmt.Sprintf("%d", bid.Held))
+	r = append(r, fmt.Sprintf("%d", bid.TimeInForce))
+	r = append(r, string(expiresText))
+	r = append(r, fmt.Sprintf("%d", bid.StopPrice))
+	return r, nil
+}
+
+func loadTransactions(reader *csv.Reader) ([]Transaction, error) {
+	var txs []Transaction
+	err := loadSection(reader, "transactions", func(p *fieldParser) {
+		txs = append(txs, parseTransaction(p))
+	})
+	return txs, err
+}
+
+func parseTransaction(p *fieldParser) Transaction {
+	tx := Transaction{
+		ID:      p.uuid(0),
+		BidID:   p.uuid(1),
+		OfferID: p.uuid(2),
+		Price:   p.int64(3),
+		Amount:  p.int64(4),
+		Date:    p.time(5),
+	}
+	if p.optional(6) {
+		tx.Symbol = p.field(6)
+		tx.BidAccount = p.int64(7)
+		tx.OfferAccount = p.int64(8)
+	}
+	if p.optional(9) {
+		tx.BidFee = p.int64(9)
+		tx.OfferFee = p.int64(10)
 	}
+	return tx
 }
 
-func saveTransactions(w io.Writer, txs []Transaction) {
+func saveTransactions(w io.Writer, txs []Transaction) error {
 	writer := csv.NewWriter(w)
-	defer writer.Flush()
 	for _, tx := range txs {
-		dateText, err := tx.Date.MarshalText()
+		r, err := transactionRecord(tx)
 		if err != nil {
-			panic(err.Error())
+			return err
 		}
-		var r []string
-		r = append(r, tx.ID.String())
-		r = append(r, tx.BidID.String())
-		r = append(r, tx.OfferID.String())
-		r = append(r, fmt.Sprintf("%d", tx.Price))
-		r = append(r, fmt.Sprintf("%d", tx.Amount))
-		r = append(r, string(dateText))
 		writer.Write(r)
 	}
+	writer.Flush()
+	return writer.Error()
 }
 
-func mustParseByte(v string) byte {
-	r, err := strconv.ParseInt(v, 10, 8)
+func transactionRecord(tx Transaction) ([]string, error) {
+	dateText, err := tx.Date.MarshalText()
 	if err != nil {
-		panic(err.Error())
+		return nil, err
 	}
-	return byte(r)
+	var r []string
+	r = append(r, tx.ID.String())
+	r = append(r, tx.BidID.String())
+	r = append(r, tx.OfferID.String())
+	r = append(r, fmt.Sprintf("%d", tx.Price))
+	r = append(r, fmt.Sprintf("%d", tx.Amount))
+	r = append(r, string(dateText))
+	r = append(r, tx.Symbol)
+	r = append(r, fmt.Sprintf("%d", tx.BidAccount))
+	r = append(r, fmt.Sprintf("%d", tx.OfferAccount))
+	r = append(r, fmt.Sprintf("%d", tx.BidFee))
+	r = append(r, fmt.Sprintf("%d", tx.OfferFee))
+	return r, nil
 }
 
-func mustParseInt64(v string) int64 {
-	r, err := strconv.ParseInt(v, 10, 64)
-	if err != nil {
-		panic(err.Error())
+func loadCandles(reader *csv.Reader) ([]Candle, error) {
+	var candles []Candle
+	err := loadOptionalSection(reader, "candles", func(p *fieldParser) {
+		candles = append(candles, parseCandle(p))
+	})
+	return candles, err
+}
+
+func parseCandle(p *fieldParser) Candle {
+	return Candle{
+		Symbol:   p.field(0),
+		Interval: time.Duration(p.int64(1)),
+		Start:    p.time(2),
+		Open:     p.int64(3),
+		High:     p.int64(4),
+		Low:      p.int64(5),
+		Close:    p.int64(6),
+		Volume:   p.int64(7),
+		Value:    p.int64(8),
+	}
+}
+
+func saveCandles(w io.Writer, candles []Candle) error {
+	writer := csv.NewWriter(w)
+	for _, c := range candles {
+		r, err := candleRecord(c)
+		if err != nil {
+			return err
+		}
+		writer.Write(r)
 	}
-	return r
+	writer.Flush()
+	return writer.Error()
 }
 
-func mustParseBool(v string) bool {
-	r, err := strconv.ParseBool(v)
+func candleRecord(c Candle) ([]string, error) {
+	startText, err := c.Start.MarshalText()
 	if err != nil {
-		panic(err.Error())
+		return nil, err
 	}
-	return r
+	var r []string
+	r = append(r, c.Symbol)
+	r = append(r, fmt.Sprintf("%d", int64(c.Interval)))
+	r = append(r, string(startText))
+	r = append(r, fmt.Sprintf("%d", c.Open))
+	r = append(r, fmt.Sprintf("%d", c.High))
+	r = append(r, fmt.Sprintf("%d", c.Low))
+	r = append(r, fmt.Sprintf("%d", c.Close))
+	r = append(r, fmt.Sprintf("%d", c.Volume))
+	r = append(r, fmt.Sprintf("%d", c.Value))
+	return r, nil
 }
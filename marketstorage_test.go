@@ -2,6 +2,7 @@ package economy
 
 import (
 	"bytes"
+	"errors"
 	"reflect"
 	"testing"
 
@@ -15,7 +16,7 @@ const (
 func TestAddOffer(t *testing.T) {
 	ms := MakeMemoryStorage()
 	offer := Offer{Symbol: sym}
-	offer.ID = ms.AddOffer(offer)
+	offer.ID, _ = ms.AddOffer(offer)
 	if offer.ID == uuid.Nil {
 		t.Fatalf("UUID not generated")
 	}
@@ -28,8 +29,8 @@ func TestAddOffer(t *testing.T) {
 func TestBestOfferBasic(t *testing.T) {
 	ms := MakeMemoryStorage()
 	offer := Offer{Symbol: sym, Amount: 10}
-	offer.ID = ms.AddOffer(offer)
-	r, found := ms.BestOffer(sym)
+	offer.ID, _ = ms.AddOffer(offer)
+	r, found, _ := ms.BestOffer(sym)
 	if !found {
 		t.Fatal("Not found")
 	}
@@ -41,8 +42,8 @@ func TestBestOfferBasic(t *testing.T) {
 func TestBestOfferIgnoresEmpty(t *testing.T) {
 	ms := MakeMemoryStorage()
 	offer := Offer{Symbol: sym, Amount: 0}
-	offer.ID = ms.AddOffer(offer)
-	r, found := ms.BestOffer(sym)
+	offer.ID, _ = ms.AddOffer(offer)
+	r, found, _ := ms.BestOffer(sym)
 	if found {
 		t.Fatalf("Incorrectly found %+v", r)
 	}
@@ -51,10 +52,10 @@ func TestBestOfferIgnoresEmpty(t *testing.T) {
 func TestBestOfferSelectsCorrectly(t *testing.T) {
 	ms := MakeMemoryStorage()
 	offer0 := Offer{Symbol: sym, Amount: 10, Price: 5, OfferType: OrderTypeLimit}
-	offer0.ID = ms.AddOffer(offer0)
+	offer0.ID, _ = ms.AddOffer(offer0)
 	offer1 := Offer{Symbol: sym, Amount: 10, Price: 2, OfferType: OrderTypeLimit}
-	offer1.ID = ms.AddOffer(offer1)
-	r, found := ms.BestOffer(sym)
+	offer1.ID, _ = ms.AddOffer(offer1)
+	r, found, _ := ms.BestOffer(sym)
 	if !found {
 		t.Fatal("Not found")
 	}
@@ -66,10 +67,10 @@ func TestBestOfferSelectsCorrectly(t *testing.T) {
 func TestBestOfferSelectsNonEmpty(t *testing.T) {
 	ms := MakeMemoryStorage()
 	offer0 := Offer{Symbol: sym, Amount: 10, Price: 5, OfferType: OrderTypeLimit}
-	offer0.ID = ms.AddOffer(offer0)
+	offer0.ID, _ = ms.AddOffer(offer0)
 	offer1 := Offer{Symbol: sym, Amount: 0, Price: 2, OfferType: OrderTypeLimit}
-	offer1.ID = ms.AddOffer(offer1)
-	r, found := ms.BestOffer(sym)
+	offer1.ID, _ = ms.AddOffer(offer1)
+	r, found, _ := ms.BestOffer(sym)
 	if !found {
 		t.Fatal("Not found")
 	}
@@ -81,8 +82,8 @@ func TestBestOfferSelectsNonEmpty(t *testing.T) {
 func TestBestBidMarketBasic(t *testing.T) {
 	ms := MakeMemoryStorage()
 	bid := Bid{Symbol: sym, Amount: 10}
-	bid.ID = ms.AddBid(bid)
-	r, found := ms.BestBid(sym)
+	bid.ID, _ = ms.AddBid(bid)
+	r, found, _ := ms.BestBid(sym)
 	if !found {
 		t.Fatal("Not found")
 	}
@@ -94,8 +95,8 @@ func TestBestBidMarketBasic(t *testing.T) {
 func TestBestBidLimitBasic(t *testing.T) {
 	ms := MakeMemoryStorage()
 	bid := Bid{Symbol: sym, Amount: 10, BidType: OrderTypeLimit, Price: 5}
-	bid.ID = ms.AddBid(bid)
-	r, found := ms.BestBid(sym)
+	bid.ID, _ = ms.AddBid(bid)
+	r, found, _ := ms.BestBid(sym)
 	if !found {
 		t.Fatal("Not found")
 	}
@@ -107,8 +108,8 @@ func TestBestBidLimitBasic(t *testing.T) {
 func TestBestBidIgnoresEmpty(t *testing.T) {
 	ms := MakeMemoryStorage()
 	bid := Bid{Symbol: sym, Amount: 0}
-	bid.ID = ms.AddBid(bid)
-	r, found := ms.BestBid(sym)
+	bid.ID, _ = ms.AddBid(bid)
+	r, found, _ := ms.BestBid(sym)
 	if found {
 		t.Fatalf("Should not have been found: %+v", r)
 	}
@@ -117,10 +118,10 @@ func TestBestBidIgnoresEmpty(t *testing.T) {
 func TestBestBidSelectsCorrectly(t *testing.T) {
 	ms := MakeMemoryStorage()
 	bid0 := Bid{Symbol: sym, Amount: 10, Price: 5, BidType: OrderTypeLimit}
-	bid0.ID = ms.AddBid(bid0)
+	bid0.ID, _ = ms.AddBid(bid0)
 	bid1 := Bid{Symbol: sym, Amount: 10, Price: 7, BidType: OrderTypeLimit}
-	bid1.ID = ms.AddBid(bid1)
-	r, found := ms.BestBid(sym)
+	bid1.ID, _ = ms.AddBid(bid1)
+	r, found, _ := ms.BestBid(sym)
 	if !found {
 		t.Fatal("Not Found")
 	}
@@ -132,10 +133,10 @@ func TestBestBidSelectsCorrectly(t *testing.T) {
 func TestBestBidSelectsNonempty(t *testing.T) {
 	ms := MakeMemoryStorage()
 	bid0 := Bid{Symbol: sym, Amount: 10, Price: 5, BidType: OrderTypeLimit}
-	bid0.ID = ms.AddBid(bid0)
+	bid0.ID, _ = ms.AddBid(bid0)
 	bid1 := Bid{Symbol: sym, Amount: 0, Price: 7, BidType: OrderTypeLimit}
-	bid1.ID = ms.AddBid(bid1)
-	r, found := ms.BestBid(sym)
+	bid1.ID, _ = ms.AddBid(bid1)
+	r, found, _ := ms.BestBid(sym)
 	if !found {
 		t.Fatal("Not Found")
 	}
@@ -160,7 +161,7 @@ func TestUpdateOffer(t *testing.T) {
 func TestAddBid(t *testing.T) {
 	ms := MakeMemoryStorage()
 	bid := Bid{Symbol: sym}
-	bid.ID = ms.AddBid(bid)
+	bid.ID, _ = ms.AddBid(bid)
 	if bid.ID == uuid.Nil {
 		t.Fatalf("UUID not generated")
 	}
@@ -186,7 +187,7 @@ func TestGetBid(t *testing.T) {
 	ms := MakeMemoryStorage()
 	bid := Bid{Symbol: sym, ID: uuid.New()}
 	ms.bids[bid.ID] = bid
-	r := ms.GetBid(bid.ID)
+	r, _ := ms.GetBid(bid.ID)
 	if r != bid {
 		t.Fatalf("%+v != %+v", r, bid)
 	}
@@ -197,7 +198,7 @@ func TestGetOffer(t *testing.T) {
 	offer := Offer{Symbol: sym, ID: uuid.New()}
 	ms.offers[sym] = make(map[uuid.UUID]Offer)
 	ms.offers[sym][offer.ID] = offer
-	r := ms.GetOffer(offer.ID)
+	r, _ := ms.GetOffer(offer.ID)
 	if r != offer {
 		t.Fatalf("%+v != %+v", r, offer)
 	}
@@ -250,7 +251,7 @@ func TestSetLastPrice(t *testing.T) {
 func TestGetLastPrice(t *testing.T) {
 	ms := MakeMemoryStorage()
 	ms.lastPrice[sym] = 42
-	if ms.LastPrice(sym) != 42 {
+	if ms.lastPriceOf(sym) != 42 {
 		t.Fatalf("Should be 42: %+v", ms.lastPrice)
 	}
 }
@@ -262,7 +263,7 @@ func TestAllSymbols(t *testing.T) {
 	offer := Offer{Symbol: sym, ID: uuid.New()}
 	ms.offers[sym] = make(map[uuid.UUID]Offer)
 	ms.offers[sym][offer.ID] = offer
-	syms := ms.AllSymbols()
+	syms, _ := ms.AllSymbols()
 	if len(syms) != 2 {
 		t.Fatalf("Wrong: %+v", syms)
 	}
@@ -294,11 +295,13 @@ func TestMarshalEmpty(t *testing.T) {
 
 func TestMarshalData(t *testing.T) {
 	ms := MakeMemoryStorage()
-	ms.AddBid(Bid{Symbol: sym, Amount: 10})
+	ms.AddBid(Bid{Symbol: sym, Amount: 10, Held: 100})
 	ms.AddBid(Bid{Symbol: "G", Amount: 11, Account: 2})
+	ms.AddBid(Bid{Symbol: "G", Amount: 3, Account: 5, Cancelled: true})
 	ms.AddOffer(Offer{Symbol: "Z", Amount: 14})
+	ms.AddOffer(Offer{Symbol: "Z", Amount: 9, Cancelled: true})
 	ms.AddOffer(Offer{Symbol: "Y", Amount: 8, Account: 4, OfferType: OrderTypeLimit, Price: 42})
-	ms.NewTransaction(Transaction{Price: 24})
+	ms.NewTransaction(Transaction{Price: 24, Symbol: "Q", BidAccount: 3, OfferAccount: 4})
 	ms.NewTransaction(Transaction{Price: 424})
 	ms.SetLastPrice("Q", 233)
 	ms.SetLastPrice("X", 322)
@@ -321,3 +324,71 @@ func TestMarshalData(t *testing.T) {
 		t.Fatalf("transactions:\n%+v\n%+v", msr.transactions, ms.transactions)
 	}
 }
+
+func TestUnMarshalWithoutCancelled(t *testing.T) {
+	data := "2c8f3e5e-1f8e-4c4a-9a43-4b0f4d7e3a11,1,4,Y,42,8\n" +
+		"EOF\nEOF\n" +
+		"6b1f0f4c-55a8-4d6c-8f7e-2b6a5f3c9d20,0,2,G,0,11,false\n" +
+		"EOF\nEOF\n"
+	ms := MakeMemoryStorage()
+	ms.UnMarshal(bytes.NewReader([]byte(data)))
+	offer, _ := ms.GetOffer(uuid.MustParse("2c8f3e5e-1f8e-4c4a-9a43-4b0f4d7e3a11"))
+	if !offer.IsActive() {
+		t.Fatalf("%+v", offer)
+	}
+	bid, _ := ms.GetBid(uuid.MustParse("6b1f0f4c-55a8-4d6c-8f7e-2b6a5f3c9d20"))
+	if !bid.IsActive() {
+		t.Fatalf("%+v", bid)
+	}
+}
+
+func TestGetBidNotFound(t *testing.T) {
+	ms := MakeMemoryStorage()
+	if _, err := ms.GetBid(uuid.New()); err != ErrOrderNotFound {
+		t.Fatalf("Expected ErrOrderNotFound, got %v", err)
+	}
+}
+
+func TestGetOfferNotFound(t *testing.T) {
+	ms := MakeMemoryStorage()
+	if _, err := ms.GetOffer(uuid.New()); err != ErrOrderNotFound {
+		t.Fatalf("Expected ErrOrderNotFound, got %v", err)
+	}
+}
+
+func TestAddOfferUnknownType(t *testing.T) {
+	ms := MakeMemoryStorage()
+	_, err := ms.AddOffer(Offer{Symbol: sym, Amount: 1, OfferType: 99})
+	if err != ErrUnknownOrderType {
+		t.Fatalf("Expected ErrUnknownOrderType, got %v", err)
+	}
+	if len(ms.offers[sym]) != 0 {
+		t.Fatalf("%+v", ms.offers)
+	}
+}
+
+func TestUnMarshalCorrupt(t *testing.T) {
+	ms := MakeMemoryStorage()
+	ms.SetLastPrice(sym, 5)
+	before := bytes.Buffer{}
+	ms.Marshal(&before)
+	inputs := []string{
+		"",
+		"EOF\n",
+		"not-a-uuid,1,4,Y,42,8\nEOF\nEOF\nEOF\nEOF\n",
+		"EOF\nS,many\nEOF\nEOF\nEOF\n",
+		"EOF\nEOF\n6b1f0f4c-55a8-4d6c-8f7e-2b6a5f3c9d20,7,2,G,0,11,false\nEOF\nEOF\n",
+		"garbage\n",
+	}
+	for _, input := range inputs {
+		err := ms.UnMarshal(bytes.NewReader([]byte(input)))
+		if !errors.Is(err, ErrCorruptSnapshot) {
+			t.Fatalf("Expected ErrCorruptSnapshot for %q, got %v", input, err)
+		}
+		after := bytes.Buffer{}
+		ms.Marshal(&after)
+		if after.String() != before.String() {
+			t.Fatalf("Storage changed by %q:\n%s", input, after.String())
+		}
+	}
+}
@@ -7,37 +7,37 @@ type mockOrderProcessor struct {
 
 func (m *mockOrderProcessor) TryFillBid(
 	ms MarketStorage,
-	accounts Accounts,
-	opl map[OrderType]orderProcessor,
+	s *Settlement,
+	opl map[OrderType]OrderProcessor,
 	bid Bid,
-) {
+) error {
 	bid.Amount -= m.fulfill
 	if bid.Amount < 0 {
 		bid.Amount = 0
 	}
-	ms.UpdateBid(bid)
+	return ms.UpdateBid(bid)
 }
 
 func (m *mockOrderProcessor) TrySell(
 	ms MarketStorage,
-	accounts Accounts,
-	opl map[OrderType]orderProcessor,
+	s *Settlement,
+	opl map[OrderType]OrderProcessor,
 	offer Offer,
-) {
+) error {
 	offer.Amount -= m.fulfill
 	if offer.Amount < 0 {
 		offer.Amount = 0
 	}
-	ms.UpdateOffer(offer)
+	return ms.UpdateOffer(offer)
 }
 
-func (m *mockOrderProcessor) GetAskingPrice(ms MarketStorage, o Offer) int64 {
+func (m *mockOrderProcessor) GetAskingPrice(ms MarketStorage, o Offer) (int64, error) {
 	if m.askingPrice > 0 {
-		return m.askingPrice
+		return m.askingPrice, nil
 	}
 	return ms.LastPrice(o.Symbol)
 }
 
-func (m *mockOrderProcessor) GetBidPrice(ms MarketStorage, b Bid) int64 {
+func (m *mockOrderProcessor) GetBidPrice(ms MarketStorage, b Bid) (int64, error) {
 	return ms.LastPrice(b.Symbol)
 }
@@ -0,0 +1,252 @@
+package economy
+
+import (
+	"container/heap"
+	"container/list"
+	"sort"
+
+	"github.com/google/uuid"
+)
+
+// orderBook indexes the active orders for a single symbol.
+// Limit orders are grouped into price levels, and orders at
+// each level are queued in the order they arrived. Market
+// orders have no price of their own, so they are kept in a
+// separate queue and priced at the last price when matched.
+// Stop orders aren't matched at all until they are
+// triggered, so they are only kept in arrival order.
+// Orders of types registered with RegisterOrderType are
+// also kept in arrival order, and are priced by their
+// OrderPricer each time the book is searched.
+//
+// Every entry can be found through entries, so an order is
+// taken out of its queue as soon as it is filled, cancelled
+// or moved by an amendment.
+type orderBook struct {
+	limitBids    bookSide
+	limitOffers  bookSide
+	marketBids   list.List
+	marketOffers list.List
+	stopBids     list.List
+	stopOffers   list.List
+	customBids   list.List
+	customOffers list.List
+	entries      map[uuid.UUID]bookRef
+}
+
+func makeOrderBook() *orderBook {
+	return &orderBook{
+		limitBids:   makeBookSide(func(a, b int64) bool { return a > b }),
+		limitOffers: makeBookSide(func(a, b int64) bool { return a < b }),
+		entries:     make(map[uuid.UUID]bookRef),
+	}
+}
+
+type bookEntry struct {
+	id  uuid.UUID
+	seq uint64
+}
+
+// bookRef is where an order's entry is queued. side and
+// level are only set for limit orders.
+type bookRef struct {
+	queue   *list.List
+	element *list.Element
+	side    *bookSide
+	level   *priceLevel
+}
+
+// push queues e at the back of q
+func (b *orderBook) push(q *list.List, e bookEntry) {
+	b.entries[e.id] = bookRef{queue: q, element: q.PushBack(e)}
+}
+
+// pushLimit queues e at the back of its price level on side
+func (b *orderBook) pushLimit(side *bookSide, price int64, e bookEntry) {
+	level := side.level(price)
+	b.entries[e.id] = bookRef{
+		queue:   &level.orders,
+		element: level.orders.PushBack(e),
+		side:    side,
+		level:   level,
+	}
+}
+
+// remove takes the order out of the book, if it is queued
+func (b *orderBook) remove(id uuid.UUID) {
+	r, found := b.entries[id]
+	if !found {
+		return
+	}
+	r.queue.Remove(r.element)
+	if r.level != nil && r.level.orders.Len() == 0 {
+		r.side.removeLevel(r.level)
+	}
+	delete(b.entries, id)
+}
+
+type priceLevel struct {
+	price  int64
+	orders list.List
+	// index is the level's position in the heap
+	index int
+}
+
+// bookSide finds price levels by price, and keeps them in
+// a heap so the best level can be found without sorting.
+type bookSide struct {
+	levels map[int64]*priceLevel
+	heap   levelHeap
+}
+
+// makeBookSide returns a bookSide where better returns true
+// if a is a better price than b
+func makeBookSide(better func(a, b int64) bool) bookSide {
+	return bookSide{
+		levels: make(map[int64]*priceLevel),
+		heap:   levelHeap{better: better},
+	}
+}
+
+// level returns the level for price, adding it if needed
+func (s *bookSide) level(price int64) *priceLevel {
+	l, found := s.levels[price]
+	if !found {
+		l = &priceLevel{price: price}
+		s.levels[price] = l
+		heap.Push(&s.heap, l)
+	}
+	return l
+}
+
+func (s *bookSide) removeLevel(l *priceLevel) {
+	heap.Remove(&s.heap, l.index)
+	delete(s.levels, l.price)
+}
+
+// best returns the earliest entry at the best price
+func (s *bookSide) best() (int64, bookEntry, bool) {
+	if len(s.heap.levels) == 0 {
+		return 0, bookEntry{}, false
+	}
+	l := s.heap.levels[0]
+	return l.price, l.orders.Front().Value.(bookEntry), true
+}
+
+// levelHeap orders price levels with the best price first
+type levelHeap struct {
+	levels []*priceLevel
+	better func(a, b int64) bool
+}
+
+func (h levelHeap) Len() int { return len(h.levels) }
+
+func (h levelHeap) Less(i, j int) bool { return h.better(h.levels[i].price, h.levels[j].price) }
+
+func (h levelHeap) Swap(i, j int) {
+	h.levels[i], h.levels[j] = h.levels[j], h.levels[i]
+	h.levels[i].index, h.levels[j].index = i, j
+}
+
+func (h *levelHeap) Push(x interface{}) {
+	l := x.(*priceLevel)
+	l.index = len(h.levels)
+	h.levels = append(h.levels, l)
+}
+
+func (h *levelHeap) Pop() interface{} {
+	old := h.levels
+	l := old[len(old)-1]
+	h.levels = old[:len(old)-1]
+	return l
+}
+
+// pricedEntry is an order book entry with the price it
+// would currently trade at
+type pricedEntry struct {
+	price int64
+	e     bookEntry
+}
+
+// beats returns true if a should be matched before b
+func (s *bookSide) beats(a, b pricedEntry) bool {
+	if a.price != b.price {
+		return s.heap.better(a.price, b.price)
+	}
+	return a.e.seq < b.e.seq
+}
+
+// bestOffer returns the offer with the lowest asking
+// price, using marketPrice for market offers. Ties go to
+// the offer that arrived first.
+func (b *orderBook) bestOffer(marketPrice int64) (pricedEntry, bool) {
+	return best(&b.limitOffers, &b.marketOffers, marketPrice)
+}
+
+// bestBid returns the bid with the highest bid price,
+// using marketPrice for market bids. Ties go to the bid
+// that arrived first.
+func (b *orderBook) bestBid(marketPrice int64) (pricedEntry, bool) {
+	return best(&b.limitBids, &b.marketBids, marketPrice)
+}
+
+func best(side *bookSide, market *list.List, marketPrice int64) (pricedEntry, bool) {
+	price, e, found := side.best()
+	top := pricedEntry{price: price, e: e}
+	if market.Len() > 0 {
+		m := pricedEntry{price: marketPrice, e: market.Front().Value.(bookEntry)}
+		if !found || side.beats(m, top) {
+			return m, true
+		}
+	}
+	return top, found
+}
+
+// rankedOffers returns the IDs of all the offers in
+// the order they would be matched, including the already
+// priced entries in extra
+func (b *orderBook) rankedOffers(marketPrice int64, extra []pricedEntry) []uuid.UUID {
+	return rank(&b.limitOffers, &b.marketOffers, marketPrice, extra)
+}
+
+// rankedBids returns the IDs of all the bids in the
+// order they would be matched, including the already
+// priced entries in extra
+func (b *orderBook) rankedBids(marketPrice int64, extra []pricedEntry) []uuid.UUID {
+	return rank(&b.limitBids, &b.marketBids, marketPrice, extra)
+}
+
+func rank(
+	side *bookSide,
+	market *list.List,
+	marketPrice int64,
+	extra []pricedEntry,
+) []uuid.UUID {
+	all := append([]pricedEntry(nil), extra...)
+	for _, level := range side.levels {
+		for _, e := range entries(&level.orders) {
+			all = append(all, pricedEntry{price: level.price, e: e})
+		}
+	}
+	for _, e := range entries(market) {
+		all = append(all, pricedEntry{price: marketPrice, e: e})
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return side.beats(all[i], all[j])
+	})
+	ids := make([]uuid.UUID, len(all))
+	for i, r := range all {
+		ids[i] = r.e.id
+	}
+	return ids
+}
+
+// entries returns the entries in q in the order they
+// arrived
+func entries(q *list.List) []bookEntry {
+	rv := make([]bookEntry, 0, q.Len())
+	for e := q.Front(); e != nil; e = e.Next() {
+		rv = append(rv, e.Value.(bookEntry))
+	}
+	return rv
+}
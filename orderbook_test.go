@@ -0,0 +1,263 @@
+package economy
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestBestOfferTimePriority(t *testing.T) {
+	ms := MakeMemoryStorage()
+	first, _ := ms.AddOffer(Offer{Symbol: sym, Amount: 10, Price: 5, OfferType: OrderTypeLimit})
+	ms.AddOffer(Offer{Symbol: sym, Amount: 10, Price: 5, OfferType: OrderTypeLimit})
+	for i := 0; i < 10; i++ {
+		r, _, _ := ms.BestOffer(sym)
+		if r.ID != first {
+			t.Fatalf("%s != %s", r.ID, first)
+		}
+	}
+}
+
+func TestBestBidTimePriority(t *testing.T) {
+	ms := MakeMemoryStorage()
+	first, _ := ms.AddBid(Bid{Symbol: sym, Amount: 10, Price: 5, BidType: OrderTypeLimit})
+	ms.AddBid(Bid{Symbol: sym, Amount: 10, Price: 5, BidType: OrderTypeLimit})
+	for i := 0; i < 10; i++ {
+		r, _, _ := ms.BestBid(sym)
+		if r.ID != first {
+			t.Fatalf("%s != %s", r.ID, first)
+		}
+	}
+}
+
+func TestBestBidIgnoresOtherSymbols(t *testing.T) {
+	ms := MakeMemoryStorage()
+	ms.AddBid(Bid{Symbol: "X", Amount: 10, Price: 50, BidType: OrderTypeLimit})
+	bid := Bid{Symbol: sym, Amount: 10, Price: 5, BidType: OrderTypeLimit}
+	bid.ID, _ = ms.AddBid(bid)
+	r, found, _ := ms.BestBid(sym)
+	if !found || r != bid {
+		t.Fatalf("%+v", r)
+	}
+}
+
+func TestBestOfferHighPrice(t *testing.T) {
+	ms := MakeMemoryStorage()
+	offer := Offer{Symbol: sym, Amount: 10, Price: 500, OfferType: OrderTypeLimit}
+	offer.ID, _ = ms.AddOffer(offer)
+	r, found, _ := ms.BestOffer(sym)
+	if !found || r != offer {
+		t.Fatalf("%+v", r)
+	}
+}
+
+func TestPartialFillKeepsPriority(t *testing.T) {
+	ms := MakeMemoryStorage()
+	first := Offer{Symbol: sym, Amount: 10, Price: 5, OfferType: OrderTypeLimit}
+	first.ID, _ = ms.AddOffer(first)
+	ms.AddOffer(Offer{Symbol: sym, Amount: 10, Price: 5, OfferType: OrderTypeLimit})
+	first.Amount = 4
+	ms.UpdateOffer(first)
+	r, _, _ := ms.BestOffer(sym)
+	if r.ID != first.ID {
+		t.Fatalf("%+v", r)
+	}
+}
+
+func TestAmendedAmountLosesPriority(t *testing.T) {
+	ms := MakeMemoryStorage()
+	first := Bid{Symbol: sym, Amount: 10, Price: 5, BidType: OrderTypeLimit}
+	first.ID, _ = ms.AddBid(first)
+	second, _ := ms.AddBid(Bid{Symbol: sym, Amount: 10, Price: 5, BidType: OrderTypeLimit})
+	first.Amount = 20
+	ms.UpdateBid(first)
+	r, _, _ := ms.BestBid(sym)
+	if r.ID != second {
+		t.Fatalf("%+v", r)
+	}
+}
+
+func TestFilledOrderLeavesBook(t *testing.T) {
+	ms := MakeMemoryStorage()
+	first := Offer{Symbol: sym, Amount: 10, Price: 2, OfferType: OrderTypeLimit}
+	first.ID, _ = ms.AddOffer(first)
+	second, _ := ms.AddOffer(Offer{Symbol: sym, Amount: 10, Price: 3, OfferType: OrderTypeLimit})
+	first.Amount = 0
+	ms.UpdateOffer(first)
+	r, _, _ := ms.BestOffer(sym)
+	if r.ID != second {
+		t.Fatalf("%+v", r)
+	}
+	r.Amount = 0
+	ms.UpdateOffer(r)
+	if r, found, _ := ms.BestOffer(sym); found {
+		t.Fatalf("%+v", r)
+	}
+}
+
+func TestCancelledOrdersLeaveBook(t *testing.T) {
+	ms := MakeMemoryStorage()
+	var bids []Bid
+	for i := int64(1); i <= 5; i++ {
+		b := Bid{Symbol: sym, Amount: 10, Price: i, BidType: OrderTypeLimit}
+		b.ID, _ = ms.AddBid(b)
+		bids = append(bids, b)
+	}
+	stop := Bid{Symbol: sym, Amount: 10, StopPrice: 5, BidType: OrderTypeStopMarket}
+	stop.ID, _ = ms.AddBid(stop)
+	bids[4].Price = 9
+	ms.UpdateBid(bids[4])
+	for _, b := range append(bids, stop) {
+		b.Cancelled = true
+		ms.UpdateBid(b)
+	}
+	book := ms.books[sym]
+	if len(book.entries) != 0 || len(book.limitBids.levels) != 0 ||
+		book.limitBids.heap.Len() != 0 || book.stopBids.Len() != 0 {
+		t.Fatalf("%d entries, %d levels", len(book.entries), len(book.limitBids.levels))
+	}
+}
+
+func TestMarketOfferTiesWithEarlierLimit(t *testing.T) {
+	ms := MakeMemoryStorage()
+	ms.SetLastPrice(sym, 5)
+	limit, _ := ms.AddOffer(Offer{Symbol: sym, Amount: 10, Price: 5, OfferType: OrderTypeLimit})
+	ms.AddOffer(Offer{Symbol: sym, Amount: 10, OfferType: OrderTypeMarket})
+	r, _, _ := ms.BestOffer(sym)
+	if r.ID != limit {
+		t.Fatalf("%+v", r)
+	}
+	ms.SetLastPrice(sym, 4)
+	r, _, _ = ms.BestOffer(sym)
+	if r.ID == limit {
+		t.Fatalf("%+v", r)
+	}
+}
+
+func TestMarshalKeepsPriority(t *testing.T) {
+	ms := MakeMemoryStorage()
+	var ids []uuid.UUID
+	for i := 0; i < 20; i++ {
+		id, _ := ms.AddOffer(Offer{Symbol: sym, Amount: 1, Price: 5, OfferType: OrderTypeLimit})
+		ids = append(ids, id)
+	}
+	buffer := bytes.Buffer{}
+	ms.Marshal(&buffer)
+	msr := MakeMemoryStorage()
+	msr.UnMarshal(bytes.NewReader(buffer.Bytes()))
+	for _, id := range ids {
+		r, _, _ := msr.BestOffer(sym)
+		if r.ID != id {
+			t.Fatalf("%s != %s", r.ID, id)
+		}
+		r.Amount = 0
+		msr.UpdateOffer(r)
+	}
+}
+
+// scanBestOffer is the linear scan that MemoryStorage used
+// before it kept an order book, kept for benchmarking
+func scanBestOffer(s *MemoryStorage, sym string) (Offer, bool) {
+	o := Offer{Price: math.MaxInt64}
+	marketPrice := s.lastPriceOf(sym)
+	for _, offer := range s.offers[sym] {
+		if offer.IsActive() {
+			switch offer.OfferType {
+			case OrderTypeLimit:
+				if offer.Price < o.Price {
+					o = offer
+				}
+			case OrderTypeMarket:
+				if marketPrice < o.Price {
+					o = offer
+				}
+			}
+		}
+	}
+	return o, o.Amount > 0
+}
+
+// scanBestBid is the linear scan that MemoryStorage used
+// before it kept an order book, kept for benchmarking
+func scanBestBid(s *MemoryStorage, sym string) (Bid, bool) {
+	result := Bid{Price: 0}
+	marketPrice := s.lastPriceOf(sym)
+	for _, bid := range s.bids {
+		if bid.IsActive() && bid.Symbol == sym {
+			switch bid.BidType {
+			case OrderTypeLimit:
+				if bid.Price > result.Price {
+					result = bid
+				}
+			case OrderTypeMarket:
+				if marketPrice > result.Price {
+					result = bid
+				}
+			}
+		}
+	}
+	return result, result.Amount > 0
+}
+
+func benchmarkStorage(n int) *MemoryStorage {
+	ms := MakeMemoryStorage()
+	for i := 0; i < n; i++ {
+		s := fmt.Sprintf("S%d", i%10)
+		ms.AddOffer(Offer{Symbol: s, Amount: 10, Price: int64(1000 + i%500), OfferType: OrderTypeLimit})
+		ms.AddBid(Bid{Symbol: s, Amount: 10, Price: int64(1 + i%500), BidType: OrderTypeLimit})
+	}
+	return ms
+}
+
+func BenchmarkBestOfferScan(b *testing.B) {
+	ms := benchmarkStorage(10000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		scanBestOffer(ms, "S1")
+	}
+}
+
+func BenchmarkBestOfferBook(b *testing.B) {
+	ms := benchmarkStorage(10000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ms.BestOffer("S1")
+	}
+}
+
+func BenchmarkBestBidScan(b *testing.B) {
+	ms := benchmarkStorage(10000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		scanBestBid(ms, "S1")
+	}
+}
+
+func BenchmarkBestBidBook(b *testing.B) {
+	ms := benchmarkStorage(10000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ms.BestBid("S1")
+	}
+}
+
+// BenchmarkMatchBook fills a deep book one order at a time,
+// which is what the order processors do while matching
+func BenchmarkMatchBook(b *testing.B) {
+	ms := benchmarkStorage(10000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		o, found, _ := ms.BestOffer("S1")
+		if !found {
+			b.StopTimer()
+			ms = benchmarkStorage(10000)
+			b.StartTimer()
+			continue
+		}
+		o.Amount = 0
+		ms.UpdateOffer(o)
+	}
+}
@@ -2,14 +2,50 @@ package economy
 
 import "time"
 
+// Fill trades as much as possible between bid and off at
+// price, recording the transaction and telling subscribers,
+// and returns the updated orders. It returns false if the
+// bid could not be paid for, in which case the bid has
+// been marked NSF. Funds and goods have already moved if
+// storage fails partway through, so storage errors should
+// be treated as fatal.
+func (s *Settlement) Fill(
+	ms MarketStorage, ts time.Time, bid Bid, off Offer, price int64,
+) (Bid, Offer, bool, error) {
+	return fillBid(ms, s, ts, bid, off, price)
+}
+
+// CancelBid cancels what is left of bid, for processors
+// that don't leave bids resting
+func (s *Settlement) CancelBid(ms MarketStorage, ts time.Time, bid Bid) error {
+	return s.cancelBid(ms, ts, bid, EventCancelled)
+}
+
+// CancelOffer cancels what is left of off, for processors
+// that don't leave offers resting
+func (s *Settlement) CancelOffer(ms MarketStorage, ts time.Time, off Offer) error {
+	return s.cancelOffer(ms, ts, off, EventCancelled)
+}
+
+// fillBid trades as much as possible between bid and off
+// at price, and returns the updated orders. It returns
+// false if the bid could not be paid for, or if price is
+// outside the symbol's band, which halts trading in it.
+// Funds and goods have already moved if storage fails
+// partway through, so storage errors should be treated as
+// fatal.
 func fillBid(
 	ms MarketStorage,
-	accounts Accounts,
+	s *Settlement,
 	ts time.Time,
 	bid Bid,
 	off Offer,
 	price int64,
-) (Bid, Offer, bool) {
+) (Bid, Offer, bool, error) {
+	if !s.inBand(off.Symbol, price) {
+		s.halt(off.Symbol, ts, price, true)
+		return bid, off, false, nil
+	}
 	var amount int64
 	if off.Amount <= bid.Amount {
 		amount = off.Amount
@@ -17,12 +53,23 @@ func fillBid(
 		amount = bid.Amount
 	}
 	totalPrice := amount * price
-	if !accounts.DebitIfPossible(bid.Account, totalPrice) {
+	bidFee, offerFee := s.fees.charge(off.Symbol, totalPrice, s.taker)
+	if !s.debit(&bid, totalPrice+bidFee) {
 		bid.NSF = true
-		ms.UpdateBid(bid)
-		return bid, off, false
+		s.release(&bid)
+		if err := ms.UpdateBid(bid); err != nil {
+			return bid, off, false, err
+		}
+		s.events.publish(bidEvent(EventNSF, ts, bid))
+		return bid, off, false, nil
+	}
+	s.accounts.Credit(off.Account, totalPrice-offerFee)
+	if fees := bidFee + offerFee; fees > 0 {
+		s.accounts.Credit(s.fees.House, fees)
+	}
+	if s.holdings != nil {
+		s.holdings.Transfer(off.Account, bid.Account, off.Symbol, amount)
 	}
-	accounts.Credit(off.Account, totalPrice)
 	if off.Amount <= bid.Amount {
 		bid.Amount -= off.Amount
 		off.Amount = 0
@@ -30,17 +77,49 @@ func fillBid(
 		off.Amount -= bid.Amount
 		bid.Amount = 0
 	}
-	ms.NewTransaction(
-		Transaction{
-			BidID:   bid.ID,
-			OfferID: off.ID,
-			Price:   price,
-			Amount:  amount,
-			Date:    ts,
-		},
-	)
-	ms.UpdateOffer(off)
-	ms.UpdateBid(bid)
-	ms.SetLastPrice(off.Symbol, price)
-	return bid, off, true
+	if bid.Amount == 0 {
+		s.release(&bid)
+	}
+	tx := Transaction{
+		BidID:        bid.ID,
+		OfferID:      off.ID,
+		Symbol:       off.Symbol,
+		BidAccount:   bid.Account,
+		OfferAccount: off.Account,
+		Price:        price,
+		Amount:       amount,
+		Date:         ts,
+		BidFee:       bidFee,
+		OfferFee:     offerFee,
+	}
+	var err error
+	tx.ID, err = ms.NewTransaction(tx)
+	if err != nil {
+		return bid, off, false, err
+	}
+	s.trades = append(s.trades, tx)
+	if err = s.updateCandles(ms, tx); err != nil {
+		return bid, off, false, err
+	}
+	if err = ms.UpdateOffer(off); err != nil {
+		return bid, off, false, err
+	}
+	if err = ms.UpdateBid(bid); err != nil {
+		return bid, off, false, err
+	}
+	s.events.publishFill(ts, bid, off, price, amount)
+	s.traded(off.Symbol, price)
+	lastPrice, err := ms.LastPrice(off.Symbol)
+	if err != nil {
+		return bid, off, false, err
+	}
+	if lastPrice != price {
+		s.events.publish(Event{
+			Type:   EventLastPrice,
+			Date:   ts,
+			Symbol: off.Symbol,
+			Price:  price,
+		})
+	}
+	return bid, off, true, ms.SetLastPrice(off.Symbol, price)
 }
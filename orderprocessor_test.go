@@ -8,12 +8,12 @@ import (
 func TestFillBidFilledByExactOffer(t *testing.T) {
 	storage := MakeMemoryStorage()
 	o := Offer{Symbol: "m", Amount: 10}
-	offerID := storage.AddOffer(o)
+	offerID, _ := storage.AddOffer(o)
 	o.ID = offerID
 	bid := Bid{Symbol: "m", Amount: 10, BidType: OrderTypeMarket}
-	id := storage.AddBid(bid)
+	id, _ := storage.AddBid(bid)
 	bid.ID = id
-	bid, o, _ = fillBid(storage, makeMockAccounts(), time.Time{}, bid, o, 7)
+	bid, o, _, _ = fillBid(storage, &Settlement{accounts: makeMockAccounts()}, time.Time{}, bid, o, 7)
 	if bid.Amount != 0 {
 		t.Fatalf("%+v", bid)
 	}
@@ -21,20 +21,20 @@ func TestFillBidFilledByExactOffer(t *testing.T) {
 	if o.Amount != 0 {
 		t.Fatalf("%+v", o)
 	}
-	if storage.LastPrice("m") != 7 {
-		t.Fatalf("%d != 7", storage.LastPrice("m"))
+	if storage.lastPriceOf("m") != 7 {
+		t.Fatalf("%d != 7", storage.lastPriceOf("m"))
 	}
 }
 
 func TestFillBidFilledByLargerOffer(t *testing.T) {
 	storage := MakeMemoryStorage()
 	o := Offer{Symbol: "m", Amount: 20}
-	offerID := storage.AddOffer(o)
+	offerID, _ := storage.AddOffer(o)
 	o.ID = offerID
 	bid := Bid{Symbol: "m", Amount: 10, BidType: OrderTypeMarket}
-	id := storage.AddBid(bid)
+	id, _ := storage.AddBid(bid)
 	bid.ID = id
-	bid, o, _ = fillBid(storage, makeMockAccounts(), time.Time{}, bid, o, 7)
+	bid, o, _, _ = fillBid(storage, &Settlement{accounts: makeMockAccounts()}, time.Time{}, bid, o, 7)
 	if bid.Amount != 0 {
 		t.Fatalf("%+v", bid)
 	}
@@ -42,20 +42,20 @@ func TestFillBidFilledByLargerOffer(t *testing.T) {
 	if o.Amount != 10 {
 		t.Fatalf("%+v", o)
 	}
-	if storage.LastPrice("m") != 7 {
-		t.Fatalf("%d != 7", storage.LastPrice("m"))
+	if storage.lastPriceOf("m") != 7 {
+		t.Fatalf("%d != 7", storage.lastPriceOf("m"))
 	}
 }
 
 func TestFillBidPartiallyFilled(t *testing.T) {
 	storage := MakeMemoryStorage()
 	o := Offer{Symbol: "m", Amount: 5}
-	offerID := storage.AddOffer(o)
+	offerID, _ := storage.AddOffer(o)
 	o.ID = offerID
 	bid := Bid{Symbol: "m", Amount: 10, BidType: OrderTypeMarket}
-	id := storage.AddBid(bid)
+	id, _ := storage.AddBid(bid)
 	bid.ID = id
-	bid, o, _ = fillBid(storage, makeMockAccounts(), time.Time{}, bid, o, 7)
+	bid, o, _, _ = fillBid(storage, &Settlement{accounts: makeMockAccounts()}, time.Time{}, bid, o, 7)
 	if bid.Amount != 5 {
 		t.Fatalf("%+v", bid)
 	}
@@ -63,8 +63,8 @@ func TestFillBidPartiallyFilled(t *testing.T) {
 	if o.Amount != 0 {
 		t.Fatalf("%+v", o)
 	}
-	if storage.LastPrice("m") != 7 {
-		t.Fatalf("%d != 7", storage.LastPrice("m"))
+	if storage.lastPriceOf("m") != 7 {
+		t.Fatalf("%d != 7", storage.lastPriceOf("m"))
 	}
 }
 
@@ -72,13 +72,13 @@ func TestFillBidExchangesFunds(t *testing.T) {
 	storage := MakeMemoryStorage()
 	accounts := makeMockAccounts()
 	o := Offer{Symbol: "m", Amount: 10, Account: 1}
-	offerID := storage.AddOffer(o)
+	offerID, _ := storage.AddOffer(o)
 	o.ID = offerID
 	bid := Bid{Symbol: "m", Amount: 10, Account: 2}
-	id := storage.AddBid(bid)
+	id, _ := storage.AddBid(bid)
 	bid.ID = id
 	storage.SetLastPrice("m", 10)
-	bid, o, filled := fillBid(storage, accounts, time.Time{}, bid, o, 10)
+	bid, o, filled, _ := fillBid(storage, &Settlement{accounts: accounts}, time.Time{}, bid, o, 10)
 	if !filled {
 		t.Fatal("Bid not filled")
 	}
@@ -95,13 +95,13 @@ func TestFillBidRejectsOnNoFunds(t *testing.T) {
 	accounts := makeMockAccounts()
 	accounts.rejects[2] = true
 	o := Offer{Symbol: "m", Amount: 10, Account: 1}
-	offerID := storage.AddOffer(o)
+	offerID, _ := storage.AddOffer(o)
 	o.ID = offerID
 	bid := Bid{Symbol: "m", Amount: 10, Account: 2}
-	id := storage.AddBid(bid)
+	id, _ := storage.AddBid(bid)
 	bid.ID = id
 	storage.SetLastPrice("m", 10)
-	bid, o, filled := fillBid(storage, accounts, time.Time{}, bid, o, 10)
+	bid, o, filled, _ := fillBid(storage, &Settlement{accounts: accounts}, time.Time{}, bid, o, 10)
 	if filled {
 		t.Fatal("Bid was filled")
 	}
@@ -0,0 +1,94 @@
+package economy
+
+import "github.com/google/uuid"
+
+type OrderStatus byte
+
+const (
+	// OrderStatusResting means nothing has been filled yet
+	// and the whole order is waiting in the market
+	OrderStatusResting OrderStatus = 0
+	// OrderStatusPartiallyFilled means some of the order
+	// was filled and the rest is waiting in the market
+	OrderStatusPartiallyFilled OrderStatus = 1
+	// OrderStatusFilled means the whole order was filled
+	OrderStatusFilled OrderStatus = 2
+	// OrderStatusNSF means the buyer could not pay and the
+	// rest of the bid was dropped
+	OrderStatusNSF OrderStatus = 3
+	// OrderStatusCancelled means the rest of the order was
+	// cancelled by its time in force instead of resting
+	OrderStatusCancelled OrderStatus = 4
+)
+
+func (s OrderStatus) String() string {
+	switch s {
+	case OrderStatusResting:
+		return "resting"
+	case OrderStatusPartiallyFilled:
+		return "partially filled"
+	case OrderStatusFilled:
+		return "filled"
+	case OrderStatusNSF:
+		return "NSF"
+	case OrderStatusCancelled:
+		return "cancelled"
+	}
+	return "unknown"
+}
+
+// ExecutionReport tells the caller of Market.Bid or
+// Market.Offer what happened to their order
+type ExecutionReport struct {
+	OrderID uuid.UUID
+	Status  OrderStatus
+	// Transactions lists the fills made for the order, in
+	// the order they happened
+	Transactions []Transaction
+	// Remaining is the amount of the order still unfilled
+	Remaining int64
+}
+
+// Filled returns the total amount filled by the order
+func (r ExecutionReport) Filled() int64 {
+	var filled int64
+	for _, tx := range r.Transactions {
+		filled += tx.Amount
+	}
+	return filled
+}
+
+func makeReport(id uuid.UUID, remaining int64, nsf, cancelled bool, txs []Transaction) ExecutionReport {
+	r := ExecutionReport{
+		OrderID:   id,
+		Remaining: remaining,
+	}
+	// Stop orders triggered by the order can trade with
+	// each other, which doesn't concern the caller
+	for _, tx := range txs {
+		if tx.BidID == id || tx.OfferID == id {
+			r.Transactions = append(r.Transactions, tx)
+		}
+	}
+	r.Status = StatusOf(remaining, nsf, cancelled, len(r.Transactions) > 0)
+	return r
+}
+
+// StatusOf returns the status of an order with remaining
+// left unfilled, given whether it was dropped for NSF,
+// whether it was cancelled and whether it has traded. It is
+// how an ExecutionReport's Status is set, and gives the
+// same answer for a stored order.
+func StatusOf(remaining int64, nsf, cancelled, traded bool) OrderStatus {
+	switch {
+	case nsf:
+		return OrderStatusNSF
+	case remaining == 0:
+		return OrderStatusFilled
+	case cancelled:
+		return OrderStatusCancelled
+	case traded:
+		return OrderStatusPartiallyFilled
+	}
+	return OrderStatusResting
+}
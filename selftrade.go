@@ -0,0 +1,128 @@
+package economy
+
+import "time"
+
+// SelfTradePrevention decides what happens when an order
+// would trade with a resting order from the same account
+type SelfTradePrevention byte
+
+const (
+	// SelfTradeAllow lets accounts trade with themselves
+	SelfTradeAllow SelfTradePrevention = 0
+	// SelfTradeCancelNewest cancels the incoming order
+	SelfTradeCancelNewest SelfTradePrevention = 1
+	// SelfTradeCancelOldest cancels the resting order, and
+	// the incoming order goes on to match the next one
+	SelfTradeCancelOldest SelfTradePrevention = 2
+	// SelfTradeCancelBoth cancels both orders
+	SelfTradeCancelBoth SelfTradePrevention = 3
+	// SelfTradeDecrement reduces both orders by the smaller
+	// amount without trading, which cancels the smaller one
+	SelfTradeDecrement SelfTradePrevention = 4
+)
+
+func (p SelfTradePrevention) String() string {
+	switch p {
+	case SelfTradeAllow:
+		return "allow"
+	case SelfTradeCancelNewest:
+		return "cancel newest"
+	case SelfTradeCancelOldest:
+		return "cancel oldest"
+	case SelfTradeCancelBoth:
+		return "cancel both"
+	case SelfTradeDecrement:
+		return "decrement"
+	}
+	return "unknown"
+}
+
+// WithSelfTradePrevention stops accounts trading with
+// themselves, which they could otherwise do to move the
+// last price
+func WithSelfTradePrevention(p SelfTradePrevention) Option {
+	return func(m *Market) {
+		m.settlement.selfTrade = p
+	}
+}
+
+// selfTrades returns true if bid and off must not trade
+// with each other
+func (s *Settlement) selfTrades(bid Bid, off Offer) bool {
+	return s.selfTrade != SelfTradeAllow && bid.Account == off.Account
+}
+
+// PreventSelfTrade applies the Market's self-trade
+// prevention to bid and off. It returns true if they
+// belong to the same account and must not trade, having
+// cancelled or reduced one or both of them, along with the
+// updated orders. Processors call it once they know the
+// prices cross, before Fill, and go on to the next
+// counter-order if the incoming order is still active.
+func (s *Settlement) PreventSelfTrade(
+	ms MarketStorage, ts time.Time, bid Bid, off Offer,
+) (Bid, Offer, bool, error) {
+	if !s.selfTrades(bid, off) {
+		return bid, off, false, nil
+	}
+	incomingBid := s.taker == SideBid
+	var cancelBid, cancelOffer bool
+	switch s.selfTrade {
+	case SelfTradeCancelNewest:
+		cancelBid, cancelOffer = incomingBid, !incomingBid
+	case SelfTradeCancelOldest:
+		cancelBid, cancelOffer = !incomingBid, incomingBid
+	case SelfTradeCancelBoth:
+		cancelBid, cancelOffer = true, true
+	case SelfTradeDecrement:
+		// The smaller order is cancelled and the larger one
+		// reduced by its amount
+		cancelBid, cancelOffer = bid.Amount <= off.Amount, off.Amount <= bid.Amount
+		var err error
+		if bid, off, err = s.decrement(ms, ts, bid, off); err != nil {
+			return bid, off, true, err
+		}
+	}
+	if cancelBid {
+		if err := s.cancelBid(ms, ts, bid, EventCancelled); err != nil {
+			return bid, off, true, err
+		}
+		bid.Cancelled, bid.Held = true, 0
+	}
+	if cancelOffer {
+		if err := s.cancelOffer(ms, ts, off, EventCancelled); err != nil {
+			return bid, off, true, err
+		}
+		off.Cancelled = true
+	}
+	return bid, off, true, nil
+}
+
+// decrement takes the amount of the smaller order off the
+// larger one. Orders of the same size are left unchanged.
+func (s *Settlement) decrement(
+	ms MarketStorage, ts time.Time, bid Bid, off Offer,
+) (Bid, Offer, error) {
+	if bid.Amount > off.Amount {
+		bid.Amount -= off.Amount
+		// Holding less can only fail for market bids whose
+		// price has risen, which keeps what is held
+		if err := s.hold(ms, &bid); err != nil && err != ErrInsufficientFunds {
+			return bid, off, err
+		}
+		if err := ms.UpdateBid(bid); err != nil {
+			return bid, off, err
+		}
+		s.events.publish(bidEvent(EventAmended, ts, bid))
+	} else if off.Amount > bid.Amount {
+		off.Amount -= bid.Amount
+		if s.holdings != nil {
+			s.holdings.Release(off.Account, off.Symbol, bid.Amount)
+		}
+		if err := ms.UpdateOffer(off); err != nil {
+			return bid, off, err
+		}
+		s.events.publish(offerEvent(EventAmended, ts, off))
+	}
+	return bid, off, nil
+}
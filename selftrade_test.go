@@ -0,0 +1,144 @@
+package economy
+
+import (
+	"testing"
+	"time"
+)
+
+func makeSelfTradeMarket(p SelfTradePrevention) *Market {
+	return MakeMarket(time.Now, MakeMemoryStorage(), makeMockAccounts(), WithSelfTradePrevention(p))
+}
+
+func TestSelfTradeAllowedByDefault(t *testing.T) {
+	m := MakeMarket(time.Now, MakeMemoryStorage(), makeMockAccounts())
+	m.Offer(Offer{Symbol: sym, Account: 1, Amount: 5, OfferType: OrderTypeLimit, Price: 5})
+	report, _ := m.Bid(Bid{Symbol: sym, Account: 1, Amount: 5, BidType: OrderTypeLimit, Price: 5})
+	if report.Status != OrderStatusFilled {
+		t.Fatalf("%+v", report)
+	}
+}
+
+func TestSelfTradeCancelNewest(t *testing.T) {
+	m := makeSelfTradeMarket(SelfTradeCancelNewest)
+	m.Offer(Offer{Symbol: sym, Account: 1, Amount: 5, OfferType: OrderTypeLimit, Price: 5})
+	report, _ := m.Bid(Bid{Symbol: sym, Account: 1, Amount: 5, BidType: OrderTypeLimit, Price: 5})
+	if report.Status != OrderStatusCancelled || report.Remaining != 5 {
+		t.Fatalf("%+v", report)
+	}
+	if d, _ := m.Depth(sym, 0); len(d.Offers) != 1 || len(d.Bids) != 0 {
+		t.Fatalf("%+v", d)
+	}
+	report, _ = m.Offer(Offer{Symbol: sym, Account: 1, Amount: 5, OfferType: OrderTypeMarket})
+	if report.Status != OrderStatusResting {
+		t.Fatalf("Offer matched its own offer: %+v", report)
+	}
+}
+
+func TestSelfTradeOnlyWhenPricesCross(t *testing.T) {
+	for _, p := range []SelfTradePrevention{SelfTradeCancelNewest, SelfTradeCancelOldest, SelfTradeCancelBoth, SelfTradeDecrement} {
+		m := makeSelfTradeMarket(p)
+		m.Bid(Bid{Symbol: sym, Account: 1, Amount: 5, BidType: OrderTypeLimit, Price: 99})
+		report, _ := m.Offer(Offer{Symbol: sym, Account: 1, Amount: 5, OfferType: OrderTypeLimit, Price: 101})
+		if report.Status != OrderStatusResting {
+			t.Fatalf("%s: %+v", p, report)
+		}
+		report, _ = m.Bid(Bid{Symbol: sym, Account: 1, Amount: 5, BidType: OrderTypeLimit, Price: 100})
+		if report.Status != OrderStatusResting {
+			t.Fatalf("%s: %+v", p, report)
+		}
+		if d, _ := m.Depth(sym, 0); len(d.Bids) != 2 || len(d.Offers) != 1 {
+			t.Fatalf("%s: %+v", p, d)
+		}
+	}
+}
+
+func TestSelfTradeCancelNewestOffer(t *testing.T) {
+	m := makeSelfTradeMarket(SelfTradeCancelNewest)
+	m.Bid(Bid{Symbol: sym, Account: 1, Amount: 5, BidType: OrderTypeLimit, Price: 5})
+	report, _ := m.Offer(Offer{Symbol: sym, Account: 1, Amount: 5, OfferType: OrderTypeMarket})
+	if report.Status != OrderStatusCancelled {
+		t.Fatalf("%+v", report)
+	}
+	if d, _ := m.Depth(sym, 0); len(d.Bids) != 1 || len(d.Offers) != 0 {
+		t.Fatalf("%+v", d)
+	}
+}
+
+func TestSelfTradeCancelOldest(t *testing.T) {
+	m := makeSelfTradeMarket(SelfTradeCancelOldest)
+	own, _ := m.Offer(Offer{Symbol: sym, Account: 1, Amount: 5, OfferType: OrderTypeLimit, Price: 5})
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 5, OfferType: OrderTypeLimit, Price: 6})
+	report, _ := m.Bid(Bid{Symbol: sym, Account: 1, Amount: 5, BidType: OrderTypeLimit, Price: 6})
+	if report.Status != OrderStatusFilled || len(report.Transactions) != 1 || report.Transactions[0].OfferAccount != 2 {
+		t.Fatalf("%+v", report)
+	}
+	if o, _ := m.FindOffer(own.OrderID); !o.Cancelled || o.Amount != 5 {
+		t.Fatalf("%+v", o)
+	}
+}
+
+func TestSelfTradeCancelBoth(t *testing.T) {
+	m := makeSelfTradeMarket(SelfTradeCancelBoth)
+	own, _ := m.Offer(Offer{Symbol: sym, Account: 1, Amount: 5, OfferType: OrderTypeLimit, Price: 5})
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 5, OfferType: OrderTypeLimit, Price: 5})
+	report, _ := m.Bid(Bid{Symbol: sym, Account: 1, Amount: 5, BidType: OrderTypeLimit, Price: 5})
+	if report.Status != OrderStatusCancelled || len(report.Transactions) != 0 {
+		t.Fatalf("%+v", report)
+	}
+	if o, _ := m.FindOffer(own.OrderID); !o.Cancelled {
+		t.Fatalf("%+v", o)
+	}
+	if d, _ := m.Depth(sym, 0); len(d.Offers) != 1 || d.Offers[0].Amount != 5 {
+		t.Fatalf("%+v", d)
+	}
+}
+
+func TestSelfTradeDecrement(t *testing.T) {
+	m := makeSelfTradeMarket(SelfTradeDecrement)
+	own, _ := m.Offer(Offer{Symbol: sym, Account: 1, Amount: 10, OfferType: OrderTypeLimit, Price: 5})
+	var events []Event
+	m.Subscribe(func(e Event) { events = append(events, e) })
+	report, _ := m.Bid(Bid{Symbol: sym, Account: 1, Amount: 4, BidType: OrderTypeLimit, Price: 5})
+	if report.Status != OrderStatusCancelled || report.Remaining != 4 || len(report.Transactions) != 0 {
+		t.Fatalf("%+v", report)
+	}
+	if o, _ := m.FindOffer(own.OrderID); !o.IsActive() || o.Amount != 6 {
+		t.Fatalf("%+v", o)
+	}
+	if len(events) != 3 || events[1].Type != EventAmended || events[2].Type != EventCancelled {
+		t.Fatalf("%+v", events)
+	}
+	report, _ = m.Bid(Bid{Symbol: sym, Account: 1, Amount: 8, BidType: OrderTypeLimit, Price: 5})
+	if report.Status != OrderStatusResting || report.Remaining != 2 {
+		t.Fatalf("%+v", report)
+	}
+	if o, _ := m.FindOffer(own.OrderID); !o.Cancelled {
+		t.Fatalf("%+v", o)
+	}
+}
+
+func TestSelfTradeDecrementReleasesEscrow(t *testing.T) {
+	accounts := makeMockAccounts()
+	accounts.accounts[1] = 100
+	m := MakeMarket(time.Now, MakeMemoryStorage(), accounts,
+		WithEscrow(0), WithSelfTradePrevention(SelfTradeDecrement))
+	m.Offer(Offer{Symbol: sym, Account: 1, Amount: 4, OfferType: OrderTypeLimit, Price: 5})
+	report, _ := m.Bid(Bid{Symbol: sym, Account: 1, Amount: 10, BidType: OrderTypeLimit, Price: 5})
+	b, _ := m.FindBid(report.OrderID)
+	if b.Amount != 6 || b.Held != 30 || accounts.held[1] != 30 {
+		t.Fatalf("%+v %+v", b, accounts.held)
+	}
+}
+
+func TestSelfTradeNotLiquidityForFOK(t *testing.T) {
+	m := makeSelfTradeMarket(SelfTradeCancelOldest)
+	m.Offer(Offer{Symbol: sym, Account: 1, Amount: 5, OfferType: OrderTypeLimit, Price: 5})
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 3, OfferType: OrderTypeLimit, Price: 5})
+	report, _ := m.Bid(Bid{Symbol: sym, Account: 1, Amount: 5, BidType: OrderTypeLimit, Price: 5, TimeInForce: TimeInForceFOK})
+	if report.Status != OrderStatusCancelled || len(report.Transactions) != 0 {
+		t.Fatalf("%+v", report)
+	}
+	if d, _ := m.Depth(sym, 0); len(d.Offers) != 1 || d.Offers[0].Amount != 8 {
+		t.Fatalf("Own offer cancelled by a killed bid: %+v", d)
+	}
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/williammoran/economy"
+)
+
+// FeedMessage is one update on the live feed. Type is
+// "trade" with Trade set, "price" with LastPrice set, or
+// "top" with Top set.
+type FeedMessage struct {
+	Type      string       `json:"type"`
+	Symbol    string       `json:"symbol"`
+	Trade     *TradeUpdate `json:"trade,omitempty"`
+	LastPrice int64        `json:"last_price,omitempty"`
+	Top       *TopOfBook   `json:"top,omitempty"`
+}
+
+// TradeUpdate is a fill between a bid and an offer
+type TradeUpdate struct {
+	BidID   string    `json:"bid_id"`
+	OfferID string    `json:"offer_id"`
+	Price   int64     `json:"price"`
+	Amount  int64     `json:"amount"`
+	Date    time.Time `json:"date"`
+}
+
+// TopOfBook is the best price level on each side of a
+// symbol. The amounts are zero for a side with no orders.
+type TopOfBook struct {
+	LastPrice   int64 `json:"last_price"`
+	Bid         int64 `json:"bid"`
+	BidAmount   int64 `json:"bid_amount"`
+	Offer       int64 `json:"offer"`
+	OfferAmount int64 `json:"offer_amount"`
+}
+
+// feedBuffer is how many messages a client can fall
+// behind before it is disconnected
+const feedBuffer = 256
+
+// errSlowConsumer is sent to clients that are
+// disconnected for not keeping up
+var errSlowConsumer = errors.New("slow consumer")
+
+// feed fans market events out to streaming clients.
+// Trades and prices are queued from the Market's event
+// subscription, which runs under the storage lock, so
+// nothing there may block. Each client has its own bounded
+// queue and is dropped when it is full, rather than
+// holding up matching. Top of book needs the Market, so it
+// is worked out afterwards by the feed's own goroutine for
+// the symbols that changed.
+type feed struct {
+	market      *economy.Market
+	buffer      int
+	unsubscribe func()
+	mutex       sync.Mutex
+	clients     map[*feedClient]bool
+	// dirty are the symbols that have changed since top of
+	// book was last checked, and tops what was last sent
+	dirty map[string]bool
+	tops  map[string]TopOfBook
+	wake  chan struct{}
+	done  chan struct{}
+	once  sync.Once
+}
+
+// feedClient is one streaming connection
+type feedClient struct {
+	symbols map[string]bool
+	out     chan FeedMessage
+	// dropped is closed when the client has fallen too far
+	// behind
+	dropped chan struct{}
+	once    sync.Once
+}
+
+func makeFeed(m *economy.Market, buffer int) *feed {
+	f := &feed{
+		market:  m,
+		buffer:  buffer,
+		clients: make(map[*feedClient]bool),
+		dirty:   make(map[string]bool),
+		tops:    make(map[string]TopOfBook),
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	f.unsubscribe = m.Subscribe(f.onEvent)
+	go f.run()
+	return f
+}
+
+func (f *feed) close() {
+	f.once.Do(func() {
+		f.unsubscribe()
+		close(f.done)
+	})
+}
+
+// onEvent is called by the Market with its storage locked
+func (f *feed) onEvent(e economy.Event) {
+	if e.Symbol == "" {
+		return
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	switch {
+	case (e.Type == economy.EventFilled || e.Type == economy.EventPartiallyFilled) && e.Side == economy.SideBid:
+		f.broadcast(FeedMessage{Type: "trade", Symbol: e.Symbol, Trade: &TradeUpdate{
+			BidID:   e.OrderID.String(),
+			OfferID: e.CounterID.String(),
+			Price:   e.Price,
+			Amount:  e.Amount,
+			Date:    e.Date,
+		}})
+	case e.Type == economy.EventLastPrice:
+		f.broadcast(FeedMessage{Type: "price", Symbol: e.Symbol, LastPrice: e.Price})
+	}
+	f.dirty[e.Symbol] = true
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+}
+
+// broadcast sends msg to every client subscribed to its
+// symbol. The feed's mutex must be held.
+func (f *feed) broadcast(msg FeedMessage) {
+	for c := range f.clients {
+		if c.symbols[msg.Symbol] {
+			c.send(msg)
+		}
+	}
+}
+
+func (c *feedClient) send(msg FeedMessage) {
+	select {
+	case c.out <- msg:
+	default:
+		c.once.Do(func() { close(c.dropped) })
+	}
+}
+
+// run sends top of book for the symbols that have changed
+func (f *feed) run() {
+	for {
+		select {
+		case <-f.done:
+			return
+		case <-f.wake:
+		}
+		f.mutex.Lock()
+		var dirty []string
+		for symbol := range f.dirty {
+			if f.watched(symbol) {
+				dirty = append(dirty, symbol)
+			}
+		}
+		f.dirty = make(map[string]bool)
+		f.mutex.Unlock()
+		for _, symbol := range dirty {
+			top, err := f.top(symbol)
+			if err != nil {
+				continue
+			}
+			f.mutex.Lock()
+			if f.tops[symbol] != top {
+				f.tops[symbol] = top
+				f.broadcast(FeedMessage{Type: "top", Symbol: symbol, Top: &top})
+			}
+			f.mutex.Unlock()
+		}
+	}
+}
+
+// watched returns true if any client is subscribed to
+// symbol. The feed's mutex must be held.
+func (f *feed) watched(symbol string) bool {
+	for c := range f.clients {
+		if c.symbols[symbol] {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *feed) top(symbol string) (TopOfBook, error) {
+	d, err := f.market.Depth(symbol, 1)
+	if err != nil {
+		return TopOfBook{}, err
+	}
+	top := TopOfBook{LastPrice: d.LastPrice}
+	if len(d.Bids) > 0 {
+		top.Bid, top.BidAmount = d.Bids[0].Price, d.Bids[0].Amount
+	}
+	if len(d.Offers) > 0 {
+		top.Offer, top.OfferAmount = d.Offers[0].Price, d.Offers[0].Amount
+	}
+	return top, nil
+}
+
+func (f *feed) join() *feedClient {
+	c := &feedClient{
+		symbols: make(map[string]bool),
+		out:     make(chan FeedMessage, f.buffer),
+		dropped: make(chan struct{}),
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.clients[c] = true
+	return c
+}
+
+func (f *feed) leave(c *feedClient) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.clients, c)
+}
+
+// subscribe adds symbols to what c receives. Their top of
+// book is sent again, so that c starts with it.
+func (f *feed) subscribe(c *feedClient, symbols []string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, symbol := range symbols {
+		c.symbols[symbol] = true
+		delete(f.tops, symbol)
+		f.dirty[symbol] = true
+	}
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (f *feed) unsubscribeSymbols(c *feedClient, symbols []string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, symbol := range symbols {
+		delete(c.symbols, symbol)
+	}
+}
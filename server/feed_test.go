@@ -0,0 +1,76 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/williammoran/economy"
+)
+
+// next returns the next message for c that isn't top of
+// book, or fails after a second
+func next(t *testing.T, c *feedClient, skipTops bool) FeedMessage {
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case msg := <-c.out:
+			if skipTops && msg.Type == "top" {
+				continue
+			}
+			return msg
+		case <-timeout:
+			t.Fatal("No message")
+		}
+	}
+}
+
+func TestFeedSendsTradesAndPrices(t *testing.T) {
+	accounts := testAccounts{1: 100}
+	m := economy.MakeMarket(time.Now, economy.MakeMemoryStorage(), accounts)
+	f := makeFeed(m, 16)
+	defer f.close()
+	c := f.join()
+	f.subscribe(c, []string{"S"})
+	if msg := next(t, c, false); msg.Type != "top" || msg.Top.Offer != 0 {
+		t.Fatalf("%+v", msg)
+	}
+	m.Offer(economy.Offer{Symbol: "other", Account: 2, Amount: 5, OfferType: economy.OrderTypeLimit, Price: 5})
+	m.Offer(economy.Offer{Symbol: "S", Account: 2, Amount: 5, OfferType: economy.OrderTypeLimit, Price: 5})
+	if msg := next(t, c, false); msg.Type != "top" || msg.Symbol != "S" || msg.Top.Offer != 5 || msg.Top.OfferAmount != 5 {
+		t.Fatalf("%+v %+v", msg, msg.Top)
+	}
+	m.Bid(economy.Bid{Symbol: "S", Account: 1, Amount: 2, BidType: economy.OrderTypeLimit, Price: 5})
+	if msg := next(t, c, true); msg.Type != "trade" || msg.Trade.Price != 5 || msg.Trade.Amount != 2 {
+		t.Fatalf("%+v", msg)
+	}
+	if msg := next(t, c, true); msg.Type != "price" || msg.LastPrice != 5 {
+		t.Fatalf("%+v", msg)
+	}
+}
+
+func TestFeedDropsSlowClient(t *testing.T) {
+	accounts := testAccounts{1: 1000}
+	m := economy.MakeMarket(time.Now, economy.MakeMemoryStorage(), accounts)
+	f := makeFeed(m, 2)
+	defer f.close()
+	slow := f.join()
+	f.subscribe(slow, []string{"S"})
+	m.Offer(economy.Offer{Symbol: "S", Account: 2, Amount: 100, OfferType: economy.OrderTypeLimit, Price: 5})
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			m.Bid(economy.Bid{Symbol: "S", Account: 1, Amount: 1, BidType: economy.OrderTypeLimit, Price: 5})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Slow client held up the market")
+	}
+	select {
+	case <-slow.dropped:
+	case <-time.After(time.Second):
+		t.Fatal("Slow client wasn't dropped")
+	}
+}
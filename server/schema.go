@@ -0,0 +1,121 @@
+package server
+
+import (
+	"time"
+
+	"github.com/williammoran/economy"
+)
+
+// OrderRequest is the body of POST /bids and POST
+// /offers. Type is "market", "limit", "stop market" or
+// "stop limit", and defaults to market. Price is required
+// for limit and stop limit orders and not allowed for the
+// others. StopPrice is required for stop orders only.
+type OrderRequest struct {
+	Account   int64  `json:"account"`
+	Symbol    string `json:"symbol"`
+	Amount    int64  `json:"amount"`
+	Type      string `json:"type,omitempty"`
+	Price     int64  `json:"price,omitempty"`
+	StopPrice int64  `json:"stop_price,omitempty"`
+}
+
+// ReportResponse is what happened to a newly placed order
+type ReportResponse struct {
+	OrderID      string                `json:"order_id"`
+	Status       string                `json:"status"`
+	Remaining    int64                 `json:"remaining"`
+	Filled       int64                 `json:"filled"`
+	Transactions []TransactionResponse `json:"transactions"`
+}
+
+// OrderResponse is the body of GET /bids/{id} and GET
+// /offers/{id}. Amount is what is left unfilled.
+type OrderResponse struct {
+	ID           string                `json:"id"`
+	Type         string                `json:"type"`
+	Account      int64                 `json:"account"`
+	Symbol       string                `json:"symbol"`
+	Price        int64                 `json:"price"`
+	StopPrice    int64                 `json:"stop_price,omitempty"`
+	Amount       int64                 `json:"amount"`
+	Status       string                `json:"status"`
+	Transactions []TransactionResponse `json:"transactions"`
+}
+
+// TransactionResponse is a single fill
+type TransactionResponse struct {
+	ID           string    `json:"id"`
+	BidID        string    `json:"bid_id"`
+	OfferID      string    `json:"offer_id"`
+	Symbol       string    `json:"symbol"`
+	BidAccount   int64     `json:"bid_account"`
+	OfferAccount int64     `json:"offer_account"`
+	Price        int64     `json:"price"`
+	Amount       int64     `json:"amount"`
+	Date         time.Time `json:"date"`
+	BidFee       int64     `json:"bid_fee"`
+	OfferFee     int64     `json:"offer_fee"`
+}
+
+// PriceResponse is the body of GET /symbols/{symbol}
+type PriceResponse struct {
+	Symbol    string `json:"symbol"`
+	LastPrice int64  `json:"last_price"`
+}
+
+// BalanceResponse is the body of GET /accounts/{id}
+type BalanceResponse struct {
+	Account int64 `json:"account"`
+	Balance int64 `json:"balance"`
+}
+
+// ErrorResponse is the body of every response that isn't
+// a success
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+var orderTypes = map[economy.OrderType]string{
+	economy.OrderTypeMarket:     "market",
+	economy.OrderTypeLimit:      "limit",
+	economy.OrderTypeStopMarket: "stop market",
+	economy.OrderTypeStopLimit:  "stop limit",
+}
+
+func typeName(t economy.OrderType) string {
+	if name, found := orderTypes[t]; found {
+		return name
+	}
+	return "unknown"
+}
+
+func makeTransactions(txs []economy.Transaction) []TransactionResponse {
+	rv := []TransactionResponse{}
+	for _, tx := range txs {
+		rv = append(rv, TransactionResponse{
+			ID:           tx.ID.String(),
+			BidID:        tx.BidID.String(),
+			OfferID:      tx.OfferID.String(),
+			Symbol:       tx.Symbol,
+			BidAccount:   tx.BidAccount,
+			OfferAccount: tx.OfferAccount,
+			Price:        tx.Price,
+			Amount:       tx.Amount,
+			Date:         tx.Date,
+			BidFee:       tx.BidFee,
+			OfferFee:     tx.OfferFee,
+		})
+	}
+	return rv
+}
+
+func makeReportResponse(r economy.ExecutionReport) ReportResponse {
+	return ReportResponse{
+		OrderID:      r.OrderID.String(),
+		Status:       r.Status.String(),
+		Remaining:    r.Remaining,
+		Filled:       r.Filled(),
+		Transactions: makeTransactions(r.Transactions),
+	}
+}
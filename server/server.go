@@ -0,0 +1,417 @@
+// Package server serves a Market over HTTP, so that web
+// clients and bots can trade. Requests and responses are
+// JSON, using the types in schema.go:
+//
+//	POST   /bids                 place a bid (OrderRequest)
+//	GET    /bids/{id}            bid status (OrderResponse)
+//	DELETE /bids/{id}?account=   cancel a bid
+//	POST   /offers               place an offer (OrderRequest)
+//	GET    /offers/{id}          offer status (OrderResponse)
+//	DELETE /offers/{id}?account= cancel an offer
+//	GET    /symbols              all known symbols
+//	GET    /symbols/{symbol}     last price (PriceResponse)
+//	GET    /accounts/{id}        balance (BalanceResponse)
+//	GET    /feed?symbol=         live feed as Server-Sent Events
+//	GET    /feed/ws?symbol=      live feed over a WebSocket
+//
+// Errors are returned as an ErrorResponse with a status
+// code that depends on the error.
+//
+// The live feed sends a FeedMessage for each trade, last
+// price change and change to the top of the book in the
+// subscribed symbols. A client that falls too far behind
+// is disconnected, so that it can't hold up the Market.
+//
+// The Server does no authentication. It trusts the account
+// in each request, so anyone who can reach it can trade for
+// and see the balance of any account. It must be run behind
+// a proxy that authenticates clients and only passes on
+// requests for their own accounts.
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/williammoran/economy"
+)
+
+// Balances reports account balances. The Market's Accounts
+// usually implement it too.
+type Balances interface {
+	// Balance returns the funds in the account, or false if
+	// there is no such account
+	Balance(accountID int64) (int64, bool)
+}
+
+// Server is an http.Handler for a Market
+type Server struct {
+	market   *economy.Market
+	balances Balances
+	mux      *http.ServeMux
+	feed     *feed
+}
+
+// errInvalidRequest wraps everything wrong with a request
+// that the Market never sees
+var errInvalidRequest = errors.New("invalid request")
+
+// errNotFound is returned for paths that don't exist
+var errNotFound = errors.New("not found")
+
+// errTooLarge is returned for request bodies over
+// maxBodySize
+var errTooLarge = errors.New("request body too large")
+
+// maxBodySize is the largest request body the Server reads
+const maxBodySize = 4096
+
+// MakeServer returns a Server for m that looks up account
+// balances in b. Close must be called when the Server is
+// no longer needed, to stop its live feed.
+func MakeServer(m *economy.Market, b Balances) *Server {
+	s := &Server{
+		market:   m,
+		balances: b,
+		mux:      http.NewServeMux(),
+		feed:     makeFeed(m, feedBuffer),
+	}
+	s.mux.HandleFunc("/bids", s.handleBids)
+	s.mux.HandleFunc("/bids/", s.handleBid)
+	s.mux.HandleFunc("/offers", s.handleOffers)
+	s.mux.HandleFunc("/offers/", s.handleOffer)
+	s.mux.HandleFunc("/symbols", s.handleSymbols)
+	s.mux.HandleFunc("/symbols/", s.handleSymbol)
+	s.mux.HandleFunc("/accounts/", s.handleAccount)
+	s.mux.HandleFunc("/feed", s.handleSSE)
+	s.mux.HandleFunc("/feed/ws", s.handleWebSocket)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Close stops the live feed and ends its streams
+func (s *Server) Close() {
+	s.feed.close()
+}
+
+func (s *Server) handleBids(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodPost) {
+		return
+	}
+	req, err := decodeOrder(w, r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	t, err := req.orderType()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	report, err := s.market.Bid(economy.Bid{
+		BidType:   t,
+		Account:   req.Account,
+		Symbol:    req.Symbol,
+		Price:     req.Price,
+		StopPrice: req.StopPrice,
+		Amount:    req.Amount,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, makeReportResponse(report))
+}
+
+func (s *Server) handleOffers(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodPost) {
+		return
+	}
+	req, err := decodeOrder(w, r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	t, err := req.orderType()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	report, err := s.market.Offer(economy.Offer{
+		OfferType: t,
+		Account:   req.Account,
+		Symbol:    req.Symbol,
+		Price:     req.Price,
+		StopPrice: req.StopPrice,
+		Amount:    req.Amount,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, makeReportResponse(report))
+}
+
+func (s *Server) handleBid(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet, http.MethodDelete) {
+		return
+	}
+	id, err := pathID(r, "/bids/")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if r.Method == http.MethodDelete {
+		s.cancel(w, r, func(account int64) error { return s.market.CancelBid(account, id) })
+		return
+	}
+	b, err := s.market.FindBid(id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	txs, err := s.market.Transactions(economy.TransactionFilter{OrderID: id})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, OrderResponse{
+		ID:           b.ID.String(),
+		Type:         typeName(b.BidType),
+		Account:      b.Account,
+		Symbol:       b.Symbol,
+		Price:        b.Price,
+		StopPrice:    b.StopPrice,
+		Amount:       b.Amount,
+		Status:       economy.StatusOf(b.Amount, b.NSF, b.Cancelled, len(txs) > 0).String(),
+		Transactions: makeTransactions(txs),
+	})
+}
+
+func (s *Server) handleOffer(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet, http.MethodDelete) {
+		return
+	}
+	id, err := pathID(r, "/offers/")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if r.Method == http.MethodDelete {
+		s.cancel(w, r, func(account int64) error { return s.market.CancelOffer(account, id) })
+		return
+	}
+	o, err := s.market.FindOffer(id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	txs, err := s.market.Transactions(economy.TransactionFilter{OrderID: id})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, OrderResponse{
+		ID:           o.ID.String(),
+		Type:         typeName(o.OfferType),
+		Account:      o.Account,
+		Symbol:       o.Symbol,
+		Price:        o.Price,
+		StopPrice:    o.StopPrice,
+		Amount:       o.Amount,
+		Status:       economy.StatusOf(o.Amount, false, o.Cancelled, len(txs) > 0).String(),
+		Transactions: makeTransactions(txs),
+	})
+}
+
+// cancel runs f for the account in the query string
+func (s *Server) cancel(w http.ResponseWriter, r *http.Request, f func(account int64) error) {
+	account, err := parseInt64(r.URL.Query().Get("account"), "account")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err = f(account); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleSymbols(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	symbols, err := s.market.Symbols()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if symbols == nil {
+		symbols = []string{}
+	}
+	writeJSON(w, http.StatusOK, symbols)
+}
+
+func (s *Server) handleSymbol(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	symbol := strings.TrimPrefix(r.URL.Path, "/symbols/")
+	if symbol == "" || strings.Contains(symbol, "/") {
+		writeError(w, errNotFound)
+		return
+	}
+	price, err := s.market.Price(symbol)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, PriceResponse{Symbol: symbol, LastPrice: price})
+}
+
+func (s *Server) handleAccount(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	id, err := parseInt64(strings.TrimPrefix(r.URL.Path, "/accounts/"), "account")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	balance, found := s.balances.Balance(id)
+	if !found {
+		writeError(w, errNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, BalanceResponse{Account: id, Balance: balance})
+}
+
+// decodeOrder reads an OrderRequest and checks it the way
+// the CLI checks its bid and offer commands
+func decodeOrder(w http.ResponseWriter, r *http.Request) (OrderRequest, error) {
+	var req OrderRequest
+	d := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return req, errTooLarge
+		}
+		return req, fmt.Errorf("%w: %s", errInvalidRequest, err)
+	}
+	if req.Symbol == "" {
+		return req, fmt.Errorf("%w: symbol is required", errInvalidRequest)
+	}
+	if req.Amount < 1 {
+		return req, fmt.Errorf("%w: amount must be greater than zero", errInvalidRequest)
+	}
+	return req, nil
+}
+
+// orderType returns the OrderType the request asks for
+func (req OrderRequest) orderType() (economy.OrderType, error) {
+	var t economy.OrderType
+	switch req.Type {
+	case "", "market":
+		t = economy.OrderTypeMarket
+	case "limit":
+		t = economy.OrderTypeLimit
+	case "stop market":
+		t = economy.OrderTypeStopMarket
+	case "stop limit":
+		t = economy.OrderTypeStopLimit
+	default:
+		return 0, fmt.Errorf(
+			"%w: type must be market, limit, stop market or stop limit, not %q",
+			errInvalidRequest, req.Type,
+		)
+	}
+	name := typeName(t)
+	priced := t == economy.OrderTypeLimit || t == economy.OrderTypeStopLimit
+	stop := t == economy.OrderTypeStopMarket || t == economy.OrderTypeStopLimit
+	switch {
+	case priced && req.Price < 1:
+		return 0, fmt.Errorf("%w: %s orders need a price greater than zero", errInvalidRequest, name)
+	case !priced && req.Price != 0:
+		return 0, fmt.Errorf("%w: %s orders have no price", errInvalidRequest, name)
+	case stop && req.StopPrice < 1:
+		return 0, fmt.Errorf("%w: %s orders need a stop price greater than zero", errInvalidRequest, name)
+	case !stop && req.StopPrice != 0:
+		return 0, fmt.Errorf("%w: %s orders have no stop price", errInvalidRequest, name)
+	}
+	return t, nil
+}
+
+func pathID(r *http.Request, prefix string) (uuid.UUID, error) {
+	id, err := uuid.Parse(strings.TrimPrefix(r.URL.Path, prefix))
+	if err != nil {
+		return id, fmt.Errorf("%w: %s", errInvalidRequest, err)
+	}
+	return id, nil
+}
+
+func parseInt64(in, name string) (int64, error) {
+	n, err := strconv.ParseInt(in, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s must be an int64", errInvalidRequest, name)
+	}
+	return n, nil
+}
+
+// allow writes 405 and returns false if the request's
+// method isn't one of methods
+func allow(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "method not allowed"})
+	return false
+}
+
+// statusCode is the HTTP status for err
+func statusCode(err error) int {
+	switch {
+	case errors.Is(err, errInvalidRequest),
+		errors.Is(err, economy.ErrInvalidAmount),
+		errors.Is(err, economy.ErrUnknownOrderType):
+		return http.StatusBadRequest
+	case errors.Is(err, errNotFound),
+		errors.Is(err, economy.ErrOrderNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, economy.ErrNotOwner):
+		return http.StatusForbidden
+	case errors.Is(err, errTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, economy.ErrOrderInactive),
+		errors.Is(err, economy.ErrSymbolHalted),
+		errors.Is(err, economy.ErrAuctionInProgress):
+		return http.StatusConflict
+	case errors.Is(err, economy.ErrInsufficientFunds),
+		errors.Is(err, economy.ErrInsufficientHoldings),
+		errors.Is(err, economy.ErrPriceOutsideBand):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	writeJSON(w, statusCode(err), ErrorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/williammoran/economy"
+)
+
+type testAccounts map[int64]int64
+
+func (a testAccounts) Credit(accountID, funds int64) {
+	a[accountID] += funds
+}
+
+func (a testAccounts) DebitIfPossible(accountID, funds int64) bool {
+	if a[accountID] < funds {
+		return false
+	}
+	a[accountID] -= funds
+	return true
+}
+
+func (a testAccounts) Balance(accountID int64) (int64, bool) {
+	balance, found := a[accountID]
+	return balance, found
+}
+
+func makeTestServer(t *testing.T) (*httptest.Server, testAccounts) {
+	accounts := testAccounts{1: 100, 2: 0}
+	m := economy.MakeMarket(time.Now, economy.MakeMemoryStorage(), accounts)
+	s := MakeServer(m, accounts)
+	ts := httptest.NewServer(s)
+	t.Cleanup(func() {
+		s.Close()
+		ts.Close()
+	})
+	return ts, accounts
+}
+
+// do sends a request and decodes the response into v,
+// returning the status code
+func do(t *testing.T, method, url, body string, v interface{}) int {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if v != nil && resp.StatusCode != http.StatusNoContent {
+		if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestPlaceAndQueryOrders(t *testing.T) {
+	ts, accounts := makeTestServer(t)
+	var offer ReportResponse
+	code := do(t, http.MethodPost, ts.URL+"/offers", `{"account":2,"symbol":"S","amount":10,"type":"limit","price":5}`, &offer)
+	if code != http.StatusCreated || offer.Status != "resting" {
+		t.Fatalf("%d %+v", code, offer)
+	}
+	var bid ReportResponse
+	code = do(t, http.MethodPost, ts.URL+"/bids", `{"account":1,"symbol":"S","amount":4,"type":"limit","price":5}`, &bid)
+	if code != http.StatusCreated || bid.Status != "filled" || bid.Filled != 4 || len(bid.Transactions) != 1 {
+		t.Fatalf("%d %+v", code, bid)
+	}
+	var o OrderResponse
+	code = do(t, http.MethodGet, ts.URL+"/offers/"+offer.OrderID, "", &o)
+	if code != http.StatusOK || o.Status != "partially filled" || o.Amount != 6 || o.Type != "limit" {
+		t.Fatalf("%d %+v", code, o)
+	}
+	var b OrderResponse
+	code = do(t, http.MethodGet, ts.URL+"/bids/"+bid.OrderID, "", &b)
+	if code != http.StatusOK || b.Status != "filled" || len(b.Transactions) != 1 {
+		t.Fatalf("%d %+v", code, b)
+	}
+	var price PriceResponse
+	if code = do(t, http.MethodGet, ts.URL+"/symbols/S", "", &price); code != http.StatusOK || price.LastPrice != 5 {
+		t.Fatalf("%d %+v", code, price)
+	}
+	var symbols []string
+	if code = do(t, http.MethodGet, ts.URL+"/symbols", "", &symbols); code != http.StatusOK || len(symbols) != 1 || symbols[0] != "S" {
+		t.Fatalf("%d %+v", code, symbols)
+	}
+	var balance BalanceResponse
+	if code = do(t, http.MethodGet, ts.URL+"/accounts/2", "", &balance); code != http.StatusOK || balance.Balance != 20 {
+		t.Fatalf("%d %+v %+v", code, balance, accounts)
+	}
+}
+
+func TestCancelOrder(t *testing.T) {
+	ts, _ := makeTestServer(t)
+	var bid ReportResponse
+	do(t, http.MethodPost, ts.URL+"/bids", `{"account":1,"symbol":"S","amount":4,"type":"limit","price":5}`, &bid)
+	var e ErrorResponse
+	if code := do(t, http.MethodDelete, ts.URL+"/bids/"+bid.OrderID+"?account=2", "", &e); code != http.StatusForbidden {
+		t.Fatalf("%d %+v", code, e)
+	}
+	if code := do(t, http.MethodDelete, ts.URL+"/bids/"+bid.OrderID+"?account=1", "", nil); code != http.StatusNoContent {
+		t.Fatalf("%d", code)
+	}
+	if code := do(t, http.MethodDelete, ts.URL+"/bids/"+bid.OrderID+"?account=1", "", &e); code != http.StatusConflict {
+		t.Fatalf("%d %+v", code, e)
+	}
+	var b OrderResponse
+	if do(t, http.MethodGet, ts.URL+"/bids/"+bid.OrderID, "", &b); b.Status != "cancelled" {
+		t.Fatalf("%+v", b)
+	}
+}
+
+func TestPlaceStopOrder(t *testing.T) {
+	ts, _ := makeTestServer(t)
+	var bid ReportResponse
+	code := do(t, http.MethodPost, ts.URL+"/bids", `{"account":1,"symbol":"S","amount":4,"type":"stop limit","price":60,"stop_price":50}`, &bid)
+	if code != http.StatusCreated || bid.Status != "resting" {
+		t.Fatalf("%d %+v", code, bid)
+	}
+	var b OrderResponse
+	code = do(t, http.MethodGet, ts.URL+"/bids/"+bid.OrderID, "", &b)
+	if code != http.StatusOK || b.Type != "stop limit" || b.Price != 60 || b.StopPrice != 50 || b.Status != "resting" {
+		t.Fatalf("%d %+v", code, b)
+	}
+}
+
+func TestOrderValidation(t *testing.T) {
+	ts, _ := makeTestServer(t)
+	bodies := []string{
+		`{"account":1,"symbol":"S","amount":4,"type":"limit"}`,
+		`{"account":1,"symbol":"S","amount":4,"price":5}`,
+		`{"account":1,"symbol":"S","amount":0}`,
+		`{"account":1,"amount":4}`,
+		`{"account":1,"symbol":"S","amount":4,"type":"stop"}`,
+		`{"account":1,"symbol":"S","amount":4,"type":"stop market"}`,
+		`{"account":1,"symbol":"S","amount":4,"type":"stop market","price":5,"stop_price":5}`,
+		`{"account":1,"symbol":"S","amount":4,"type":"stop limit","stop_price":5}`,
+		`{"account":1,"symbol":"S","amount":4,"stop_price":5}`,
+		`{"account":"one","symbol":"S","amount":4}`,
+		`{"account":1,"symbol":"S","amount":4.5}`,
+		`{"account":1,"symbol":"S","amount":4,"colour":"red"}`,
+		`not json`,
+	}
+	for _, body := range bodies {
+		var e ErrorResponse
+		if code := do(t, http.MethodPost, ts.URL+"/offers", body, &e); code != http.StatusBadRequest || e.Error == "" {
+			t.Fatalf("%s: %d %+v", body, code, e)
+		}
+	}
+}
+
+func TestOrderTooLarge(t *testing.T) {
+	ts, _ := makeTestServer(t)
+	body := `{"account":1,"symbol":"` + strings.Repeat("S", maxBodySize) + `","amount":4}`
+	var e ErrorResponse
+	if code := do(t, http.MethodPost, ts.URL+"/bids", body, &e); code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("%d %+v", code, e)
+	}
+}
+
+func TestErrorStatuses(t *testing.T) {
+	ts, _ := makeTestServer(t)
+	tests := []struct {
+		method, path string
+		code         int
+	}{
+		{http.MethodGet, "/bids/not-a-uuid", http.StatusBadRequest},
+		{http.MethodGet, "/bids/6b1f0f4c-55a8-4d6c-8f7e-2b6a5f3c9d20", http.StatusNotFound},
+		{http.MethodGet, "/accounts/99", http.StatusNotFound},
+		{http.MethodGet, "/accounts/x", http.StatusBadRequest},
+		{http.MethodPut, "/bids", http.StatusMethodNotAllowed},
+		{http.MethodDelete, "/offers/6b1f0f4c-55a8-4d6c-8f7e-2b6a5f3c9d20", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		var e ErrorResponse
+		if code := do(t, test.method, ts.URL+test.path, "", &e); code != test.code {
+			t.Fatalf("%s %s: %d %+v", test.method, test.path, code, e)
+		}
+	}
+}
+
+func TestResponsesAreJSON(t *testing.T) {
+	accounts := testAccounts{}
+	s := MakeServer(economy.MakeMarket(time.Now, economy.MakeMemoryStorage(), accounts), accounts)
+	defer s.Close()
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/symbols", nil))
+	if w.Header().Get("Content-Type") != "application/json" {
+		t.Fatal(w.Header())
+	}
+	if got := bytes.TrimSpace(w.Body.Bytes()); string(got) != "[]" {
+		t.Fatalf("%s", got)
+	}
+}
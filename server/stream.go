@@ -0,0 +1,135 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// writeTimeout limits how long a stalled client can hold
+// its own connection's goroutine. It never holds up the
+// Market, which only queues messages.
+const writeTimeout = 10 * time.Second
+
+// handleSSE streams the feed for the symbols in the query
+// string as Server-Sent Events, with the message type as
+// the event name
+func (s *Server) handleSSE(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	symbols := r.URL.Query()["symbol"]
+	if len(symbols) == 0 {
+		writeError(w, fmt.Errorf("%w: symbol is required", errInvalidRequest))
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, fmt.Errorf("streaming not supported"))
+		return
+	}
+	c := s.feed.join()
+	defer s.feed.leave(c)
+	s.feed.subscribe(c, symbols)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	rc := http.NewResponseController(w)
+	for {
+		select {
+		case msg := <-c.out:
+			data, err := json.Marshal(msg)
+			if err != nil {
+				return
+			}
+			rc.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", msg.Type, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-c.dropped:
+			data, _ := json.Marshal(ErrorResponse{Error: errSlowConsumer.Error()})
+			fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+			flusher.Flush()
+			return
+		case <-r.Context().Done():
+			return
+		case <-s.feed.done:
+			return
+		}
+	}
+}
+
+// feedRequest is a message from a WebSocket client
+// changing its subscriptions
+type feedRequest struct {
+	Subscribe   []string `json:"subscribe"`
+	Unsubscribe []string `json:"unsubscribe"`
+}
+
+// handleWebSocket streams the feed over a WebSocket as
+// text messages holding a FeedMessage each. Clients start
+// with the symbols in the query string, and can send
+// {"subscribe": [...]} and {"unsubscribe": [...]} to
+// change them.
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	ws, err := upgrade(w, r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer ws.close()
+	c := s.feed.join()
+	defer s.feed.leave(c)
+	s.feed.subscribe(c, r.URL.Query()["symbol"])
+	requests := make(chan feedRequest)
+	quit := make(chan struct{})
+	defer close(quit)
+	go func() {
+		defer close(requests)
+		for {
+			data, err := ws.readMessage()
+			if err != nil {
+				return
+			}
+			var req feedRequest
+			if json.Unmarshal(data, &req) != nil {
+				continue
+			}
+			select {
+			case requests <- req:
+			case <-quit:
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case msg := <-c.out:
+			data, err := json.Marshal(msg)
+			if err != nil {
+				return
+			}
+			if err = ws.writeText(data); err != nil {
+				return
+			}
+		case req, ok := <-requests:
+			if !ok {
+				return
+			}
+			s.feed.subscribe(c, req.Subscribe)
+			s.feed.unsubscribeSymbols(c, req.Unsubscribe)
+		case <-c.dropped:
+			ws.writeClose(closePolicyViolation, errSlowConsumer.Error())
+			return
+		case <-s.feed.done:
+			ws.writeClose(closeGoingAway, "")
+			return
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/williammoran/economy"
+)
+
+func makeStreamServer(t *testing.T) (*httptest.Server, *economy.Market) {
+	accounts := testAccounts{1: 100}
+	m := economy.MakeMarket(time.Now, economy.MakeMemoryStorage(), accounts)
+	s := MakeServer(m, accounts)
+	ts := httptest.NewServer(s)
+	t.Cleanup(func() {
+		s.Close()
+		ts.Close()
+	})
+	return ts, m
+}
+
+// nextEvent reads Server-Sent Events until one that isn't
+// top of book
+func nextEvent(t *testing.T, r *bufio.Reader) (string, FeedMessage) {
+	var event string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: ") && event != "top":
+			var msg FeedMessage
+			if err = json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &msg); err != nil {
+				t.Fatal(err)
+			}
+			return event, msg
+		}
+	}
+}
+
+func TestServerSentEvents(t *testing.T) {
+	ts, m := makeStreamServer(t)
+	resp, err := http.Get(ts.URL + "/feed?symbol=S")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatal(resp.Header)
+	}
+	m.Offer(economy.Offer{Symbol: "S", Account: 2, Amount: 5, OfferType: economy.OrderTypeLimit, Price: 5})
+	m.Bid(economy.Bid{Symbol: "S", Account: 1, Amount: 2, BidType: economy.OrderTypeLimit, Price: 5})
+	r := bufio.NewReader(resp.Body)
+	if event, msg := nextEvent(t, r); event != "trade" || msg.Trade.Amount != 2 {
+		t.Fatalf("%s %+v", event, msg)
+	}
+	if event, msg := nextEvent(t, r); event != "price" || msg.LastPrice != 5 {
+		t.Fatalf("%s %+v", event, msg)
+	}
+}
+
+func TestServerSentEventsNeedSymbol(t *testing.T) {
+	ts, _ := makeStreamServer(t)
+	var e ErrorResponse
+	if code := do(t, http.MethodGet, ts.URL+"/feed", "", &e); code != http.StatusBadRequest {
+		t.Fatalf("%d %+v", code, e)
+	}
+}
+
+// dialWebSocket does the client side of the handshake
+func dialWebSocket(t *testing.T, ts *httptest.Server, path string) (net.Conn, *bufio.Reader) {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(ts.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "GET "+path+" HTTP/1.1\r\n"+
+		"Host: test\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"+
+		"Sec-WebSocket-Version: 13\r\n\r\n")
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("%+v", resp)
+	}
+	return conn, r
+}
+
+// writeClientFrame sends a masked frame, as clients must
+func writeClientFrame(conn net.Conn, op byte, payload []byte) {
+	frame := []byte{0x80 | op, 0x80 | byte(len(payload))}
+	mask := make([]byte, 4)
+	rand.Read(mask)
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	conn.Write(frame)
+}
+
+// readServerFrame reads an unmasked frame from the server
+func readServerFrame(t *testing.T, r *bufio.Reader) (byte, []byte) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		t.Fatal(err)
+	}
+	n := uint64(head[1] & 0x7f)
+	if n == 126 {
+		var ext [2]byte
+		io.ReadFull(r, ext[:])
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatal(err)
+	}
+	return head[0] & 0x0f, payload
+}
+
+func nextWebSocketMessage(t *testing.T, r *bufio.Reader) FeedMessage {
+	for {
+		op, payload := readServerFrame(t, r)
+		if op != opText {
+			t.Fatalf("Opcode %d %q", op, payload)
+		}
+		var msg FeedMessage
+		if err := json.Unmarshal(payload, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Type != "top" {
+			return msg
+		}
+	}
+}
+
+func TestWebSocketFeed(t *testing.T) {
+	ts, m := makeStreamServer(t)
+	conn, r := dialWebSocket(t, ts, "/feed/ws")
+	writeClientFrame(conn, opPing, []byte("hi"))
+	if op, payload := readServerFrame(t, r); op != opPong || string(payload) != "hi" {
+		t.Fatalf("%d %q", op, payload)
+	}
+	writeClientFrame(conn, opText, []byte(`{"subscribe":["S"]}`))
+	op, payload := readServerFrame(t, r)
+	var msg FeedMessage
+	json.Unmarshal(payload, &msg)
+	if op != opText || msg.Type != "top" || msg.Symbol != "S" {
+		t.Fatalf("%d %q", op, payload)
+	}
+	m.Offer(economy.Offer{Symbol: "S", Account: 2, Amount: 5, OfferType: economy.OrderTypeLimit, Price: 5})
+	m.Bid(economy.Bid{Symbol: "S", Account: 1, Amount: 2, BidType: economy.OrderTypeLimit, Price: 5})
+	if msg = nextWebSocketMessage(t, r); msg.Type != "trade" || msg.Trade.Amount != 2 {
+		t.Fatalf("%+v", msg)
+	}
+	writeClientFrame(conn, opClose, []byte{0x03, 0xe8})
+	for op != opClose {
+		op, _ = readServerFrame(t, r)
+	}
+}
+
+func TestWebSocketNeedsHandshake(t *testing.T) {
+	ts, _ := makeStreamServer(t)
+	var e ErrorResponse
+	if code := do(t, http.MethodGet, ts.URL+"/feed/ws", "", &e); code != http.StatusBadRequest {
+		t.Fatalf("%d %+v", code, e)
+	}
+}
@@ -0,0 +1,90 @@
+package sim
+
+import "sync"
+
+// Accounts is the cash of every agent in a simulation. It
+// implements economy.EscrowAccounts and
+// economy.FundsAccounts.
+type Accounts struct {
+	mutex     sync.Mutex
+	available map[int64]int64
+	held      map[int64]int64
+}
+
+// MakeAccounts returns empty Accounts
+func MakeAccounts() *Accounts {
+	return &Accounts{
+		available: make(map[int64]int64),
+		held:      make(map[int64]int64),
+	}
+}
+
+// Credit adds funds to the account
+func (a *Accounts) Credit(accountID, funds int64) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.available[accountID] += funds
+}
+
+// DebitIfPossible takes funds that aren't held from the
+// account, or returns false
+func (a *Accounts) DebitIfPossible(accountID, funds int64) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.available[accountID] < funds {
+		return false
+	}
+	a.available[accountID] -= funds
+	return true
+}
+
+// CanDebit returns true if DebitIfPossible would take
+// funds
+func (a *Accounts) CanDebit(accountID, funds int64) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.available[accountID] >= funds
+}
+
+// Hold sets funds aside for a bid, or returns false
+func (a *Accounts) Hold(accountID, funds int64) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.available[accountID] < funds {
+		return false
+	}
+	a.available[accountID] -= funds
+	a.held[accountID] += funds
+	return true
+}
+
+// Release returns held funds to the account
+func (a *Accounts) Release(accountID, funds int64) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.held[accountID] -= funds
+	a.available[accountID] += funds
+}
+
+// DebitHeld takes funds that were held
+func (a *Accounts) DebitHeld(accountID, funds int64) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.held[accountID] -= funds
+}
+
+// Balance returns the funds in the account that aren't
+// held, and whether it exists
+func (a *Accounts) Balance(accountID int64) (int64, bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	balance, found := a.available[accountID]
+	return balance, found
+}
+
+// Held returns the funds held for the account's bids
+func (a *Accounts) Held(accountID int64) int64 {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.held[accountID]
+}
@@ -0,0 +1,19 @@
+package sim
+
+import "testing"
+
+func TestAccountsEscrow(t *testing.T) {
+	a := MakeAccounts()
+	a.Credit(1, 100)
+	if !a.Hold(1, 60) || a.Hold(1, 60) {
+		t.Fatal("Held more than the balance")
+	}
+	a.DebitHeld(1, 50)
+	a.Release(1, 10)
+	if balance, _ := a.Balance(1); balance != 50 || a.Held(1) != 0 {
+		t.Fatalf("Balance %d held %d", balance, a.Held(1))
+	}
+	if a.DebitIfPossible(1, 51) || !a.DebitIfPossible(1, 50) {
+		t.Fatal("Wrong debit")
+	}
+}
@@ -0,0 +1,107 @@
+// Package sim populates a Market with automated agents, for
+// balancing a game economy before players get to it. A
+// Runner gives each Agent an account with cash and goods,
+// shows it the market every tick of a SimClock and places
+// the orders it asks for, then reports how each agent did.
+//
+// Strategies for common kinds of participant are included:
+// noise traders, market makers, momentum and mean
+// reversion traders, and NPC producers and consumers that
+// add goods to the economy and take them out of it.
+package sim
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/williammoran/economy"
+)
+
+// Agent is an automated market participant
+type Agent interface {
+	// Tick is called once per tick with what the agent can
+	// see of the market, and returns what it wants to do
+	Tick(State) Actions
+}
+
+// State is what an agent sees on its tick
+type State struct {
+	Time    time.Time
+	Account int64
+	// Cash is the agent's funds that aren't held for bids
+	Cash int64
+	// Goods is the units of each symbol the agent owns,
+	// including those reserved by its offers
+	Goods map[string]int64
+	// Open is the agent's orders that are still active
+	Open []OpenOrder
+	// Markets is the depth of each symbol being simulated
+	Markets map[string]economy.Depth
+	// Rand is the simulation's random numbers. Agents that
+	// use it instead of their own are replayed exactly for
+	// the same seed.
+	Rand *rand.Rand
+}
+
+// OpenOrder is an active order of an agent
+type OpenOrder struct {
+	ID     uuid.UUID
+	Side   economy.Side
+	Symbol string
+	// Price is zero for market orders
+	Price     int64
+	Remaining int64
+	Placed    time.Time
+}
+
+// Order is a bid or offer an agent wants to place
+type Order struct {
+	Side   economy.Side
+	Symbol string
+	// Type is OrderTypeLimit or OrderTypeMarket, and Price
+	// is only used by limit orders
+	Type        economy.OrderType
+	Price       int64
+	Amount      int64
+	TimeInForce economy.TimeInForce
+	Expires     time.Time
+}
+
+// Actions is what an agent does on a tick. Cancellations
+// happen first, then production and consumption, then the
+// orders are placed in order.
+type Actions struct {
+	// Cancel lists open orders to withdraw
+	Cancel []uuid.UUID
+	// Produce adds units of each symbol to the agent's
+	// goods, and Consume removes them, up to what it has
+	// available
+	Produce map[string]int64
+	Consume map[string]int64
+	Orders  []Order
+}
+
+// cancelAll returns the IDs of the open orders in symbol
+func cancelAll(s State, symbol string) []uuid.UUID {
+	var ids []uuid.UUID
+	for _, o := range s.Open {
+		if o.Symbol == symbol {
+			ids = append(ids, o.ID)
+		}
+	}
+	return ids
+}
+
+// best returns the best bid and offer prices in d, or zero
+// for a side without orders
+func best(d economy.Depth) (int64, int64) {
+	var bid, offer int64
+	if len(d.Bids) > 0 {
+		bid = d.Bids[0].Price
+	}
+	if len(d.Offers) > 0 {
+		offer = d.Offers[0].Price
+	}
+	return bid, offer
+}
@@ -0,0 +1,396 @@
+package sim
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/williammoran/economy"
+)
+
+// visibleLevels is how many price levels of each side of
+// the book agents are shown
+const visibleLevels = 10
+
+// escrowSlippage is the percentage above the last price
+// held for market bids
+const escrowSlippage = 20
+
+// Runner runs agents against a Market of its own, on a
+// SimClock. The Market holds funds for bids and reserves
+// goods for offers, so agents can't spend what they don't
+// have.
+type Runner struct {
+	clock    *economy.SimClock
+	market   *economy.Market
+	accounts *Accounts
+	holdings *economy.MemoryHoldings
+	symbols  []string
+	rand     *rand.Rand
+	// mutex guards what the market's events update
+	mutex     sync.Mutex
+	agents    []*participant
+	byAccount map[int64]*participant
+	// seq numbers orders as they are accepted, so they are
+	// listed in the order they were placed
+	seq uint64
+}
+
+// participant is an agent and what it has done
+type participant struct {
+	name      string
+	agent     Agent
+	account   int64
+	startCash int64
+	start     map[string]int64
+	produced  map[string]int64
+	consumed  map[string]int64
+	open      map[uuid.UUID]*openEntry
+	trades    int
+	volume    int64
+	rejected  int
+}
+
+type openEntry struct {
+	OpenOrder
+	seq uint64
+}
+
+// MakeRunner returns a Runner for a market in symbols,
+// with its clock at start. The seed makes the simulation
+// repeatable. opts are passed to MakeMarket.
+func MakeRunner(start time.Time, seed int64, symbols []string, opts ...economy.Option) *Runner {
+	r := &Runner{
+		clock:     economy.MakeSimClock(start),
+		accounts:  MakeAccounts(),
+		holdings:  economy.MakeMemoryHoldings(),
+		symbols:   symbols,
+		rand:      rand.New(rand.NewSource(seed)),
+		byAccount: make(map[int64]*participant),
+	}
+	opts = append([]economy.Option{
+		economy.WithHoldings(r.holdings),
+		economy.WithEscrow(escrowSlippage),
+	}, opts...)
+	r.market = economy.MakeMarket(r.clock.Now, economy.MakeMemoryStorage(), r.accounts, opts...)
+	r.market.Subscribe(r.onEvent)
+	return r
+}
+
+// Market returns the Market the agents trade in
+func (r *Runner) Market() *economy.Market {
+	return r.market
+}
+
+// Clock returns the simulation's clock
+func (r *Runner) Clock() *economy.SimClock {
+	return r.clock
+}
+
+// Accounts returns the agents' cash
+func (r *Runner) Accounts() *Accounts {
+	return r.accounts
+}
+
+// Holdings returns the agents' goods
+func (r *Runner) Holdings() *economy.MemoryHoldings {
+	return r.holdings
+}
+
+// Add gives agent a new account with cash and goods, and
+// returns the account ID
+func (r *Runner) Add(name string, agent Agent, cash int64, goods map[string]int64) int64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	p := &participant{
+		name:      name,
+		agent:     agent,
+		account:   int64(len(r.agents) + 1),
+		startCash: cash,
+		start:     make(map[string]int64),
+		produced:  make(map[string]int64),
+		consumed:  make(map[string]int64),
+		open:      make(map[uuid.UUID]*openEntry),
+	}
+	r.accounts.Credit(p.account, cash)
+	for symbol, amount := range goods {
+		r.holdings.Deposit(p.account, symbol, amount)
+		p.start[symbol] = amount
+	}
+	r.agents = append(r.agents, p)
+	r.byAccount[p.account] = p
+	return p.account
+}
+
+// Run runs ticks ticks, interval apart on the clock. Each
+// tick the Market's auctions and expiring orders are
+// updated, then every agent acts, in a random order that
+// the seed decides. It returns the first error from the
+// Market that isn't an order being rejected. Nothing runs
+// unless interval is greater than zero.
+func (r *Runner) Run(ticks int, interval time.Duration) error {
+	stopDrive := r.clock.Drive(r.market, interval)
+	defer stopDrive()
+	stopTicks := r.clock.Every(interval, r.tick)
+	defer stopTicks()
+	return r.clock.Advance(time.Duration(ticks) * interval)
+}
+
+func (r *Runner) tick(now time.Time) error {
+	for _, i := range r.rand.Perm(len(r.agents)) {
+		p := r.agents[i]
+		s, err := r.state(p, now)
+		if err != nil {
+			return err
+		}
+		if err = r.apply(p, p.agent.Tick(s)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Runner) state(p *participant, now time.Time) (State, error) {
+	cash, _ := r.accounts.Balance(p.account)
+	s := State{
+		Time:    now,
+		Account: p.account,
+		Cash:    cash,
+		Goods:   make(map[string]int64),
+		Markets: make(map[string]economy.Depth),
+		Rand:    r.rand,
+	}
+	for _, symbol := range r.symbols {
+		s.Goods[symbol] = r.holdings.Available(p.account, symbol) + r.holdings.Reserved(p.account, symbol)
+		d, err := r.market.Depth(symbol, visibleLevels)
+		if err != nil {
+			return s, err
+		}
+		s.Markets[symbol] = d
+	}
+	r.mutex.Lock()
+	open := make([]openEntry, 0, len(p.open))
+	for _, o := range p.open {
+		open = append(open, *o)
+	}
+	r.mutex.Unlock()
+	sort.Slice(open, func(i, j int) bool { return open[i].seq < open[j].seq })
+	for _, o := range open {
+		s.Open = append(s.Open, o.OpenOrder)
+	}
+	return s, nil
+}
+
+func (r *Runner) apply(p *participant, a Actions) error {
+	for _, id := range a.Cancel {
+		r.mutex.Lock()
+		o, found := p.open[id]
+		r.mutex.Unlock()
+		if !found {
+			continue
+		}
+		var err error
+		if o.Side == economy.SideBid {
+			err = r.market.CancelBid(p.account, id)
+		} else {
+			err = r.market.CancelOffer(p.account, id)
+		}
+		if err != nil && err != economy.ErrOrderInactive {
+			return err
+		}
+	}
+	for _, symbol := range sortedKeys(a.Produce) {
+		if amount := a.Produce[symbol]; amount > 0 {
+			r.holdings.Deposit(p.account, symbol, amount)
+			p.produced[symbol] += amount
+		}
+	}
+	for _, symbol := range sortedKeys(a.Consume) {
+		amount := a.Consume[symbol]
+		if available := r.holdings.Available(p.account, symbol); amount > available {
+			amount = available
+		}
+		if amount > 0 && r.holdings.Withdraw(p.account, symbol, amount) {
+			p.consumed[symbol] += amount
+		}
+	}
+	for _, o := range a.Orders {
+		err := r.place(p.account, o)
+		if rejected(err) {
+			p.rejected++
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Runner) place(account int64, o Order) error {
+	var err error
+	if o.Side == economy.SideBid {
+		_, err = r.market.Bid(economy.Bid{
+			BidType:     o.Type,
+			Account:     account,
+			Symbol:      o.Symbol,
+			Price:       o.Price,
+			Amount:      o.Amount,
+			TimeInForce: o.TimeInForce,
+			Expires:     o.Expires,
+		})
+	} else {
+		_, err = r.market.Offer(economy.Offer{
+			OfferType:   o.Type,
+			Account:     account,
+			Symbol:      o.Symbol,
+			Price:       o.Price,
+			Amount:      o.Amount,
+			TimeInForce: o.TimeInForce,
+			Expires:     o.Expires,
+		})
+	}
+	return err
+}
+
+// rejected returns true for the errors the Market uses to
+// turn an order down, which are the agent's problem rather
+// than the simulation's
+func rejected(err error) bool {
+	for _, e := range []error{
+		economy.ErrInvalidAmount,
+		economy.ErrInsufficientFunds,
+		economy.ErrInsufficientHoldings,
+		economy.ErrSymbolHalted,
+		economy.ErrPriceOutsideBand,
+		economy.ErrAuctionInProgress,
+	} {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// onEvent keeps track of the agents' open orders and
+// trades. It is called with the Market's storage locked,
+// so it mustn't use the Market.
+func (r *Runner) onEvent(e economy.Event) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	p, found := r.byAccount[e.Account]
+	if !found {
+		return
+	}
+	switch e.Type {
+	case economy.EventOrderAccepted:
+		r.seq++
+		p.open[e.OrderID] = &openEntry{
+			OpenOrder: OpenOrder{
+				ID:        e.OrderID,
+				Side:      e.Side,
+				Symbol:    e.Symbol,
+				Price:     e.Price,
+				Remaining: e.Remaining,
+				Placed:    e.Date,
+			},
+			seq: r.seq,
+		}
+	case economy.EventPartiallyFilled, economy.EventFilled:
+		p.trades++
+		p.volume += e.Amount
+		if o, found := p.open[e.OrderID]; found {
+			o.Remaining = e.Remaining
+		}
+		if e.Type == economy.EventFilled {
+			delete(p.open, e.OrderID)
+		}
+	case economy.EventAmended:
+		if o, found := p.open[e.OrderID]; found {
+			o.Remaining = e.Remaining
+		}
+	case economy.EventCancelled, economy.EventNSF, economy.EventExpired:
+		delete(p.open, e.OrderID)
+	}
+}
+
+// Result is how an agent did
+type Result struct {
+	Name    string
+	Account int64
+	// Cash includes funds held for open bids, and Goods
+	// units reserved by open offers
+	Cash     int64
+	Goods    map[string]int64
+	Produced map[string]int64
+	Consumed map[string]int64
+	Trades   int
+	Volume   int64
+	// Rejected counts the orders the Market turned down
+	Rejected int
+	// PnL is the change in the agent's cash plus the change
+	// in its goods valued at the last prices, so goods
+	// produced count as a gain and goods consumed as a loss
+	PnL int64
+}
+
+// Results returns how each agent has done, in the order
+// they were added
+func (r *Runner) Results() ([]Result, error) {
+	prices := make(map[string]int64)
+	for _, symbol := range r.symbols {
+		price, err := r.market.Price(symbol)
+		if err != nil {
+			return nil, err
+		}
+		prices[symbol] = price
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var results []Result
+	for _, p := range r.agents {
+		cash, _ := r.accounts.Balance(p.account)
+		cash += r.accounts.Held(p.account)
+		res := Result{
+			Name:     p.name,
+			Account:  p.account,
+			Cash:     cash,
+			Goods:    make(map[string]int64),
+			Produced: copyAmounts(p.produced),
+			Consumed: copyAmounts(p.consumed),
+			Trades:   p.trades,
+			Volume:   p.volume,
+			Rejected: p.rejected,
+			PnL:      cash - p.startCash,
+		}
+		for _, symbol := range r.symbols {
+			goods := r.holdings.Available(p.account, symbol) + r.holdings.Reserved(p.account, symbol)
+			res.Goods[symbol] = goods
+			res.PnL += (goods - p.start[symbol]) * prices[symbol]
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+func copyAmounts(m map[string]int64) map[string]int64 {
+	rv := make(map[string]int64, len(m))
+	for k, v := range m {
+		rv[k] = v
+	}
+	return rv
+}
+
+// sortedKeys returns the keys of m in order, so that maps
+// are applied the same way every run
+func sortedKeys(m map[string]int64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,106 @@
+package sim
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/williammoran/economy"
+)
+
+var start = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// scripted places the orders it is given on its first tick
+type scripted struct {
+	orders []Order
+	states []State
+}
+
+func (a *scripted) Tick(s State) Actions {
+	a.states = append(a.states, s)
+	orders := a.orders
+	a.orders = nil
+	return Actions{Orders: orders}
+}
+
+func TestRunnerTradesAndPnL(t *testing.T) {
+	r := MakeRunner(start, 1, []string{"S"})
+	seller := &scripted{orders: []Order{{Side: economy.SideOffer, Symbol: "S", Type: economy.OrderTypeLimit, Price: 5, Amount: 4}}}
+	buyer := &scripted{}
+	r.Add("seller", seller, 0, map[string]int64{"S": 10})
+	r.Add("buyer", buyer, 100, nil)
+	if err := r.Run(1, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	buyer.orders = []Order{{Side: economy.SideBid, Symbol: "S", Type: economy.OrderTypeLimit, Price: 5, Amount: 3}}
+	if err := r.Run(2, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if len(buyer.states) != 3 || !buyer.states[2].Time.Equal(start.Add(3*time.Second)) {
+		t.Fatalf("%+v", buyer.states)
+	}
+	if open := seller.states[2].Open; len(open) != 1 || open[0].Remaining != 1 || open[0].Price != 5 {
+		t.Fatalf("%+v", open)
+	}
+	if m := buyer.states[2].Markets["S"]; m.LastPrice != 5 || len(m.Offers) != 1 {
+		t.Fatalf("%+v", m)
+	}
+	results, err := r.Results()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := results[0]; s.Name != "seller" || s.Cash != 15 || s.Goods["S"] != 7 || s.Trades != 1 || s.Volume != 3 || s.PnL != 0 {
+		t.Fatalf("%+v", s)
+	}
+	if b := results[1]; b.Cash != 85 || b.Goods["S"] != 3 || b.PnL != 0 {
+		t.Fatalf("%+v", b)
+	}
+}
+
+func TestRunnerCountsRejections(t *testing.T) {
+	r := MakeRunner(start, 1, []string{"S"})
+	broke := &scripted{orders: []Order{{Side: economy.SideBid, Symbol: "S", Type: economy.OrderTypeLimit, Price: 5, Amount: 3}}}
+	r.Add("broke", broke, 10, nil)
+	if err := r.Run(1, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if results, _ := r.Results(); results[0].Rejected != 1 || results[0].Cash != 10 {
+		t.Fatalf("%+v", results)
+	}
+}
+
+// simulate runs a market with one of every strategy
+func simulate(t *testing.T, seed int64) []Result {
+	r := MakeRunner(start, seed, []string{"ore"})
+	r.Add("mine", &Producer{Symbol: "ore", Rate: 5, Cost: 8}, 0, nil)
+	r.Add("smithy", &Consumer{Symbol: "ore", Need: 4, Budget: 60, MaxPrice: 15}, 100000, nil)
+	r.Add("maker", &MarketMaker{Symbol: "ore", Edge: 1, Size: 3, MaxInventory: 30}, 10000, map[string]int64{"ore": 10})
+	r.Add("noise", &NoiseTrader{Symbol: "ore", Chance: 0.5, MaxAmount: 3, Spread: 2, Lifetime: time.Minute}, 10000, map[string]int64{"ore": 20})
+	r.Add("trend", &Momentum{Symbol: "ore", Lookback: 3, Amount: 2}, 10000, nil)
+	r.Add("value", &MeanReversion{Symbol: "ore", Lookback: 10, Threshold: 5, Amount: 2}, 10000, nil)
+	if err := r.Run(500, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	results, err := r.Results()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return results
+}
+
+func TestRunnerIsRepeatable(t *testing.T) {
+	first := simulate(t, 42)
+	if !reflect.DeepEqual(first, simulate(t, 42)) {
+		t.Fatal("Same seed gave different results")
+	}
+	var volume int64
+	for _, res := range first {
+		volume += res.Volume
+	}
+	if volume == 0 {
+		t.Fatalf("Nothing traded: %+v", first)
+	}
+	if first[0].Produced["ore"] != 2500 || first[1].Consumed["ore"] == 0 {
+		t.Fatalf("%+v %+v", first[0], first[1])
+	}
+}
@@ -0,0 +1,215 @@
+package sim
+
+import (
+	"time"
+
+	"github.com/williammoran/economy"
+)
+
+// NoiseTrader places random limit orders near the last
+// price, providing liquidity and volatility without any
+// view of value
+type NoiseTrader struct {
+	Symbol string
+	// Chance is the probability of placing an order on a
+	// tick, from 0 to 1
+	Chance float64
+	// MaxAmount is the largest order placed
+	MaxAmount int64
+	// Spread is the furthest from the last price an order
+	// is placed
+	Spread int64
+	// Lifetime is how long orders rest before they expire,
+	// or zero for them to rest until filled
+	Lifetime time.Duration
+}
+
+func (a *NoiseTrader) Tick(s State) Actions {
+	if s.Rand.Float64() >= a.Chance || a.MaxAmount < 1 {
+		return Actions{}
+	}
+	side := economy.SideBid
+	if s.Rand.Intn(2) == 1 {
+		side = economy.SideOffer
+	}
+	price := s.Markets[a.Symbol].LastPrice + s.Rand.Int63n(2*a.Spread+1) - a.Spread
+	if price < 1 {
+		price = 1
+	}
+	o := Order{
+		Side:   side,
+		Symbol: a.Symbol,
+		Type:   economy.OrderTypeLimit,
+		Price:  price,
+		Amount: 1 + s.Rand.Int63n(a.MaxAmount),
+	}
+	if a.Lifetime > 0 {
+		o.TimeInForce = economy.TimeInForceGTD
+		o.Expires = s.Time.Add(a.Lifetime)
+	}
+	return Actions{Orders: []Order{o}}
+}
+
+// MarketMaker quotes a bid and an offer around the last
+// price every tick, replacing its previous quotes. It stops
+// bidding once it holds MaxInventory units.
+type MarketMaker struct {
+	Symbol string
+	// Edge is how far each quote is from the last price
+	Edge         int64
+	Size         int64
+	MaxInventory int64
+}
+
+func (a *MarketMaker) Tick(s State) Actions {
+	actions := Actions{Cancel: cancelAll(s, a.Symbol)}
+	last := s.Markets[a.Symbol].LastPrice
+	if bid := last - a.Edge; bid > 0 && s.Goods[a.Symbol] < a.MaxInventory {
+		actions.Orders = append(actions.Orders, Order{
+			Side: economy.SideBid, Symbol: a.Symbol, Type: economy.OrderTypeLimit,
+			Price: bid, Amount: a.Size,
+		})
+	}
+	if amount := min64(a.Size, s.Goods[a.Symbol]); amount > 0 {
+		actions.Orders = append(actions.Orders, Order{
+			Side: economy.SideOffer, Symbol: a.Symbol, Type: economy.OrderTypeLimit,
+			Price: last + a.Edge, Amount: amount,
+		})
+	}
+	return actions
+}
+
+// Momentum buys when the price has risen over the last
+// Lookback ticks and sells what it holds when it has
+// fallen, with market orders
+type Momentum struct {
+	Symbol   string
+	Lookback int
+	Amount   int64
+	history  []int64
+}
+
+func (a *Momentum) Tick(s State) Actions {
+	last := s.Markets[a.Symbol].LastPrice
+	a.history = appendHistory(a.history, last, a.Lookback+1)
+	if len(a.history) <= a.Lookback {
+		return Actions{}
+	}
+	switch then := a.history[0]; {
+	case last > then:
+		return Actions{Orders: []Order{marketOrder(economy.SideBid, a.Symbol, a.Amount)}}
+	case last < then && s.Goods[a.Symbol] > 0:
+		return Actions{Orders: []Order{marketOrder(economy.SideOffer, a.Symbol, min64(a.Amount, s.Goods[a.Symbol]))}}
+	}
+	return Actions{}
+}
+
+// MeanReversion buys when the price is Threshold percent
+// below its average over the last Lookback ticks, and sells
+// what it holds when it is that far above
+type MeanReversion struct {
+	Symbol    string
+	Lookback  int
+	Threshold int64
+	Amount    int64
+	history   []int64
+}
+
+func (a *MeanReversion) Tick(s State) Actions {
+	if a.Lookback < 1 {
+		return Actions{}
+	}
+	last := s.Markets[a.Symbol].LastPrice
+	a.history = appendHistory(a.history, last, a.Lookback)
+	if len(a.history) < a.Lookback {
+		return Actions{}
+	}
+	var total int64
+	for _, p := range a.history {
+		total += p
+	}
+	average := total / int64(len(a.history))
+	switch {
+	case last*100 < average*(100-a.Threshold):
+		return Actions{Orders: []Order{marketOrder(economy.SideBid, a.Symbol, a.Amount)}}
+	case last*100 > average*(100+a.Threshold) && s.Goods[a.Symbol] > 0:
+		return Actions{Orders: []Order{marketOrder(economy.SideOffer, a.Symbol, min64(a.Amount, s.Goods[a.Symbol]))}}
+	}
+	return Actions{}
+}
+
+// Producer is an NPC that makes Rate units every tick and
+// offers everything it holds, at the last price but never
+// below its Cost
+type Producer struct {
+	Symbol string
+	Rate   int64
+	Cost   int64
+}
+
+func (a *Producer) Tick(s State) Actions {
+	actions := Actions{
+		Cancel:  cancelAll(s, a.Symbol),
+		Produce: map[string]int64{a.Symbol: a.Rate},
+	}
+	price := s.Markets[a.Symbol].LastPrice
+	if price < a.Cost {
+		price = a.Cost
+	}
+	if amount := s.Goods[a.Symbol] + a.Rate; amount > 0 && price > 0 {
+		actions.Orders = []Order{{
+			Side: economy.SideOffer, Symbol: a.Symbol, Type: economy.OrderTypeLimit,
+			Price: price, Amount: amount,
+		}}
+	}
+	return actions
+}
+
+// Consumer is an NPC that uses up to Need units every tick
+// and tries to buy as many, spending no more than Budget a
+// tick and paying no more than MaxPrice a unit. Whatever it
+// can't buy right away is cancelled.
+type Consumer struct {
+	Symbol   string
+	Need     int64
+	Budget   int64
+	MaxPrice int64
+}
+
+func (a *Consumer) Tick(s State) Actions {
+	actions := Actions{Consume: map[string]int64{a.Symbol: a.Need}}
+	_, price := best(s.Markets[a.Symbol])
+	if price == 0 || price > a.MaxPrice {
+		price = a.MaxPrice
+	}
+	if price < 1 {
+		return actions
+	}
+	if amount := min64(a.Need, a.Budget/price); amount > 0 {
+		actions.Orders = []Order{{
+			Side: economy.SideBid, Symbol: a.Symbol, Type: economy.OrderTypeLimit,
+			Price: price, Amount: amount, TimeInForce: economy.TimeInForceIOC,
+		}}
+	}
+	return actions
+}
+
+func marketOrder(side economy.Side, symbol string, amount int64) Order {
+	return Order{Side: side, Symbol: symbol, Type: economy.OrderTypeMarket, Amount: amount}
+}
+
+// appendHistory adds price to history, keeping the last n
+func appendHistory(history []int64, price int64, n int) []int64 {
+	history = append(history, price)
+	if len(history) > n {
+		history = history[len(history)-n:]
+	}
+	return history
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,104 @@
+package sim
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/williammoran/economy"
+)
+
+func testState(last int64, goods int64) State {
+	return State{
+		Goods:   map[string]int64{"S": goods},
+		Markets: map[string]economy.Depth{"S": {Symbol: "S", LastPrice: last}},
+		Rand:    rand.New(rand.NewSource(1)),
+	}
+}
+
+func TestMarketMakerQuotes(t *testing.T) {
+	a := &MarketMaker{Symbol: "S", Edge: 2, Size: 5, MaxInventory: 10}
+	s := testState(10, 3)
+	s.Open = []OpenOrder{{ID: uuid.New(), Symbol: "S"}}
+	actions := a.Tick(s)
+	if len(actions.Cancel) != 1 || len(actions.Orders) != 2 {
+		t.Fatalf("%+v", actions)
+	}
+	if bid := actions.Orders[0]; bid.Side != economy.SideBid || bid.Price != 8 || bid.Amount != 5 {
+		t.Fatalf("%+v", bid)
+	}
+	if offer := actions.Orders[1]; offer.Side != economy.SideOffer || offer.Price != 12 || offer.Amount != 3 {
+		t.Fatalf("%+v", offer)
+	}
+	if actions = a.Tick(testState(10, 10)); len(actions.Orders) != 1 || actions.Orders[0].Side != economy.SideOffer {
+		t.Fatalf("Bid with full inventory: %+v", actions)
+	}
+}
+
+func TestMomentum(t *testing.T) {
+	a := &Momentum{Symbol: "S", Lookback: 2, Amount: 3}
+	for _, price := range []int64{10, 11} {
+		if actions := a.Tick(testState(price, 5)); len(actions.Orders) != 0 {
+			t.Fatalf("%+v", actions)
+		}
+	}
+	if actions := a.Tick(testState(12, 5)); len(actions.Orders) != 1 || actions.Orders[0].Side != economy.SideBid {
+		t.Fatalf("%+v", actions)
+	}
+	a.Tick(testState(10, 5))
+	if actions := a.Tick(testState(9, 1)); len(actions.Orders) != 1 || actions.Orders[0].Side != economy.SideOffer || actions.Orders[0].Amount != 1 {
+		t.Fatalf("%+v", actions)
+	}
+}
+
+func TestMeanReversion(t *testing.T) {
+	a := &MeanReversion{Symbol: "S", Lookback: 3, Threshold: 10, Amount: 2}
+	a.Tick(testState(10, 0))
+	a.Tick(testState(10, 0))
+	if actions := a.Tick(testState(8, 0)); len(actions.Orders) != 1 || actions.Orders[0].Side != economy.SideBid {
+		t.Fatalf("%+v", actions)
+	}
+	if actions := a.Tick(testState(12, 0)); len(actions.Orders) != 0 {
+		t.Fatalf("Sold nothing held: %+v", actions)
+	}
+}
+
+func TestProducerNeverSellsBelowCost(t *testing.T) {
+	a := &Producer{Symbol: "S", Rate: 2, Cost: 10}
+	actions := a.Tick(testState(5, 3))
+	if actions.Produce["S"] != 2 || len(actions.Orders) != 1 {
+		t.Fatalf("%+v", actions)
+	}
+	if o := actions.Orders[0]; o.Price != 10 || o.Amount != 5 || o.Side != economy.SideOffer {
+		t.Fatalf("%+v", o)
+	}
+}
+
+func TestConsumerKeepsToBudget(t *testing.T) {
+	a := &Consumer{Symbol: "S", Need: 5, Budget: 20, MaxPrice: 8}
+	s := testState(5, 0)
+	s.Markets["S"] = economy.Depth{Symbol: "S", Offers: []economy.PriceLevel{{Price: 6, Amount: 10}}}
+	actions := a.Tick(s)
+	if actions.Consume["S"] != 5 || len(actions.Orders) != 1 {
+		t.Fatalf("%+v", actions)
+	}
+	if o := actions.Orders[0]; o.Price != 6 || o.Amount != 3 || o.TimeInForce != economy.TimeInForceIOC {
+		t.Fatalf("%+v", o)
+	}
+}
+
+func TestNoiseTrader(t *testing.T) {
+	a := &NoiseTrader{Symbol: "S", Chance: 1, MaxAmount: 3, Spread: 2}
+	for i := 0; i < 20; i++ {
+		actions := a.Tick(testState(10, 0))
+		if len(actions.Orders) != 1 {
+			t.Fatalf("%+v", actions)
+		}
+		if o := actions.Orders[0]; o.Price < 8 || o.Price > 12 || o.Amount < 1 || o.Amount > 3 {
+			t.Fatalf("%+v", o)
+		}
+	}
+	if actions := (&NoiseTrader{Symbol: "S", MaxAmount: 3}).Tick(testState(10, 0)); len(actions.Orders) != 0 {
+		t.Fatalf("%+v", actions)
+	}
+}
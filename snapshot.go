@@ -0,0 +1,311 @@
+package economy
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strconv"
+)
+
+// SnapshotEncoding selects how MarshalEncoding writes a
+// snapshot. UnMarshal recognizes the encoding by itself.
+type SnapshotEncoding int
+
+const (
+	SnapshotCSV SnapshotEncoding = iota
+	SnapshotJSON
+)
+
+// SnapshotVersion is the version of the snapshot format
+// that Marshal writes. UnMarshal loads every version up to
+// this one. Version 1 snapshots have no header and are
+// recognized by that.
+//
+// Columns and fields can be added to the format without a
+// new version, since older snapshots just don't have them.
+// Any other change needs a new version, with the older
+// ones converted in decodeSnapshot.
+const SnapshotVersion = 2
+
+// snapshotFormat starts every snapshot since version 2
+const snapshotFormat = "economy snapshot"
+
+// checksumRecord ends CSV snapshots, with the CRC-32 of
+// everything before it
+const checksumRecord = "checksum"
+
+// snapshot is the data in a MemoryStorage, as every
+// encoding and version is decoded. Orders are in the order
+// they are queued in the order book.
+type snapshot struct {
+	Offers       []Offer          `json:"offers"`
+	Prices       map[string]int64 `json:"prices"`
+	Bids         []Bid            `json:"bids"`
+	Transactions []Transaction    `json:"transactions"`
+	Candles      []Candle         `json:"candles"`
+}
+
+// snapshotSections are the sections of a CSV snapshot in
+// the order they are written. Each section starts with a
+// record of its name and the names of its columns, so a
+// field is found by name. Version 1 snapshots have the
+// same columns in this order, without the names.
+var snapshotSections = []struct {
+	name    string
+	columns []string
+}{
+	{"offers", []string{
+		"id", "type", "account", "symbol", "price", "amount",
+		"cancelled", "time_in_force", "expires", "stop_price",
+	}},
+	{"prices", []string{"symbol", "price"}},
+	{"bids", []string{
+		"id", "type", "account", "symbol", "price", "amount",
+		"nsf", "cancelled", "held", "time_in_force", "expires", "stop_price",
+	}},
+	{"transactions", []string{
+		"id", "bid_id", "offer_id", "price", "amount", "date",
+		"symbol", "bid_account", "offer_account", "bid_fee", "offer_fee",
+	}},
+	{"candles", []string{
+		"symbol", "interval", "start", "open", "high", "low",
+		"close", "volume", "value",
+	}},
+}
+
+func encodeCSV(w io.Writer, snap snapshot) error {
+	sum := crc32.NewIEEE()
+	out := io.MultiWriter(w, sum)
+	if err := writeRecord(out, snapshotFormat, strconv.Itoa(SnapshotVersion)); err != nil {
+		return err
+	}
+	for _, section := range snapshotSections {
+		if err := writeRecord(out, append([]string{section.name}, section.columns...)...); err != nil {
+			return err
+		}
+		if err := snap.save(out, section.name); err != nil {
+			return err
+		}
+		if err := writeEOF(out); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%s,%08x\n", checksumRecord, sum.Sum32())
+	return err
+}
+
+func writeRecord(w io.Writer, fields ...string) error {
+	writer := csv.NewWriter(w)
+	writer.Write(fields)
+	writer.Flush()
+	return writer.Error()
+}
+
+func (snap *snapshot) save(w io.Writer, section string) error {
+	switch section {
+	case "offers":
+		return saveOffers(w, snap.Offers)
+	case "prices":
+		return savePrices(w, snap.Prices)
+	case "bids":
+		return saveBids(w, snap.Bids)
+	case "transactions":
+		return saveTransactions(w, snap.Transactions)
+	default:
+		return saveCandles(w, snap.Candles)
+	}
+}
+
+// jsonSnapshot is a snapshot encoded as JSON. The checksum
+// is the CRC-32 of Data exactly as it is in the file.
+type jsonSnapshot struct {
+	Format   string          `json:"format"`
+	Version  int             `json:"version"`
+	Checksum string          `json:"checksum"`
+	Data     json.RawMessage `json:"data"`
+}
+
+func encodeJSON(w io.Writer, snap snapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(jsonSnapshot{
+		Format:   snapshotFormat,
+		Version:  SnapshotVersion,
+		Checksum: fmt.Sprintf("%08x", crc32.ChecksumIEEE(data)),
+		Data:     data,
+	})
+}
+
+// decodeSnapshot decodes data in any encoding and version
+func decodeSnapshot(data []byte) (snapshot, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		return decodeJSON(trimmed)
+	}
+	if bytes.HasPrefix(data, []byte(snapshotFormat+",")) {
+		return decodeCSV(data)
+	}
+	return decodeVersion1(data)
+}
+
+func checkVersion(version int) error {
+	if version < 2 || version > SnapshotVersion {
+		return corrupt(fmt.Errorf("unsupported version %d", version), "header")
+	}
+	return nil
+}
+
+func decodeJSON(data []byte) (snapshot, error) {
+	var snap snapshot
+	var envelope jsonSnapshot
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return snap, corrupt(err, "snapshot")
+	}
+	if envelope.Format != snapshotFormat {
+		return snap, corrupt(fmt.Errorf("format %q", envelope.Format), "header")
+	}
+	if err := checkVersion(envelope.Version); err != nil {
+		return snap, err
+	}
+	if sum := fmt.Sprintf("%08x", crc32.ChecksumIEEE(envelope.Data)); sum != envelope.Checksum {
+		return snap, corrupt(fmt.Errorf("expected %s, got %s", envelope.Checksum, sum), "checksum")
+	}
+	if err := json.Unmarshal(envelope.Data, &snap); err != nil {
+		return snap, corrupt(err, "data")
+	}
+	return snap, nil
+}
+
+func decodeCSV(data []byte) (snapshot, error) {
+	snap := snapshot{Prices: make(map[string]int64)}
+	body, err := verifyChecksum(data)
+	if err != nil {
+		return snap, err
+	}
+	reader := csv.NewReader(bytes.NewReader(body))
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return snap, corrupt(err, "header")
+	}
+	version, err := strconv.Atoi(header[1])
+	if err != nil {
+		return snap, corrupt(err, "header")
+	}
+	if err := checkVersion(version); err != nil {
+		return snap, err
+	}
+	for {
+		names, err := reader.Read()
+		if err == io.EOF {
+			return snap, nil
+		}
+		if err != nil {
+			return snap, corrupt(err, "snapshot")
+		}
+		section := names[0]
+		columns, found := sectionColumns(section)
+		if !found {
+			return snap, corrupt(errors.New("unknown section"), "%s", section)
+		}
+		positions := columnPositions(columns, names[1:])
+		parse := snap.parser(section)
+		err = loadSection(reader, section, func(p *fieldParser) {
+			p.columns = positions
+			parse(p)
+		})
+		if err != nil {
+			return snap, err
+		}
+	}
+}
+
+// verifyChecksum returns data without its checksum record
+// if the checksum matches. A snapshot cut short fails
+// here.
+func verifyChecksum(data []byte) ([]byte, error) {
+	if !bytes.HasSuffix(data, []byte("\n")) {
+		return nil, corrupt(errors.New("missing"), "checksum")
+	}
+	start := bytes.LastIndexByte(data[:len(data)-1], '\n') + 1
+	body, last := data[:start], string(data[start:len(data)-1])
+	prefix := checksumRecord + ","
+	if len(last) <= len(prefix) || last[:len(prefix)] != prefix {
+		return nil, corrupt(errors.New("missing"), "checksum")
+	}
+	expected := last[len(prefix):]
+	if sum := fmt.Sprintf("%08x", crc32.ChecksumIEEE(body)); sum != expected {
+		return nil, corrupt(fmt.Errorf("expected %s, got %s", expected, sum), "checksum")
+	}
+	return body, nil
+}
+
+func sectionColumns(name string) ([]string, bool) {
+	for _, section := range snapshotSections {
+		if section.name == name {
+			return section.columns, true
+		}
+	}
+	return nil, false
+}
+
+// columnPositions finds each of columns in names, for
+// fieldParser.columns. Names that aren't columns are
+// ignored.
+func columnPositions(columns, names []string) []int {
+	positions := make([]int, len(columns))
+	for i, column := range columns {
+		positions[i] = -1
+		for j, name := range names {
+			if name == column {
+				positions[i] = j
+			}
+		}
+	}
+	return positions
+}
+
+func (snap *snapshot) parser(section string) func(*fieldParser) {
+	switch section {
+	case "offers":
+		return func(p *fieldParser) { snap.Offers = append(snap.Offers, parseOffer(p)) }
+	case "prices":
+		return func(p *fieldParser) { snap.Prices[p.field(0)] = p.int64(1) }
+	case "bids":
+		return func(p *fieldParser) { snap.Bids = append(snap.Bids, parseBid(p)) }
+	case "transactions":
+		return func(p *fieldParser) { snap.Transactions = append(snap.Transactions, parseTransaction(p)) }
+	default:
+		return func(p *fieldParser) { snap.Candles = append(snap.Candles, parseCandle(p)) }
+	}
+}
+
+// decodeVersion1 decodes the headerless CSV that Marshal
+// wrote before there were versions. Its sections have no
+// names and must be in order, and it has no checksum.
+func decodeVersion1(data []byte) (snapshot, error) {
+	var snap snapshot
+	var err error
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	if snap.Offers, err = loadOffers(reader); err != nil {
+		return snap, err
+	}
+	if snap.Prices, err = loadPrices(reader); err != nil {
+		return snap, err
+	}
+	if snap.Bids, err = loadBids(reader); err != nil {
+		return snap, err
+	}
+	if snap.Transactions, err = loadTransactions(reader); err != nil {
+		return snap, err
+	}
+	snap.Candles, err = loadCandles(reader)
+	return snap, err
+}
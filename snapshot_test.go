@@ -0,0 +1,150 @@
+package economy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func makeSnapshotStorage() *MemoryStorage {
+	date := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	ms := MakeMemoryStorage()
+	ms.AddBid(Bid{Symbol: sym, Amount: 10, BidType: OrderTypeLimit, Price: 4, Held: 40})
+	ms.AddBid(Bid{Symbol: sym, Amount: 3, Cancelled: true, Expires: date})
+	ms.AddOffer(Offer{Symbol: "Y", Amount: 8, Account: 4, OfferType: OrderTypeLimit, Price: 42})
+	ms.AddOffer(Offer{Symbol: "Y", Amount: 2, OfferType: OrderTypeStopMarket, StopPrice: 30})
+	ms.NewTransaction(Transaction{Price: 24, Symbol: "Q", Date: date, BidFee: 1})
+	ms.SetLastPrice("Q", 233)
+	ms.SetLastPrice("X", 322)
+	ms.SetCandle(Candle{Symbol: "Q", Interval: CandleMinute, Start: date, Open: 24, Volume: 1})
+	return ms
+}
+
+func assertSameData(t *testing.T, ms, loaded *MemoryStorage) {
+	if !reflect.DeepEqual(ms.bids, loaded.bids) {
+		t.Fatalf("bids != %+v", loaded.bids)
+	}
+	if !reflect.DeepEqual(ms.offers, loaded.offers) {
+		t.Fatalf("offers != %+v", loaded.offers)
+	}
+	if !reflect.DeepEqual(ms.lastPrice, loaded.lastPrice) {
+		t.Fatalf("prices != %+v", loaded.lastPrice)
+	}
+	if !reflect.DeepEqual(ms.transactions, loaded.transactions) {
+		t.Fatalf("transactions != %+v", loaded.transactions)
+	}
+	if !reflect.DeepEqual(ms.candles, loaded.candles) {
+		t.Fatalf("candles != %+v", loaded.candles)
+	}
+}
+
+func TestSnapshotEncodings(t *testing.T) {
+	ms := makeSnapshotStorage()
+	for _, e := range []SnapshotEncoding{SnapshotCSV, SnapshotJSON} {
+		var buf bytes.Buffer
+		if err := ms.MarshalEncoding(&buf, e); err != nil {
+			t.Fatal(err)
+		}
+		loaded := MakeMemoryStorage()
+		if err := loaded.UnMarshal(bytes.NewReader(buf.Bytes())); err != nil {
+			t.Fatalf("%d: %v\n%s", e, err, buf.String())
+		}
+		assertSameData(t, ms, loaded)
+	}
+}
+
+func TestSnapshotHasHeader(t *testing.T) {
+	var buf bytes.Buffer
+	MakeMemoryStorage().Marshal(&buf)
+	if !strings.HasPrefix(buf.String(), "economy snapshot,2\noffers,id,type,") {
+		t.Fatalf("%s", buf.String())
+	}
+}
+
+func TestSnapshotDetectsTruncation(t *testing.T) {
+	ms := makeSnapshotStorage()
+	for _, e := range []SnapshotEncoding{SnapshotCSV, SnapshotJSON} {
+		var buf bytes.Buffer
+		ms.MarshalEncoding(&buf, e)
+		saved := buf.Bytes()
+		for _, cut := range []int{2, 20, len(saved) / 2} {
+			err := MakeMemoryStorage().UnMarshal(bytes.NewReader(saved[:len(saved)-cut]))
+			if !errors.Is(err, ErrCorruptSnapshot) {
+				t.Fatalf("Expected ErrCorruptSnapshot for %d cut by %d, got %v", e, cut, err)
+			}
+		}
+		damaged := bytes.Replace(saved, []byte("233"), []byte("234"), 1)
+		if err := MakeMemoryStorage().UnMarshal(bytes.NewReader(damaged)); !errors.Is(err, ErrCorruptSnapshot) {
+			t.Fatalf("Expected ErrCorruptSnapshot for damaged %d, got %v", e, err)
+		}
+	}
+}
+
+// withChecksum finishes a hand written CSV snapshot
+func withChecksum(body string) string {
+	return fmt.Sprintf("%schecksum,%08x\n", body, crc32.ChecksumIEEE([]byte(body)))
+}
+
+func TestSnapshotColumnsByName(t *testing.T) {
+	data := withChecksum("economy snapshot,2\n" +
+		"prices,price,unknown,symbol\n" +
+		"5,x,S\n" +
+		"EOF\n" +
+		"offers,symbol,amount,price,type,id,account\n" +
+		"Y,8,42,1,2c8f3e5e-1f8e-4c4a-9a43-4b0f4d7e3a11,4\n" +
+		"EOF\n")
+	ms := MakeMemoryStorage()
+	if err := ms.UnMarshal(strings.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if price, _ := ms.LastPrice(sym); price != 5 {
+		t.Fatalf("Last price %d", price)
+	}
+	o, found, _ := ms.BestOffer("Y")
+	if !found || o.Amount != 8 || o.Price != 42 || o.Account != 4 || o.Cancelled {
+		t.Fatalf("%+v", o)
+	}
+	missing := withChecksum("economy snapshot,2\noffers,symbol,amount\nY,8\nEOF\n")
+	if err := ms.UnMarshal(strings.NewReader(missing)); !errors.Is(err, ErrCorruptSnapshot) {
+		t.Fatalf("Expected ErrCorruptSnapshot without id, got %v", err)
+	}
+}
+
+func TestSnapshotUnsupportedVersion(t *testing.T) {
+	inputs := []string{
+		withChecksum("economy snapshot,3\n"),
+		`{"format":"economy snapshot","version":1,"checksum":"00000000","data":{}}`,
+	}
+	for _, input := range inputs {
+		err := MakeMemoryStorage().UnMarshal(strings.NewReader(input))
+		if !errors.Is(err, ErrCorruptSnapshot) {
+			t.Fatalf("Expected ErrCorruptSnapshot for %q, got %v", input, err)
+		}
+	}
+}
+
+func TestSnapshotMigratesVersion1(t *testing.T) {
+	data := "2c8f3e5e-1f8e-4c4a-9a43-4b0f4d7e3a11,1,4,Y,42,8\n" +
+		"EOF\nS,5\nEOF\n" +
+		"6b1f0f4c-55a8-4d6c-8f7e-2b6a5f3c9d20,0,2,G,0,11,false\n" +
+		"EOF\nEOF\n"
+	ms := MakeMemoryStorage()
+	if err := ms.UnMarshal(strings.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	ms.Marshal(&buf)
+	if !strings.HasPrefix(buf.String(), "economy snapshot,2\n") {
+		t.Fatalf("%s", buf.String())
+	}
+	loaded := MakeMemoryStorage()
+	if err := loaded.UnMarshal(&buf); err != nil {
+		t.Fatal(err)
+	}
+	assertSameData(t, ms, loaded)
+}
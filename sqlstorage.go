@@ -0,0 +1,958 @@
+package economy
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SQLStorage is a MarketStorage kept in a SQL database
+// through database/sql. Its SQL works with both SQLite and
+// PostgreSQL. SQLite databases should be limited to one
+// open connection with SetMaxOpenConns.
+//
+// Lock begins a database transaction and Unlock commits
+// it, so everything the Market does with one order is
+// saved together or not at all. If a database call fails
+// while locked, Unlock rolls the transaction back instead
+// and every later call before Unlock returns the same
+// error. SQLStorage is CommitStorage, so the Market
+// returns an error for an operation whose changes weren't
+// saved. Calls made without Lock run on their own.
+type SQLStorage struct {
+	db    *sql.DB
+	mutex sync.Mutex
+	tx    *sql.Tx
+	// err is the first database error since Lock
+	err error
+	// commitErr is why the changes made before the last
+	// Unlock weren't saved
+	commitErr error
+	pricers   map[OrderType]OrderPricer
+}
+
+// sqlMigrations bring a database up to date. Each one is
+// run in a single transaction and recorded in
+// schema_migrations. Released migrations must never be
+// changed; add a new one instead.
+var sqlMigrations = [][]string{
+	{
+		`CREATE TABLE bids (
+			id TEXT PRIMARY KEY,
+			bid_type SMALLINT NOT NULL,
+			account BIGINT NOT NULL,
+			symbol TEXT NOT NULL,
+			price BIGINT NOT NULL,
+			amount BIGINT NOT NULL,
+			nsf BOOLEAN NOT NULL,
+			cancelled BOOLEAN NOT NULL,
+			held BIGINT NOT NULL,
+			time_in_force SMALLINT NOT NULL,
+			expires BIGINT NOT NULL,
+			stop_price BIGINT NOT NULL,
+			active BOOLEAN NOT NULL,
+			seq BIGINT NOT NULL
+		)`,
+		`CREATE INDEX bids_best ON bids (symbol, active, bid_type, price, seq)`,
+		`CREATE TABLE offers (
+			id TEXT PRIMARY KEY,
+			offer_type SMALLINT NOT NULL,
+			account BIGINT NOT NULL,
+			symbol TEXT NOT NULL,
+			price BIGINT NOT NULL,
+			amount BIGINT NOT NULL,
+			cancelled BOOLEAN NOT NULL,
+			time_in_force SMALLINT NOT NULL,
+			expires BIGINT NOT NULL,
+			stop_price BIGINT NOT NULL,
+			active BOOLEAN NOT NULL,
+			seq BIGINT NOT NULL
+		)`,
+		`CREATE INDEX offers_best ON offers (symbol, active, offer_type, price, seq)`,
+		`CREATE TABLE transactions (
+			id TEXT PRIMARY KEY,
+			n BIGINT NOT NULL UNIQUE,
+			bid_id TEXT NOT NULL,
+			offer_id TEXT NOT NULL,
+			symbol TEXT NOT NULL,
+			bid_account BIGINT NOT NULL,
+			offer_account BIGINT NOT NULL,
+			price BIGINT NOT NULL,
+			amount BIGINT NOT NULL,
+			date BIGINT NOT NULL,
+			bid_fee BIGINT NOT NULL,
+			offer_fee BIGINT NOT NULL
+		)`,
+		`CREATE TABLE last_prices (
+			symbol TEXT PRIMARY KEY,
+			price BIGINT NOT NULL
+		)`,
+		`CREATE TABLE candles (
+			symbol TEXT NOT NULL,
+			interval_ns BIGINT NOT NULL,
+			start_ns BIGINT NOT NULL,
+			open_price BIGINT NOT NULL,
+			high_price BIGINT NOT NULL,
+			low_price BIGINT NOT NULL,
+			close_price BIGINT NOT NULL,
+			volume BIGINT NOT NULL,
+			total_value BIGINT NOT NULL,
+			PRIMARY KEY (symbol, interval_ns, start_ns)
+		)`,
+		`CREATE TABLE counters (
+			name TEXT PRIMARY KEY,
+			value BIGINT NOT NULL
+		)`,
+		`INSERT INTO counters (name, value) VALUES ('order', 0), ('transaction', 0)`,
+	},
+}
+
+// OpenSQLStorage returns storage that uses db, after
+// bringing its schema up to date
+func OpenSQLStorage(db *sql.DB) (*SQLStorage, error) {
+	s := &SQLStorage{db: db, pricers: make(map[OrderType]OrderPricer)}
+	if err := s.migrate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SQLStorage) migrate() error {
+	_, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version BIGINT NOT NULL)`)
+	if err != nil {
+		return err
+	}
+	var version int
+	err = s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return err
+	}
+	for ; version < len(sqlMigrations); version++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		for _, stmt := range sqlMigrations[version] {
+			if _, err := tx.Exec(stmt); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("migration %d: %w", version+1, err)
+			}
+		}
+		_, err = tx.Exec(`INSERT INTO schema_migrations (version) VALUES ($1)`, version+1)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLStorage) Lock() {
+	s.mutex.Lock()
+	s.commitErr = nil
+	s.tx, s.err = s.db.Begin()
+}
+
+func (s *SQLStorage) Unlock() {
+	s.Commit()
+}
+
+// Commit commits the transaction begun by Lock and
+// unlocks storage. It returns the first database error
+// since Lock, having rolled the transaction back, or the
+// error from committing it.
+func (s *SQLStorage) Commit() error {
+	defer s.mutex.Unlock()
+	if s.err != nil {
+		if s.tx != nil {
+			s.tx.Rollback()
+		}
+		s.commitErr = s.err
+	} else {
+		s.commitErr = s.tx.Commit()
+	}
+	s.tx, s.err = nil, nil
+	return s.commitErr
+}
+
+// Err returns the error that stopped the changes made
+// before the last Unlock from being saved
+func (s *SQLStorage) Err() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.commitErr
+}
+
+type sqlQuerier interface {
+	Exec(string, ...interface{}) (sql.Result, error)
+	Query(string, ...interface{}) (*sql.Rows, error)
+	QueryRow(string, ...interface{}) *sql.Row
+}
+
+func (s *SQLStorage) q() sqlQuerier {
+	if s.tx != nil {
+		return s.tx
+	}
+	return s.db
+}
+
+// dbErr records a database error so that the transaction
+// is rolled back, and returns it
+func (s *SQLStorage) dbErr(err error) error {
+	if err != nil && s.tx != nil && s.err == nil {
+		s.err = err
+	}
+	return err
+}
+
+// next returns the next value of the named counter
+func (s *SQLStorage) next(name string) (uint64, error) {
+	var v uint64
+	err := s.q().QueryRow(
+		`UPDATE counters SET value = value + 1 WHERE name = $1 RETURNING value`, name,
+	).Scan(&v)
+	return v, s.dbErr(err)
+}
+
+// Times are stored as nanoseconds since 1970, which an
+// int64 holds between these
+var (
+	minNanosTime = time.Unix(0, math.MinInt64)
+	maxNanosTime = time.Unix(0, math.MaxInt64)
+)
+
+// toNanos returns t as it is stored, or ErrTimeOutOfRange
+func toNanos(t time.Time) (int64, error) {
+	if t.IsZero() {
+		return 0, nil
+	}
+	if t.Before(minNanosTime) || t.After(maxNanosTime) {
+		return 0, ErrTimeOutOfRange
+	}
+	return t.UnixNano(), nil
+}
+
+// boundNanos returns t as it is stored, for comparing with
+// stored times. Times out of range are moved to the nearest
+// end of it, which compares the same with everything
+// stored.
+func boundNanos(t time.Time) int64 {
+	switch {
+	case t.Before(minNanosTime):
+		return math.MinInt64
+	case t.After(maxNanosTime):
+		return math.MaxInt64
+	}
+	return t.UnixNano()
+}
+
+func fromNanos(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n).UTC()
+}
+
+type sqlScanner interface {
+	Scan(...interface{}) error
+}
+
+func (s *SQLStorage) knownType(t OrderType) bool {
+	if t <= OrderTypeStopLimit {
+		return true
+	}
+	_, found := s.pricers[t]
+	return found
+}
+
+// RegisterOrderType returns ErrOrderTypeInUse if t is a
+// built in type or is already registered
+func (s *SQLStorage) RegisterOrderType(t OrderType, p OrderPricer) error {
+	if s.knownType(t) {
+		return ErrOrderTypeInUse
+	}
+	s.pricers[t] = p
+	return nil
+}
+
+// requeue returns true if an order changing from the old
+// values to the new ones loses its place in the queue.
+// Partial fills and reduced amounts keep their place.
+func requeue(wasActive, active bool, oldType, newType OrderType, oldPrice, newPrice, oldAmount, newAmount int64) bool {
+	return !wasActive || !active || oldType != newType || oldPrice != newPrice || newAmount > oldAmount
+}
+
+const offerColumns = `id, offer_type, account, symbol, price, amount, cancelled,
+	time_in_force, expires, stop_price, seq`
+
+func scanOffer(row sqlScanner) (Offer, uint64, error) {
+	var o Offer
+	var id string
+	var expires int64
+	var seq uint64
+	err := row.Scan(
+		&id, &o.OfferType, &o.Account, &o.Symbol, &o.Price, &o.Amount, &o.Cancelled,
+		&o.TimeInForce, &expires, &o.StopPrice, &seq,
+	)
+	if err != nil {
+		return Offer{}, 0, err
+	}
+	o.ID, err = uuid.Parse(id)
+	o.Expires = fromNanos(expires)
+	return o, seq, err
+}
+
+// queryOffers returns the offers selected by the rest of
+// the query, and their sequence numbers
+func (s *SQLStorage) queryOffers(rest string, args ...interface{}) ([]Offer, []uint64, error) {
+	if s.err != nil {
+		return nil, nil, s.err
+	}
+	rows, err := s.q().Query(`SELECT `+offerColumns+` FROM offers `+rest, args...)
+	if err != nil {
+		return nil, nil, s.dbErr(err)
+	}
+	defer rows.Close()
+	var offers []Offer
+	var seqs []uint64
+	for rows.Next() {
+		o, seq, err := scanOffer(rows)
+		if err != nil {
+			return nil, nil, s.dbErr(err)
+		}
+		offers = append(offers, o)
+		seqs = append(seqs, seq)
+	}
+	return offers, seqs, s.dbErr(rows.Err())
+}
+
+func (s *SQLStorage) writeOffer(insert bool, o Offer, seq uint64) error {
+	query := `UPDATE offers SET offer_type = $2, account = $3, symbol = $4,
+		price = $5, amount = $6, cancelled = $7, time_in_force = $8,
+		expires = $9, stop_price = $10, active = $11, seq = $12
+		WHERE id = $1`
+	if insert {
+		query = `INSERT INTO offers (id, offer_type, account, symbol, price,
+			amount, cancelled, time_in_force, expires, stop_price, active, seq)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`
+	}
+	expires, err := toNanos(o.Expires)
+	if err != nil {
+		return s.dbErr(err)
+	}
+	_, err = s.q().Exec(
+		query, o.ID.String(), o.OfferType, o.Account, o.Symbol, o.Price, o.Amount,
+		o.Cancelled, o.TimeInForce, expires, o.StopPrice, o.IsActive(), seq,
+	)
+	return s.dbErr(err)
+}
+
+func (s *SQLStorage) AddOffer(o Offer) (uuid.UUID, error) {
+	if s.err != nil {
+		return uuid.Nil, s.err
+	}
+	if !s.knownType(o.OfferType) {
+		return uuid.Nil, ErrUnknownOrderType
+	}
+	o.ID = uuid.New()
+	seq, err := s.next("order")
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return o.ID, s.writeOffer(true, o, seq)
+}
+
+func (s *SQLStorage) UpdateOffer(o Offer) error {
+	found, seqs, err := s.queryOffers(`WHERE id = $1`, o.ID.String())
+	if err != nil {
+		return err
+	}
+	insert := len(found) == 0
+	var old Offer
+	var seq uint64
+	if !insert {
+		old, seq = found[0], seqs[0]
+	}
+	if requeue(old.IsActive(), o.IsActive(), old.OfferType, o.OfferType, old.Price, o.Price, old.Amount, o.Amount) {
+		if o.IsActive() && !s.knownType(o.OfferType) {
+			return ErrUnknownOrderType
+		}
+		if seq, err = s.next("order"); err != nil {
+			return err
+		}
+	}
+	return s.writeOffer(insert, o, seq)
+}
+
+func (s *SQLStorage) GetOffer(id uuid.UUID) (Offer, error) {
+	offers, _, err := s.queryOffers(`WHERE id = $1`, id.String())
+	if err != nil {
+		return Offer{}, err
+	}
+	if len(offers) == 0 {
+		return Offer{}, ErrOrderNotFound
+	}
+	return offers[0], nil
+}
+
+// offerPrice returns the price an offer is asking, as the
+// order book would rank it
+func (s *SQLStorage) offerPrice(o Offer, lastPrice int64) (int64, error) {
+	switch o.OfferType {
+	case OrderTypeLimit:
+		return o.Price, nil
+	case OrderTypeMarket:
+		return lastPrice, nil
+	}
+	p, found := s.pricers[o.OfferType]
+	if !found {
+		return 0, ErrUnknownOrderType
+	}
+	return p.GetAskingPrice(s, o)
+}
+
+// rankOffers sorts offers into the order they would be
+// matched
+func (s *SQLStorage) rankOffers(offers []Offer, seqs []uint64, lastPrice int64) ([]Offer, error) {
+	side := makeBookSide(func(a, b int64) bool { return a < b })
+	order, err := rankOrder(seqs, &side, func(i int) (int64, error) {
+		return s.offerPrice(offers[i], lastPrice)
+	})
+	if err != nil {
+		return nil, err
+	}
+	ranked := make([]Offer, len(order))
+	for i, j := range order {
+		ranked[i] = offers[j]
+	}
+	return ranked, nil
+}
+
+// rankOrder returns the indexes of orders sorted by the
+// price that price gives them, and then by sequence
+func rankOrder(seqs []uint64, side *bookSide, price func(int) (int64, error)) ([]int, error) {
+	entries := make([]pricedEntry, len(seqs))
+	order := make([]int, len(seqs))
+	for i := range entries {
+		p, err := price(i)
+		if err != nil {
+			return nil, err
+		}
+		entries[i] = pricedEntry{price: p, e: bookEntry{seq: seqs[i]}}
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return side.beats(entries[order[i]], entries[order[j]])
+	})
+	return order, nil
+}
+
+// bookOffers are the conditions for offers that are in
+// the order book rather than waiting to be triggered
+const bookOffers = `symbol = $1 AND active AND offer_type NOT IN ($2, $3)`
+
+func (s *SQLStorage) BestOffer(sym string) (Offer, bool, error) {
+	lastPrice, err := s.LastPrice(sym)
+	if err != nil {
+		return Offer{}, false, err
+	}
+	// Only the first limit and market offers can be best,
+	// but every custom offer needs pricing
+	var offers []Offer
+	var seqs []uint64
+	for _, rest := range []string{
+		`WHERE ` + bookOffers + ` AND offer_type = $4 ORDER BY price, seq LIMIT 1`,
+		`WHERE ` + bookOffers + ` AND offer_type = $5 ORDER BY seq LIMIT 1`,
+		`WHERE ` + bookOffers + ` AND offer_type NOT IN ($4, $5)`,
+	} {
+		found, foundSeqs, err := s.queryOffers(
+			rest, sym, OrderTypeStopMarket, OrderTypeStopLimit, OrderTypeLimit, OrderTypeMarket,
+		)
+		if err != nil {
+			return Offer{}, false, err
+		}
+		offers = append(offers, found...)
+		seqs = append(seqs, foundSeqs...)
+	}
+	if len(offers) == 0 {
+		return Offer{}, false, nil
+	}
+	offers, err = s.rankOffers(offers, seqs, lastPrice)
+	if err != nil {
+		return Offer{}, false, err
+	}
+	return offers[0], true, nil
+}
+
+func (s *SQLStorage) RestingOffers(sym string) ([]Offer, error) {
+	lastPrice, err := s.LastPrice(sym)
+	if err != nil {
+		return nil, err
+	}
+	offers, seqs, err := s.queryOffers(
+		`WHERE `+bookOffers, sym, OrderTypeStopMarket, OrderTypeStopLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return s.rankOffers(offers, seqs, lastPrice)
+}
+
+func (s *SQLStorage) StopOffers(sym string) ([]Offer, error) {
+	offers, _, err := s.queryOffers(
+		`WHERE symbol = $1 AND active AND offer_type IN ($2, $3) ORDER BY seq`,
+		sym, OrderTypeStopMarket, OrderTypeStopLimit,
+	)
+	return offers, err
+}
+
+const bidColumns = `id, bid_type, account, symbol, price, amount, nsf, cancelled,
+	held, time_in_force, expires, stop_price, seq`
+
+func scanBid(row sqlScanner) (Bid, uint64, error) {
+	var b Bid
+	var id string
+	var expires int64
+	var seq uint64
+	err := row.Scan(
+		&id, &b.BidType, &b.Account, &b.Symbol, &b.Price, &b.Amount, &b.NSF, &b.Cancelled,
+		&b.Held, &b.TimeInForce, &expires, &b.StopPrice, &seq,
+	)
+	if err != nil {
+		return Bid{}, 0, err
+	}
+	b.ID, err = uuid.Parse(id)
+	b.Expires = fromNanos(expires)
+	return b, seq, err
+}
+
+// queryBids returns the bids selected by the rest of the
+// query, and their sequence numbers
+func (s *SQLStorage) queryBids(rest string, args ...interface{}) ([]Bid, []uint64, error) {
+	if s.err != nil {
+		return nil, nil, s.err
+	}
+	rows, err := s.q().Query(`SELECT `+bidColumns+` FROM bids `+rest, args...)
+	if err != nil {
+		return nil, nil, s.dbErr(err)
+	}
+	defer rows.Close()
+	var bids []Bid
+	var seqs []uint64
+	for rows.Next() {
+		b, seq, err := scanBid(rows)
+		if err != nil {
+			return nil, nil, s.dbErr(err)
+		}
+		bids = append(bids, b)
+		seqs = append(seqs, seq)
+	}
+	return bids, seqs, s.dbErr(rows.Err())
+}
+
+func (s *SQLStorage) writeBid(insert bool, b Bid, seq uint64) error {
+	query := `UPDATE bids SET bid_type = $2, account = $3, symbol = $4,
+		price = $5, amount = $6, nsf = $7, cancelled = $8, held = $9,
+		time_in_force = $10, expires = $11, stop_price = $12, active = $13,
+		seq = $14
+		WHERE id = $1`
+	if insert {
+		query = `INSERT INTO bids (id, bid_type, account, symbol, price, amount,
+			nsf, cancelled, held, time_in_force, expires, stop_price, active, seq)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`
+	}
+	expires, err := toNanos(b.Expires)
+	if err != nil {
+		return s.dbErr(err)
+	}
+	_, err = s.q().Exec(
+		query, b.ID.String(), b.BidType, b.Account, b.Symbol, b.Price, b.Amount,
+		b.NSF, b.Cancelled, b.Held, b.TimeInForce, expires, b.StopPrice,
+		b.IsActive(), seq,
+	)
+	return s.dbErr(err)
+}
+
+func (s *SQLStorage) AddBid(b Bid) (uuid.UUID, error) {
+	if s.err != nil {
+		return uuid.Nil, s.err
+	}
+	if !s.knownType(b.BidType) {
+		return uuid.Nil, ErrUnknownOrderType
+	}
+	b.ID = uuid.New()
+	seq, err := s.next("order")
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return b.ID, s.writeBid(true, b, seq)
+}
+
+func (s *SQLStorage) UpdateBid(b Bid) error {
+	found, seqs, err := s.queryBids(`WHERE id = $1`, b.ID.String())
+	if err != nil {
+		return err
+	}
+	insert := len(found) == 0
+	var old Bid
+	var seq uint64
+	if !insert {
+		old, seq = found[0], seqs[0]
+	}
+	if requeue(old.IsActive(), b.IsActive(), old.BidType, b.BidType, old.Price, b.Price, old.Amount, b.Amount) {
+		if b.IsActive() && !s.knownType(b.BidType) {
+			return ErrUnknownOrderType
+		}
+		if seq, err = s.next("order"); err != nil {
+			return err
+		}
+	}
+	return s.writeBid(insert, b, seq)
+}
+
+func (s *SQLStorage) GetBid(id uuid.UUID) (Bid, error) {
+	bids, _, err := s.queryBids(`WHERE id = $1`, id.String())
+	if err != nil {
+		return Bid{}, err
+	}
+	if len(bids) == 0 {
+		return Bid{}, ErrOrderNotFound
+	}
+	return bids[0], nil
+}
+
+// bidPrice returns the price a bid is offering, as the
+// order book would rank it
+func (s *SQLStorage) bidPrice(b Bid, lastPrice int64) (int64, error) {
+	switch b.BidType {
+	case OrderTypeLimit:
+		return b.Price, nil
+	case OrderTypeMarket:
+		return lastPrice, nil
+	}
+	p, found := s.pricers[b.BidType]
+	if !found {
+		return 0, ErrUnknownOrderType
+	}
+	return p.GetBidPrice(s, b)
+}
+
+// rankBids sorts bids into the order they would be filled
+func (s *SQLStorage) rankBids(bids []Bid, seqs []uint64, lastPrice int64) ([]Bid, error) {
+	side := makeBookSide(func(a, b int64) bool { return a > b })
+	order, err := rankOrder(seqs, &side, func(i int) (int64, error) {
+		return s.bidPrice(bids[i], lastPrice)
+	})
+	if err != nil {
+		return nil, err
+	}
+	ranked := make([]Bid, len(order))
+	for i, j := range order {
+		ranked[i] = bids[j]
+	}
+	return ranked, nil
+}
+
+// bookBids are the conditions for bids that are in the
+// order book rather than waiting to be triggered
+const bookBids = `symbol = $1 AND active AND bid_type NOT IN ($2, $3)`
+
+func (s *SQLStorage) BestBid(sym string) (Bid, bool, error) {
+	lastPrice, err := s.LastPrice(sym)
+	if err != nil {
+		return Bid{}, false, err
+	}
+	var bids []Bid
+	var seqs []uint64
+	for _, rest := range []string{
+		`WHERE ` + bookBids + ` AND bid_type = $4 ORDER BY price DESC, seq LIMIT 1`,
+		`WHERE ` + bookBids + ` AND bid_type = $5 ORDER BY seq LIMIT 1`,
+		`WHERE ` + bookBids + ` AND bid_type NOT IN ($4, $5)`,
+	} {
+		found, foundSeqs, err := s.queryBids(
+			rest, sym, OrderTypeStopMarket, OrderTypeStopLimit, OrderTypeLimit, OrderTypeMarket,
+		)
+		if err != nil {
+			return Bid{}, false, err
+		}
+		bids = append(bids, found...)
+		seqs = append(seqs, foundSeqs...)
+	}
+	if len(bids) == 0 {
+		return Bid{}, false, nil
+	}
+	bids, err = s.rankBids(bids, seqs, lastPrice)
+	if err != nil {
+		return Bid{}, false, err
+	}
+	return bids[0], true, nil
+}
+
+func (s *SQLStorage) RestingBids(sym string) ([]Bid, error) {
+	lastPrice, err := s.LastPrice(sym)
+	if err != nil {
+		return nil, err
+	}
+	bids, seqs, err := s.queryBids(
+		`WHERE `+bookBids, sym, OrderTypeStopMarket, OrderTypeStopLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return s.rankBids(bids, seqs, lastPrice)
+}
+
+func (s *SQLStorage) StopBids(sym string) ([]Bid, error) {
+	bids, _, err := s.queryBids(
+		`WHERE symbol = $1 AND active AND bid_type IN ($2, $3) ORDER BY seq`,
+		sym, OrderTypeStopMarket, OrderTypeStopLimit,
+	)
+	return bids, err
+}
+
+func (s *SQLStorage) NewTransaction(t Transaction) (uuid.UUID, error) {
+	if s.err != nil {
+		return uuid.Nil, s.err
+	}
+	t.ID = uuid.New()
+	date, err := toNanos(t.Date)
+	if err != nil {
+		return uuid.Nil, s.dbErr(err)
+	}
+	n, err := s.next("transaction")
+	if err != nil {
+		return uuid.Nil, err
+	}
+	_, err = s.q().Exec(
+		`INSERT INTO transactions (id, n, bid_id, offer_id, symbol, bid_account,
+			offer_account, price, amount, date, bid_fee, offer_fee)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		t.ID.String(), n, t.BidID.String(), t.OfferID.String(), t.Symbol, t.BidAccount,
+		t.OfferAccount, t.Price, t.Amount, date, t.BidFee, t.OfferFee,
+	)
+	return t.ID, s.dbErr(err)
+}
+
+// transactionQuery returns SQL and arguments that select
+// the transactions matching f
+func transactionQuery(f TransactionFilter) (string, []interface{}) {
+	var where []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if f.Symbol != "" {
+		where = append(where, "symbol = "+arg(f.Symbol))
+	}
+	if len(f.Accounts) > 0 {
+		var list []string
+		for _, a := range f.Accounts {
+			list = append(list, arg(a))
+		}
+		in := strings.Join(list, ", ")
+		where = append(where, "(bid_account IN ("+in+") OR offer_account IN ("+in+"))")
+	}
+	if f.OrderID != uuid.Nil {
+		id := arg(f.OrderID.String())
+		where = append(where, "(bid_id = "+id+" OR offer_id = "+id+")")
+	}
+	if !f.Since.IsZero() {
+		where = append(where, "date >= "+arg(boundNanos(f.Since)))
+	}
+	if !f.Until.IsZero() {
+		where = append(where, "date < "+arg(boundNanos(f.Until)))
+	}
+	query := `SELECT id, bid_id, offer_id, symbol, bid_account, offer_account,
+		price, amount, date, bid_fee, offer_fee FROM transactions`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	limit := int64(f.Limit)
+	if limit < 1 {
+		limit = math.MaxInt64
+	}
+	query += " ORDER BY n LIMIT " + arg(limit) + " OFFSET " + arg(f.Offset)
+	return query, args
+}
+
+func (s *SQLStorage) Transactions(f TransactionFilter) ([]Transaction, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	query, args := transactionQuery(f)
+	rows, err := s.q().Query(query, args...)
+	if err != nil {
+		return nil, s.dbErr(err)
+	}
+	defer rows.Close()
+	var rv []Transaction
+	for rows.Next() {
+		var tx Transaction
+		var id, bidID, offerID string
+		var date int64
+		err := rows.Scan(
+			&id, &bidID, &offerID, &tx.Symbol, &tx.BidAccount, &tx.OfferAccount,
+			&tx.Price, &tx.Amount, &date, &tx.BidFee, &tx.OfferFee,
+		)
+		if err != nil {
+			return nil, s.dbErr(err)
+		}
+		for _, f := range []struct {
+			text string
+			id   *uuid.UUID
+		}{{id, &tx.ID}, {bidID, &tx.BidID}, {offerID, &tx.OfferID}} {
+			if *f.id, err = uuid.Parse(f.text); err != nil {
+				return nil, s.dbErr(err)
+			}
+		}
+		tx.Date = fromNanos(date)
+		rv = append(rv, tx)
+	}
+	return rv, s.dbErr(rows.Err())
+}
+
+func (s *SQLStorage) LastPrice(symbol string) (int64, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	var price int64
+	err := s.q().QueryRow(`SELECT price FROM last_prices WHERE symbol = $1`, symbol).Scan(&price)
+	if err == sql.ErrNoRows {
+		return 1, nil
+	}
+	return price, s.dbErr(err)
+}
+
+func (s *SQLStorage) SetLastPrice(symbol string, price int64) error {
+	if s.err != nil {
+		return s.err
+	}
+	_, err := s.q().Exec(
+		`INSERT INTO last_prices (symbol, price) VALUES ($1, $2)
+			ON CONFLICT (symbol) DO UPDATE SET price = excluded.price`,
+		symbol, price,
+	)
+	return s.dbErr(err)
+}
+
+func (s *SQLStorage) AllSymbols() ([]string, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	rows, err := s.q().Query(`SELECT symbol FROM last_prices UNION SELECT symbol FROM offers
+		UNION SELECT symbol FROM bids`)
+	if err != nil {
+		return nil, s.dbErr(err)
+	}
+	defer rows.Close()
+	var rv []string
+	for rows.Next() {
+		var symbol string
+		if err := rows.Scan(&symbol); err != nil {
+			return nil, s.dbErr(err)
+		}
+		rv = append(rv, symbol)
+	}
+	return rv, s.dbErr(rows.Err())
+}
+
+const candleColumns = `symbol, interval_ns, start_ns, open_price, high_price,
+	low_price, close_price, volume, total_value`
+
+func (s *SQLStorage) queryCandles(rest string, args ...interface{}) ([]Candle, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	rows, err := s.q().Query(`SELECT `+candleColumns+` FROM candles `+rest, args...)
+	if err != nil {
+		return nil, s.dbErr(err)
+	}
+	defer rows.Close()
+	var rv []Candle
+	for rows.Next() {
+		var c Candle
+		var start int64
+		err := rows.Scan(
+			&c.Symbol, &c.Interval, &start, &c.Open, &c.High, &c.Low, &c.Close, &c.Volume, &c.Value,
+		)
+		if err != nil {
+			return nil, s.dbErr(err)
+		}
+		c.Start = fromNanos(start)
+		rv = append(rv, c)
+	}
+	return rv, s.dbErr(rows.Err())
+}
+
+func (s *SQLStorage) Candle(
+	symbol string, interval time.Duration, start time.Time,
+) (Candle, bool, error) {
+	startNanos, err := toNanos(start)
+	if err != nil {
+		// SetCandle can't have stored it
+		return Candle{}, false, nil
+	}
+	candles, err := s.queryCandles(
+		`WHERE symbol = $1 AND interval_ns = $2 AND start_ns = $3`,
+		symbol, int64(interval), startNanos,
+	)
+	if err != nil || len(candles) == 0 {
+		return Candle{}, false, err
+	}
+	return candles[0], true, nil
+}
+
+func (s *SQLStorage) SetCandle(c Candle) error {
+	if s.err != nil {
+		return s.err
+	}
+	start, err := toNanos(c.Start)
+	if err != nil {
+		return s.dbErr(err)
+	}
+	_, err = s.q().Exec(
+		`INSERT INTO candles (`+candleColumns+`)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			ON CONFLICT (symbol, interval_ns, start_ns) DO UPDATE SET
+			open_price = excluded.open_price, high_price = excluded.high_price,
+			low_price = excluded.low_price, close_price = excluded.close_price,
+			volume = excluded.volume, total_value = excluded.total_value`,
+		c.Symbol, int64(c.Interval), start, c.Open, c.High, c.Low, c.Close, c.Volume, c.Value,
+	)
+	return s.dbErr(err)
+}
+
+func (s *SQLStorage) DeleteCandles(symbol string) error {
+	if s.err != nil {
+		return s.err
+	}
+	_, err := s.q().Exec(`DELETE FROM candles WHERE symbol = $1`, symbol)
+	return s.dbErr(err)
+}
+
+func (s *SQLStorage) Candles(
+	symbol string, interval time.Duration, since, until time.Time,
+) ([]Candle, error) {
+	var sinceNanos, untilNanos int64 = math.MinInt64, math.MaxInt64
+	if !since.IsZero() {
+		sinceNanos = boundNanos(since)
+	}
+	if !until.IsZero() {
+		untilNanos = boundNanos(until)
+	}
+	return s.queryCandles(
+		`WHERE symbol = $1 AND interval_ns = $2 AND start_ns >= $3 AND start_ns < $4
+			ORDER BY start_ns`,
+		symbol, int64(interval), sinceNanos, untilNanos,
+	)
+}
@@ -0,0 +1,5 @@
+// Package sqltest tests economy.SQLStorage against SQLite.
+// It is a module of its own so that the SQLite driver is
+// only needed to run these tests, and isn't a dependency
+// of economy. Run them from this directory with go test.
+package sqltest
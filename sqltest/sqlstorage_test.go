@@ -0,0 +1,338 @@
+package sqltest
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/williammoran/economy"
+	_ "modernc.org/sqlite"
+)
+
+const sym = "S"
+
+// testAccounts let balances go negative, so every bid can
+// pay
+type testAccounts map[int64]int64
+
+func (a testAccounts) Credit(accountID, funds int64) {
+	a[accountID] += funds
+}
+
+func (a testAccounts) DebitIfPossible(accountID, funds int64) bool {
+	a[accountID] -= funds
+	return true
+}
+
+const orderTypePegged economy.OrderType = 10
+
+// peggedProcessor prices offers at their Price above the
+// last price, and bids at their Price below it
+type peggedProcessor struct{}
+
+func (p peggedProcessor) TryFillBid(economy.MarketStorage, *economy.Settlement, map[economy.OrderType]economy.OrderProcessor, economy.Bid) error {
+	return nil
+}
+
+func (p peggedProcessor) TrySell(economy.MarketStorage, *economy.Settlement, map[economy.OrderType]economy.OrderProcessor, economy.Offer) error {
+	return nil
+}
+
+func (p peggedProcessor) GetAskingPrice(ms economy.MarketStorage, o economy.Offer) (int64, error) {
+	lastPrice, err := ms.LastPrice(o.Symbol)
+	return lastPrice + o.Price, err
+}
+
+func (p peggedProcessor) GetBidPrice(ms economy.MarketStorage, b economy.Bid) (int64, error) {
+	lastPrice, err := ms.LastPrice(b.Symbol)
+	return lastPrice - b.Price, err
+}
+
+func openDB(t *testing.T, path string) *sql.DB {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func openSQLite(t *testing.T, path string) *economy.SQLStorage {
+	s, err := economy.OpenSQLStorage(openDB(t, path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func makeSQLStorage(t *testing.T) *economy.SQLStorage {
+	return openSQLite(t, filepath.Join(t.TempDir(), "market.db"))
+}
+
+func TestSQLMigrationsAreIdempotent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "market.db")
+	s := openSQLite(t, path)
+	s.SetLastPrice(sym, 42)
+	s = openSQLite(t, path)
+	if price, err := s.LastPrice(sym); err != nil || price != 42 {
+		t.Fatalf("%d %v", price, err)
+	}
+}
+
+func TestSQLBestOfferAndBid(t *testing.T) {
+	s := makeSQLStorage(t)
+	s.SetLastPrice(sym, 6)
+	s.AddOffer(economy.Offer{Symbol: sym, Amount: 1, OfferType: economy.OrderTypeLimit, Price: 7})
+	first, _ := s.AddOffer(economy.Offer{Symbol: sym, Amount: 1, OfferType: economy.OrderTypeLimit, Price: 5})
+	s.AddOffer(economy.Offer{Symbol: sym, Amount: 1, OfferType: economy.OrderTypeLimit, Price: 5})
+	s.AddOffer(economy.Offer{Symbol: "other", Amount: 1, OfferType: economy.OrderTypeLimit, Price: 1})
+	o, found, err := s.BestOffer(sym)
+	if err != nil || !found || o.ID != first {
+		t.Fatalf("%+v %t %v", o, found, err)
+	}
+	market, _ := s.AddBid(economy.Bid{Symbol: sym, Amount: 1, BidType: economy.OrderTypeMarket})
+	s.AddBid(economy.Bid{Symbol: sym, Amount: 1, BidType: economy.OrderTypeLimit, Price: 6})
+	b, found, _ := s.BestBid(sym)
+	if !found || b.ID != market {
+		t.Fatalf("Market bid at the last price arrived first: %+v", b)
+	}
+	limit, _ := s.AddBid(economy.Bid{Symbol: sym, Amount: 1, BidType: economy.OrderTypeLimit, Price: 8})
+	if b, _, _ = s.BestBid(sym); b.ID != limit {
+		t.Fatalf("%+v", b)
+	}
+	if _, found, _ = s.BestBid("none"); found {
+		t.Fatal("Found bid for unknown symbol")
+	}
+}
+
+func TestSQLUpdateKeepsPriorityForPartialFills(t *testing.T) {
+	s := makeSQLStorage(t)
+	first, _ := s.AddOffer(economy.Offer{Symbol: sym, Amount: 5, OfferType: economy.OrderTypeLimit, Price: 5})
+	second, _ := s.AddOffer(economy.Offer{Symbol: sym, Amount: 5, OfferType: economy.OrderTypeLimit, Price: 5})
+	o, _ := s.GetOffer(first)
+	o.Amount = 3
+	s.UpdateOffer(o)
+	if best, _, _ := s.BestOffer(sym); best.ID != first || best.Amount != 3 {
+		t.Fatalf("%+v", best)
+	}
+	o.Amount = 4
+	s.UpdateOffer(o)
+	if best, _, _ := s.BestOffer(sym); best.ID != second {
+		t.Fatalf("Increased offer kept priority: %+v", best)
+	}
+	o.Cancelled = true
+	s.UpdateOffer(o)
+	if offers, _ := s.RestingOffers(sym); len(offers) != 1 {
+		t.Fatalf("%+v", offers)
+	}
+}
+
+func TestSQLGetNotFound(t *testing.T) {
+	s := makeSQLStorage(t)
+	if _, err := s.GetBid(uuid.New()); err != economy.ErrOrderNotFound {
+		t.Fatalf("Expected ErrOrderNotFound, got %v", err)
+	}
+	if _, err := s.GetOffer(uuid.New()); err != economy.ErrOrderNotFound {
+		t.Fatalf("Expected ErrOrderNotFound, got %v", err)
+	}
+	if _, err := s.AddBid(economy.Bid{Symbol: sym, Amount: 1, BidType: 99}); err != economy.ErrUnknownOrderType {
+		t.Fatalf("Expected ErrUnknownOrderType, got %v", err)
+	}
+}
+
+func TestSQLMarketTrades(t *testing.T) {
+	s := makeSQLStorage(t)
+	accounts := testAccounts{}
+	now := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+	m := economy.MakeMarket(func() time.Time { return now }, s, accounts)
+	m.Offer(economy.Offer{Symbol: sym, Account: 2, Amount: 10, OfferType: economy.OrderTypeLimit, Price: 5})
+	report, err := m.Bid(economy.Bid{Symbol: sym, Account: 1, Amount: 4, BidType: economy.OrderTypeLimit, Price: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Status != economy.OrderStatusFilled || report.Filled() != 4 {
+		t.Fatalf("%+v", report)
+	}
+	if accounts[1] != -20 || accounts[2] != 20 {
+		t.Fatalf("%+v", accounts)
+	}
+	txs, err := m.Transactions(economy.TransactionFilter{Accounts: []int64{2}, Symbol: sym})
+	if err != nil || len(txs) != 1 || txs[0].Amount != 4 || !txs[0].Date.Equal(now) {
+		t.Fatalf("%+v %v", txs, err)
+	}
+	if txs, _ = m.Transactions(economy.TransactionFilter{Accounts: []int64{3}}); len(txs) != 0 {
+		t.Fatalf("%+v", txs)
+	}
+	d, _ := m.Depth(sym, 0)
+	if len(d.Offers) != 1 || d.Offers[0].Amount != 6 || d.LastPrice != 5 {
+		t.Fatalf("%+v", d)
+	}
+	candles, err := m.Candles(sym, economy.CandleMinute, time.Time{}, time.Time{})
+	if err != nil || len(candles) != 1 || candles[0].Volume != 4 || !candles[0].Start.Equal(now) {
+		t.Fatalf("%+v %v", candles, err)
+	}
+	symbols, _ := m.Symbols()
+	if len(symbols) != 1 || symbols[0] != sym {
+		t.Fatalf("%+v", symbols)
+	}
+	m.Bid(economy.Bid{Symbol: "other", Account: 1, Amount: 1, BidType: economy.OrderTypeLimit, Price: 1})
+	if symbols, _ = m.Symbols(); len(symbols) != 2 {
+		t.Fatalf("Symbol with only a bid missing: %+v", symbols)
+	}
+}
+
+func TestSQLRebuildCandles(t *testing.T) {
+	s := makeSQLStorage(t)
+	now := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+	m := economy.MakeMarket(func() time.Time { return now }, s, testAccounts{})
+	m.Offer(economy.Offer{Symbol: sym, Account: 2, Amount: 4, OfferType: economy.OrderTypeLimit, Price: 5})
+	m.Bid(economy.Bid{Symbol: sym, Account: 1, Amount: 4, BidType: economy.OrderTypeLimit, Price: 5})
+	s.SetCandle(economy.Candle{Symbol: sym, Interval: economy.CandleMinute, Start: now.Add(-time.Hour), Volume: 7})
+	for i := 0; i < 2; i++ {
+		if err := m.RebuildCandles(); err != nil {
+			t.Fatal(err)
+		}
+		candles, err := m.Candles(sym, economy.CandleMinute, time.Time{}, time.Time{})
+		if err != nil || len(candles) != 1 || candles[0].Volume != 4 {
+			t.Fatalf("%+v %v", candles, err)
+		}
+	}
+}
+
+func TestSQLTransactionPaging(t *testing.T) {
+	s := makeSQLStorage(t)
+	for i := 0; i < 5; i++ {
+		s.NewTransaction(economy.Transaction{Symbol: sym, Price: int64(i), Amount: 1})
+	}
+	txs, err := s.Transactions(economy.TransactionFilter{Offset: 1, Limit: 2})
+	if err != nil || len(txs) != 2 || txs[0].Price != 1 || txs[1].Price != 2 {
+		t.Fatalf("%+v %v", txs, err)
+	}
+	if txs, _ = s.Transactions(economy.TransactionFilter{Offset: 3}); len(txs) != 2 {
+		t.Fatalf("%+v", txs)
+	}
+}
+
+func TestSQLStopsAndCustomTypes(t *testing.T) {
+	s := makeSQLStorage(t)
+	s.SetLastPrice(sym, 5)
+	m := economy.MakeMarket(time.Now, s, testAccounts{})
+	if err := m.RegisterOrderType(orderTypePegged, peggedProcessor{}); err != nil {
+		t.Fatal(err)
+	}
+	m.Offer(economy.Offer{Symbol: sym, Account: 2, Amount: 1, OfferType: economy.OrderTypeLimit, Price: 8})
+	pegged, _ := m.Offer(economy.Offer{Symbol: sym, Account: 3, Amount: 1, OfferType: orderTypePegged, Price: 2})
+	stop, _ := m.Bid(economy.Bid{Symbol: sym, Account: 4, Amount: 1, BidType: economy.OrderTypeStopMarket, StopPrice: 7})
+	if stops, _ := s.StopBids(sym); len(stops) != 1 {
+		t.Fatalf("%+v", stops)
+	}
+	report, _ := m.Bid(economy.Bid{Symbol: sym, Account: 1, Amount: 1, BidType: economy.OrderTypeLimit, Price: 7})
+	if report.Transactions[0].OfferID != pegged.OrderID {
+		t.Fatalf("%+v", report)
+	}
+	b, _ := m.FindBid(stop.OrderID)
+	if b.BidType != economy.OrderTypeMarket || b.IsActive() {
+		t.Fatalf("Stop not triggered: %+v", b)
+	}
+}
+
+func TestSQLRollsBackOnError(t *testing.T) {
+	s := makeSQLStorage(t)
+	s.Lock()
+	s.AddOffer(economy.Offer{Symbol: sym, Amount: 1, OfferType: economy.OrderTypeLimit, Price: 5})
+	s.AddOffer(economy.Offer{
+		Symbol: sym, Amount: 1, OfferType: economy.OrderTypeLimit, Price: 5,
+		Expires: time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+	if _, err := s.LastPrice(sym); err == nil {
+		t.Fatal("Call after failure succeeded")
+	}
+	s.Unlock()
+	if err := s.Err(); !errors.Is(err, economy.ErrTimeOutOfRange) {
+		t.Fatalf("Expected ErrTimeOutOfRange, got %v", err)
+	}
+	if _, found, _ := s.BestOffer(sym); found {
+		t.Fatal("Offer was committed")
+	}
+	s.Lock()
+	s.AddOffer(economy.Offer{Symbol: sym, Amount: 1, OfferType: economy.OrderTypeLimit, Price: 5})
+	s.Unlock()
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, _ := s.BestOffer(sym); !found {
+		t.Fatal("Offer was not committed")
+	}
+}
+
+func TestSQLTimesOutOfRange(t *testing.T) {
+	s := makeSQLStorage(t)
+	far := time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := s.AddBid(economy.Bid{Symbol: sym, Amount: 1, BidType: economy.OrderTypeLimit, Price: 5, Expires: far}); !errors.Is(err, economy.ErrTimeOutOfRange) {
+		t.Fatalf("Expected ErrTimeOutOfRange, got %v", err)
+	}
+	if _, err := s.NewTransaction(economy.Transaction{Symbol: sym, Price: 5, Amount: 1, Date: far}); !errors.Is(err, economy.ErrTimeOutOfRange) {
+		t.Fatalf("Expected ErrTimeOutOfRange, got %v", err)
+	}
+	if err := s.SetCandle(economy.Candle{Symbol: sym, Interval: economy.CandleMinute, Start: far}); !errors.Is(err, economy.ErrTimeOutOfRange) {
+		t.Fatalf("Expected ErrTimeOutOfRange, got %v", err)
+	}
+	now := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+	s.NewTransaction(economy.Transaction{Symbol: sym, Price: 5, Amount: 1, Date: now})
+	s.SetCandle(economy.Candle{Symbol: sym, Interval: economy.CandleMinute, Start: now})
+	long := time.Date(1000, 1, 1, 0, 0, 0, 0, time.UTC)
+	if txs, err := s.Transactions(economy.TransactionFilter{Since: long, Until: far}); err != nil || len(txs) != 1 {
+		t.Fatalf("%+v %v", txs, err)
+	}
+	if candles, err := s.Candles(sym, economy.CandleMinute, long, far); err != nil || len(candles) != 1 {
+		t.Fatalf("%+v %v", candles, err)
+	}
+	if _, found, err := s.Candle(sym, economy.CandleMinute, far); err != nil || found {
+		t.Fatalf("%t %v", found, err)
+	}
+}
+
+func TestSQLBadTransactionRollsBack(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "market.db")
+	db := openDB(t, path)
+	s, err := economy.OpenSQLStorage(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(
+		`INSERT INTO transactions (id, n, bid_id, offer_id, symbol, bid_account,
+			offer_account, price, amount, date, bid_fee, offer_fee)
+			VALUES ('bad', 1, 'bad', 'bad', 'S', 1, 2, 5, 1, 0, 0, 0)`,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Lock()
+	defer s.Unlock()
+	if _, err = s.Transactions(economy.TransactionFilter{}); err == nil {
+		t.Fatal("Bad transaction ID was read")
+	}
+	if _, err = s.LastPrice(sym); err == nil {
+		t.Fatal("Call after failure succeeded")
+	}
+}
+
+func TestSQLMarketReportsFailedTransaction(t *testing.T) {
+	db := openDB(t, filepath.Join(t.TempDir(), "market.db"))
+	s, err := economy.OpenSQLStorage(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := economy.MakeMarket(time.Now, s, testAccounts{})
+	db.Close()
+	if _, err = m.Offer(economy.Offer{Symbol: sym, Account: 2, Amount: 3, OfferType: economy.OrderTypeLimit, Price: 5}); err == nil {
+		t.Fatal("Expected the offer to fail")
+	}
+	if err = s.Err(); err == nil {
+		t.Fatal("Expected Err to report the failure")
+	}
+}
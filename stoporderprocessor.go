@@ -0,0 +1,156 @@
+package economy
+
+import "time"
+
+// stopOrderProcessor handles stop orders, which are kept
+// out of the order book until the last price reaches
+// their StopPrice. Once triggered, a stop order becomes a
+// market or limit order and is handed to the processor
+// for that type.
+type stopOrderProcessor struct {
+	now func() time.Time
+}
+
+// triggeredType returns the order type a stop order
+// becomes when it is triggered
+func triggeredType(t OrderType) OrderType {
+	if t == OrderTypeStopLimit {
+		return OrderTypeLimit
+	}
+	return OrderTypeMarket
+}
+
+// Triggered returns true if a stop bid would be triggered
+// at lastPrice
+func (b Bid) Triggered(lastPrice int64) bool {
+	return lastPrice >= b.StopPrice
+}
+
+// Triggered returns true if a stop offer would be
+// triggered at lastPrice
+func (o Offer) Triggered(lastPrice int64) bool {
+	return lastPrice <= o.StopPrice
+}
+
+func (m *stopOrderProcessor) TryFillBid(
+	ms MarketStorage,
+	s *Settlement,
+	opl map[OrderType]OrderProcessor,
+	bid Bid,
+) error {
+	ts := m.now()
+	if bid.Expired(ts) {
+		return s.cancelBid(ms, ts, bid, EventExpired)
+	}
+	lastPrice, err := ms.LastPrice(bid.Symbol)
+	if err != nil || !bid.Triggered(lastPrice) {
+		return err
+	}
+	bid.BidType = triggeredType(bid.BidType)
+	p, found := opl[bid.BidType]
+	if !found {
+		return ErrUnknownOrderType
+	}
+	if err = ms.UpdateBid(bid); err != nil {
+		return err
+	}
+	s.events.publish(bidEvent(EventTriggered, ts, bid))
+	return p.TryFillBid(ms, s, opl, bid)
+}
+
+func (m *stopOrderProcessor) TrySell(
+	ms MarketStorage,
+	s *Settlement,
+	opl map[OrderType]OrderProcessor,
+	offer Offer,
+) error {
+	ts := m.now()
+	if offer.Expired(ts) {
+		return s.cancelOffer(ms, ts, offer, EventExpired)
+	}
+	lastPrice, err := ms.LastPrice(offer.Symbol)
+	if err != nil || !offer.Triggered(lastPrice) {
+		return err
+	}
+	offer.OfferType = triggeredType(offer.OfferType)
+	p, found := opl[offer.OfferType]
+	if !found {
+		return ErrUnknownOrderType
+	}
+	if err = ms.UpdateOffer(offer); err != nil {
+		return err
+	}
+	s.events.publish(offerEvent(EventTriggered, ts, offer))
+	return p.TrySell(ms, s, opl, offer)
+}
+
+// GetAskingPrice is only called for orders in the order
+// book, which stop orders never are, so it returns the
+// price the offer would have once triggered
+func (m *stopOrderProcessor) GetAskingPrice(ms MarketStorage, o Offer) (int64, error) {
+	if o.OfferType == OrderTypeStopLimit {
+		return o.Price, nil
+	}
+	return ms.LastPrice(o.Symbol)
+}
+
+// GetBidPrice is GetAskingPrice for bids
+func (m *stopOrderProcessor) GetBidPrice(ms MarketStorage, b Bid) (int64, error) {
+	if b.BidType == OrderTypeStopLimit {
+		return b.Price, nil
+	}
+	return ms.LastPrice(b.Symbol)
+}
+
+// triggerStops runs every stop order for the symbol that
+// the last price has reached. Each triggered order can
+// move the price again, so the stops are checked again
+// after each one until none are triggered.
+func (m *Market) triggerStops(symbol string) error {
+	for {
+		if m.settlement.Halted(symbol) {
+			return nil
+		}
+		triggered, err := m.triggerStop(symbol)
+		if err != nil || !triggered {
+			return err
+		}
+	}
+}
+
+// triggerStop runs the first stop order for the symbol
+// that the last price has reached, and returns false if
+// there isn't one
+func (m *Market) triggerStop(symbol string) (bool, error) {
+	lastPrice, err := m.storage.LastPrice(symbol)
+	if err != nil {
+		return false, err
+	}
+	bids, err := m.storage.StopBids(symbol)
+	if err != nil {
+		return false, err
+	}
+	for _, bid := range bids {
+		if bid.Triggered(lastPrice) {
+			p, err := m.processor(bid.BidType)
+			if err != nil {
+				return false, err
+			}
+			return true, m.tryFillBid(p, bid)
+		}
+	}
+	offers, err := m.storage.StopOffers(symbol)
+	if err != nil {
+		return false, err
+	}
+	for _, offer := range offers {
+		if offer.Triggered(lastPrice) {
+			p, err := m.processor(offer.OfferType)
+			if err != nil {
+				return false, err
+			}
+			return true, m.trySell(p, offer)
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,148 @@
+package economy
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestStopBidDormantUntilTriggered(t *testing.T) {
+	s := MakeMemoryStorage()
+	s.SetLastPrice(sym, 5)
+	m := MakeMarket(time.Now, s, makeMockAccounts())
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 1, OfferType: OrderTypeLimit, Price: 6})
+	m.Offer(Offer{Symbol: sym, Account: 3, Amount: 2, OfferType: OrderTypeLimit, Price: 7})
+	report, err := m.Bid(Bid{Symbol: sym, Account: 1, Amount: 2, BidType: OrderTypeStopMarket, StopPrice: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Status != OrderStatusResting {
+		t.Fatalf("%+v", report)
+	}
+	stop := report.OrderID
+	if d, _ := m.Depth(sym, 0); len(d.Bids) != 0 {
+		t.Fatalf("Dormant stop in book: %+v", d)
+	}
+	var events []Event
+	m.Subscribe(func(e Event) { events = append(events, e) })
+	report, _ = m.Bid(Bid{Symbol: sym, Account: 4, Amount: 1, BidType: OrderTypeLimit, Price: 6})
+	if report.Status != OrderStatusFilled || len(report.Transactions) != 1 {
+		t.Fatalf("%+v", report)
+	}
+	b, _ := m.FindBid(stop)
+	if b.BidType != OrderTypeMarket || b.IsActive() {
+		t.Fatalf("%+v", b)
+	}
+	triggered := false
+	for _, e := range events {
+		if e.Type == EventTriggered && e.OrderID == stop {
+			triggered = true
+		}
+	}
+	if !triggered {
+		t.Fatalf("%+v", events)
+	}
+	if price, _ := m.Price(sym); price != 7 {
+		t.Fatalf("Last price %d", price)
+	}
+}
+
+func TestStopLimitOfferRestsAfterTrigger(t *testing.T) {
+	s := MakeMemoryStorage()
+	s.SetLastPrice(sym, 10)
+	m := MakeMarket(time.Now, s, makeMockAccounts())
+	report, _ := m.Offer(Offer{Symbol: sym, Account: 2, Amount: 5, OfferType: OrderTypeStopLimit, StopPrice: 8, Price: 9})
+	stop := report.OrderID
+	m.Offer(Offer{Symbol: sym, Account: 3, Amount: 1, OfferType: OrderTypeLimit, Price: 9})
+	m.Bid(Bid{Symbol: sym, Account: 1, Amount: 1, BidType: OrderTypeLimit, Price: 9})
+	if o, _ := m.FindOffer(stop); o.OfferType != OrderTypeStopLimit {
+		t.Fatalf("Triggered early: %+v", o)
+	}
+	m.Offer(Offer{Symbol: sym, Account: 3, Amount: 1, OfferType: OrderTypeLimit, Price: 8})
+	m.Bid(Bid{Symbol: sym, Account: 1, Amount: 1, BidType: OrderTypeLimit, Price: 8})
+	o, _ := m.FindOffer(stop)
+	if o.OfferType != OrderTypeLimit || o.Amount != 5 {
+		t.Fatalf("%+v", o)
+	}
+	if d, _ := m.Depth(sym, 0); len(d.Offers) != 1 || d.Offers[0].Price != 9 {
+		t.Fatalf("%+v", d)
+	}
+}
+
+func TestStopTriggeredOnArrival(t *testing.T) {
+	s := MakeMemoryStorage()
+	s.SetLastPrice(sym, 10)
+	m := MakeMarket(time.Now, s, makeMockAccounts())
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 1, OfferType: OrderTypeLimit, Price: 11})
+	report, _ := m.Bid(Bid{Symbol: sym, Account: 1, Amount: 1, BidType: OrderTypeStopLimit, StopPrice: 9, Price: 11})
+	if report.Status != OrderStatusFilled {
+		t.Fatalf("%+v", report)
+	}
+}
+
+func TestReportExcludesTriggeredTrades(t *testing.T) {
+	s := MakeMemoryStorage()
+	s.SetLastPrice(sym, 5)
+	m := MakeMarket(time.Now, s, makeMockAccounts())
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 1, OfferType: OrderTypeLimit, Price: 6})
+	m.Offer(Offer{Symbol: sym, Account: 3, Amount: 1, OfferType: OrderTypeLimit, Price: 7})
+	m.Bid(Bid{Symbol: sym, Account: 1, Amount: 1, BidType: OrderTypeStopMarket, StopPrice: 6})
+	report, _ := m.Bid(Bid{Symbol: sym, Account: 4, Amount: 1, BidType: OrderTypeLimit, Price: 6})
+	if len(report.Transactions) != 1 || report.Transactions[0].BidID != report.OrderID {
+		t.Fatalf("%+v", report)
+	}
+}
+
+func TestReportStatusIgnoresTriggeredTrades(t *testing.T) {
+	s := MakeMemoryStorage()
+	s.SetLastPrice(sym, 5)
+	m := MakeMarket(time.Now, s, makeMockAccounts())
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 1, OfferType: OrderTypeLimit, Price: 7})
+	m.Bid(Bid{Symbol: sym, Account: 1, Amount: 1, BidType: OrderTypeStopMarket, StopPrice: 6})
+	// The price moves without the stop being checked, so
+	// the next order triggers it without trading itself
+	s.SetLastPrice(sym, 6)
+	report, _ := m.Bid(Bid{Symbol: sym, Account: 4, Amount: 1, BidType: OrderTypeLimit, Price: 1})
+	if report.Status != OrderStatusResting || len(report.Transactions) != 0 {
+		t.Fatalf("%+v", report)
+	}
+	if p, _ := m.Price(sym); p != 7 {
+		t.Fatalf("Stop wasn't triggered, price %d", p)
+	}
+}
+
+func TestStopsSurviveSnapshot(t *testing.T) {
+	s := MakeMemoryStorage()
+	id, _ := s.AddBid(Bid{Symbol: sym, Account: 1, Amount: 2, BidType: OrderTypeStopLimit, StopPrice: 6, Price: 7})
+	s.AddOffer(Offer{Symbol: sym, Account: 2, Amount: 2, OfferType: OrderTypeStopMarket, StopPrice: 3})
+	var buf bytes.Buffer
+	s.Marshal(&buf)
+	loaded := MakeMemoryStorage()
+	if err := loaded.UnMarshal(&buf); err != nil {
+		t.Fatal(err)
+	}
+	bids, _ := loaded.StopBids(sym)
+	offers, _ := loaded.StopOffers(sym)
+	if len(bids) != 1 || bids[0].ID != id || bids[0].StopPrice != 6 || bids[0].Price != 7 {
+		t.Fatalf("%+v", bids)
+	}
+	if len(offers) != 1 || offers[0].StopPrice != 3 {
+		t.Fatalf("%+v", offers)
+	}
+	if _, found, _ := loaded.BestBid(sym); found {
+		t.Fatal("Stop bid in the order book")
+	}
+}
+
+func TestCancelledStopIsNotTriggered(t *testing.T) {
+	s := MakeMemoryStorage()
+	s.SetLastPrice(sym, 5)
+	m := MakeMarket(time.Now, s, makeMockAccounts())
+	report, _ := m.Bid(Bid{Symbol: sym, Account: 1, Amount: 1, BidType: OrderTypeStopMarket, StopPrice: 6})
+	if err := m.CancelBid(1, report.OrderID); err != nil {
+		t.Fatal(err)
+	}
+	if bids, _ := s.StopBids(sym); len(bids) != 0 {
+		t.Fatalf("%+v", bids)
+	}
+}
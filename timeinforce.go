@@ -0,0 +1,379 @@
+package economy
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TimeInForce controls how long an order stays in the
+// market
+type TimeInForce byte
+
+const (
+	// TimeInForceGTC orders rest until they are filled or
+	// cancelled. It is the default.
+	TimeInForceGTC TimeInForce = 0
+	// TimeInForceIOC orders trade as much as they can right
+	// away, and the rest is cancelled
+	TimeInForceIOC TimeInForce = 1
+	// TimeInForceFOK orders are cancelled without trading
+	// unless they can be filled completely right away
+	TimeInForceFOK TimeInForce = 2
+	// TimeInForceGTD orders rest until their Expires time,
+	// according to the Market's clock
+	TimeInForceGTD TimeInForce = 3
+)
+
+func (t TimeInForce) String() string {
+	switch t {
+	case TimeInForceGTC:
+		return "GTC"
+	case TimeInForceIOC:
+		return "IOC"
+	case TimeInForceFOK:
+		return "FOK"
+	case TimeInForceGTD:
+		return "GTD"
+	}
+	return "unknown"
+}
+
+// Expired returns true if the bid is good till a date
+// that is at or before now
+func (b Bid) Expired(now time.Time) bool {
+	return b.TimeInForce == TimeInForceGTD && !now.Before(b.Expires)
+}
+
+// Expired returns true if the offer is good till a date
+// that is at or before now
+func (o Offer) Expired(now time.Time) bool {
+	return o.TimeInForce == TimeInForceGTD && !now.Before(o.Expires)
+}
+
+// immediate returns true for time in force policies that
+// never leave an order resting
+func (t TimeInForce) immediate() bool {
+	return t == TimeInForceIOC || t == TimeInForceFOK
+}
+
+// cancelBid withdraws bid and returns any funds held for
+// it, publishing an event of type t
+func (s *Settlement) cancelBid(ms MarketStorage, ts time.Time, bid Bid, t EventType) error {
+	bid.Cancelled = true
+	held := bid
+	bid.Held = 0
+	if err := ms.UpdateBid(bid); err != nil {
+		return err
+	}
+	s.release(&held)
+	s.events.publish(bidEvent(t, ts, bid))
+	return nil
+}
+
+// cancelOffer withdraws off and returns its goods to the
+// seller, publishing an event of type t
+func (s *Settlement) cancelOffer(ms MarketStorage, ts time.Time, off Offer, t EventType) error {
+	off.Cancelled = true
+	if err := ms.UpdateOffer(off); err != nil {
+		return err
+	}
+	if s.holdings != nil {
+		s.holdings.Release(off.Account, off.Symbol, off.Amount)
+	}
+	s.events.publish(offerEvent(t, ts, off))
+	return nil
+}
+
+// fillPrice returns the price an incoming order would
+// trade at with a counter-order priced at price when the
+// last price is last, or false if they don't trade
+type fillPrice func(last, price int64) (int64, bool)
+
+// admitBid applies the bid's time in force before any
+// attempt to fill it. It returns false, having cancelled
+// the bid, if the bid has expired or is fill or kill and
+// can't be filled completely. Fill or kill bids are
+// checked against the offers they would trade with at the
+// prices fill gives, and killed if there aren't enough of
+// them, if the buyer can't pay for them all or if any of
+// the prices would halt trading, so that they never stop
+// partway. Buyers are only checked beyond the funds held
+// for their bids if Accounts is FundsAccounts.
+func (s *Settlement) admitBid(
+	ms MarketStorage,
+	opl map[OrderType]OrderProcessor,
+	ts time.Time,
+	bid Bid,
+	fill fillPrice,
+) (bool, error) {
+	if bid.Expired(ts) {
+		return false, s.cancelBid(ms, ts, bid, EventExpired)
+	}
+	if bid.TimeInForce != TimeInForceFOK {
+		return true, nil
+	}
+	offers, err := ms.RestingOffers(bid.Symbol)
+	if err != nil {
+		return false, err
+	}
+	f, err := s.makeFOKCheck(ms, bid.Symbol)
+	if err != nil {
+		return false, err
+	}
+	for _, off := range offers {
+		if f.filled >= bid.Amount {
+			break
+		}
+		if off.Expired(ts) || s.selfTrades(bid, off) {
+			continue
+		}
+		p, found := opl[off.OfferType]
+		if !found {
+			return false, ErrUnknownOrderType
+		}
+		ask, err := p.GetAskingPrice(ms, off)
+		if err != nil {
+			return false, err
+		}
+		price, ok := fill(f.last, ask)
+		if !ok {
+			continue
+		}
+		if !f.add(bid, price, off.Amount, bid.Amount) {
+			break
+		}
+	}
+	if f.complete(bid.Amount) {
+		return true, nil
+	}
+	return false, s.cancelBid(ms, ts, bid, EventCancelled)
+}
+
+// admitOffer is admitBid for offers. Fill or kill offers
+// are killed if any of the buyers they would trade with
+// can't pay.
+func (s *Settlement) admitOffer(
+	ms MarketStorage,
+	opl map[OrderType]OrderProcessor,
+	ts time.Time,
+	off Offer,
+	fill fillPrice,
+) (bool, error) {
+	if off.Expired(ts) {
+		return false, s.cancelOffer(ms, ts, off, EventExpired)
+	}
+	if off.TimeInForce != TimeInForceFOK {
+		return true, nil
+	}
+	bids, err := ms.RestingBids(off.Symbol)
+	if err != nil {
+		return false, err
+	}
+	f, err := s.makeFOKCheck(ms, off.Symbol)
+	if err != nil {
+		return false, err
+	}
+	for _, bid := range bids {
+		if f.filled >= off.Amount {
+			break
+		}
+		if bid.Expired(ts) || s.selfTrades(bid, off) {
+			continue
+		}
+		p, found := opl[bid.BidType]
+		if !found {
+			return false, ErrUnknownOrderType
+		}
+		bidPrice, err := p.GetBidPrice(ms, bid)
+		if err != nil {
+			return false, err
+		}
+		price, ok := fill(f.last, bidPrice)
+		if !ok {
+			continue
+		}
+		if !f.add(bid, price, bid.Amount, off.Amount) {
+			break
+		}
+	}
+	if f.complete(off.Amount) {
+		return true, nil
+	}
+	return false, s.cancelOffer(ms, ts, off, EventCancelled)
+}
+
+// fokCheck follows the fills a fill or kill order would
+// make without making them
+type fokCheck struct {
+	s      *Settlement
+	symbol string
+	last   int64
+	// reference is the band's reference price, which the
+	// first trade sets if there isn't one
+	reference    int64
+	hasReference bool
+	filled       int64
+	// owed is what each buyer would pay beyond the funds
+	// held for their bids, and held what is left of those
+	owed map[int64]int64
+	held map[uuid.UUID]int64
+	// outOfBand is set by a fill that would halt trading
+	outOfBand bool
+}
+
+func (s *Settlement) makeFOKCheck(ms MarketStorage, symbol string) (*fokCheck, error) {
+	last, err := ms.LastPrice(symbol)
+	if err != nil {
+		return nil, err
+	}
+	reference, found := s.reference[symbol]
+	return &fokCheck{
+		s:            s,
+		symbol:       symbol,
+		last:         last,
+		reference:    reference,
+		hasReference: found,
+		owed:         make(map[int64]int64),
+		held:         make(map[uuid.UUID]int64),
+	}, nil
+}
+
+// add counts a fill of up to available units at price
+// paid for by bid, towards an order for amount. It returns
+// false if the fill would halt trading.
+func (f *fokCheck) add(bid Bid, price, available, amount int64) bool {
+	if !f.hasReference {
+		f.reference, f.hasReference = price, true
+	}
+	if !f.s.withinBand(f.symbol, f.reference, price) {
+		f.outOfBand = true
+		return false
+	}
+	if left := amount - f.filled; available > left {
+		available = left
+	}
+	value := price * available
+	cost := value + f.s.fees.maxFee(f.symbol, value)
+	// Funds held for the bid are used first
+	held, found := f.held[bid.ID]
+	if !found {
+		held = bid.Held
+	}
+	if held >= cost {
+		f.held[bid.ID] = held - cost
+	} else {
+		f.held[bid.ID] = 0
+		f.owed[bid.Account] += cost - held
+	}
+	f.filled += available
+	f.last = price
+	return true
+}
+
+// complete returns true if the fills add up to amount and
+// the buyers can pay for them, as far as Accounts can say
+func (f *fokCheck) complete(amount int64) bool {
+	if f.outOfBand || f.filled < amount {
+		return false
+	}
+	funds, ok := f.s.accounts.(FundsAccounts)
+	if !ok {
+		return true
+	}
+	for account, owed := range f.owed {
+		if !funds.CanDebit(account, owed) {
+			return false
+		}
+	}
+	return true
+}
+
+// finishBid cancels whatever is left of an immediate or
+// cancel or fill or kill bid once matching is done
+func (s *Settlement) finishBid(ms MarketStorage, ts time.Time, bid Bid) error {
+	if !bid.TimeInForce.immediate() {
+		return nil
+	}
+	bid, err := ms.GetBid(bid.ID)
+	if err != nil || !bid.IsActive() {
+		return err
+	}
+	return s.cancelBid(ms, ts, bid, EventCancelled)
+}
+
+// finishOffer is finishBid for offers
+func (s *Settlement) finishOffer(ms MarketStorage, ts time.Time, off Offer) error {
+	if !off.TimeInForce.immediate() {
+		return nil
+	}
+	off, err := ms.GetOffer(off.ID)
+	if err != nil || !off.IsActive() {
+		return err
+	}
+	return s.cancelOffer(ms, ts, off, EventCancelled)
+}
+
+// ExpireOrders cancels every good till date order that has
+// expired, resting or stop, publishing EventExpired for
+// each. Expired orders are otherwise only removed when an
+// order would trade with them, so a simulation can call it
+// from a timer to have them leave the book on time.
+func (m *Market) ExpireOrders() (err error) {
+	m.storage.Lock()
+	defer m.unlock(&err)
+	symbols, err := m.storage.AllSymbols()
+	if err != nil {
+		return err
+	}
+	ts := m.now()
+	for _, symbol := range symbols {
+		if err = m.expireBids(symbol, ts); err != nil {
+			return err
+		}
+		if err = m.expireOffers(symbol, ts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Market) expireBids(symbol string, ts time.Time) error {
+	resting, err := m.storage.RestingBids(symbol)
+	if err != nil {
+		return err
+	}
+	stops, err := m.storage.StopBids(symbol)
+	if err != nil {
+		return err
+	}
+	for _, b := range append(resting, stops...) {
+		if !b.Expired(ts) {
+			continue
+		}
+		if err = m.settlement.cancelBid(m.storage, ts, b, EventExpired); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Market) expireOffers(symbol string, ts time.Time) error {
+	resting, err := m.storage.RestingOffers(symbol)
+	if err != nil {
+		return err
+	}
+	stops, err := m.storage.StopOffers(symbol)
+	if err != nil {
+		return err
+	}
+	for _, o := range append(resting, stops...) {
+		if !o.Expired(ts) {
+			continue
+		}
+		if err = m.settlement.cancelOffer(m.storage, ts, o, EventExpired); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,282 @@
+package economy
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestIOCCancelsRemainder(t *testing.T) {
+	m := MakeMarket(time.Now, MakeMemoryStorage(), makeMockAccounts())
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 3, OfferType: OrderTypeLimit, Price: 5})
+	report, err := m.Bid(Bid{Symbol: sym, Account: 1, Amount: 5, BidType: OrderTypeLimit, Price: 5, TimeInForce: TimeInForceIOC})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Status != OrderStatusCancelled || report.Filled() != 3 || report.Remaining != 2 {
+		t.Fatalf("%+v", report)
+	}
+	if b, _ := m.Book(sym); len(b.Bids) != 0 {
+		t.Fatalf("%+v", b)
+	}
+}
+
+func TestIOCFilledCompletely(t *testing.T) {
+	m := MakeMarket(time.Now, MakeMemoryStorage(), makeMockAccounts())
+	m.Bid(Bid{Symbol: sym, Account: 1, Amount: 5, BidType: OrderTypeLimit, Price: 5})
+	report, _ := m.Offer(Offer{Symbol: sym, Account: 2, Amount: 5, OfferType: OrderTypeLimit, Price: 5, TimeInForce: TimeInForceIOC})
+	if report.Status != OrderStatusFilled {
+		t.Fatalf("%+v", report)
+	}
+}
+
+func TestFOKKilledWithoutTrading(t *testing.T) {
+	m := MakeMarket(time.Now, MakeMemoryStorage(), makeMockAccounts())
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 3, OfferType: OrderTypeLimit, Price: 5})
+	m.Offer(Offer{Symbol: sym, Account: 3, Amount: 3, OfferType: OrderTypeLimit, Price: 7})
+	var events []Event
+	m.Subscribe(func(e Event) { events = append(events, e) })
+	report, _ := m.Bid(Bid{Symbol: sym, Account: 1, Amount: 5, BidType: OrderTypeLimit, Price: 6, TimeInForce: TimeInForceFOK})
+	if report.Status != OrderStatusCancelled || report.Filled() != 0 || report.Remaining != 5 {
+		t.Fatalf("%+v", report)
+	}
+	if len(events) != 2 || events[1].Type != EventCancelled {
+		t.Fatalf("%+v", events)
+	}
+	if d, _ := m.Depth(sym, 0); len(d.Offers) != 2 || d.Offers[0].Amount != 3 {
+		t.Fatalf("%+v", d)
+	}
+}
+
+func TestFOKFilledAcrossLevels(t *testing.T) {
+	m := MakeMarket(time.Now, MakeMemoryStorage(), makeMockAccounts())
+	m.Bid(Bid{Symbol: sym, Account: 1, Amount: 3, BidType: OrderTypeLimit, Price: 7})
+	m.Bid(Bid{Symbol: sym, Account: 3, Amount: 3, BidType: OrderTypeLimit, Price: 6})
+	m.Bid(Bid{Symbol: sym, Account: 4, Amount: 3, BidType: OrderTypeLimit, Price: 4})
+	report, _ := m.Offer(Offer{Symbol: sym, Account: 2, Amount: 6, OfferType: OrderTypeLimit, Price: 6, TimeInForce: TimeInForceFOK})
+	if report.Status != OrderStatusFilled || len(report.Transactions) != 2 {
+		t.Fatalf("%+v", report)
+	}
+	report, _ = m.Offer(Offer{Symbol: sym, Account: 2, Amount: 6, OfferType: OrderTypeMarket, TimeInForce: TimeInForceFOK})
+	if report.Status != OrderStatusCancelled || report.Filled() != 0 {
+		t.Fatalf("%+v", report)
+	}
+}
+
+func TestFOKKilledWhenBuyerRunsOutOfFunds(t *testing.T) {
+	accounts := makeMockAccounts()
+	m := MakeMarket(time.Now, MakeMemoryStorage(), accounts)
+	m.Bid(Bid{Symbol: sym, Account: 1, Amount: 3, BidType: OrderTypeLimit, Price: 7})
+	m.Bid(Bid{Symbol: sym, Account: 3, Amount: 3, BidType: OrderTypeLimit, Price: 6})
+	accounts.rejects[3] = true
+	report, err := m.Offer(Offer{Symbol: sym, Account: 2, Amount: 6, OfferType: OrderTypeLimit, Price: 6, TimeInForce: TimeInForceFOK})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Status != OrderStatusCancelled || report.Filled() != 0 || report.Remaining != 6 {
+		t.Fatalf("%+v", report)
+	}
+	if d, _ := m.Depth(sym, 0); len(d.Bids) != 2 || d.Bids[0].Amount != 3 {
+		t.Fatalf("%+v", d)
+	}
+	if accounts.accounts[1] != 0 || accounts.accounts[2] != 0 {
+		t.Fatalf("%+v", accounts.accounts)
+	}
+}
+
+func TestFOKBidKilledWithoutFunds(t *testing.T) {
+	accounts := makeMockAccounts()
+	m := MakeMarket(time.Now, MakeMemoryStorage(), accounts)
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 3, OfferType: OrderTypeLimit, Price: 5})
+	accounts.rejects[1] = true
+	report, err := m.Bid(Bid{Symbol: sym, Account: 1, Amount: 3, BidType: OrderTypeMarket, TimeInForce: TimeInForceFOK})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Status != OrderStatusCancelled || report.Filled() != 0 {
+		t.Fatalf("%+v", report)
+	}
+	if d, _ := m.Depth(sym, 0); len(d.Offers) != 1 || d.Offers[0].Amount != 3 {
+		t.Fatalf("%+v", d)
+	}
+}
+
+// ledgerAccounts counts the changes made to accounts
+type ledgerAccounts struct {
+	*mockAccounts
+	entries int
+}
+
+func (a *ledgerAccounts) Credit(accountID, funds int64) {
+	a.entries++
+	a.mockAccounts.Credit(accountID, funds)
+}
+
+func (a *ledgerAccounts) DebitIfPossible(accountID, funds int64) bool {
+	a.entries++
+	return a.mockAccounts.DebitIfPossible(accountID, funds)
+}
+
+func TestFOKCheckLeavesAccountsAlone(t *testing.T) {
+	accounts := &ledgerAccounts{mockAccounts: makeMockAccounts()}
+	m := MakeMarket(time.Now, MakeMemoryStorage(), accounts)
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 3, OfferType: OrderTypeLimit, Price: 5})
+	accounts.rejects[1] = true
+	m.Bid(Bid{Symbol: sym, Account: 1, Amount: 3, BidType: OrderTypeMarket, TimeInForce: TimeInForceFOK})
+	accounts.rejects[1] = false
+	m.Bid(Bid{Symbol: sym, Account: 1, Amount: 3, BidType: OrderTypeMarket, TimeInForce: TimeInForceFOK})
+	// Only the trade's debit and credit
+	if accounts.entries != 2 {
+		t.Fatalf("%d ledger entries", accounts.entries)
+	}
+}
+
+func TestFOKKilledOutsideBand(t *testing.T) {
+	m := makeBandedMarket(time.Now, BandRule{Band: 1000})
+	m.SetReferencePrice(sym, 100)
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 3, OfferType: OrderTypeLimit, Price: 100})
+	m.Offer(Offer{Symbol: sym, Account: 3, Amount: 3, OfferType: OrderTypeLimit, Price: 109})
+	m.SetReferencePrice(sym, 95)
+	report, err := m.Bid(Bid{Symbol: sym, Account: 1, Amount: 6, BidType: OrderTypeMarket, TimeInForce: TimeInForceFOK})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Status != OrderStatusCancelled || report.Filled() != 0 {
+		t.Fatalf("%+v", report)
+	}
+	if halted, _ := m.Halted(sym); halted {
+		t.Fatal("FOK order halted trading")
+	}
+	if d, _ := m.Depth(sym, 0); len(d.Offers) != 2 || d.Offers[0].Amount != 3 {
+		t.Fatalf("%+v", d)
+	}
+}
+
+func TestGTDExpiresByClock(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := MakeMarket(func() time.Time { return now }, MakeMemoryStorage(), makeMockAccounts())
+	report, _ := m.Offer(Offer{
+		Symbol: sym, Account: 2, Amount: 3, OfferType: OrderTypeLimit, Price: 5,
+		TimeInForce: TimeInForceGTD, Expires: now.Add(time.Hour),
+	})
+	expiring := report.OrderID
+	m.Offer(Offer{Symbol: sym, Account: 3, Amount: 3, OfferType: OrderTypeLimit, Price: 6})
+	if b, _ := m.Book(sym); len(b.Offers) != 2 {
+		t.Fatalf("%+v", b)
+	}
+	now = now.Add(time.Hour)
+	if b, _ := m.Book(sym); len(b.Offers) != 1 {
+		t.Fatalf("%+v", b)
+	}
+	var events []Event
+	m.Subscribe(func(e Event) { events = append(events, e) })
+	report, _ = m.Bid(Bid{Symbol: sym, Account: 1, Amount: 3, BidType: OrderTypeLimit, Price: 6})
+	if report.Status != OrderStatusFilled || report.Transactions[0].OfferID == expiring {
+		t.Fatalf("%+v", report)
+	}
+	if events[1].Type != EventExpired || events[1].OrderID != expiring {
+		t.Fatalf("%+v", events)
+	}
+	if o, _ := m.FindOffer(expiring); !o.Cancelled {
+		t.Fatalf("%+v", o)
+	}
+}
+
+func TestExpireOrders(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := MakeMarket(func() time.Time { return now }, MakeMemoryStorage(), makeMockAccounts())
+	m.Bid(Bid{
+		Symbol: sym, Account: 1, Amount: 3, BidType: OrderTypeLimit, Price: 4,
+		TimeInForce: TimeInForceGTD, Expires: now.Add(time.Hour),
+	})
+	m.Bid(Bid{
+		Symbol: sym, Account: 1, Amount: 3, BidType: OrderTypeStopMarket, StopPrice: 10,
+		TimeInForce: TimeInForceGTD, Expires: now.Add(time.Hour),
+	})
+	m.Offer(Offer{
+		Symbol: sym, Account: 2, Amount: 3, OfferType: OrderTypeLimit, Price: 6,
+		TimeInForce: TimeInForceGTD, Expires: now.Add(time.Hour),
+	})
+	m.Offer(Offer{Symbol: sym, Account: 3, Amount: 3, OfferType: OrderTypeLimit, Price: 7})
+	var events []Event
+	m.Subscribe(func(e Event) { events = append(events, e) })
+	if err := m.ExpireOrders(); err != nil || len(events) != 0 {
+		t.Fatalf("%+v %v", events, err)
+	}
+	now = now.Add(time.Hour)
+	if err := m.ExpireOrders(); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("%+v", events)
+	}
+	for _, e := range events {
+		if e.Type != EventExpired || !e.Date.Equal(now) {
+			t.Fatalf("%+v", events)
+		}
+	}
+	if b, _ := m.Book(sym); len(b.Bids) != 0 || len(b.Offers) != 1 {
+		t.Fatalf("%+v", b)
+	}
+}
+
+func TestExpireOrdersOnlyBids(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := MakeMarket(func() time.Time { return now }, MakeMemoryStorage(), makeMockAccounts())
+	m.Bid(Bid{
+		Symbol: sym, Account: 1, Amount: 3, BidType: OrderTypeLimit, Price: 4,
+		TimeInForce: TimeInForceGTD, Expires: now.Add(time.Hour),
+	})
+	var events []Event
+	m.Subscribe(func(e Event) { events = append(events, e) })
+	now = now.Add(time.Hour)
+	if err := m.ExpireOrders(); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Type != EventExpired {
+		t.Fatalf("%+v", events)
+	}
+}
+
+func TestGTDAlreadyExpired(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := MakeMarket(func() time.Time { return now }, MakeMemoryStorage(), makeMockAccounts())
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 3, OfferType: OrderTypeLimit, Price: 5})
+	report, _ := m.Bid(Bid{
+		Symbol: sym, Account: 1, Amount: 3, BidType: OrderTypeLimit, Price: 5,
+		TimeInForce: TimeInForceGTD, Expires: now,
+	})
+	if report.Status != OrderStatusCancelled || report.Filled() != 0 {
+		t.Fatalf("%+v", report)
+	}
+}
+
+func TestLimitOfferDoesNotSellBelowPrice(t *testing.T) {
+	m := MakeMarket(time.Now, MakeMemoryStorage(), makeMockAccounts())
+	m.Bid(Bid{Symbol: sym, Account: 1, Amount: 3, BidType: OrderTypeLimit, Price: 4})
+	report, _ := m.Offer(Offer{Symbol: sym, Account: 2, Amount: 3, OfferType: OrderTypeLimit, Price: 5})
+	if report.Status != OrderStatusResting {
+		t.Fatalf("%+v", report)
+	}
+}
+
+func TestTimeInForceSurvivesSnapshot(t *testing.T) {
+	s := MakeMemoryStorage()
+	expires := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.AddBid(Bid{Symbol: sym, Account: 1, Amount: 3, BidType: OrderTypeLimit, Price: 4, TimeInForce: TimeInForceGTD, Expires: expires})
+	s.AddOffer(Offer{Symbol: sym, Account: 2, Amount: 3, OfferType: OrderTypeLimit, Price: 5, TimeInForce: TimeInForceGTD, Expires: expires})
+	var buf bytes.Buffer
+	s.Marshal(&buf)
+	loaded := MakeMemoryStorage()
+	if err := loaded.UnMarshal(&buf); err != nil {
+		t.Fatal(err)
+	}
+	bid, _, _ := loaded.BestBid(sym)
+	offer, _, _ := loaded.BestOffer(sym)
+	if bid.TimeInForce != TimeInForceGTD || !bid.Expires.Equal(expires) {
+		t.Fatalf("%+v", bid)
+	}
+	if offer.TimeInForce != TimeInForceGTD || !offer.Expires.Equal(expires) {
+		t.Fatalf("%+v", offer)
+	}
+}
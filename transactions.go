@@ -0,0 +1,57 @@
+package economy
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TransactionFilter selects transactions. Fields left at
+// their zero value match every transaction.
+type TransactionFilter struct {
+	Symbol string
+	// Accounts matches transactions where any of the
+	// accounts was the buyer or the seller
+	Accounts []int64
+	// OrderID matches transactions that filled the bid or
+	// offer with this ID
+	OrderID uuid.UUID
+	// Since and Until limit transactions to those dated at
+	// or after Since, and before Until
+	Since time.Time
+	Until time.Time
+	// Offset skips that many matching transactions, and
+	// Limit returns at most that many, for paging
+	Offset int
+	Limit  int
+}
+
+// Matches returns true if tx is selected by the filter,
+// ignoring Offset and Limit
+func (f TransactionFilter) Matches(tx Transaction) bool {
+	if f.Symbol != "" && tx.Symbol != f.Symbol {
+		return false
+	}
+	if len(f.Accounts) > 0 && !f.hasAccount(tx) {
+		return false
+	}
+	if f.OrderID != uuid.Nil && tx.BidID != f.OrderID && tx.OfferID != f.OrderID {
+		return false
+	}
+	if !f.Since.IsZero() && tx.Date.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !tx.Date.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+func (f TransactionFilter) hasAccount(tx Transaction) bool {
+	for _, a := range f.Accounts {
+		if tx.BidAccount == a || tx.OfferAccount == a {
+			return true
+		}
+	}
+	return false
+}
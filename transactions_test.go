@@ -0,0 +1,105 @@
+package economy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func makeHistory() *MemoryStorage {
+	ms := MakeMemoryStorage()
+	day := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		s := "A"
+		if i%2 == 1 {
+			s = "B"
+		}
+		ms.NewTransaction(Transaction{
+			BidID:        uuid.New(),
+			OfferID:      uuid.New(),
+			Symbol:       s,
+			BidAccount:   int64(i % 3),
+			OfferAccount: 10,
+			Price:        int64(i),
+			Amount:       1,
+			Date:         day.Add(time.Duration(i) * time.Hour),
+		})
+	}
+	return ms
+}
+
+func prices(txs []Transaction) []int64 {
+	var rv []int64
+	for _, tx := range txs {
+		rv = append(rv, tx.Price)
+	}
+	return rv
+}
+
+func samePrices(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestTransactionsFilter(t *testing.T) {
+	ms := makeHistory()
+	day := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		filter   TransactionFilter
+		expected []int64
+	}{
+		{TransactionFilter{}, []int64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{TransactionFilter{Symbol: "B"}, []int64{1, 3, 5, 7, 9}},
+		{TransactionFilter{Accounts: []int64{2}}, []int64{2, 5, 8}},
+		{TransactionFilter{Accounts: []int64{1, 2}}, []int64{1, 2, 4, 5, 7, 8}},
+		{TransactionFilter{Accounts: []int64{10}, Symbol: "A"}, []int64{0, 2, 4, 6, 8}},
+		{TransactionFilter{Since: day.Add(3 * time.Hour), Until: day.Add(6 * time.Hour)}, []int64{3, 4, 5}},
+		{TransactionFilter{Offset: 2, Limit: 3}, []int64{2, 3, 4}},
+		{TransactionFilter{Symbol: "A", Offset: 4, Limit: 3}, []int64{8}},
+	}
+	for _, c := range cases {
+		txs, err := ms.Transactions(c.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !samePrices(prices(txs), c.expected) {
+			t.Fatalf("%+v: %v != %v", c.filter, prices(txs), c.expected)
+		}
+	}
+}
+
+func TestTransactionsByOrder(t *testing.T) {
+	ms := makeHistory()
+	tx := ms.transactions[4]
+	for _, id := range []uuid.UUID{tx.BidID, tx.OfferID} {
+		txs, _ := ms.Transactions(TransactionFilter{OrderID: id})
+		if len(txs) != 1 || txs[0].ID != tx.ID {
+			t.Fatalf("%+v", txs)
+		}
+	}
+}
+
+func TestMarketTransactionsRecordsParties(t *testing.T) {
+	m := MakeMarket(time.Now, MakeMemoryStorage(), makeMockAccounts())
+	m.Offer(Offer{Symbol: sym, Account: 2, Amount: 10, OfferType: OrderTypeLimit, Price: 5})
+	m.Bid(Bid{Symbol: sym, Account: 1, Amount: 4, BidType: OrderTypeLimit, Price: 5})
+	txs, err := m.Transactions(TransactionFilter{Accounts: []int64{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 1 {
+		t.Fatalf("%+v", txs)
+	}
+	tx := txs[0]
+	if tx.Symbol != sym || tx.BidAccount != 1 || tx.OfferAccount != 2 {
+		t.Fatalf("%+v", tx)
+	}
+}